@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/config"
+	"cloudterm-go/internal/recordings"
+)
+
+// runCommand handles administrative subcommands (cloudterm <command> ...).
+// It returns the process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "hash-password":
+		return cmdHashPassword()
+	case "audit":
+		return cmdAudit(args[1:])
+	case "recording":
+		return cmdRecording(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+const usage = `usage:
+  cloudterm                      start the server
+  cloudterm hash-password        read a password from stdin and print an AUTH_USERS_FILE hash
+  cloudterm audit verify [file] [--anchor hash]...
+                                 check the audit log hash chain (default AUDIT_LOG_FILE);
+                                 each anchor is a record hash kept outside the log
+  cloudterm recording verify <file> [key]
+                                 check a recording against its signed manifest; key is the
+                                 PEM public or private key (default RECORDING_SIGNING_KEY)
+`
+
+func cmdHashPassword() int {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintf(os.Stderr, "read password: %v\n", err)
+		return 1
+	}
+	hash, err := auth.HashPassword(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hash password: %v\n", err)
+		return 1
+	}
+	fmt.Println(hash)
+	return 0
+}
+
+func cmdAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	path := config.Load().AuditLogFile
+	var anchors []string
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--anchor" && i+1 < len(args) {
+			i++
+			anchors = append(anchors, args[i])
+			continue
+		}
+		path = args[i]
+	}
+	res, err := audit.Verify(path, anchors...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log verification FAILED: %v\n", err)
+		return 1
+	}
+	if res.Segments == 0 {
+		fmt.Fprintf(os.Stderr, "no audit log found at %s\n", path)
+		return 1
+	}
+	fmt.Printf("audit log OK: %d records in %d segment(s)", res.Records, res.Segments)
+	if res.Legacy > 0 {
+		fmt.Printf(", %d unchained legacy record(s)", res.Legacy)
+	}
+	fmt.Printf("\nhead: %s\n", res.Head)
+	return 0
+}
+
+func cmdRecording(args []string) int {
+	if len(args) < 2 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	path := args[1]
+	keyPath := config.Load().RecordingSigningKey
+	if len(args) > 2 {
+		keyPath = args[2]
+	}
+	pub, err := recordings.LoadPublicKey(keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load key: %v\n", err)
+		return 1
+	}
+	m, err := recordings.ReadManifest(recordings.ManifestPath(path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read manifest: %v\n", err)
+		return 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open recording: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	if err := recordings.VerifyManifest(m, f, pub); err != nil {
+		fmt.Fprintf(os.Stderr, "recording verification FAILED: %v\n", err)
+		return 1
+	}
+	fmt.Printf("recording OK: %s (%d bytes, sha256 %s)\n", m.File, m.Bytes, m.SHA256)
+	fmt.Printf("instance %s %s, user %s, %s - %s\n", m.InstanceID, m.InstanceName, m.User, m.Started, m.Ended)
+	fmt.Printf("signed %s with key %s\n", m.SignedAt, m.KeyID)
+	return 0
+}
@@ -2,24 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"syscall"
 	"time"
 
 	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
 	"cloudterm-go/internal/aws"
+	"cloudterm-go/internal/catalog"
 	"cloudterm-go/internal/config"
+	"cloudterm-go/internal/crypto"
+	"cloudterm-go/internal/fleet"
+	"cloudterm-go/internal/guard"
 	"cloudterm-go/internal/handlers"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/recordings"
+	"cloudterm-go/internal/runbook"
 	"cloudterm-go/internal/session"
+	"cloudterm-go/internal/sshclient"
 	"cloudterm-go/internal/suggest"
+	"cloudterm-go/internal/types"
 	"cloudterm-go/internal/vault"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	cfg := config.Load()
 
 	logger := log.New(os.Stdout, "[cloudterm] ", log.LstdFlags|log.Lshortfile)
@@ -36,25 +53,99 @@ func main() {
 
 	// Initialize session manager
 	sessionMgr := session.NewManager(logger, cfg.SessionRecordingDir, cfg.AutoRecord)
+	if cfg.RecordInput {
+		var prompt *regexp.Regexp
+		if cfg.RecordInputPrompt != "" {
+			re, err := regexp.Compile(cfg.RecordInputPrompt)
+			if err != nil {
+				logger.Fatalf("invalid RECORD_INPUT_PROMPT: %v", err)
+			}
+			prompt = re
+		}
+		sessionMgr.SetInputCapture(true, prompt)
+		logger.Printf("Keystroke capture enabled for session recordings")
+	}
 
 	// Initialize audit logger
-	auditLogger := audit.NewLogger(cfg.AuditLogFile)
+	auditLogger := audit.NewLogger(cfg.AuditLogFile, int64(cfg.AuditMaxSizeMB)<<20, time.Duration(cfg.AuditMaxAgeHours)*time.Hour)
+	auditSinks, err := buildAuditSinks(cfg)
+	if err != nil {
+		logger.Fatalf("audit sinks: %v", err)
+	}
+	var auditDispatcher *audit.Dispatcher
+	if len(auditSinks) > 0 {
+		auditDispatcher, err = audit.NewDispatcher(cfg.AuditSpoolDir, logger, auditSinks...)
+		if err != nil {
+			logger.Fatalf("audit sinks: %v", err)
+		}
+		auditLogger.SetDispatcher(auditDispatcher)
+		logger.Printf("Audit forwarding enabled (%d sink(s))", len(auditSinks))
+	}
 
-	// Initialize AWS account store
-	accountStore := aws.NewAccountStore(cfg.AWSAccountsFile)
-	discovery.SetAccountStore(accountStore)
+	// Keep sessions running when the browser disconnects.
+	if cfg.SessionDetachGraceMinutes != 0 {
+		grace := time.Duration(cfg.SessionDetachGraceMinutes) * time.Minute
+		sessionMgr.SetDetachGrace(grace, func(s session.DetachedSession, reason string) {
+			auditLogger.Log(audit.AuditEvent{Action: "session_end", Actor: s.Owner, CorrelationID: s.SessionID, InstanceID: s.InstanceID, InstanceName: s.InstanceName, Details: "reason=" + reason})
+		})
+	}
+
+	// Close idle and long-running sessions.
+	if err := setSessionLimits(cfg, sessionMgr, discovery, auditLogger); err != nil {
+		logger.Fatalf("session limits: %v", err)
+	}
 
-	var encKey []byte
-	if cfg.SuggestEncryptionKey != "" {
-		encKey = []byte(cfg.SuggestEncryptionKey)
-		if len(encKey) < 32 {
-			padded := make([]byte, 32)
-			copy(padded, encKey)
-			encKey = padded
-		} else if len(encKey) > 32 {
-			encKey = encKey[:32]
+	// Initialize authentication
+	authSvc, err := auth.New(context.Background(), cfg, logger)
+	if err != nil {
+		logger.Fatalf("auth init failed: %v", err)
+	}
+	policy, err := rbac.Load(cfg.RBACPolicyFile)
+	if err != nil {
+		logger.Fatalf("rbac init failed: %v", err)
+	}
+
+	// The data key shared by the vault, suggestion store and account store.
+	keyring, err := openKeyring(cfg)
+	if err != nil {
+		logger.Fatalf("encryption key: %v", err)
+	}
+	if _, ok := keyring.Pending(); ok {
+		if err := resumeRotation(cfg, keyring); err != nil {
+			logger.Fatalf("encryption key: %v", err)
 		}
+		logger.Printf("Stored data re-encrypted with the %s data key", keyring.Source())
+	}
+	encKey := keyring.Key()
+
+	// Initialize AWS account store; secrets share the vault's encryption key.
+	accountStore, err := aws.NewAccountStore(cfg.AWSAccountsFile, encKey)
+	if err != nil {
+		logger.Fatalf("aws accounts: %v", err)
 	}
+	if !accountStore.Encrypted() {
+		logger.Printf("warning: no encryption key is configured; accounts with secrets and vault credentials cannot be saved")
+	}
+	discovery.SetAccountStore(accountStore)
+
+	if cfg.SSMClient != "cli" {
+		sessionMgr.SetChannelOpener(func(ctx context.Context, instanceID, profile, region string) (session.Channel, error) {
+			return discovery.StartShellSession(ctx, profile, region, instanceID)
+		})
+	}
+	sessionMgr.SetSSHOpener(func(ctx context.Context, instanceID, profile, region, osUser string, cols, rows uint16) (session.Channel, error) {
+		client, err := discovery.DialSSH(ctx, profile, region, instanceID, osUser)
+		if err != nil {
+			return nil, err
+		}
+		shell, err := client.Shell(cols, rows)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return shell, nil
+	})
+
 	suggestEngine, err := suggest.New(suggest.Config{
 		Enabled:       cfg.SuggestEnabled,
 		DataDir:       cfg.SuggestDataDir,
@@ -67,14 +158,62 @@ func main() {
 	vaultStore, err := vault.Open(cfg.SuggestDataDir, encKey)
 	if err != nil {
 		logger.Printf("warning: vault init failed: %v", err)
+	} else {
+		discovery.SetSSHKeys(vaultSSHKeys(vaultStore, discovery))
+		discovery.SetHostKeys(vaultStore)
+	}
+	discovery.SetHostKeyHook(func(instanceID string, err error) {
+		auditLogger.Log(audit.AuditEvent{Action: "ssh_host_key_changed", Actor: "system", InstanceID: instanceID, Outcome: audit.OutcomeDenied, Details: err.Error()})
+	})
+
+	recordingStore, err := buildRecordingStore(cfg, logger, discovery, sessionMgr, auditLogger)
+	if err != nil {
+		logger.Fatalf("recordings: %v", err)
+	}
+
+	fleetJobs, err := fleet.NewManager(cfg.FleetJobsFile, discovery, logger)
+	if err != nil {
+		logger.Fatalf("fleet: %v", err)
+	}
+
+	guardPolicy, err := guard.Load(cfg.GuardPolicyFile)
+	if err != nil {
+		logger.Fatalf("guard: %v", err)
+	}
+
+	runbookStore, err := runbook.NewStore(cfg.RunbookDir)
+	if err != nil {
+		logger.Fatalf("runbooks: %v", err)
 	}
+	runbookRuns := runbook.NewManager(discovery, sessionMgr, logger)
 
-	handler := handlers.New(cfg, discovery, sessionMgr, logger, auditLogger, accountStore, suggestEngine, vaultStore)
+	var sessionCatalog *catalog.Store
+	if cfg.SessionCatalogFile != "" {
+		sessionCatalog, err = catalog.Open(cfg.SessionCatalogFile)
+		if err != nil {
+			logger.Fatalf("session catalog: %v", err)
+		}
+		sessionMgr.SetEndHook(func(s session.Summary) {
+			err := sessionCatalog.End(s.SessionID, catalog.Ending{
+				Reason:     s.Reason,
+				Ended:      s.Ended,
+				BytesIn:    s.BytesIn,
+				BytesOut:   s.BytesOut,
+				Recordings: s.Recordings,
+			})
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				logger.Printf("session catalog: end %s: %v", s.SessionID, err)
+			}
+		})
+	}
+
+	handler := handlers.New(cfg, discovery, sessionMgr, logger, auditLogger, authSvc, policy, accountStore, suggestEngine, vaultStore, recordingStore, fleetJobs, guardPolicy, runbookStore, runbookRuns, sessionCatalog, keyring)
 
 	// Start background scanner
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go discovery.BackgroundScanLoop(ctx)
+	go recordingStore.Run(ctx, time.Duration(cfg.RecordingSweepMinutes)*time.Minute)
 
 	// Build HTTP server
 	srv := &http.Server{
@@ -114,5 +253,252 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Printf("Shutdown error: %v", err)
 	}
+	fleetJobs.Close()
+	runbookRuns.Close()
+	if sessionCatalog != nil {
+		sessionCatalog.Close()
+	}
+	recordingStore.Close()
+	auditLogger.Close()
+	if auditDispatcher != nil {
+		auditDispatcher.Close()
+	}
 	logger.Println("Server stopped")
 }
+
+// openKeyring loads the data key from ENCRYPTION_KEY_FILE, protected by
+// ENCRYPTION_KMS_KEY_ID or derived from SUGGEST_ENCRYPTION_KEY.
+func openKeyring(cfg *config.Config) (*crypto.Keyring, error) {
+	path := cfg.EncryptionKeyFile
+	if path == "" {
+		path = filepath.Join(cfg.SuggestDataDir, "keyring.json")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create keyring dir: %w", err)
+	}
+	ctx := context.Background()
+	var kms crypto.KMS
+	if cfg.EncryptionKMSKeyID != "" {
+		k, err := aws.NewKMSKeys(ctx, cfg.EncryptionKMSKeyID)
+		if err != nil {
+			return nil, err
+		}
+		kms = k
+	}
+	keyring, err := crypto.OpenKeyring(ctx, path, cfg.SuggestEncryptionKey, kms, cfg.EncryptionKMSKeyID)
+	if errors.Is(err, crypto.ErrWrongKey) {
+		return nil, fmt.Errorf("%w: SUGGEST_ENCRYPTION_KEY must be the passphrase the keyring %s was created or last rotated with", err, path)
+	}
+	return keyring, err
+}
+
+// resumeRotation re-encrypts the stores for a rotation the keyring has not
+// finished: the first start with a keyring, a change of key source, or a
+// rotation interrupted by a restart. It runs before the stores are opened
+// for use so none of them reads data sealed with an earlier key.
+func resumeRotation(cfg *config.Config, keyring *crypto.Keyring) error {
+	r, _ := keyring.Pending()
+	accounts, err := aws.NewAccountStore(cfg.AWSAccountsFile, r.Key, r.Previous...)
+	if err != nil {
+		return err
+	}
+	vaultStore, err := vault.Open(cfg.SuggestDataDir, r.Key)
+	if err != nil {
+		return err
+	}
+	defer vaultStore.Close()
+	suggestStore, err := suggest.OpenStore(cfg.SuggestDataDir, r.Key)
+	if err != nil {
+		return err
+	}
+	defer suggestStore.Close()
+	return keyring.Resume(accounts, vaultStore, suggestStore)
+}
+
+// buildAuditSinks returns the external audit destinations enabled in cfg.
+func buildAuditSinks(cfg *config.Config) ([]audit.Sink, error) {
+	var sinks []audit.Sink
+	if cfg.AuditSyslogAddr != "" {
+		s, err := audit.NewSyslogSink(cfg.AuditSyslogAddr, audit.FormatRFC5424)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.AuditCEFAddr != "" {
+		s, err := audit.NewSyslogSink(cfg.AuditCEFAddr, audit.FormatCEF)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.AuditWebhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(cfg.AuditWebhookURL, cfg.AuditWebhookSecret))
+	}
+	return sinks, nil
+}
+
+// setSessionLimits applies the configured idle and maximum-duration limits.
+// Warnings and expiries are audited; the instance's environment tag and
+// account select per-environment and per-account rules.
+func setSessionLimits(cfg *config.Config, sessions *session.Manager, discovery *aws.Discovery, auditLogger *audit.Logger) error {
+	idleBy, err := session.ParseLimitRules(cfg.SessionIdleTimeoutBy)
+	if err != nil {
+		return err
+	}
+	maxBy, err := session.ParseLimitRules(cfg.SessionMaxDurationBy)
+	if err != nil {
+		return err
+	}
+	policy := session.LimitPolicy{
+		Idle:   time.Duration(cfg.SessionIdleTimeoutMinutes) * time.Minute,
+		IdleBy: idleBy,
+		Max:    time.Duration(cfg.SessionMaxDurationMinutes) * time.Minute,
+		MaxBy:  maxBy,
+		Warn:   time.Duration(cfg.SessionExpiryWarningMinutes) * time.Minute,
+		Extend: time.Duration(cfg.SessionExtendMinutes) * time.Minute,
+	}
+	if !policy.Enabled() {
+		return nil
+	}
+	locate := func(instanceID string) (string, string) {
+		instances, _ := discovery.GetAllInstances()
+		for _, inst := range instances {
+			if inst.InstanceID == instanceID {
+				return inst.Tag2Value, inst.AccountID
+			}
+		}
+		return "", ""
+	}
+	sessions.SetLimitPolicy(policy, locate, func(ev session.ExpiryEvent) {
+		action, details := "session_expiry_warning", "reason="+ev.Reason+" expires_at="+ev.ExpiresAt.UTC().Format(time.RFC3339)
+		if ev.Type == session.ExpiryExpired {
+			action, details = "session_end", "reason="+ev.Reason
+		}
+		auditLogger.Log(audit.AuditEvent{Action: action, Actor: ev.Owner, CorrelationID: ev.SessionID, InstanceID: ev.InstanceID, InstanceName: ev.InstanceName, Details: details})
+	})
+	return nil
+}
+
+// buildRecordingStore sets up retention and, if a bucket is configured,
+// offloading for the session recording directory.
+func buildRecordingStore(cfg *config.Config, logger *log.Logger, discovery *aws.Discovery, sessions *session.Manager, auditLogger *audit.Logger) (*recordings.Store, error) {
+	byEnv, err := recordings.ParseEnvRetention(cfg.RecordingRetentionByEnv)
+	if err != nil {
+		return nil, err
+	}
+	store := recordings.NewStore(cfg.SessionRecordingDir, recordings.Policy{
+		MaxAge:        time.Duration(cfg.RecordingRetentionDays) * 24 * time.Hour,
+		MaxAgeByEnv:   byEnv,
+		EnvTag:        cfg.Tag2,
+		MaxLocalBytes: int64(cfg.RecordingMaxLocalMB) << 20,
+		LocalKeep:     time.Duration(cfg.RecordingLocalKeepHours) * time.Hour,
+	}, logger)
+	store.SetActiveFunc(sessions.ActiveRecordings)
+	var idx *recordings.Index
+	if cfg.RecordingIndexFile != "" {
+		idx, err = recordings.OpenIndex(cfg.RecordingIndexFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		store.SetIndex(idx)
+		go idx.Backfill(cfg.SessionRecordingDir, sessions.ActiveRecordings())
+	}
+	if cfg.RecordingSigningKey != "none" {
+		signer, err := recordings.LoadSigner(cfg.RecordingSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		store.SetSigner(signer)
+		logger.Printf("Recording manifests are signed with key %s", signer.KeyID())
+	}
+	sessions.SetRecordingHook(func(info session.RecordingInfo) {
+		m := &recordings.Manifest{
+			InstanceID:   info.InstanceID,
+			InstanceName: info.InstanceName,
+			Profile:      info.Profile,
+			Region:       info.Region,
+			User:         info.Owner,
+			SessionID:    info.SessionID,
+			Started:      info.Started.UTC().Format(time.RFC3339),
+			Ended:        info.Ended.UTC().Format(time.RFC3339),
+		}
+		if inst := findInstance(discovery, info.InstanceID); inst != nil {
+			m.AccountID = inst.AccountID
+		}
+		if err := store.Seal(info.Path, m); err != nil {
+			logger.Printf("recordings: sign %s: %v", filepath.Base(info.Path), err)
+		}
+		if idx != nil {
+			idx.Enqueue(info.Path)
+		}
+	})
+	store.SetTagLookup(func(instanceID string) map[string]string {
+		inst := findInstance(discovery, instanceID)
+		if inst == nil {
+			return nil
+		}
+		tags := map[string]string{cfg.Tag1: inst.Tag1Value, cfg.Tag2: inst.Tag2Value}
+		for k, v := range inst.Tags {
+			tags[k] = v
+		}
+		return tags
+	})
+	store.SetRemoveHook(func(name, reason string) {
+		auditLogger.Log(audit.AuditEvent{Action: "recording_expire", Actor: "system", InstanceID: recordings.InstanceID(name), Details: "file=" + name + " reason=" + reason})
+	})
+
+	if cfg.RecordingS3Bucket != "" {
+		bucket, err := recordings.NewBucket(context.Background(), recordings.BucketConfig{
+			Bucket:    cfg.RecordingS3Bucket,
+			Prefix:    cfg.RecordingS3Prefix,
+			Region:    cfg.RecordingS3Region,
+			Profile:   cfg.RecordingS3Profile,
+			Endpoint:  cfg.RecordingS3Endpoint,
+			PathStyle: cfg.RecordingS3PathStyle,
+			AccessKey: cfg.RecordingS3AccessKey,
+			SecretKey: cfg.RecordingS3SecretKey,
+			SSE:       cfg.RecordingS3SSE,
+			KMSKeyID:  cfg.RecordingS3KMSKeyID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		store.SetBucket(bucket)
+		logger.Printf("Recording offload enabled (s3://%s/%s)", cfg.RecordingS3Bucket, cfg.RecordingS3Prefix)
+	}
+	return store, nil
+}
+
+// vaultSSHKeys looks up SSH keys in the vault by the instance's match
+// rules. An entry without a username applies to every OS user.
+func vaultSSHKeys(store *vault.Store, discovery *aws.Discovery) aws.SSHKeyFunc {
+	return func(instanceID, osUser string) (*sshclient.Key, error) {
+		inst := findInstance(discovery, instanceID)
+		if inst == nil {
+			return nil, nil
+		}
+		entry, err := store.FindMatch(vault.KindSSH, instanceID, inst.Name, inst.Tag2Value, inst.AccountID)
+		if err != nil || entry.SSH == nil {
+			return nil, nil
+		}
+		if entry.SSH.Username != "" && entry.SSH.Username != osUser {
+			return nil, nil
+		}
+		key, err := sshclient.ParseKey([]byte(entry.SSH.PrivateKey), entry.SSH.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("vault entry %s: %w", entry.Rule.ID, err)
+		}
+		return key, nil
+	}
+}
+
+func findInstance(discovery *aws.Discovery, instanceID string) *types.EC2Instance {
+	instances, _ := discovery.GetAllInstances()
+	for i := range instances {
+		if instances[i].InstanceID == instanceID {
+			return &instances[i]
+		}
+	}
+	return nil
+}
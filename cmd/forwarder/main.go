@@ -14,10 +14,17 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"cloudterm-go/internal/ssmclient"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
-// ForwarderSession tracks an active SSM port forwarding session with its
-// associated socat relay process.
+// ForwarderSession tracks an active SSM port forwarding session: either a
+// native data channel serving the local port, or an aws CLI process with
+// its socat relay.
 type ForwarderSession struct {
 	InstanceID   string    `json:"instance_id"`
 	InstanceName string    `json:"instance_name"`
@@ -28,6 +35,8 @@ type ForwarderSession struct {
 	StartedAt    time.Time `json:"started_at"`
 	ssmProcess   *exec.Cmd
 	socatProcess *exec.Cmd
+	tunnel       *ssmclient.Session
+	listener     net.Listener
 }
 
 var (
@@ -37,6 +46,7 @@ var (
 
 	portRangeStart int
 	portRangeEnd   int
+	ssmClient      string // "native" or "cli"
 
 	logger *log.Logger
 )
@@ -47,6 +57,10 @@ func main() {
 	port := envInt("PORT", 5001)
 	portRangeStart = envInt("PORT_RANGE_START", 33890)
 	portRangeEnd = envInt("PORT_RANGE_END", 33999)
+	ssmClient = os.Getenv("SSM_CLIENT")
+	if ssmClient == "" {
+		ssmClient = "native"
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", handleHealth)
@@ -119,22 +133,25 @@ func handleSessions(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, sessions)
 }
 
+// startRequest is the body of POST /start.
+type startRequest struct {
+	InstanceID         string `json:"instance_id"`
+	InstanceName       string `json:"instance_name"`
+	AWSProfile         string `json:"aws_profile"`
+	AWSRegion          string `json:"aws_region"`
+	PortNumber         int    `json:"port_number"`
+	AWSAccessKeyID     string `json:"aws_access_key_id"`
+	AWSSecretAccessKey string `json:"aws_secret_access_key"`
+	AWSSessionToken    string `json:"aws_session_token"`
+}
+
 func handleStart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		InstanceID         string `json:"instance_id"`
-		InstanceName       string `json:"instance_name"`
-		AWSProfile         string `json:"aws_profile"`
-		AWSRegion          string `json:"aws_region"`
-		PortNumber         int    `json:"port_number"`
-		AWSAccessKeyID     string `json:"aws_access_key_id"`
-		AWSSecretAccessKey string `json:"aws_secret_access_key"`
-		AWSSessionToken    string `json:"aws_session_token"`
-	}
+	var req startRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
 		return
@@ -174,6 +191,39 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var sess *ForwarderSession
+	if ssmClient == "cli" {
+		sess, err = startCLI(req, allocatedPort)
+	} else {
+		sess, err = startNative(r.Context(), req, allocatedPort)
+	}
+	if err != nil {
+		logger.Printf("Failed to start forwarding for %s: %v", req.InstanceID, err)
+		mu.Lock()
+		delete(allocatedPorts, allocatedPort)
+		mu.Unlock()
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	mu.Lock()
+	activeSessions[sessionKey] = sess
+	mu.Unlock()
+
+	go monitorSession(sessionKey)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":        "started",
+		"instance_id":   req.InstanceID,
+		"port":          allocatedPort,
+		"remote_port":   req.PortNumber,
+		"instance_name": req.InstanceName,
+	})
+}
+
+// startCLI forwards allocatedPort with aws ssm start-session (listening on
+// an internal port) and a socat relay.
+func startCLI(req startRequest, allocatedPort int) (*ForwarderSession, error) {
 	internalPort := allocatedPort + 10000
 
 	// Start SSM port forwarding.
@@ -207,12 +257,7 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 	ssmCmd.Stderr = os.Stderr
 
 	if err := ssmCmd.Start(); err != nil {
-		logger.Printf("Failed to start SSM session for %s: %v", req.InstanceID, err)
-		mu.Lock()
-		delete(allocatedPorts, allocatedPort)
-		mu.Unlock()
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start SSM session"})
-		return
+		return nil, fmt.Errorf("failed to start SSM session: %w", err)
 	}
 	logger.Printf("SSM process started for %s (pid %d, internal port %d)", req.InstanceID, ssmCmd.Process.Pid, internalPort)
 
@@ -242,13 +287,8 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 	socatCmd.Stderr = os.Stderr
 
 	if err := socatCmd.Start(); err != nil {
-		logger.Printf("Failed to start socat for %s: %v", req.InstanceID, err)
 		_ = ssmCmd.Process.Kill()
-		mu.Lock()
-		delete(allocatedPorts, allocatedPort)
-		mu.Unlock()
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start socat relay"})
-		return
+		return nil, fmt.Errorf("failed to start socat relay: %w", err)
 	}
 	logger.Printf("Socat relay started for %s (pid %d, port %d -> %d)", req.InstanceID, socatCmd.Process.Pid, allocatedPort, internalPort)
 
@@ -262,7 +302,7 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(200 * time.Millisecond)
 	}
 
-	sess := &ForwarderSession{
+	return &ForwarderSession{
 		InstanceID:   req.InstanceID,
 		InstanceName: req.InstanceName,
 		LocalPort:    allocatedPort,
@@ -272,21 +312,62 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 		StartedAt:    time.Now(),
 		ssmProcess:   ssmCmd,
 		socatProcess: socatCmd,
-	}
-
-	mu.Lock()
-	activeSessions[sessionKey] = sess
-	mu.Unlock()
+	}, nil
+}
 
-	go monitorSession(sessionKey)
+// startNative opens the port forwarding session with the Go data channel
+// client and serves allocatedPort directly.
+func startNative(ctx context.Context, req startRequest, allocatedPort int) (*ForwarderSession, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", allocatedPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", allocatedPort, err)
+	}
+	client, err := newSSMClient(ctx, req)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	tunnel, err := ssmclient.StartPortForward(ctx, client, req.InstanceID, req.PortNumber)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	logger.Printf("SSM session %s started for %s (port %d -> %d)", tunnel.ID, req.InstanceID, allocatedPort, req.PortNumber)
+	go func() {
+		if err := tunnel.Serve(ln); err != nil {
+			logger.Printf("Port forwarding for %s ended: %v", req.InstanceID, err)
+		}
+		tunnel.Close()
+	}()
+	return &ForwarderSession{
+		InstanceID:   req.InstanceID,
+		InstanceName: req.InstanceName,
+		LocalPort:    allocatedPort,
+		RemotePort:   req.PortNumber,
+		AWSProfile:   req.AWSProfile,
+		AWSRegion:    req.AWSRegion,
+		StartedAt:    time.Now(),
+		tunnel:       tunnel,
+		listener:     ln,
+	}, nil
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status":        "started",
-		"instance_id":   req.InstanceID,
-		"port":          allocatedPort,
-		"remote_port":   req.PortNumber,
-		"instance_name": req.InstanceName,
-	})
+// newSSMClient builds an SSM client from the request's explicit
+// credentials, or its profile.
+func newSSMClient(ctx context.Context, req startRequest) (*ssm.Client, error) {
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(req.AWSRegion)}
+	if req.AWSAccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(req.AWSAccessKeyID, req.AWSSecretAccessKey, req.AWSSessionToken),
+		))
+	} else if req.AWSProfile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(req.AWSProfile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return ssm.NewFromConfig(cfg), nil
 }
 
 func handleStop(w http.ResponseWriter, r *http.Request) {
@@ -324,8 +405,7 @@ func handleStop(w http.ResponseWriter, r *http.Request) {
 	delete(allocatedPorts, sess.LocalPort)
 	mu.Unlock()
 
-	killProcess(sess.socatProcess, "socat", sessionKey)
-	killProcess(sess.ssmProcess, "ssm", sessionKey)
+	stopSession(sess, sessionKey)
 
 	logger.Printf("Session stopped for %s (port %d freed)", sessionKey, sess.LocalPort)
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -360,7 +440,7 @@ func getAvailablePort() (int, error) {
 	return 0, fmt.Errorf("all ports in range %d-%d are exhausted", portRangeStart, portRangeEnd)
 }
 
-// monitorSession waits for the SSM process to exit, then cleans up.
+// monitorSession waits for the SSM session to end, then cleans up.
 func monitorSession(sessionKey string) {
 	mu.RLock()
 	sess, ok := activeSessions[sessionKey]
@@ -368,11 +448,16 @@ func monitorSession(sessionKey string) {
 		mu.RUnlock()
 		return
 	}
-	ssmCmd := sess.ssmProcess
+	ssmCmd, tunnel := sess.ssmProcess, sess.tunnel
 	mu.RUnlock()
 
-	err := ssmCmd.Wait()
-	logger.Printf("SSM process exited for %s: %v", sessionKey, err)
+	if tunnel != nil {
+		<-tunnel.Done()
+		logger.Printf("SSM session ended for %s: %v", sessionKey, tunnel.Err())
+	} else {
+		err := ssmCmd.Wait()
+		logger.Printf("SSM process exited for %s: %v", sessionKey, err)
+	}
 
 	mu.Lock()
 	sess, ok = activeSessions[sessionKey]
@@ -384,7 +469,7 @@ func monitorSession(sessionKey string) {
 	delete(allocatedPorts, sess.LocalPort)
 	mu.Unlock()
 
-	killProcess(sess.socatProcess, "socat", sessionKey)
+	stopSession(sess, sessionKey)
 	logger.Printf("Cleaned up session for %s (port %d freed)", sessionKey, sess.LocalPort)
 }
 
@@ -400,12 +485,24 @@ func cleanupAll() {
 	mu.Unlock()
 
 	for id, sess := range sessions {
-		killProcess(sess.socatProcess, "socat", id)
-		killProcess(sess.ssmProcess, "ssm", id)
+		stopSession(sess, id)
 		logger.Printf("Cleaned up session for %s", id)
 	}
 }
 
+// stopSession ends a forwarding session and releases its local port.
+func stopSession(sess *ForwarderSession, sessionKey string) {
+	if sess.tunnel != nil {
+		sess.listener.Close()
+		if err := sess.tunnel.Close(); err != nil {
+			logger.Printf("Failed to terminate SSM session for %s: %v", sessionKey, err)
+		}
+		return
+	}
+	killProcess(sess.socatProcess, "socat", sessionKey)
+	killProcess(sess.ssmProcess, "ssm", sessionKey)
+}
+
 // killProcess sends SIGKILL to a process if it is still running.
 func killProcess(cmd *exec.Cmd, name, instanceID string) {
 	if cmd == nil || cmd.Process == nil {
@@ -7,93 +7,160 @@ import (
 	"time"
 )
 
+// Outcomes recorded on audit events.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
 type AuditEvent struct {
-	Timestamp    string `json:"timestamp"`
-	Action       string `json:"action"`
-	InstanceID   string `json:"instance_id,omitempty"`
-	InstanceName string `json:"instance_name,omitempty"`
-	Profile      string `json:"profile,omitempty"`
-	Region       string `json:"region,omitempty"`
-	Details      string `json:"details,omitempty"`
+	Timestamp     string `json:"timestamp"`
+	Action        string `json:"action"`
+	Outcome       string `json:"outcome,omitempty"`
+	Actor         string `json:"actor,omitempty"`
+	SourceIP      string `json:"source_ip,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	SessionID     string `json:"session_id,omitempty"`     // login session of the actor
+	CorrelationID string `json:"correlation_id,omitempty"` // terminal session, tunnel, clone or request ID
+	InstanceID    string `json:"instance_id,omitempty"`
+	InstanceName  string `json:"instance_name,omitempty"`
+	AccountID     string `json:"account_id,omitempty"`
+	Profile       string `json:"profile,omitempty"`
+	Region        string `json:"region,omitempty"`
+	Details       string `json:"details,omitempty"`
+	// PrevHash and Hash chain each record to its predecessor; see chain.go.
+	// Hash must stay the last field.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
+// Logger appends hash-chained events to a JSON-lines file and rotates it
+// into gzip-compressed segments by size and age.
 type Logger struct {
-	path string
-	mu   sync.Mutex
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	opened   time.Time
+	lastHash string
+	gzipWG   sync.WaitGroup
+	sinks    *Dispatcher
 }
 
-func NewLogger(path string) *Logger {
-	return &Logger{path: path}
+// NewLogger opens the audit log at path. A segment is rotated once it
+// exceeds maxBytes or is older than maxAge; zero disables either limit.
+func NewLogger(path string, maxBytes int64, maxAge time.Duration) *Logger {
+	l := &Logger{path: path, maxBytes: maxBytes, maxAge: maxAge}
+	l.lastHash = lastChainHash(path)
+	return l
 }
 
 func (l *Logger) Log(event AuditEvent) {
 	if event.Timestamp == "" {
 		event.Timestamp = time.Now().UTC().Format(time.RFC3339)
 	}
-	line, err := json.Marshal(event)
-	if err != nil {
-		return
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
 	}
-	line = append(line, '\n')
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	event.PrevHash = l.lastHash
+	event.Hash = ""
+	body, err := json.Marshal(event)
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	f.Write(line)
-}
+	hash := recordHash(body)
+	line := appendHash(body, hash)
 
-func (l *Logger) Recent(limit, offset int) []AuditEvent {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	data, err := os.ReadFile(l.path)
+	if err := l.openLocked(); err != nil {
+		return
+	}
+	if l.needsRotateLocked(int64(len(line))) {
+		l.rotateLocked()
+		if err := l.openLocked(); err != nil {
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
 	if err != nil {
-		return nil
+		return
+	}
+	l.lastHash = hash
+	if l.sinks != nil {
+		event.Hash = hash
+		l.sinks.Enqueue(event)
 	}
+}
+
+// SetDispatcher forwards every logged event to the dispatcher's sinks.
+func (l *Logger) SetDispatcher(d *Dispatcher) {
+	l.mu.Lock()
+	l.sinks = d
+	l.mu.Unlock()
+}
 
-	lines := splitNonEmpty(data)
-	// Reverse so newest is first
-	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
-		lines[i], lines[j] = lines[j], lines[i]
+// Close closes the active segment and waits for pending compression.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+		l.file = nil
 	}
+	l.mu.Unlock()
+	l.gzipWG.Wait()
+	return err
+}
 
-	if offset >= len(lines) {
+func (l *Logger) openLocked() error {
+	if l.file != nil {
 		return nil
 	}
-	end := offset + limit
-	if end > len(lines) {
-		end = len(lines)
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
 	}
-	slice := lines[offset:end]
+	l.file = f
+	l.size = info.Size()
+	l.opened = segmentStart(l.path, info)
+	return nil
+}
 
-	var events []AuditEvent
-	for _, line := range slice {
-		var ev AuditEvent
-		if err := json.Unmarshal(line, &ev); err == nil {
-			events = append(events, ev)
-		}
+func (l *Logger) needsRotateLocked(next int64) bool {
+	if l.size == 0 {
+		return false
 	}
-	return events
+	if l.maxBytes > 0 && l.size+next > l.maxBytes {
+		return true
+	}
+	return l.maxAge > 0 && time.Since(l.opened) > l.maxAge
 }
 
-func splitNonEmpty(data []byte) [][]byte {
-	var result [][]byte
-	start := 0
-	for i := 0; i < len(data); i++ {
-		if data[i] == '\n' {
-			if i > start {
-				result = append(result, data[start:i])
-			}
-			start = i + 1
-		}
-	}
-	if start < len(data) {
-		result = append(result, data[start:])
+// rotateLocked renames the active file to a timestamped segment and
+// compresses it in the background. The chain continues in the new file.
+func (l *Logger) rotateLocked() {
+	l.file.Close()
+	l.file = nil
+	rotated := rotatedName(l.path, time.Now())
+	if err := os.Rename(l.path, rotated); err != nil {
+		return
 	}
-	return result
+	l.gzipWG.Add(1)
+	go func() {
+		defer l.gzipWG.Done()
+		compressSegment(rotated)
+	}()
 }
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEvents(l *Logger, from, to int) {
+	for i := from; i < to; i++ {
+		l.Log(AuditEvent{Action: "session_start", InstanceID: fmt.Sprintf("i-%03d", i)})
+	}
+}
+
+func TestRotationAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLogger(path, 2048, 0)
+	writeEvents(l, 0, 50)
+	l.Close()
+
+	segs := segments(path)
+	if len(segs) < 3 {
+		t.Fatalf("expected rotation into several segments, got %v", segs)
+	}
+	for _, s := range segs[:len(segs)-1] {
+		if !strings.HasSuffix(s, ".gz") {
+			t.Errorf("rotated segment %s not compressed", s)
+		}
+	}
+
+	res, err := Verify(path)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if res.Records != 50 || res.Segments != len(segs) {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// A restarted logger continues the existing chain.
+	l = NewLogger(path, 2048, 0)
+	writeEvents(l, 50, 55)
+	l.Close()
+	if res, err := Verify(path); err != nil || res.Records != 55 {
+		t.Fatalf("verify after restart: %+v, %v", res, err)
+	}
+}
+
+func TestRecentPaginatesAcrossSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLogger(path, 1024, 0)
+	writeEvents(l, 0, 40)
+	l.Close()
+
+	page := l.Recent(5, 0)
+	if len(page) != 5 || page[0].InstanceID != "i-039" || page[4].InstanceID != "i-035" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page = l.Recent(10, 25)
+	if len(page) != 10 || page[0].InstanceID != "i-014" || page[9].InstanceID != "i-005" {
+		t.Fatalf("unexpected page at offset 25: %+v", page)
+	}
+	if got := l.Recent(10, 35); len(got) != 5 || got[4].InstanceID != "i-000" {
+		t.Fatalf("unexpected last page %+v", got)
+	}
+	if got := l.Recent(10, 40); len(got) != 0 {
+		t.Fatalf("expected empty page, got %d", len(got))
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	cases := map[string]func(lines [][]byte) [][]byte{
+		"edit": func(lines [][]byte) [][]byte {
+			lines[2] = bytes.Replace(lines[2], []byte("i-002"), []byte("i-999"), 1)
+			return lines
+		},
+		"delete": func(lines [][]byte) [][]byte {
+			return append(lines[:3], lines[4:]...)
+		},
+		"reorder": func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		},
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			l := NewLogger(path, 0, 0)
+			writeEvents(l, 0, 6)
+			l.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tamper(splitNonEmpty(data))
+			if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Verify(path); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsTailTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLogger(path, 0, 0)
+	writeEvents(l, 0, 6)
+	l.Close()
+	res, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor := res.Head
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := splitNonEmpty(data)
+	if err := os.WriteFile(path, append(bytes.Join(lines[:4], []byte("\n")), '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err != nil {
+		t.Fatalf("truncated chain is still intact on its own: %v", err)
+	}
+	if _, err := Verify(path, anchor); err == nil {
+		t.Error("expected verification against the old head to fail")
+	}
+
+	// The log may have grown past an anchor.
+	l = NewLogger(path, 0, 0)
+	writeEvents(l, 4, 6)
+	res, _ = Verify(path)
+	head := res.Head
+	writeEvents(l, 6, 7)
+	l.Close()
+	if _, err := Verify(path, head); err != nil {
+		t.Errorf("verify with an older anchor: %v", err)
+	}
+}
+
+func TestVerifyAcceptsLegacyPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	legacy := `{"timestamp":"2025-01-01T00:00:00Z","action":"session_start","instance_id":"i-old"}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLogger(path, 0, 0)
+	writeEvents(l, 0, 3)
+	l.Close()
+
+	res, err := Verify(path)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if res.Legacy != 1 || res.Records != 3 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
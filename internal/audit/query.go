@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Filter selects audit events. Zero-valued fields match everything.
+type Filter struct {
+	Since      time.Time
+	Until      time.Time
+	Action     string // exact, case-insensitive
+	InstanceID string
+	Account    string // account ID or profile
+	Actor      string // exact, case-insensitive
+	Outcome    string
+	Text       string // case-insensitive substring of any text field
+}
+
+// Match reports whether ev satisfies every condition of f.
+func (f Filter) Match(ev AuditEvent) bool {
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+		if err != nil {
+			return false
+		}
+		if !f.Since.IsZero() && ts.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && ts.After(f.Until) {
+			return false
+		}
+	}
+	if f.Action != "" && !strings.EqualFold(ev.Action, f.Action) {
+		return false
+	}
+	if f.InstanceID != "" && ev.InstanceID != f.InstanceID {
+		return false
+	}
+	if f.Account != "" && ev.AccountID != f.Account && ev.Profile != f.Account {
+		return false
+	}
+	if f.Actor != "" && !strings.EqualFold(ev.Actor, f.Actor) {
+		return false
+	}
+	if f.Outcome != "" && !strings.EqualFold(ev.Outcome, f.Outcome) {
+		return false
+	}
+	if f.Text != "" {
+		needle := strings.ToLower(f.Text)
+		found := false
+		for _, v := range []string{ev.Action, ev.Actor, ev.SourceIP, ev.CorrelationID, ev.InstanceID, ev.InstanceName, ev.AccountID, ev.Profile, ev.Region, ev.Details} {
+			if strings.Contains(strings.ToLower(v), needle) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan calls fn for every event matching f, newest first, until fn returns
+// false. Segments are read newest first and scanning stops at the first
+// event older than f.Since.
+func (l *Logger) Scan(f Filter, fn func(AuditEvent) bool) {
+	l.mu.Lock()
+	segs := segments(l.path)
+	l.mu.Unlock()
+
+	stop := false
+	for i := len(segs) - 1; i >= 0 && !stop; i-- {
+		eachLineReverse(segs[i], func(line []byte) bool {
+			var ev AuditEvent
+			if err := json.Unmarshal(line, &ev); err != nil {
+				return true
+			}
+			if !f.Since.IsZero() {
+				if ts, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil && ts.Before(f.Since) {
+					stop = true
+					return false
+				}
+			}
+			if !f.Match(ev) {
+				return true
+			}
+			if !fn(ev) {
+				stop = true
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// Query returns up to limit matching events (all if limit <= 0), newest
+// first, after skipping offset matches.
+func (l *Logger) Query(f Filter, limit, offset int) []AuditEvent {
+	var events []AuditEvent
+	skipped := 0
+	l.Scan(f, func(ev AuditEvent) bool {
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		events = append(events, ev)
+		return limit <= 0 || len(events) < limit
+	})
+	return events
+}
+
+// csvColumns is the header row written by CSVWriter.
+var csvColumns = []string{
+	"timestamp", "action", "outcome", "actor", "source_ip", "user_agent", "session_id",
+	"correlation_id", "instance_id", "instance_name", "account_id", "profile", "region", "details", "hash",
+}
+
+// CSVWriter streams events as CSV with a header row.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (c *CSVWriter) Write(ev AuditEvent) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		ev.Timestamp, ev.Action, ev.Outcome, ev.Actor, ev.SourceIP, ev.UserAgent, ev.SessionID,
+		ev.CorrelationID, ev.InstanceID, ev.InstanceName, ev.AccountID, ev.Profile, ev.Region, ev.Details, ev.Hash,
+	})
+}
+
+// Flush writes the header if nothing was written yet and flushes buffered rows.
+func (c *CSVWriter) Flush() error {
+	if !c.header {
+		c.header = true
+		c.w.Write(csvColumns)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rotatedTimeFormat = "20060102T150405.000000000Z"
+
+// rotatedName returns the segment name for a file rotated at t, e.g.
+// audit.log.20250102T150405.000000000Z. Names sort chronologically.
+func rotatedName(path string, t time.Time) string {
+	return path + "." + t.UTC().Format(rotatedTimeFormat)
+}
+
+// segments lists the log's files oldest first: rotated segments (plain or
+// .gz) followed by the active file, if present.
+func segments(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	byBase := make(map[string]string)
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".tmp") {
+			continue
+		}
+		base := strings.TrimSuffix(m, ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, strings.TrimPrefix(base, path+".")); err != nil {
+			continue
+		}
+		// Prefer the compressed copy if compression finished but the
+		// original has not been removed yet.
+		if prev, ok := byBase[base]; !ok || !strings.HasSuffix(prev, ".gz") {
+			byBase[base] = m
+		}
+	}
+	bases := make([]string, 0, len(byBase))
+	for b := range byBase {
+		bases = append(bases, b)
+	}
+	sort.Strings(bases)
+	out := make([]string, 0, len(bases)+1)
+	for _, b := range bases {
+		out = append(out, byBase[b])
+	}
+	if _, err := os.Stat(path); err == nil {
+		out = append(out, path)
+	}
+	return out
+}
+
+// compressSegment gzips path to path.gz and removes the original.
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// readSegment returns the full (decompressed) contents of a segment.
+func readSegment(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(path, ".gz") {
+		return io.ReadAll(f)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// eachLineReverse calls fn for every non-empty line of the segment, newest
+// first, until fn returns false. Plain files are read backwards in blocks so
+// only the requested page is held in memory.
+func eachLineReverse(path string, fn func(line []byte) bool) error {
+	if strings.HasSuffix(path, ".gz") {
+		data, err := readSegment(path)
+		if err != nil {
+			return err
+		}
+		lines := splitNonEmpty(data)
+		for i := len(lines) - 1; i >= 0; i-- {
+			if !fn(lines[i]) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	const blockSize = 64 * 1024
+	pos := info.Size()
+	var carry []byte
+	for pos > 0 {
+		n := int64(blockSize)
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+		block := make([]byte, n, n+int64(len(carry)))
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return err
+		}
+		data := append(block, carry...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if line := data[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			data = data[:i]
+		}
+		carry = data
+	}
+	if len(carry) > 0 {
+		fn(carry)
+	}
+	return nil
+}
+
+// segmentStart returns when the active segment was started: the timestamp
+// of its first record, or the file's modification time.
+func segmentStart(path string, info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return time.Now()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return info.ModTime()
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadBytes('\n')
+	var ev AuditEvent
+	if json.Unmarshal(line, &ev) == nil {
+		if t, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil {
+			return t
+		}
+	}
+	return info.ModTime()
+}
+
+// lastChainHash returns the hash of the newest record so a restarted
+// logger continues the chain.
+func lastChainHash(path string) string {
+	segs := segments(path)
+	for i := len(segs) - 1; i >= 0; i-- {
+		var hash string
+		found := false
+		eachLineReverse(segs[i], func(line []byte) bool {
+			var ev AuditEvent
+			if json.Unmarshal(line, &ev) != nil {
+				return true
+			}
+			hash, found = ev.Hash, true
+			return false
+		})
+		if found {
+			return hash
+		}
+	}
+	return ""
+}
+
+// Recent returns up to limit events, newest first, skipping the newest
+// offset events.
+func (l *Logger) Recent(limit, offset int) []AuditEvent {
+	return l.Query(Filter{}, limit, offset)
+}
+
+func splitNonEmpty(data []byte) [][]byte {
+	var result [][]byte
+	start := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\n' {
+			if i > start {
+				result = append(result, data[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		result = append(result, data[start:])
+	}
+	return result
+}
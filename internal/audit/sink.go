@@ -0,0 +1,291 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sink delivers audit events to an external collector.
+type Sink interface {
+	// Name identifies the sink; it also names the sink's spool file.
+	Name() string
+	Send(ev AuditEvent) error
+	Close() error
+}
+
+const (
+	sinkQueueSize   = 1024
+	minRetryBackoff = time.Second
+	maxRetryBackoff = 5 * time.Minute
+)
+
+// Dispatcher fans events out to sinks. Each sink has its own worker; events
+// that cannot be delivered are appended to a per-sink spool file and
+// retried with exponential backoff, so nothing is lost while a collector is
+// down or across restarts. Delivery order is preserved per sink.
+type Dispatcher struct {
+	workers []*sinkWorker
+}
+
+// NewDispatcher starts a worker for each sink. Spool files are kept in
+// spoolDir.
+func NewDispatcher(spoolDir string, logger *log.Logger, sinks ...Sink) (*Dispatcher, error) {
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		return nil, fmt.Errorf("create audit spool dir: %w", err)
+	}
+	d := &Dispatcher{}
+	for _, s := range sinks {
+		w := &sinkWorker{
+			sink:   s,
+			spool:  filepath.Join(spoolDir, s.Name()+".spool"),
+			logger: logger,
+			queue:  make(chan AuditEvent, sinkQueueSize),
+			kick:   make(chan struct{}, 1),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+		if info, err := os.Stat(w.spool); err == nil && info.Size() > 0 {
+			w.spooled = true
+		}
+		d.workers = append(d.workers, w)
+		go w.run()
+	}
+	return d, nil
+}
+
+// Enqueue hands ev to every sink without blocking the caller.
+func (d *Dispatcher) Enqueue(ev AuditEvent) {
+	for _, w := range d.workers {
+		w.enqueue(ev)
+	}
+}
+
+// Close stops the workers, spooling anything still queued, and closes the
+// sinks.
+func (d *Dispatcher) Close() {
+	for _, w := range d.workers {
+		close(w.stop)
+		<-w.done
+		w.sink.Close()
+	}
+}
+
+type sinkWorker struct {
+	sink   Sink
+	spool  string
+	logger *log.Logger
+	queue  chan AuditEvent
+	kick   chan struct{} // overflow is waiting to be spooled
+	stop   chan struct{}
+	done   chan struct{}
+
+	omu      sync.Mutex // guards overflow; never held during I/O
+	overflow []AuditEvent
+
+	mu      sync.Mutex // guards the spool file and spooled
+	spooled bool
+}
+
+// enqueue queues ev for the worker. When the queue is full the event waits
+// in memory until the worker spools it, and later events wait behind it so
+// order is kept. It never waits for the worker, the sink or the disk.
+func (w *sinkWorker) enqueue(ev AuditEvent) {
+	w.omu.Lock()
+	defer w.omu.Unlock()
+	if len(w.overflow) == 0 {
+		select {
+		case w.queue <- ev:
+			return
+		default:
+		}
+	}
+	w.overflow = append(w.overflow, ev)
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	backoff := minRetryBackoff
+	for {
+		var retry <-chan time.Time
+		if w.hasSpool() {
+			retry = time.After(backoff)
+		}
+		select {
+		case ev := <-w.queue:
+			w.deliver(ev)
+		case <-w.kick:
+			w.spillOverflow(w.deliver)
+		case <-retry:
+			if w.drainSpool() {
+				backoff = minRetryBackoff
+			} else if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		case <-w.stop:
+			w.spillOverflow(w.appendSpool)
+			return
+		}
+	}
+}
+
+// deliver sends ev, or spools it when the sink fails. While older events
+// wait in the spool, new ones queue behind them.
+func (w *sinkWorker) deliver(ev AuditEvent) {
+	if w.hasSpool() {
+		w.appendSpool(ev)
+		return
+	}
+	if err := w.sink.Send(ev); err != nil {
+		w.logger.Printf("audit sink %s: %v (spooling)", w.sink.Name(), err)
+		w.appendSpool(ev)
+	}
+}
+
+// spillOverflow passes the queued events to handle and then spools the
+// overflow, which is newer. Enqueue does not use the queue while there is
+// overflow, so the queue cannot refill in between.
+func (w *sinkWorker) spillOverflow(handle func(AuditEvent)) {
+	for len(w.queue) > 0 {
+		handle(<-w.queue)
+	}
+	w.omu.Lock()
+	events := w.overflow
+	w.overflow = nil
+	w.omu.Unlock()
+	for _, ev := range events {
+		w.appendSpool(ev)
+	}
+}
+
+func (w *sinkWorker) hasSpool() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.spooled
+}
+
+func (w *sinkWorker) appendSpool(ev AuditEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.OpenFile(w.spool, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		w.logger.Printf("audit sink %s: spool: %v (event dropped)", w.sink.Name(), err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		w.logger.Printf("audit sink %s: spool: %v (event dropped)", w.sink.Name(), err)
+		return
+	}
+	w.spooled = true
+}
+
+// drainSpool resends spooled events in order, a batch at a time. Sends run
+// without the lock so Enqueue's spooling never waits on the sink; delivered
+// lines are cut from the spool afterwards, keeping any appended meanwhile.
+// It stops at the first failure and reports whether everything was sent.
+func (w *sinkWorker) drainSpool() bool {
+	for {
+		batch, err := w.readSpool(sinkQueueSize)
+		if err != nil {
+			w.logger.Printf("audit sink %s: spool: %v", w.sink.Name(), err)
+			return false
+		}
+		if len(batch) == 0 {
+			return true
+		}
+		var sent int64
+		failed := false
+		for _, line := range batch {
+			var ev AuditEvent
+			if json.Unmarshal(line, &ev) == nil {
+				if err := w.sink.Send(ev); err != nil {
+					failed = true
+					break
+				}
+			}
+			sent += int64(len(line))
+		}
+		if err := w.trimSpool(sent); err != nil {
+			w.logger.Printf("audit sink %s: spool: %v", w.sink.Name(), err)
+			return false
+		}
+		if failed {
+			return false
+		}
+	}
+}
+
+// readSpool returns up to n complete lines from the head of the spool,
+// newline included.
+func (w *sinkWorker) readSpool(n int) ([][]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.Open(w.spool)
+	if os.IsNotExist(err) {
+		w.spooled = false
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines [][]byte
+	br := bufio.NewReader(f)
+	for len(lines) < n {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		os.Remove(w.spool)
+		w.spooled = false
+	}
+	return lines, nil
+}
+
+// trimSpool drops the first n bytes of the spool.
+func (w *sinkWorker) trimSpool(n int64) error {
+	if n == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.Open(w.spool)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(n, io.SeekStart); err != nil {
+		return err
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		w.spooled = false
+		return os.Remove(w.spool)
+	}
+	tmp := w.spool + ".tmp"
+	if err := os.WriteFile(tmp, rest, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.spool)
+}
@@ -0,0 +1,291 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flakySink struct {
+	mu   sync.Mutex
+	down bool
+	got  []string
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+func (s *flakySink) Close() error { return nil }
+func (s *flakySink) Send(ev AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("collector down")
+	}
+	s.got = append(s.got, ev.InstanceID)
+	return nil
+}
+
+func (s *flakySink) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.got...)
+}
+
+func TestDispatcherSpoolsAndRetries(t *testing.T) {
+	sink := &flakySink{down: true}
+	d, err := NewDispatcher(t.TempDir(), log.New(io.Discard, "", 0), sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.Enqueue(AuditEvent{Action: "a", InstanceID: "1"})
+	d.Enqueue(AuditEvent{Action: "a", InstanceID: "2"})
+	time.Sleep(100 * time.Millisecond)
+	sink.mu.Lock()
+	sink.down = false
+	sink.mu.Unlock()
+	d.Enqueue(AuditEvent{Action: "a", InstanceID: "3"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(sink.received()) < 3 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := strings.Join(sink.received(), ","); got != "1,2,3" {
+		t.Fatalf("expected in-order delivery 1,2,3 after recovery, got %q", got)
+	}
+}
+
+func TestDispatcherSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	down := &flakySink{down: true}
+	d, err := NewDispatcher(dir, log.New(io.Discard, "", 0), down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Enqueue(AuditEvent{Action: "a", InstanceID: "1"})
+	time.Sleep(100 * time.Millisecond)
+	d.Close()
+
+	up := &flakySink{}
+	d, err = NewDispatcher(dir, log.New(io.Discard, "", 0), up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(up.received()) == 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := up.received(); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("expected spooled event after restart, got %v", got)
+	}
+}
+
+// stallSink fails while down; once up, its first Send blocks until release
+// is closed.
+type stallSink struct {
+	flakySink
+	stalled bool
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *stallSink) Send(ev AuditEvent) error {
+	s.mu.Lock()
+	if !s.down && !s.stalled {
+		s.stalled = true
+		s.mu.Unlock()
+		close(s.entered)
+		<-s.release
+	} else {
+		s.mu.Unlock()
+	}
+	return s.flakySink.Send(ev)
+}
+
+func TestLogNotBlockedByStalledSink(t *testing.T) {
+	sink := &stallSink{flakySink: flakySink{down: true}, entered: make(chan struct{}), release: make(chan struct{})}
+	d, err := NewDispatcher(t.TempDir(), log.New(io.Discard, "", 0), sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	l := NewLogger(t.TempDir()+"/audit.log", 0, 0)
+	defer l.Close()
+	l.SetDispatcher(d)
+
+	l.Log(AuditEvent{Action: "a", InstanceID: "0"})
+	time.Sleep(100 * time.Millisecond)
+	sink.mu.Lock()
+	sink.down = false
+	sink.mu.Unlock()
+	select {
+	case <-sink.entered: // the retry is now stuck sending the spooled event
+	case <-time.After(5 * time.Second):
+		t.Fatal("spool was not retried")
+	}
+
+	const n = 2*sinkQueueSize + 1
+	logged := make(chan struct{})
+	go func() {
+		for i := 1; i <= n; i++ {
+			l.Log(AuditEvent{Action: "a", InstanceID: strconv.Itoa(i)})
+		}
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Log blocked behind a stalled sink")
+	}
+
+	close(sink.release)
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && len(sink.received()) < n+1 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	got := sink.received()
+	if len(got) != n+1 {
+		t.Fatalf("delivered %d of %d events", len(got), n+1)
+	}
+	for i, id := range got {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("event %d delivered as %s; order not kept", i, id)
+		}
+	}
+}
+
+func TestWebhookSinkSigns(t *testing.T) {
+	var body []byte
+	var ts, sig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		ts = r.Header.Get(WebhookTimestampHeader)
+		sig = r.Header.Get(WebhookSignatureHeader)
+	}))
+	defer srv.Close()
+
+	s := NewWebhookSink(srv.URL, "k")
+	if err := s.Send(AuditEvent{Action: "login", Actor: "alice"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if sig == "" || sig != SignWebhook([]byte("k"), ts, body) {
+		t.Errorf("bad signature %q", sig)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if err := NewWebhookSink(failing.URL, "").Send(AuditEvent{Action: "x"}); err == nil {
+		t.Error("expected non-2xx to be a delivery failure")
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	s, err := NewSyslogSink("tcp://"+ln.Addr().String(), FormatRFC5424)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ev := AuditEvent{Timestamp: "2025-01-02T03:04:05Z", Action: "access_denied", Outcome: OutcomeDenied, Actor: `bob "b"`, Details: "action=terminal\nx"}
+	if err := s.Send(ev); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "<84>1 2025-01-02T03:04:05Z ") {
+			t.Errorf("unexpected header: %s", line)
+		}
+		if !strings.Contains(line, ` access_denied [cloudterm@32473 outcome="denied" actor="bob \"b\""] action=terminal x`) {
+			t.Errorf("unexpected message: %s", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no syslog message received")
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	ev := AuditEvent{
+		Timestamp:  "2025-01-02T03:04:05Z",
+		Action:     "file_download",
+		Outcome:    OutcomeFailure,
+		Actor:      "alice",
+		InstanceID: "i-1",
+		Details:    "path=/etc/a=b|c",
+	}
+	got := FormatCEFMessage(ev, "host1")
+	want := `CEF:0|CloudTerm|CloudTerm|1.0|file_download|file_download|6|rt=1735787045000 dvchost=host1 outcome=failure suser=alice cs1Label=instanceId cs1=i-1 msg=path\=/etc/a\=b|c`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	l := NewLogger(t.TempDir()+"/audit.log", 0, 0)
+	defer l.Close()
+	l.Log(AuditEvent{Timestamp: "2025-01-01T10:00:00Z", Action: "session_start", Actor: "alice", InstanceID: "i-1", AccountID: "111"})
+	l.Log(AuditEvent{Timestamp: "2025-01-02T10:00:00Z", Action: "file_download", Actor: "bob", InstanceID: "i-2", Details: "path=/etc/passwd"})
+	l.Log(AuditEvent{Timestamp: "2025-01-03T10:00:00Z", Action: "session_start", Actor: "bob", InstanceID: "i-1", Profile: "manual:x", Outcome: OutcomeFailure})
+
+	since, _ := time.Parse(time.RFC3339, "2025-01-02T00:00:00Z")
+	cases := []struct {
+		name string
+		f    Filter
+		want int
+	}{
+		{"all", Filter{}, 3},
+		{"action", Filter{Action: "SESSION_START"}, 2},
+		{"user", Filter{Actor: "bob"}, 2},
+		{"instance", Filter{InstanceID: "i-1"}, 2},
+		{"account id", Filter{Account: "111"}, 1},
+		{"account profile", Filter{Account: "manual:x"}, 1},
+		{"text", Filter{Text: "PASSWD"}, 1},
+		{"since", Filter{Since: since}, 2},
+		{"until", Filter{Until: since}, 1},
+		{"outcome", Filter{Outcome: OutcomeFailure}, 1},
+	}
+	for _, c := range cases {
+		if got := len(l.Query(c.f, 0, 0)); got != c.want {
+			t.Errorf("%s: got %d events, want %d", c.name, got, c.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := NewCSVWriter(&buf)
+	for _, ev := range l.Query(Filter{Actor: "alice"}, 0, 0) {
+		cw.Write(ev)
+	}
+	cw.Flush()
+	if rows := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(rows) != 2 || !strings.HasPrefix(rows[0], "timestamp,action,") {
+		t.Errorf("unexpected csv %q", buf.String())
+	}
+}
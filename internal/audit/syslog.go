@@ -0,0 +1,226 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog message formats.
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatCEF     = "cef"
+)
+
+// syslog facility authpriv (10) as used for security/authorization messages.
+const facilityAuthPriv = 10
+
+// SyslogSink sends events to a syslog collector over UDP or TCP, either as
+// RFC 5424 structured data or as CEF (ArcSight Common Event Format) carried
+// in an RFC 5424 frame. TCP messages are newline-delimited.
+type SyslogSink struct {
+	name    string
+	network string
+	addr    string
+	format  string
+	host    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink parses target as "udp://host:port" or "tcp://host:port".
+func NewSyslogSink(target, format string) (*SyslogSink, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q (want udp://host:port or tcp://host:port)", target)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog transport %q", u.Scheme)
+	}
+	if format != FormatRFC5424 && format != FormatCEF {
+		return nil, fmt.Errorf("unsupported syslog format %q", format)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	return &SyslogSink{name: format, network: u.Scheme, addr: u.Host, format: format, host: host}, nil
+}
+
+func (s *SyslogSink) Name() string { return s.name }
+
+func (s *SyslogSink) Send(ev AuditEvent) error {
+	var msg string
+	if s.format == FormatCEF {
+		msg = FormatSyslog(ev, s.host, "", FormatCEFMessage(ev, s.host))
+	} else {
+		msg = FormatSyslog(ev, s.host, structuredData(ev), ev.Details)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if s.network == "tcp" {
+		msg += "\n"
+	}
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// severity maps an outcome to a syslog severity.
+func severity(ev AuditEvent) int {
+	switch ev.Outcome {
+	case OutcomeDenied:
+		return 4 // warning
+	case OutcomeFailure:
+		return 5 // notice
+	}
+	return 6 // informational
+}
+
+// FormatSyslog renders an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func FormatSyslog(ev AuditEvent, host, sd, msg string) string {
+	if sd == "" {
+		sd = "-"
+	}
+	ts := ev.Timestamp
+	if ts == "" {
+		ts = "-"
+	}
+	msgID := sanitizeHeader(ev.Action, 32)
+	line := fmt.Sprintf("<%d>1 %s %s cloudterm %d %s %s", facilityAuthPriv*8+severity(ev), ts, sanitizeHeader(host, 255), os.Getpid(), msgID, sd)
+	if msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg); msg != "" {
+		line += " " + msg
+	}
+	return line
+}
+
+// sanitizeHeader keeps printable ASCII without spaces, as RFC 5424 requires
+// for header fields.
+func sanitizeHeader(v string, max int) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+		if b.Len() == max {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+// sdEnterpriseID is the private enterprise number used for the structured
+// data element (the IANA documentation example).
+const sdEnterpriseID = "32473"
+
+func structuredData(ev AuditEvent) string {
+	params := [][2]string{
+		{"outcome", ev.Outcome},
+		{"actor", ev.Actor},
+		{"src", ev.SourceIP},
+		{"session", ev.SessionID},
+		{"correlation", ev.CorrelationID},
+		{"instance", ev.InstanceID},
+		{"account", ev.AccountID},
+		{"profile", ev.Profile},
+		{"region", ev.Region},
+		{"hash", ev.Hash},
+	}
+	var b strings.Builder
+	b.WriteString("[cloudterm@" + sdEnterpriseID)
+	for _, p := range params {
+		if p[1] == "" {
+			continue
+		}
+		b.WriteString(" " + p[0] + `="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(p[1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// FormatCEFMessage renders ev as a CEF:0 record.
+func FormatCEFMessage(ev AuditEvent, host string) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	sev := 3
+	switch ev.Outcome {
+	case OutcomeFailure:
+		sev = 6
+	case OutcomeDenied:
+		sev = 8
+	}
+	ext := [][2]string{
+		{"dvchost", host},
+		{"outcome", ev.Outcome},
+		{"suser", ev.Actor},
+		{"src", ev.SourceIP},
+		{"requestClientApplication", ev.UserAgent},
+		{"cs1Label", "instanceId"}, {"cs1", ev.InstanceID},
+		{"cs2Label", "sessionId"}, {"cs2", ev.SessionID},
+		{"cs3Label", "correlationId"}, {"cs3", ev.CorrelationID},
+		{"cs4Label", "accountId"}, {"cs4", ev.AccountID},
+		{"cs5Label", "region"}, {"cs5", ev.Region},
+		{"cs6Label", "hash"}, {"cs6", ev.Hash},
+		{"msg", ev.Details},
+	}
+	if ts, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil {
+		ext = append([][2]string{{"rt", strconv.FormatInt(ts.UnixMilli(), 10)}}, ext...)
+	}
+	value := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|CloudTerm|CloudTerm|1.0|%s|%s|%d|",
+		header.Replace(ev.Action), header.Replace(ev.Action), sev)
+	first := true
+	for i := 0; i < len(ext); i++ {
+		k, v := ext[i][0], ext[i][1]
+		// Skip empty values, and labels whose value is empty.
+		if strings.HasSuffix(k, "Label") {
+			if i+1 < len(ext) && ext[i+1][1] == "" {
+				i++
+				continue
+			}
+		} else if v == "" {
+			continue
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(k + "=" + value.Replace(v))
+	}
+	return b.String()
+}
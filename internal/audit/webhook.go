@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook signature headers. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookTimestampHeader = "X-CloudTerm-Timestamp"
+	WebhookSignatureHeader = "X-CloudTerm-Signature"
+)
+
+// WebhookSink POSTs each event as JSON to an HTTP endpoint. Any non-2xx
+// response counts as a failed delivery.
+type WebhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhookSink(url, secret string) *WebhookSink {
+	return &WebhookSink{url: url, secret: []byte(secret), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ev AuditEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	if len(s.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(s.secret, ts, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error { return nil }
+
+// SignWebhook computes the signature header value for a webhook body.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
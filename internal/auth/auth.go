@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloudterm-go/internal/config"
+)
+
+// Authentication modes.
+const (
+	ModeNone  = "none"
+	ModeLocal = "local"
+	ModeOIDC  = "oidc"
+)
+
+// Identity describes the authenticated user behind a request.
+type Identity struct {
+	Subject  string   `json:"sub"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Provider string   `json:"provider"` // "local", "oidc" or "none"
+	// SessionID identifies the login session; it is assigned by
+	// IssueSession and correlates audit events across requests.
+	SessionID string `json:"sid,omitempty"`
+}
+
+// DisplayName returns the best human-readable name for the identity.
+func (id *Identity) DisplayName() string {
+	if id == nil {
+		return ""
+	}
+	if id.Email != "" {
+		return id.Email
+	}
+	if id.Name != "" {
+		return id.Name
+	}
+	return id.Subject
+}
+
+// anonymous is attached to every request when authentication is disabled.
+var anonymous = &Identity{Subject: "anonymous", Name: "anonymous", Provider: ModeNone}
+
+type ctxKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the identity stored in ctx, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(ctxKey{}).(*Identity)
+	return id
+}
+
+// Service issues and validates login sessions for the web UI.
+type Service struct {
+	mode    string
+	ttl     time.Duration
+	signer  *cookieSigner
+	users   *UserStore
+	oidc    *OIDCProvider
+	origins map[string]bool
+	proxies []*net.IPNet
+	logger  *log.Logger
+}
+
+// New builds the authentication service from configuration. The OIDC
+// provider metadata is fetched eagerly so misconfiguration fails at startup.
+func New(ctx context.Context, cfg *config.Config, logger *log.Logger) (*Service, error) {
+	mode := strings.ToLower(strings.TrimSpace(cfg.AuthMode))
+	if mode == "" {
+		mode = ModeNone
+	}
+	if mode != ModeNone && mode != ModeLocal && mode != ModeOIDC {
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", cfg.AuthMode)
+	}
+
+	secret := []byte(cfg.AuthSessionSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate session secret: %w", err)
+		}
+		if mode != ModeNone {
+			logger.Printf("warning: AUTH_SESSION_SECRET not set, sessions will not survive a restart")
+		}
+	}
+	key := sha256.Sum256(secret)
+
+	ttl := time.Duration(cfg.AuthSessionTTLHours) * time.Hour
+	if ttl <= 0 {
+		ttl = 12 * time.Hour
+	}
+
+	s := &Service{
+		mode:    mode,
+		ttl:     ttl,
+		signer:  &cookieSigner{key: key[:]},
+		origins: make(map[string]bool),
+		logger:  logger,
+	}
+	for _, o := range strings.Split(cfg.AllowedOrigins, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			s.origins[strings.ToLower(o)] = true
+		}
+	}
+	proxies, err := parseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	s.proxies = proxies
+
+	if mode == ModeNone {
+		logger.Printf("warning: authentication disabled (AUTH_MODE=none)")
+		return s, nil
+	}
+
+	users, err := LoadUsers(cfg.AuthUsersFile)
+	if err != nil {
+		return nil, err
+	}
+	s.users = users
+
+	if mode == ModeOIDC {
+		p, err := NewOIDCProvider(ctx, OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       splitList(cfg.OIDCScopes),
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.oidc = p
+	} else if users.Len() == 0 {
+		return nil, fmt.Errorf("AUTH_MODE=local but no users defined in %s", cfg.AuthUsersFile)
+	}
+	return s, nil
+}
+
+// Enabled reports whether requests must carry a login session.
+func (s *Service) Enabled() bool { return s.mode != ModeNone }
+
+// Mode returns the configured authentication mode.
+func (s *Service) Mode() string { return s.mode }
+
+// OIDC returns the OIDC provider, or nil when OIDC is not configured.
+func (s *Service) OIDC() *OIDCProvider { return s.oidc }
+
+// LocalLoginEnabled reports whether username/password login is available.
+func (s *Service) LocalLoginEnabled() bool {
+	return s.users != nil && s.users.Len() > 0
+}
+
+// Authenticate checks a local username and password.
+func (s *Service) Authenticate(username, password string) (*Identity, error) {
+	if s.users == nil {
+		return nil, fmt.Errorf("local login disabled")
+	}
+	return s.users.Verify(username, password)
+}
+
+// Identify returns the identity behind r, or nil if the request carries no
+// valid session. When authentication is disabled every request is anonymous.
+func (s *Service) Identify(r *http.Request) *Identity {
+	if !s.Enabled() {
+		return anonymous
+	}
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	var sess sessionClaims
+	if err := s.signer.decode(c.Value, &sess); err != nil {
+		return nil
+	}
+	if time.Now().Unix() > sess.Expires {
+		return nil
+	}
+	return &sess.Identity
+}
+
+// IssueSession sets the session cookie for id on w.
+func (s *Service) IssueSession(w http.ResponseWriter, r *http.Request, id *Identity) error {
+	exp := time.Now().Add(s.ttl)
+	if id.SessionID == "" {
+		id.SessionID = randomToken()
+	}
+	value, err := s.signer.encode(sessionClaims{Identity: *id, Expires: exp.Unix()})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   s.isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// ClearSession removes the session cookie.
+func (s *Service) ClearSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CheckOrigin reports whether the request's Origin header is the app itself
+// or one of the explicitly allowed origins. Requests without an Origin header
+// (non-browser clients) are accepted; they still need a session cookie.
+// X-Forwarded-Host names the app only when a trusted proxy sent it.
+func (s *Service) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" && s.fromProxy(r) && strings.EqualFold(u.Host, fwd) {
+		return true
+	}
+	return s.origins[strings.ToLower(strings.TrimRight(origin, "/"))]
+}
+
+// isSecure reports whether the browser reached us over TLS, directly or via
+// a trusted terminating proxy.
+func (s *Service) isSecure(r *http.Request) bool {
+	return r.TLS != nil || (s.fromProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https")
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
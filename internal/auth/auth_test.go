@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloudterm-go/internal/config"
+)
+
+func newTestService(t *testing.T, mode string) *Service {
+	t.Helper()
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+	users := `[{"username":"alice","password_hash":"` + hash + `","groups":["ops"]}]`
+	if err := os.WriteFile(usersFile, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		AuthMode:            mode,
+		AuthUsersFile:       usersFile,
+		AuthSessionSecret:   "test-secret",
+		AuthSessionTTLHours: 1,
+		AllowedOrigins:      "https://dev.example.com",
+	}
+	s, err := New(context.Background(), cfg, log.New(&bytes.Buffer{}, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestPasswordHashRoundTrip(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$") {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("expected password to match")
+	}
+	if CheckPassword(hash, "wrong") {
+		t.Error("expected wrong password to fail")
+	}
+	if CheckPassword("garbage", "correct horse") {
+		t.Error("expected malformed hash to fail")
+	}
+}
+
+func TestSessionCookieRoundTrip(t *testing.T) {
+	s := newTestService(t, ModeLocal)
+
+	id, err := s.Authenticate("Alice", "s3cret")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if _, err := s.Authenticate("alice", "nope"); err == nil {
+		t.Error("expected wrong password to be rejected")
+	}
+	if _, err := s.Authenticate("bob", "s3cret"); err == nil {
+		t.Error("expected unknown user to be rejected")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := s.IssueSession(rec, req, id); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("expected one HttpOnly cookie, got %+v", cookies)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/instances", nil)
+	req.AddCookie(cookies[0])
+	got := s.Identify(req)
+	if got == nil || got.Subject != "alice" || len(got.Groups) != 1 || got.Groups[0] != "ops" {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+
+	// Tampering with the payload must invalidate the cookie.
+	tampered := *cookies[0]
+	tampered.Value = "x" + tampered.Value
+	req = httptest.NewRequest(http.MethodGet, "/instances", nil)
+	req.AddCookie(&tampered)
+	if s.Identify(req) != nil {
+		t.Error("expected tampered cookie to be rejected")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/instances", nil)
+	if s.Identify(req) != nil {
+		t.Error("expected request without cookie to be rejected")
+	}
+}
+
+func TestIdentifyWhenDisabled(t *testing.T) {
+	s := newTestService(t, ModeNone)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	id := s.Identify(req)
+	if id == nil || id.Subject != "anonymous" {
+		t.Fatalf("expected anonymous identity, got %+v", id)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	s := newTestService(t, ModeNone)
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://cloudterm.local:5000", true},
+		{"https://dev.example.com", true},
+		{"https://dev.example.com/", true},
+		{"https://evil.example.com", false},
+		{"null", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "http://cloudterm.local:5000/ws", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if got := s.CheckOrigin(req); got != c.want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", c.origin, got, c.want)
+		}
+	}
+}
+
+func TestCheckOriginForwardedHost(t *testing.T) {
+	s := newTestService(t, ModeNone)
+	s.proxies, _ = parseProxies("10.0.0.1")
+	check := func(remote string) bool {
+		req := httptest.NewRequest(http.MethodGet, "http://cloudterm:5000/ws", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.Header.Set("X-Forwarded-Host", "evil.example.com")
+		return s.CheckOrigin(req)
+	}
+	if check("203.0.113.7:5555") {
+		t.Error("X-Forwarded-Host from an untrusted peer was honoured")
+	}
+	if !check("10.0.0.1:5555") {
+		t.Error("X-Forwarded-Host from a trusted proxy was ignored")
+	}
+}
+
+func TestIsSecureForwardedProto(t *testing.T) {
+	s := newTestService(t, ModeNone)
+	s.proxies, _ = parseProxies("10.0.0.1")
+	secure := func(remote string) bool {
+		req := httptest.NewRequest(http.MethodGet, "http://cloudterm:5000/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		return s.isSecure(req)
+	}
+	if secure("203.0.113.7:5555") {
+		t.Error("X-Forwarded-Proto from an untrusted peer was honoured")
+	}
+	if !secure("10.0.0.1:5555") {
+		t.Error("X-Forwarded-Proto from a trusted proxy was ignored")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newTestService(t, ModeNone)
+	s.proxies, _ = parseProxies("10.0.0.0/8, 192.168.1.1")
+	cases := []struct {
+		name   string
+		remote string
+		fwd    string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5555", "", "203.0.113.7"},
+		{"spoofed by untrusted peer", "203.0.113.7:5555", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:443", "198.51.100.1", "198.51.100.1"},
+		{"client-supplied hop ignored", "10.1.2.3:443", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "192.168.1.1:443", "198.51.100.1, 10.9.9.9", "198.51.100.1"},
+		{"trusted proxy without header", "10.1.2.3:443", "", "10.1.2.3"},
+		{"ipv6 peer", "[2001:db8::1]:443", "198.51.100.1", "2001:db8::1"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		if c.fwd != "" {
+			req.Header.Set("X-Forwarded-For", c.fwd)
+		}
+		if got := s.ClientIP(req); got != c.want {
+			t.Errorf("%s: ClientIP = %q, want %q", c.name, got, c.want)
+		}
+	}
+	if _, err := parseProxies("10.0.0.0/33"); err == nil {
+		t.Error("parseProxies accepted an invalid CIDR")
+	}
+}
+
+func TestNewRejectsLocalModeWithoutUsers(t *testing.T) {
+	cfg := &config.Config{AuthMode: ModeLocal, AuthUsersFile: filepath.Join(t.TempDir(), "missing.json")}
+	if _, err := New(context.Background(), cfg, log.New(&bytes.Buffer{}, "", 0)); err == nil {
+		t.Error("expected error when no local users are configured")
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Cookie names used by the login flow.
+const (
+	SessionCookie = "cloudterm_session"
+	oidcCookie    = "cloudterm_oidc"
+)
+
+var errBadCookie = errors.New("invalid cookie")
+
+// sessionClaims is the payload of the session cookie.
+type sessionClaims struct {
+	Identity
+	Expires int64 `json:"exp"`
+}
+
+// cookieSigner produces tamper-proof cookie values of the form
+// base64url(json) "." base64url(hmac-sha256(json)).
+type cookieSigner struct {
+	key []byte
+}
+
+func (c *cookieSigner) encode(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.mac(payload)), nil
+}
+
+func (c *cookieSigner) decode(value string, v interface{}) error {
+	body, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errBadCookie
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(body)
+	if err != nil {
+		return errBadCookie
+	}
+	got, err := enc.DecodeString(sig)
+	if err != nil {
+		return errBadCookie
+	}
+	if !hmac.Equal(got, c.mac(payload)) {
+		return errBadCookie
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func (c *cookieSigner) mac(payload []byte) []byte {
+	m := hmac.New(sha256.New, c.key)
+	m.Write(payload)
+	return m.Sum(nil)
+}
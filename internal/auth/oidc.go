@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig holds the relying-party settings for an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+}
+
+// OIDCProvider runs the authorization-code flow (with PKCE) against an
+// OpenID Connect identity provider.
+type OIDCProvider struct {
+	cfg         OIDCConfig
+	oauth       *oauth2.Config
+	userinfoURL string
+	client      *http.Client
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcState is kept in a short-lived signed cookie between the redirect to
+// the provider and the callback.
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+	Expires  int64  `json:"exp"`
+}
+
+// NewOIDCProvider fetches the provider's discovery document and prepares the
+// OAuth2 client configuration.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required for AUTH_MODE=oidc")
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	wellKnown := strings.TrimRight(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery: HTTP %d from %s", resp.StatusCode, wellKnown)
+	}
+	var disc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&disc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(disc.Issuer, "/") != strings.TrimRight(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q != %q", disc.Issuer, cfg.Issuer)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	return &OIDCProvider{
+		cfg: cfg,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  disc.AuthorizationEndpoint,
+				TokenURL: disc.TokenEndpoint,
+			},
+		},
+		userinfoURL: disc.UserinfoEndpoint,
+		client:      client,
+	}, nil
+}
+
+// BeginLogin starts the authorization-code flow: it stores state, nonce and
+// the PKCE verifier in a signed cookie and returns the provider URL to
+// redirect the browser to.
+func (s *Service) BeginLogin(w http.ResponseWriter, r *http.Request, next string) (string, error) {
+	if s.oidc == nil {
+		return "", fmt.Errorf("oidc not configured")
+	}
+	st := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     next,
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	value, err := s.signer.encode(st)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   s.isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return s.oidc.oauth.AuthCodeURL(st.State,
+		oauth2.S256ChallengeOption(st.Verifier),
+		oauth2.SetAuthURLParam("nonce", st.Nonce),
+	), nil
+}
+
+// CompleteLogin handles the provider callback: it validates state, exchanges
+// the code and returns the resulting identity and the post-login redirect.
+func (s *Service) CompleteLogin(w http.ResponseWriter, r *http.Request) (*Identity, string, error) {
+	if s.oidc == nil {
+		return nil, "", fmt.Errorf("oidc not configured")
+	}
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, "", fmt.Errorf("login state missing or expired")
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/auth/", MaxAge: -1})
+
+	var st oidcState
+	if err := s.signer.decode(c.Value, &st); err != nil || time.Now().Unix() > st.Expires {
+		return nil, "", fmt.Errorf("login state invalid or expired")
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("identity provider returned %s: %s", e, q.Get("error_description"))
+	}
+	if q.Get("state") != st.State {
+		return nil, "", fmt.Errorf("login state mismatch")
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, s.oidc.client)
+	tok, err := s.oidc.oauth.Exchange(ctx, q.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("token exchange: %w", err)
+	}
+	id, err := s.oidc.identity(ctx, tok, st.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	return id, st.Next, nil
+}
+
+// identity builds an Identity from the ID token and, when available, the
+// userinfo endpoint. The ID token is received directly from the token
+// endpoint over TLS, so per OIDC Core §3.1.3.7 its issuer, audience, expiry
+// and nonce are validated but the JWS signature is not re-checked here.
+func (p *OIDCProvider) identity(ctx context.Context, tok *oauth2.Token, nonce string) (*Identity, error) {
+	raw, _ := tok.Extra("id_token").(string)
+	if raw == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	claims, err := decodeJWTClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != strings.TrimRight(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("id_token issuer mismatch")
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, fmt.Errorf("id_token audience mismatch")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().Unix() > int64(exp) {
+		return nil, fmt.Errorf("id_token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("id_token nonce mismatch")
+	}
+
+	if p.userinfoURL != "" {
+		if info, err := p.userinfo(ctx, tok); err == nil {
+			for k, v := range info {
+				if _, exists := claims[k]; !exists {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	id := &Identity{Provider: ModeOIDC}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	if id.Name == "" {
+		id.Name, _ = claims["preferred_username"].(string)
+	}
+	id.Groups = stringList(claims[p.cfg.GroupsClaim])
+	if id.Subject == "" {
+		return nil, fmt.Errorf("id_token has no subject")
+	}
+	return id, nil
+}
+
+func (p *OIDCProvider) userinfo(ctx context.Context, tok *oauth2.Token) (map[string]interface{}, error) {
+	resp, err := p.oauth.Client(ctx, tok).Get(p.userinfoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo: HTTP %d", resp.StatusCode)
+	}
+	var info map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func decodeJWTClaims(raw string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token: %w", err)
+	}
+	return claims, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	for _, a := range stringList(aud) {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList accepts a claim that is either a string or an array of strings.
+func stringList(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return nil
+		}
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
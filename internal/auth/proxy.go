@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseProxies parses TRUSTED_PROXIES: addresses or CIDRs, comma-separated.
+func parseProxies(s string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, p := range splitList(s) {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", p)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", p, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// trusted reports whether addr is one of the configured proxies.
+func (s *Service) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if s == nil || ip == nil {
+		return false
+	}
+	for _, n := range s.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromProxy reports whether the request came straight from a trusted proxy,
+// so its X-Forwarded-* headers can be believed.
+func (s *Service) fromProxy(r *http.Request) bool {
+	return s.trusted(remoteHost(r))
+}
+
+// ClientIP returns the address of the client behind the request. The
+// X-Forwarded-For chain is followed from the right only through trusted
+// proxies; anyone else could have written it.
+func (s *Service) ClientIP(r *http.Request) string {
+	ip := remoteHost(r)
+	if !s.trusted(ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+		if !s.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteHost returns the host part of the peer address.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return strings.Trim(r.RemoteAddr, "[]")
+	}
+	return host
+}
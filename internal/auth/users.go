@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pbkdf2Iterations is the work factor for newly hashed local passwords.
+const pbkdf2Iterations = 600000
+
+var errInvalidLogin = errors.New("invalid username or password")
+
+// dummyHash is checked for unknown usernames; it never matches.
+var dummyHash = fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations, strings.Repeat("A", 22), strings.Repeat("A", 43))
+
+// LocalUser is a username/password account defined in AUTH_USERS_FILE.
+type LocalUser struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	DisplayName  string   `json:"display_name,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+// UserStore holds local user accounts loaded from a JSON file.
+type UserStore struct {
+	users map[string]LocalUser
+}
+
+// LoadUsers reads the local users file. A missing file yields an empty store.
+func LoadUsers(path string) (*UserStore, error) {
+	s := &UserStore{users: make(map[string]LocalUser)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read users file: %w", err)
+	}
+	var users []LocalUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("parse users file %s: %w", path, err)
+	}
+	for _, u := range users {
+		if u.Username == "" || u.PasswordHash == "" {
+			continue
+		}
+		s.users[strings.ToLower(u.Username)] = u
+	}
+	return s, nil
+}
+
+// Len returns the number of configured users.
+func (s *UserStore) Len() int { return len(s.users) }
+
+// Verify checks a username and password and returns the matching identity.
+func (s *UserStore) Verify(username, password string) (*Identity, error) {
+	u, ok := s.users[strings.ToLower(strings.TrimSpace(username))]
+	if !ok {
+		// Burn the same amount of time as a real check so usernames can't
+		// be enumerated by response timing.
+		_ = CheckPassword(dummyHash, password)
+		return nil, errInvalidLogin
+	}
+	if !CheckPassword(u.PasswordHash, password) {
+		return nil, errInvalidLogin
+	}
+	name := u.DisplayName
+	if name == "" {
+		name = u.Username
+	}
+	return &Identity{
+		Subject:  u.Username,
+		Name:     name,
+		Email:    u.Email,
+		Groups:   u.Groups,
+		Provider: ModeLocal,
+	}, nil
+}
+
+// HashPassword returns a password hash suitable for AUTH_USERS_FILE in the
+// form pbkdf2-sha256$<iterations>$<salt>$<hash>.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPassword reports whether password matches the encoded hash.
+func CheckPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
@@ -1,37 +1,202 @@
 package aws
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
+
+	"cloudterm-go/internal/crypto"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// roleSessionName identifies CloudTerm's role sessions in CloudTrail.
+	roleSessionName = "cloudterm"
+	// roleRefreshWindow is how long before expiry a role session is renewed.
+	roleRefreshWindow = 5 * time.Minute
+	// stsRegion is used for STS and other global calls in the commercial
+	// partition; see partitionSTSRegion for the others.
+	stsRegion = "us-east-1"
 )
 
-// ManualAccount represents an AWS account added via the UI with explicit credentials.
+// stsRegions maps AWS partitions to a region whose STS endpoint issues
+// credentials for them. STS only serves its own partition.
+var stsRegions = map[string]string{
+	"aws":        stsRegion,
+	"aws-cn":     "cn-north-1",
+	"aws-us-gov": "us-gov-west-1",
+	"aws-iso":    "us-iso-east-1",
+	"aws-iso-b":  "us-isob-east-1",
+}
+
+// partitionSTSRegion returns the region to assume roles in for a partition.
+func partitionSTSRegion(partition string) string {
+	if r, ok := stsRegions[partition]; ok {
+		return r
+	}
+	return stsRegion
+}
+
+// arnPartition returns the partition field of an ARN, e.g. "aws-cn".
+func arnPartition(arn string) string {
+	if parts := strings.SplitN(arn, ":", 3); len(parts) == 3 && parts[1] != "" {
+		return parts[1]
+	}
+	return "aws"
+}
+
+// ErrInvalidAccount is returned by Add for an incomplete account definition.
+var ErrInvalidAccount = errors.New("invalid account")
+
+// ErrNoKey is returned by Add for an account with secrets when the store has
+// no encryption key; new secrets are never stored in plaintext.
+var ErrNoKey = errors.New("aws account store has no encryption key")
+
+var roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+
+// assumeRoleClient builds the STS client role accounts assume their role
+// with; replaced in tests.
+var assumeRoleClient = func(cfg aws.Config) stscreds.AssumeRoleAPIClient {
+	return sts.NewFromConfig(cfg)
+}
+
+// ManualAccount represents an AWS account added via the UI. It either holds
+// explicit credentials or, with RoleARN set, assumes that role using the
+// explicit credentials (or the server's own when there are none).
 type ManualAccount struct {
 	ID              string `json:"id"`
 	Name            string `json:"name"`
-	AccessKeyID     string `json:"access_key_id"`
-	SecretAccessKey string `json:"secret_access_key"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
 	SessionToken    string `json:"session_token,omitempty"`
+	RoleARN         string `json:"role_arn,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	AddedAt         string `json:"added_at"`
+}
+
+// Masked returns a copy of a with its secrets hidden.
+func (a ManualAccount) Masked() ManualAccount {
+	if len(a.SecretAccessKey) > 4 {
+		a.SecretAccessKey = "****" + a.SecretAccessKey[len(a.SecretAccessKey)-4:]
+	} else if a.SecretAccessKey != "" {
+		a.SecretAccessKey = "****"
+	}
+	if a.SessionToken != "" {
+		a.SessionToken = "****"
+	}
+	if a.ExternalID != "" {
+		a.ExternalID = "****"
+	}
+	return a
+}
+
+func (a ManualAccount) validate() error {
+	if (a.AccessKeyID == "") != (a.SecretAccessKey == "") {
+		return fmt.Errorf("%w: access_key_id and secret_access_key go together", ErrInvalidAccount)
+	}
+	if a.RoleARN == "" {
+		if a.AccessKeyID == "" {
+			return fmt.Errorf("%w: access_key_id and secret_access_key, or role_arn, are required", ErrInvalidAccount)
+		}
+		if a.ExternalID != "" {
+			return fmt.Errorf("%w: external_id needs role_arn", ErrInvalidAccount)
+		}
+		return nil
+	}
+	if !roleARNPattern.MatchString(a.RoleARN) {
+		return fmt.Errorf("%w: role_arn %q is not an IAM role ARN", ErrInvalidAccount, a.RoleARN)
+	}
+	return nil
+}
+
+// storedAccount is a ManualAccount as written to disk. With an encryption
+// key its secrets are sealed in Secrets; the plaintext fields are read for
+// files written without one (or by earlier versions).
+type storedAccount struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	RoleARN         string `json:"role_arn,omitempty"`
 	AddedAt         string `json:"added_at"`
+	Secrets         string `json:"secrets,omitempty"` // base64 AES-256-GCM of accountSecrets
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+}
+
+type accountSecrets struct {
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
 }
 
 // AccountStore manages manually-added AWS accounts, persisted to a JSON file.
 type AccountStore struct {
-	path     string
-	accounts []ManualAccount
-	mu       sync.RWMutex
+	path      string
+	key       []byte   // AES-256 key for secrets; nil stores them in plaintext
+	previous  [][]byte // earlier keys secrets may still be sealed with
+	accounts  []ManualAccount
+	providers map[string]aws.CredentialsProvider
+	mu        sync.RWMutex
 }
 
 // NewAccountStore creates an AccountStore backed by the given file path.
-func NewAccountStore(path string) *AccountStore {
-	s := &AccountStore{path: path}
-	s.load()
-	return s
+// Secrets are encrypted with key (the vault key); without one only accounts
+// without secrets can be added, and secrets already stored in plaintext by
+// earlier versions are kept as they are. Plaintext files, and secrets sealed
+// with one of the previous keys, are re-encrypted with key on load.
+func NewAccountStore(path string, key []byte, previous ...[]byte) (*AccountStore, error) {
+	s := &AccountStore{path: path, key: key, previous: previous, providers: make(map[string]aws.CredentialsProvider)}
+	migrate, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if migrate {
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("encrypt aws accounts: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// RotateKey rewrites the accounts file with secrets sealed with r.Key.
+func (s *AccountStore) RotateKey(r crypto.Rotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldKey := s.key
+	s.key = r.Key
+	if err := s.save(); err != nil {
+		s.key = oldKey
+		return fmt.Errorf("aws accounts: %w", err)
+	}
+	return nil
+}
+
+// ForgetPreviousKeys drops the keys secrets were loaded with once every
+// store has been resealed with the new one.
+func (s *AccountStore) ForgetPreviousKeys() {
+	s.mu.Lock()
+	s.previous = nil
+	s.mu.Unlock()
+}
+
+// Encrypted reports whether secrets are encrypted at rest.
+func (s *AccountStore) Encrypted() bool {
+	return len(s.key) > 0
 }
 
 // ListRaw returns all stored accounts with full credentials (for internal use).
@@ -49,15 +214,7 @@ func (s *AccountStore) List() []ManualAccount {
 	defer s.mu.RUnlock()
 	out := make([]ManualAccount, len(s.accounts))
 	for i, a := range s.accounts {
-		out[i] = a
-		if len(a.SecretAccessKey) > 4 {
-			out[i].SecretAccessKey = "****" + a.SecretAccessKey[len(a.SecretAccessKey)-4:]
-		} else {
-			out[i].SecretAccessKey = "****"
-		}
-		if a.SessionToken != "" {
-			out[i].SessionToken = "****"
-		}
+		out[i] = a.Masked()
 	}
 	return out
 }
@@ -74,8 +231,16 @@ func (s *AccountStore) Get(id string) (ManualAccount, bool) {
 	return ManualAccount{}, false
 }
 
-// Add stores a new manual account and persists to disk.
-func (s *AccountStore) Add(name, accessKey, secretKey, sessionToken string) (ManualAccount, error) {
+// Add stores a new manual account and persists to disk. ID and AddedAt are
+// assigned by the store. It fails with ErrNoKey for an account with secrets
+// when the store has no encryption key.
+func (s *AccountStore) Add(acct ManualAccount) (ManualAccount, error) {
+	if err := acct.validate(); err != nil {
+		return ManualAccount{}, err
+	}
+	if !s.Encrypted() && (acct.SecretAccessKey != "" || acct.SessionToken != "" || acct.ExternalID != "") {
+		return ManualAccount{}, ErrNoKey
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -83,17 +248,14 @@ func (s *AccountStore) Add(name, accessKey, secretKey, sessionToken string) (Man
 	if err != nil {
 		return ManualAccount{}, err
 	}
-
-	acct := ManualAccount{
-		ID:              id,
-		Name:            name,
-		AccessKeyID:     accessKey,
-		SecretAccessKey: secretKey,
-		SessionToken:    sessionToken,
-		AddedAt:         time.Now().UTC().Format(time.RFC3339),
-	}
+	acct.ID = id
+	acct.AddedAt = time.Now().UTC().Format(time.RFC3339)
 	s.accounts = append(s.accounts, acct)
-	return acct, s.save()
+	if err := s.save(); err != nil {
+		s.accounts = s.accounts[:len(s.accounts)-1]
+		return ManualAccount{}, err
+	}
+	return acct, nil
 }
 
 // Remove deletes an account by ID and persists to disk.
@@ -104,29 +266,178 @@ func (s *AccountStore) Remove(id string) error {
 	for i, a := range s.accounts {
 		if a.ID == id {
 			s.accounts = append(s.accounts[:i], s.accounts[i+1:]...)
+			delete(s.providers, id)
 			return s.save()
 		}
 	}
 	return fmt.Errorf("account %s not found", id)
 }
 
-func (s *AccountStore) load() {
+// Credentials returns the credentials provider for an account. Role
+// accounts share one cached role session per account, renewed shortly
+// before it expires.
+func (s *AccountStore) Credentials(id string) (aws.CredentialsProvider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.providers[id]; ok {
+		return p, nil
+	}
+	var acct *ManualAccount
+	for i := range s.accounts {
+		if s.accounts[i].ID == id {
+			acct = &s.accounts[i]
+		}
+	}
+	if acct == nil {
+		return nil, fmt.Errorf("account %s not found", id)
+	}
+
+	var source aws.CredentialsProvider
+	if acct.AccessKeyID != "" {
+		source = credentials.NewStaticCredentialsProvider(acct.AccessKeyID, acct.SecretAccessKey, acct.SessionToken)
+	}
+	if acct.RoleARN == "" {
+		s.providers[id] = source
+		return source, nil
+	}
+
+	// Without keys the role is assumed with the server's own credentials.
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(partitionSTSRegion(arnPartition(acct.RoleARN)))}
+	if source != nil {
+		opts = append(opts, awsconfig.WithCredentialsProvider(source))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load source credentials for account %s: %w", acct.Name, err)
+	}
+	externalID := acct.ExternalID
+	role := stscreds.NewAssumeRoleProvider(assumeRoleClient(cfg), acct.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = roleSessionName
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+	p := aws.NewCredentialsCache(role, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = roleRefreshWindow
+	})
+	s.providers[id] = p
+	return p, nil
+}
+
+// Retrieve returns current credentials for an account, for callers that
+// hand them to another process (the aws CLI or the port forwarder).
+func (s *AccountStore) Retrieve(ctx context.Context, id string) (aws.Credentials, error) {
+	p, err := s.Credentials(id)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return p.Retrieve(ctx)
+}
+
+// load reads the accounts file. It reports whether the file should be
+// rewritten because it holds plaintext secrets and a key is set, or
+// secrets sealed with a previous key.
+func (s *AccountStore) load() (bool, error) {
 	data, err := os.ReadFile(s.path)
 	if err != nil {
-		return
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read aws accounts: %w", err)
 	}
-	var accounts []ManualAccount
-	if json.Unmarshal(data, &accounts) == nil {
-		s.accounts = accounts
+	var stored []storedAccount
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return false, fmt.Errorf("parse aws accounts: %w", err)
 	}
+	migrate := false
+	s.accounts = make([]ManualAccount, 0, len(stored))
+	for _, st := range stored {
+		acct := ManualAccount{
+			ID:              st.ID,
+			Name:            st.Name,
+			AccessKeyID:     st.AccessKeyID,
+			RoleARN:         st.RoleARN,
+			AddedAt:         st.AddedAt,
+			SecretAccessKey: st.SecretAccessKey,
+			SessionToken:    st.SessionToken,
+			ExternalID:      st.ExternalID,
+		}
+		if st.Secrets != "" {
+			sec, stale, err := s.openSecrets(st.Secrets)
+			if err != nil {
+				return false, fmt.Errorf("decrypt aws account %s: %w", st.Name, err)
+			}
+			migrate = migrate || stale
+			acct.SecretAccessKey, acct.SessionToken, acct.ExternalID = sec.SecretAccessKey, sec.SessionToken, sec.ExternalID
+		} else if s.Encrypted() && (st.SecretAccessKey != "" || st.SessionToken != "" || st.ExternalID != "") {
+			migrate = true
+		}
+		s.accounts = append(s.accounts, acct)
+	}
+	return migrate, nil
 }
 
+// save writes the accounts file; s.mu must be held.
 func (s *AccountStore) save() error {
-	data, err := json.MarshalIndent(s.accounts, "", "  ")
+	stored := make([]storedAccount, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		st := storedAccount{ID: a.ID, Name: a.Name, AccessKeyID: a.AccessKeyID, RoleARN: a.RoleARN, AddedAt: a.AddedAt}
+		if s.Encrypted() {
+			sealed, err := s.sealSecrets(accountSecrets{SecretAccessKey: a.SecretAccessKey, SessionToken: a.SessionToken, ExternalID: a.ExternalID})
+			if err != nil {
+				return err
+			}
+			st.Secrets = sealed
+		} else {
+			st.SecretAccessKey, st.SessionToken, st.ExternalID = a.SecretAccessKey, a.SessionToken, a.ExternalID
+		}
+		stored = append(stored, st)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.path, data, 0600)
+	// Write to a temporary file first so a crash never leaves a truncated
+	// accounts file behind.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *AccountStore) sealSecrets(sec accountSecrets) (string, error) {
+	data, err := json.Marshal(sec)
+	if err != nil {
+		return "", err
+	}
+	enc, err := crypto.Encrypt(s.key, data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// openSecrets decrypts sealed secrets, reporting whether they were sealed
+// with a previous key.
+func (s *AccountStore) openSecrets(sealed string) (accountSecrets, bool, error) {
+	var sec accountSecrets
+	if !s.Encrypted() {
+		return sec, false, errors.New("secrets are encrypted but no encryption key is configured")
+	}
+	enc, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return sec, false, err
+	}
+	stale := false
+	data, err := crypto.Decrypt(s.key, enc)
+	if err != nil {
+		if data, err = (crypto.Rotation{Key: s.key, Previous: s.previous}).Open(enc); err != nil {
+			return sec, false, err
+		}
+		stale = true
+	}
+	return sec, stale, json.Unmarshal(data, &sec)
 }
 
 func randomID() (string, error) {
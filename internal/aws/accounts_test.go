@@ -0,0 +1,242 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudterm-go/internal/crypto"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+func testKey() []byte { return []byte("0123456789abcdef0123456789abcdef") }
+
+func TestAccountStoreEncryptsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, err := NewAccountStore(path, testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct, err := s.Add(ManualAccount{Name: "prod", AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "topsecretkey", SessionToken: "tok-123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "topsecretkey") || strings.Contains(string(data), "tok-123") {
+		t.Fatalf("secrets written in plaintext:\n%s", data)
+	}
+
+	reloaded, err := NewAccountStore(path, testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reloaded.Get(acct.ID)
+	if !ok || got.SecretAccessKey != "topsecretkey" || got.SessionToken != "tok-123" {
+		t.Errorf("reloaded = %+v", got)
+	}
+
+	if _, err := NewAccountStore(path, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+		t.Error("wrong key accepted")
+	}
+	if _, err := NewAccountStore(path, nil); err == nil {
+		t.Error("encrypted file loaded without a key")
+	}
+}
+
+func TestAccountStoreRefusesSecretsWithoutKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, err := NewAccountStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(ManualAccount{Name: "prod", AccessKeyID: "AKIATEST", SecretAccessKey: "topsecretkey"}); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("Add with secrets and no key: err = %v, want ErrNoKey", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("accounts file written for a refused account")
+	}
+	if _, err := s.Add(ManualAccount{Name: "member", RoleARN: "arn:aws:iam::123456789012:role/CloudTerm"}); err != nil {
+		t.Errorf("role account without secrets refused: %v", err)
+	}
+}
+
+func TestAccountStoreMigratesPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	legacy := `[{"id":"a1","name":"old","access_key_id":"AKIAOLD","secret_access_key":"legacysecret","added_at":"2025-01-01T00:00:00Z"}]`
+	os.WriteFile(path, []byte(legacy), 0600)
+
+	s, err := NewAccountStore(path, testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get("a1"); got.SecretAccessKey != "legacysecret" {
+		t.Errorf("account = %+v", got)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "legacysecret") || !strings.Contains(string(data), `"secrets"`) {
+		t.Errorf("file not re-encrypted:\n%s", data)
+	}
+}
+
+func TestAccountStoreRotateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, _ := NewAccountStore(path, testKey())
+	acct, err := s.Add(ManualAccount{Name: "prod", AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "topsecretkey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, _ := crypto.GenerateKey()
+	if err := s.RotateKey(crypto.Rotation{Key: newKey, Previous: [][]byte{testKey()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAccountStore(path, testKey()); err == nil {
+		t.Error("file still readable with the old key")
+	}
+	reloaded, err := NewAccountStore(path, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reloaded.Get(acct.ID); got.SecretAccessKey != "topsecretkey" {
+		t.Errorf("reloaded = %+v", got)
+	}
+
+	// A file sealed with a previous key is re-encrypted on load.
+	migrated, err := NewAccountStore(path, testKey(), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := migrated.Get(acct.ID); got.SecretAccessKey != "topsecretkey" {
+		t.Errorf("migrated = %+v", got)
+	}
+	if _, err := NewAccountStore(path, testKey()); err != nil {
+		t.Errorf("file not re-encrypted on load: %v", err)
+	}
+}
+
+func TestManualAccountValidate(t *testing.T) {
+	const role = "arn:aws:iam::123456789012:role/CloudTermReadOnly"
+	tests := []struct {
+		acct ManualAccount
+		ok   bool
+	}{
+		{ManualAccount{AccessKeyID: "AKIA", SecretAccessKey: "s"}, true},
+		{ManualAccount{RoleARN: role}, true},
+		{ManualAccount{AccessKeyID: "AKIA", SecretAccessKey: "s", RoleARN: role, ExternalID: "x"}, true},
+		{ManualAccount{}, false},
+		{ManualAccount{AccessKeyID: "AKIA"}, false},
+		{ManualAccount{AccessKeyID: "AKIA", SecretAccessKey: "s", ExternalID: "x"}, false},
+		{ManualAccount{RoleARN: "arn:aws:iam::123:user/bob"}, false},
+	}
+	for i, tt := range tests {
+		err := tt.acct.validate()
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrInvalidAccount)) {
+			t.Errorf("%d: validate(%+v) = %v", i, tt.acct, err)
+		}
+	}
+
+	m := ManualAccount{SecretAccessKey: "abcdefgh", SessionToken: "t", ExternalID: "e"}.Masked()
+	if m.SecretAccessKey != "****efgh" || m.SessionToken != "****" || m.ExternalID != "****" {
+		t.Errorf("masked = %+v", m)
+	}
+}
+
+type fakeAssumeRole struct {
+	calls int
+	input *sts.AssumeRoleInput
+}
+
+func (f *fakeAssumeRole) AssumeRole(ctx context.Context, in *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.calls++
+	f.input = in
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIAROLE"),
+		SecretAccessKey: aws.String("role-secret"),
+		SessionToken:    aws.String("role-token"),
+		// Inside the refresh window, so every retrieval renews the session.
+		Expiration: aws.Time(time.Now().Add(roleRefreshWindow / 2)),
+	}}, nil
+}
+
+func TestRoleAccountCredentials(t *testing.T) {
+	fake := &fakeAssumeRole{}
+	orig := assumeRoleClient
+	assumeRoleClient = func(aws.Config) stscreds.AssumeRoleAPIClient { return fake }
+	defer func() { assumeRoleClient = orig }()
+
+	s, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"), testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct, err := s.Add(ManualAccount{
+		Name:            "member",
+		AccessKeyID:     "AKIASOURCE",
+		SecretAccessKey: "source-secret",
+		RoleARN:         "arn:aws:iam::123456789012:role/CloudTermReadOnly",
+		ExternalID:      "ext-42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := s.Retrieve(context.Background(), acct.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROLE" || creds.SessionToken != "role-token" {
+		t.Errorf("creds = %+v", creds)
+	}
+	if aws.ToString(fake.input.ExternalId) != "ext-42" || aws.ToString(fake.input.RoleSessionName) != roleSessionName {
+		t.Errorf("assume role input = %+v", fake.input)
+	}
+
+	p1, _ := s.Credentials(acct.ID)
+	p2, _ := s.Credentials(acct.ID)
+	if p1 != p2 {
+		t.Error("role provider not cached")
+	}
+	if _, err := s.Retrieve(context.Background(), acct.ID); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != 2 {
+		t.Errorf("AssumeRole calls = %d, want a refresh before expiry", fake.calls)
+	}
+}
+
+func TestRoleAccountUsesPartitionSTSRegion(t *testing.T) {
+	var region string
+	orig := assumeRoleClient
+	assumeRoleClient = func(cfg aws.Config) stscreds.AssumeRoleAPIClient {
+		region = cfg.Region
+		return &fakeAssumeRole{}
+	}
+	defer func() { assumeRoleClient = orig }()
+
+	s, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"), testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for arn, want := range map[string]string{
+		"arn:aws:iam::123456789012:role/ReadOnly":        "us-east-1",
+		"arn:aws-cn:iam::123456789012:role/ReadOnly":     "cn-north-1",
+		"arn:aws-us-gov:iam::123456789012:role/ReadOnly": "us-gov-west-1",
+	} {
+		acct, err := s.Add(ManualAccount{Name: arn, AccessKeyID: "AKIASOURCE", SecretAccessKey: "source-secret", RoleARN: arn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Credentials(acct.ID); err != nil {
+			t.Fatal(err)
+		}
+		if region != want {
+			t.Errorf("%s: STS region = %q, want %q", arn, region, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// CommandResult is the outcome of a SendCommand invocation on one instance.
+// SSM truncates inline output to 24,000 characters of stdout and 8,000 of
+// stderr.
+type CommandResult struct {
+	Status   string // SSM invocation status, e.g. "Success", "Failed", "TimedOut"
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Details  string
+}
+
+// RunCommand runs a user script on an instance through AWS-RunShellScript
+// (or AWS-RunPowerShellScript on Windows) and waits for it to finish.
+// A non-zero exit code is reported in the result, not as an error; errors
+// mean the command could not be delivered or polled.
+func (d *Discovery) RunCommand(ctx context.Context, profile, region, instanceID, platform string, commands []string, timeout time.Duration) (*CommandResult, error) {
+	client, err := d.newSSMClient(ctx, profile, region)
+	if err != nil {
+		return nil, err
+	}
+	docName := "AWS-RunShellScript"
+	if strings.EqualFold(platform, "windows") {
+		docName = "AWS-RunPowerShellScript"
+	}
+	params := map[string][]string{"commands": commands}
+	if timeout > 0 {
+		params["executionTimeout"] = []string{strconv.Itoa(int(timeout.Seconds()))}
+	}
+	resp, err := client.SendCommand(ctx, &ssm.SendCommandInput{
+		InstanceIds:  []string{instanceID},
+		DocumentName: aws.String(docName),
+		Parameters:   params,
+		Comment:      aws.String("cloudterm fleet command"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SSM SendCommand: %w", err)
+	}
+	commandID := aws.ToString(resp.Command.CommandId)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Best effort: stop the script on the instance too.
+			cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			client.CancelCommand(cctx, &ssm.CancelCommandInput{CommandId: aws.String(commandID), InstanceIds: []string{instanceID}})
+			cancel()
+			return nil, ctx.Err()
+		case <-time.After(ssmPollInterval):
+		}
+
+		out, err := client.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(commandID),
+			InstanceId: aws.String(instanceID),
+		})
+		if err != nil {
+			continue // invocation may not be registered yet
+		}
+		switch out.Status {
+		case ssmtypes.CommandInvocationStatusSuccess,
+			ssmtypes.CommandInvocationStatusFailed,
+			ssmtypes.CommandInvocationStatusCancelled,
+			ssmtypes.CommandInvocationStatusTimedOut:
+			return &CommandResult{
+				Status:   string(out.Status),
+				ExitCode: int(out.ResponseCode),
+				Stdout:   aws.ToString(out.StandardOutputContent),
+				Stderr:   aws.ToString(out.StandardErrorContent),
+				Details:  aws.ToString(out.StatusDetails),
+			}, nil
+		}
+	}
+}
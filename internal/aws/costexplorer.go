@@ -14,7 +14,6 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
 	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
@@ -249,9 +248,11 @@ func (s *CostExplorerService) buildCEClients(ctx context.Context) []ceClientInfo
 
 	if s.accounts != nil {
 		for _, acct := range s.accounts.ListRaw() {
-			credProvider := credentials.NewStaticCredentialsProvider(
-				acct.AccessKeyID, acct.SecretAccessKey, acct.SessionToken,
-			)
+			credProvider, err := s.accounts.Credentials(acct.ID)
+			if err != nil {
+				s.logger.Printf("Cost explorer: failed to load manual account %s: %v", acct.Name, err)
+				continue
+			}
 			awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
 				awsconfig.WithRegion("us-east-1"),
 				awsconfig.WithCredentialsProvider(credProvider),
@@ -1,7 +1,6 @@
 package aws
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,17 +9,16 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"cloudterm-go/internal/config"
+	"cloudterm-go/internal/sshclient"
 	"cloudterm-go/internal/types"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
@@ -58,6 +56,27 @@ type Discovery struct {
 	mu         sync.RWMutex
 	cloneOps   map[string]*CloneStatus
 	cloneMu    sync.RWMutex
+	// profileStatus holds each shared-config profile's result from the
+	// last full scan.
+	profileStatus map[string]profileCheck
+	ssoLogins     map[string]*SSOLogin
+	ssoMu         sync.Mutex
+	// orgAccounts holds the accounts from the last Organizations scan;
+	// orgProviders caches their member-role credentials.
+	orgAccounts  map[string]OrgAccount
+	orgProviders map[string]aws.CredentialsProvider
+	orgMu        sync.Mutex
+	// sshKeys looks up a stored private key for DialSSH.
+	sshKeys SSHKeyFunc
+	// hostKeys pins instance host keys for DialSSH; hostKeyChanged is
+	// called when an instance presents a different one.
+	hostKeys       sshclient.HostKeys
+	hostKeyChanged func(instanceID string, err error)
+}
+
+type profileCheck struct {
+	Status string
+	Error  string
 }
 
 // NewDiscovery creates a new Discovery service.
@@ -65,7 +84,12 @@ func NewDiscovery(cfg *config.Config, logger *log.Logger) *Discovery {
 	return &Discovery{
 		cfg:      cfg,
 		logger:   logger,
-		cloneOps: make(map[string]*CloneStatus),
+		cloneOps:      make(map[string]*CloneStatus),
+		profileStatus: make(map[string]profileCheck),
+		ssoLogins:     make(map[string]*SSOLogin),
+		orgAccounts:   make(map[string]OrgAccount),
+		orgProviders:  make(map[string]aws.CredentialsProvider),
+		hostKeys:      sshclient.NewMemoryHostKeys(),
 	}
 }
 
@@ -79,18 +103,23 @@ func (d *Discovery) awsConfigOpts(profile, region string) []func(*awsconfig.Load
 	}
 	if strings.HasPrefix(profile, "manual:") {
 		if d.accounts != nil {
-			acctID := strings.TrimPrefix(profile, "manual:")
-			if acct, ok := d.accounts.Get(acctID); ok {
-				opts = append(opts, awsconfig.WithCredentialsProvider(
-					credentials.NewStaticCredentialsProvider(
-						acct.AccessKeyID, acct.SecretAccessKey, acct.SessionToken,
-					),
-				))
+			creds, err := d.accounts.Credentials(strings.TrimPrefix(profile, "manual:"))
+			if err != nil {
+				d.logger.Printf("manual account credentials for %s: %v", profile, err)
 				return opts
 			}
+			opts = append(opts, awsconfig.WithCredentialsProvider(creds))
 		}
 		return opts
 	}
+	if strings.HasPrefix(profile, OrgProfilePrefix) {
+		creds, err := d.orgCredentials(strings.TrimPrefix(profile, OrgProfilePrefix))
+		if err != nil {
+			d.logger.Printf("organization account credentials for %s: %v", profile, err)
+			return opts
+		}
+		return append(opts, awsconfig.WithCredentialsProvider(creds))
+	}
 	opts = append(opts, awsconfig.WithSharedConfigProfile(profile))
 	return opts
 }
@@ -322,7 +351,7 @@ func (d *Discovery) ScanRegion(profile, region string) (int, error) {
 	}
 	kept = append(kept, instances...)
 	d.cache.Instances = kept
-	d.cache.Data = buildInstanceTree(kept)
+	d.cache.Data = d.annotateOrgAccounts(buildInstanceTree(kept))
 	d.cache.Timestamp = time.Now()
 
 	d.logger.Printf("Region scan complete: %s/%s → %d instances", profile, region, len(instances))
@@ -336,6 +365,10 @@ func (d *Discovery) ScanAccount(acct ManualAccount) (int, error) {
 	defer cancel()
 
 	profileLabel := "manual:" + acct.ID
+	credProvider, err := d.accounts.Credentials(acct.ID)
+	if err != nil {
+		return 0, err
+	}
 
 	regions := getAWSRegions(ctx, d.logger)
 	d.logger.Printf("Scanning manual account %q across %d regions", acct.Name, len(regions))
@@ -356,9 +389,6 @@ func (d *Discovery) ScanAccount(acct ManualAccount) (int, error) {
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			credProvider := credentials.NewStaticCredentialsProvider(
-				acct.AccessKeyID, acct.SecretAccessKey, acct.SessionToken,
-			)
 			awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
 				awsconfig.WithRegion(region),
 				awsconfig.WithCredentialsProvider(credProvider),
@@ -441,7 +471,7 @@ func (d *Discovery) ScanAccount(acct ManualAccount) (int, error) {
 	}
 	kept = append(kept, allInstances...)
 	d.cache.Instances = kept
-	d.cache.Data = buildInstanceTree(kept)
+	d.cache.Data = d.annotateOrgAccounts(buildInstanceTree(kept))
 	d.cache.Timestamp = time.Now()
 
 	d.logger.Printf("Manual account scan complete: %s → %d instances", acct.Name, len(allInstances))
@@ -465,7 +495,7 @@ func (d *Discovery) RemoveAccountInstances(accountID string) {
 		}
 	}
 	d.cache.Instances = kept
-	d.cache.Data = buildInstanceTree(kept)
+	d.cache.Data = d.annotateOrgAccounts(buildInstanceTree(kept))
 	d.logger.Printf("Removed instances for manual account %s from cache", accountID)
 }
 
@@ -547,17 +577,75 @@ func (d *Discovery) Scan(force bool) (*types.ScanResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	profiles := parseAWSProfiles()
-	if len(profiles) == 0 {
-		d.logger.Println("No AWS profiles found in ~/.aws/credentials")
+	all := loadProfiles()
+	if len(all) == 0 {
+		d.logger.Println("No AWS profiles found in ~/.aws/config or ~/.aws/credentials")
 		d.mu.Lock()
 		d.scanStatus = types.ScanStatus{Status: "error", Message: "No AWS profiles found"}
 		d.mu.Unlock()
 		return nil, fmt.Errorf("no AWS profiles found")
 	}
 
+	// Resolve each profile's credentials once up front; profiles with an
+	// expired SSO session or token are reported instead of failing in
+	// every region.
+	checks := make([]profileCheck, len(all))
+	var checkWg sync.WaitGroup
+	checkSem := make(chan struct{}, 5)
+	for i, p := range all {
+		checkWg.Add(1)
+		go func(i int, p Profile) {
+			defer checkWg.Done()
+			checkSem <- struct{}{}
+			defer func() { <-checkSem }()
+			status, msg := checkProfile(ctx, p)
+			checks[i] = profileCheck{Status: status, Error: msg}
+		}(i, p)
+	}
+	checkWg.Wait()
+
+	var profiles, expiredProfiles []string
+	skipped := 0
+	statuses := make(map[string]profileCheck, len(all))
+	for i, p := range all {
+		statuses[p.Name] = checks[i]
+		switch checks[i].Status {
+		case ProfileOK:
+			profiles = append(profiles, p.Name)
+		case ProfileExpired:
+			expiredProfiles = append(expiredProfiles, p.Name)
+			skipped++
+		default:
+			skipped++
+		}
+	}
+	d.mu.Lock()
+	d.profileStatus = statuses
+	d.mu.Unlock()
+
+	// With a management profile, every active account of the organization
+	// is scanned through the member role. The management account itself
+	// is scanned with the management profile.
+	orgNames := make(map[string]string)
+	if mgmt := d.cfg.OrgManagementProfile; mgmt != "" {
+		if st, ok := statuses[mgmt]; ok && st.Status != ProfileOK {
+			d.logger.Printf("Skipping Organizations discovery: management profile %s is %s", mgmt, st.Status)
+		} else if orgAccounts, err := d.discoverOrgAccounts(ctx); err != nil {
+			d.logger.Printf("Organizations discovery via %s failed: %v", mgmt, err)
+		} else {
+			for _, a := range orgAccounts {
+				if a.Management {
+					continue
+				}
+				profiles = append(profiles, OrgProfilePrefix+a.ID)
+				orgNames[OrgProfilePrefix+a.ID] = a.Name
+			}
+			d.logger.Printf("Organizations discovery: %d accounts via %s", len(orgAccounts), mgmt)
+		}
+	}
+
 	regions := getAWSRegions(ctx, d.logger)
-	d.logger.Printf("Scanning %d profiles across %d regions", len(profiles), len(regions))
+	d.logger.Printf("Scanning %d profiles across %d regions (%d skipped)", len(profiles), len(regions), skipped)
 
 	var allInstances []types.EC2Instance
 	scannedCombinations := 0
@@ -590,9 +678,7 @@ func (d *Discovery) Scan(force bool) (*types.ScanResult, error) {
 				defer func() { <-sem }()
 
 				awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
-					awsconfig.WithRegion(region),
-					awsconfig.WithSharedConfigProfile(profile),
-					awsconfig.WithHTTPClient(leanHTTPClient),
+					append(d.awsConfigOpts(profile, region), awsconfig.WithHTTPClient(leanHTTPClient))...,
 				)
 				if err != nil {
 					return
@@ -616,6 +702,9 @@ func (d *Discovery) Scan(force bool) (*types.ScanResult, error) {
 					if err == nil && len(aliases.AccountAliases) > 0 {
 						meta.accountAlias = aliases.AccountAliases[0]
 					}
+					if meta.accountAlias == "" {
+						meta.accountAlias = orgNames[profile]
+					}
 
 					accountMu.Lock()
 					accountCache[profile] = meta
@@ -694,7 +783,7 @@ func (d *Discovery) Scan(force bool) (*types.ScanResult, error) {
 		}
 	}
 
-	tree := buildInstanceTree(allInstances)
+	tree := d.annotateOrgAccounts(buildInstanceTree(allInstances))
 
 	result := &types.ScanResult{
 		Data:         tree,
@@ -710,6 +799,7 @@ func (d *Discovery) Scan(force bool) (*types.ScanResult, error) {
 		ScannedCombinations: totalCombinations,
 		SuccessfulRegions:   successfulRegions,
 		TotalInstances:      len(allInstances),
+		ExpiredProfiles:     expiredProfiles,
 		Message:             fmt.Sprintf("Scan complete: %d instances found", len(allInstances)),
 	}
 	d.mu.Unlock()
@@ -1178,32 +1268,14 @@ func (d *Discovery) loadFromYAML() (*types.ScanResult, error) {
 
 // --- AWS profile and region helpers ---
 
-// parseAWSProfiles reads profile names from ~/.aws/credentials.
+// parseAWSProfiles returns the names of the profiles in ~/.aws/config and
+// ~/.aws/credentials that can produce credentials.
 func parseAWSProfiles() []string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil
-	}
-	path := filepath.Join(home, ".aws", "credentials")
-	f, err := os.Open(path)
-	if err != nil {
-		return nil
-	}
-	defer f.Close()
-
-	var profiles []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			profile := strings.TrimPrefix(strings.TrimSuffix(line, "]"), "[")
-			profile = strings.TrimSpace(profile)
-			if profile != "" {
-				profiles = append(profiles, profile)
-			}
-		}
+	var names []string
+	for _, p := range loadProfiles() {
+		names = append(names, p.Name)
 	}
-	return profiles
+	return names
 }
 
 // getAWSRegions fetches all AWS regions via the CLI, falling back to a hardcoded list.
@@ -117,11 +117,16 @@ func parseFileEntries(raw string, isWin bool) []FileEntry {
 		}
 	}
 
+	sortFileEntries(entries)
+	return entries
+}
+
+// sortFileEntries puts directories first, then sorts by name ignoring case.
+func sortFileEntries(entries []FileEntry) {
 	sort.Slice(entries, func(i, j int) bool {
 		if entries[i].IsDir != entries[j].IsDir {
 			return entries[i].IsDir
 		}
 		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
 	})
-	return entries
 }
@@ -12,7 +12,6 @@ import (
 
 	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
@@ -143,17 +142,13 @@ func (s *EKSService) GetClusterCA(ctx context.Context, accountID, region, cluste
 }
 
 func (s *EKSService) awsConfigForAccount(ctx context.Context, accountID, region string) (awsv2.Config, error) {
-	acct, ok := s.accounts.Get(accountID)
-	if !ok {
-		return awsv2.Config{}, fmt.Errorf("account %s not found", accountID)
+	creds, err := s.accounts.Credentials(accountID)
+	if err != nil {
+		return awsv2.Config{}, err
 	}
 	return awsconfig.LoadDefaultConfig(ctx,
 		awsconfig.WithRegion(region),
-		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			acct.AccessKeyID,
-			acct.SecretAccessKey,
-			acct.SessionToken,
-		)),
+		awsconfig.WithCredentialsProvider(creds),
 	)
 }
 
@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// kmsContext is bound to every data key, so ciphertexts made for CloudTerm
+// cannot be decrypted under another encryption context.
+var kmsContext = map[string]string{"app": "cloudterm"}
+
+// kmsAPI is the subset of the KMS client the keyring uses.
+type kmsAPI interface {
+	GenerateDataKey(ctx context.Context, in *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, in *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeys generates and unwraps the vault data key with AWS KMS, using the
+// server's own credentials. It implements crypto.KMS.
+type KMSKeys struct {
+	client kmsAPI
+}
+
+// NewKMSKeys creates a KMS client for keyID, in the key's region when it is
+// an ARN and the default region otherwise.
+func NewKMSKeys(ctx context.Context, keyID string) (*KMSKeys, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if a, err := arn.Parse(keyID); err == nil && a.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(a.Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load kms config: %w", err)
+	}
+	return &KMSKeys{client: kms.NewFromConfig(cfg)}, nil
+}
+
+// GenerateDataKey returns a new AES-256 key and its KMS ciphertext.
+func (k *KMSKeys) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	out, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: kmsContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// Decrypt unwraps a data key made by GenerateDataKey.
+func (k *KMSKeys) Decrypt(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(keyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
@@ -122,7 +122,7 @@ func (d *Discovery) ec2ClientForInstance(ctx context.Context, inst *types.EC2Ins
 		awsconfig.WithRegion(inst.AWSRegion),
 	}
 	if inst.AWSProfile != "" {
-		opts = append(opts, awsconfig.WithSharedConfigProfile(inst.AWSProfile))
+		opts = d.awsConfigOpts(inst.AWSProfile, inst.AWSRegion)
 	}
 	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
@@ -364,7 +364,7 @@ func (d *Discovery) DescribeLoadBalancers(ctx context.Context, instanceID string
 		awsconfig.WithRegion(inst.AWSRegion),
 	}
 	if inst.AWSProfile != "" {
-		opts = append(opts, awsconfig.WithSharedConfigProfile(inst.AWSProfile))
+		opts = d.awsConfigOpts(inst.AWSProfile, inst.AWSRegion)
 	}
 	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
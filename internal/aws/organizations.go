@@ -0,0 +1,288 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloudterm-go/internal/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+// OrgProfilePrefix marks the profile label of an account discovered through
+// AWS Organizations ("org:<account id>"); its credentials come from
+// assuming the member role from the management profile.
+const OrgProfilePrefix = "org:"
+
+// orgAPI is the subset of the Organizations client discovery uses.
+type orgAPI interface {
+	organizations.ListAccountsAPIClient
+	organizations.ListRootsAPIClient
+	organizations.ListOrganizationalUnitsForParentAPIClient
+	organizations.ListAccountsForParentAPIClient
+	organizations.ListTagsForResourceAPIClient
+	DescribeOrganization(ctx context.Context, in *organizations.DescribeOrganizationInput, optFns ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error)
+}
+
+// newOrgClient builds the Organizations client; replaced in tests.
+var newOrgClient = func(cfg aws.Config) orgAPI {
+	return organizations.NewFromConfig(cfg)
+}
+
+// OrgAccount is an active account of the organization.
+type OrgAccount struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Email      string            `json:"email,omitempty"`
+	OUPath     string            `json:"ou_path"` // OU names from the root, e.g. "/Prod/Web"
+	Tags       map[string]string `json:"tags,omitempty"`
+	Management bool              `json:"management,omitempty"`
+
+	arn   string
+	ouIDs []string // root and OU IDs from the root down
+}
+
+// partition returns the account's AWS partition ("aws", "aws-us-gov", ...).
+func (a OrgAccount) partition() string {
+	return arnPartition(a.arn)
+}
+
+// splitList parses a comma-separated configuration list.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// inOUs reports whether the account sits under any of the given OUs, each
+// an OU or root ID ("ou-ab12-cdef5678", "r-ab12") or a name path ("/Prod").
+func (a OrgAccount) inOUs(ous []string) bool {
+	for _, ou := range ous {
+		if strings.HasPrefix(ou, "/") {
+			ou = strings.TrimSuffix(ou, "/")
+			if ou == "" || a.OUPath == ou || strings.HasPrefix(a.OUPath, ou+"/") {
+				return true
+			}
+			continue
+		}
+		for _, id := range a.ouIDs {
+			if id == ou {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listOrgAccounts returns the organization's active accounts under the
+// included OUs (all when include is empty) and outside the excluded ones,
+// with their OU path and tags.
+func listOrgAccounts(ctx context.Context, client orgAPI, include, exclude []string) ([]OrgAccount, error) {
+	org, err := client.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return nil, fmt.Errorf("describe organization: %w", err)
+	}
+	managementID := ""
+	if org.Organization != nil {
+		managementID = aws.ToString(org.Organization.MasterAccountId)
+	}
+
+	accounts := make(map[string]*OrgAccount)
+	pages := organizations.NewListAccountsPaginator(client, &organizations.ListAccountsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list accounts: %w", err)
+		}
+		for _, a := range page.Accounts {
+			if a.State != "" && a.State != orgtypes.AccountStateActive {
+				continue
+			}
+			if a.State == "" && a.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+			id := aws.ToString(a.Id)
+			accounts[id] = &OrgAccount{
+				ID:         id,
+				Name:       aws.ToString(a.Name),
+				Email:      aws.ToString(a.Email),
+				OUPath:     "/",
+				Management: id == managementID,
+				arn:        aws.ToString(a.Arn),
+			}
+		}
+	}
+
+	// Walk the OU tree from each root to place every account.
+	var walk func(parentID, path string, ids []string) error
+	walk = func(parentID, path string, ids []string) error {
+		ap := organizations.NewListAccountsForParentPaginator(client, &organizations.ListAccountsForParentInput{ParentId: aws.String(parentID)})
+		for ap.HasMorePages() {
+			page, err := ap.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("list accounts in %s: %w", parentID, err)
+			}
+			for _, a := range page.Accounts {
+				if acct, ok := accounts[aws.ToString(a.Id)]; ok {
+					acct.OUPath = path
+					acct.ouIDs = ids
+				}
+			}
+		}
+		op := organizations.NewListOrganizationalUnitsForParentPaginator(client, &organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(parentID)})
+		for op.HasMorePages() {
+			page, err := op.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("list OUs in %s: %w", parentID, err)
+			}
+			for _, ou := range page.OrganizationalUnits {
+				id := aws.ToString(ou.Id)
+				childIDs := append(append([]string(nil), ids...), id)
+				if err := walk(id, strings.TrimSuffix(path, "/")+"/"+aws.ToString(ou.Name), childIDs); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	rp := organizations.NewListRootsPaginator(client, &organizations.ListRootsInput{})
+	for rp.HasMorePages() {
+		page, err := rp.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list roots: %w", err)
+		}
+		for _, root := range page.Roots {
+			if err := walk(aws.ToString(root.Id), "/", []string{aws.ToString(root.Id)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var out []OrgAccount
+	for _, acct := range accounts {
+		if len(include) > 0 && !acct.inOUs(include) {
+			continue
+		}
+		if acct.inOUs(exclude) {
+			continue
+		}
+		tp := organizations.NewListTagsForResourcePaginator(client, &organizations.ListTagsForResourceInput{ResourceId: aws.String(acct.ID)})
+		for tp.HasMorePages() {
+			page, err := tp.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list tags for %s: %w", acct.ID, err)
+			}
+			for _, t := range page.Tags {
+				if acct.Tags == nil {
+					acct.Tags = make(map[string]string)
+				}
+				acct.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+			}
+		}
+		out = append(out, *acct)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// discoverOrgAccounts lists the organization's accounts with the
+// management profile and remembers them for credentials and grouping.
+func (d *Discovery) discoverOrgAccounts(ctx context.Context) ([]OrgAccount, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(stsRegion),
+		awsconfig.WithSharedConfigProfile(d.cfg.OrgManagementProfile),
+		awsconfig.WithHTTPClient(leanHTTPClient),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load management profile %s: %w", d.cfg.OrgManagementProfile, err)
+	}
+	accounts, err := listOrgAccounts(ctx, newOrgClient(cfg), splitList(d.cfg.OrgIncludeOUs), splitList(d.cfg.OrgExcludeOUs))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]OrgAccount, len(accounts))
+	for _, a := range accounts {
+		byID[a.ID] = a
+	}
+	d.orgMu.Lock()
+	d.orgAccounts = byID
+	d.orgMu.Unlock()
+	return accounts, nil
+}
+
+// OrgAccounts returns the accounts found by the last Organizations scan.
+func (d *Discovery) OrgAccounts() []OrgAccount {
+	d.orgMu.Lock()
+	defer d.orgMu.Unlock()
+	out := make([]OrgAccount, 0, len(d.orgAccounts))
+	for _, a := range d.orgAccounts {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// orgCredentials returns the member-role credentials for an organization
+// account, assumed from the management profile and renewed shortly before
+// they expire.
+func (d *Discovery) orgCredentials(accountID string) (aws.CredentialsProvider, error) {
+	if d.cfg == nil || d.cfg.OrgManagementProfile == "" {
+		return nil, fmt.Errorf("account %s: Organizations discovery is not configured", accountID)
+	}
+	d.orgMu.Lock()
+	defer d.orgMu.Unlock()
+	if p, ok := d.orgProviders[accountID]; ok {
+		return p, nil
+	}
+	partition := d.orgAccounts[accountID].partition()
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(partitionSTSRegion(partition)),
+		awsconfig.WithSharedConfigProfile(d.cfg.OrgManagementProfile),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load management profile %s: %w", d.cfg.OrgManagementProfile, err)
+	}
+	roleARN := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountID, d.cfg.OrgMemberRole)
+	role := stscreds.NewAssumeRoleProvider(assumeRoleClient(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = roleSessionName
+	})
+	p := aws.NewCredentialsCache(role, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = roleRefreshWindow
+	})
+	d.orgProviders[accountID] = p
+	return p, nil
+}
+
+// OrgCredentials returns current credentials for an "org:<id>" profile, for
+// callers that hand them to another process.
+func (d *Discovery) OrgCredentials(ctx context.Context, profile string) (aws.Credentials, error) {
+	p, err := d.orgCredentials(strings.TrimPrefix(profile, OrgProfilePrefix))
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return p.Retrieve(ctx)
+}
+
+// annotateOrgAccounts adds the OU path and account tags of organization
+// accounts to the tree.
+func (d *Discovery) annotateOrgAccounts(tree *types.InstanceTree) *types.InstanceTree {
+	d.orgMu.Lock()
+	defer d.orgMu.Unlock()
+	for i := range tree.Accounts {
+		if a, ok := d.orgAccounts[tree.Accounts[i].AccountID]; ok {
+			tree.Accounts[i].OUPath = a.OUPath
+			tree.Accounts[i].AccountTags = a.Tags
+		}
+	}
+	return tree
+}
@@ -0,0 +1,184 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"cloudterm-go/internal/config"
+	"cloudterm-go/internal/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// fakeOrg is an organization of:
+//
+//	r-root: 100000000000 (management)
+//	  ou-prod (Prod): 200000000000
+//	    ou-web (Web): 300000000000
+//	  ou-sandbox (Sandbox): 400000000000, 500000000000 (suspended)
+type fakeOrg struct{}
+
+func orgAccount(id, name string, state orgtypes.AccountState) orgtypes.Account {
+	return orgtypes.Account{Id: aws.String(id), Name: aws.String(name), Arn: aws.String("arn:aws:organizations::100000000000:account/o-1/" + id), State: state}
+}
+
+func (fakeOrg) DescribeOrganization(ctx context.Context, in *organizations.DescribeOrganizationInput, _ ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error) {
+	return &organizations.DescribeOrganizationOutput{Organization: &orgtypes.Organization{MasterAccountId: aws.String("100000000000")}}, nil
+}
+
+func (fakeOrg) ListAccounts(ctx context.Context, in *organizations.ListAccountsInput, _ ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	// Two pages, to exercise pagination.
+	if in.NextToken == nil {
+		return &organizations.ListAccountsOutput{
+			Accounts: []orgtypes.Account{
+				orgAccount("100000000000", "management", orgtypes.AccountStateActive),
+				orgAccount("200000000000", "prod", orgtypes.AccountStateActive),
+				orgAccount("300000000000", "prod-web", orgtypes.AccountStateActive),
+			},
+			NextToken: aws.String("p2"),
+		}, nil
+	}
+	return &organizations.ListAccountsOutput{Accounts: []orgtypes.Account{
+		orgAccount("400000000000", "sandbox", orgtypes.AccountStateActive),
+		orgAccount("500000000000", "closed", orgtypes.AccountStateSuspended),
+	}}, nil
+}
+
+func (fakeOrg) ListRoots(ctx context.Context, in *organizations.ListRootsInput, _ ...func(*organizations.Options)) (*organizations.ListRootsOutput, error) {
+	return &organizations.ListRootsOutput{Roots: []orgtypes.Root{{Id: aws.String("r-root")}}}, nil
+}
+
+func (fakeOrg) ListOrganizationalUnitsForParent(ctx context.Context, in *organizations.ListOrganizationalUnitsForParentInput, _ ...func(*organizations.Options)) (*organizations.ListOrganizationalUnitsForParentOutput, error) {
+	ous := map[string][]orgtypes.OrganizationalUnit{
+		"r-root":  {{Id: aws.String("ou-prod"), Name: aws.String("Prod")}, {Id: aws.String("ou-sandbox"), Name: aws.String("Sandbox")}},
+		"ou-prod": {{Id: aws.String("ou-web"), Name: aws.String("Web")}},
+	}
+	return &organizations.ListOrganizationalUnitsForParentOutput{OrganizationalUnits: ous[aws.ToString(in.ParentId)]}, nil
+}
+
+func (fakeOrg) ListAccountsForParent(ctx context.Context, in *organizations.ListAccountsForParentInput, _ ...func(*organizations.Options)) (*organizations.ListAccountsForParentOutput, error) {
+	ids := map[string][]string{
+		"r-root":     {"100000000000"},
+		"ou-prod":    {"200000000000"},
+		"ou-web":     {"300000000000"},
+		"ou-sandbox": {"400000000000", "500000000000"},
+	}
+	var out []orgtypes.Account
+	for _, id := range ids[aws.ToString(in.ParentId)] {
+		out = append(out, orgtypes.Account{Id: aws.String(id)})
+	}
+	return &organizations.ListAccountsForParentOutput{Accounts: out}, nil
+}
+
+func (fakeOrg) ListTagsForResource(ctx context.Context, in *organizations.ListTagsForResourceInput, _ ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	if aws.ToString(in.ResourceId) != "300000000000" {
+		return &organizations.ListTagsForResourceOutput{}, nil
+	}
+	return &organizations.ListTagsForResourceOutput{Tags: []orgtypes.Tag{{Key: aws.String("CostCenter"), Value: aws.String("web")}}}, nil
+}
+
+func TestListOrgAccounts(t *testing.T) {
+	accounts, err := listOrgAccounts(context.Background(), fakeOrg{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := map[string]OrgAccount{}
+	for _, a := range accounts {
+		byID[a.ID] = a
+	}
+	if len(byID) != 4 {
+		t.Fatalf("accounts = %+v", accounts)
+	}
+	if _, ok := byID["500000000000"]; ok {
+		t.Error("suspended account listed")
+	}
+	if a := byID["100000000000"]; !a.Management || a.OUPath != "/" {
+		t.Errorf("management = %+v", a)
+	}
+	if a := byID["300000000000"]; a.OUPath != "/Prod/Web" || a.Tags["CostCenter"] != "web" || a.Management {
+		t.Errorf("prod-web = %+v", a)
+	}
+
+	tests := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{[]string{"ou-prod"}, nil, []string{"prod", "prod-web"}},
+		{[]string{"/Prod"}, []string{"/Prod/Web"}, []string{"prod"}},
+		{nil, []string{"ou-sandbox", "ou-web"}, []string{"management", "prod"}},
+		{[]string{"/Pro"}, nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := listOrgAccounts(context.Background(), fakeOrg{}, tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, a := range got {
+			names = append(names, a.Name)
+		}
+		if len(names) != len(tt.want) {
+			t.Errorf("include %v exclude %v = %v, want %v", tt.include, tt.exclude, names, tt.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.want[i] {
+				t.Errorf("include %v exclude %v = %v, want %v", tt.include, tt.exclude, names, tt.want)
+				break
+			}
+		}
+	}
+}
+
+type recordingAssumeRole struct{ roleARN string }
+
+func (f *recordingAssumeRole) AssumeRole(ctx context.Context, in *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.roleARN = aws.ToString(in.RoleArn)
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIAMEMBER"),
+		SecretAccessKey: aws.String("s"),
+		SessionToken:    aws.String("t"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func TestOrgAccountCredentialsAndTree(t *testing.T) {
+	setupAWSDir(t)
+	fake := &recordingAssumeRole{}
+	origRole, origOrg := assumeRoleClient, newOrgClient
+	assumeRoleClient = func(aws.Config) stscreds.AssumeRoleAPIClient { return fake }
+	newOrgClient = func(aws.Config) orgAPI { return fakeOrg{} }
+	defer func() { assumeRoleClient, newOrgClient = origRole, origOrg }()
+
+	cfg := &config.Config{OrgManagementProfile: "default", OrgMemberRole: "CloudTermReader", OrgExcludeOUs: "/Sandbox"}
+	d := NewDiscovery(cfg, log.New(io.Discard, "", 0))
+	if _, err := d.discoverOrgAccounts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.OrgAccounts(); len(got) != 3 {
+		t.Errorf("org accounts = %+v", got)
+	}
+
+	creds, err := d.OrgCredentials(context.Background(), OrgProfilePrefix+"300000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAMEMBER" || fake.roleARN != "arn:aws:iam::300000000000:role/CloudTermReader" {
+		t.Errorf("creds = %+v, role = %s", creds, fake.roleARN)
+	}
+
+	tree := d.annotateOrgAccounts(buildInstanceTree([]types.EC2Instance{
+		{InstanceID: "i-1", AccountID: "300000000000", AWSProfile: OrgProfilePrefix + "300000000000", AWSRegion: "us-east-1"},
+	}))
+	if n := tree.Accounts[0]; n.OUPath != "/Prod/Web" || n.AccountTags["CostCenter"] != "web" {
+		t.Errorf("account node = %+v", n)
+	}
+}
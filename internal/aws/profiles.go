@@ -0,0 +1,373 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/smithy-go"
+)
+
+// Profile kinds, by how the profile obtains credentials.
+const (
+	ProfileStatic            = "static"
+	ProfileSSO               = "sso"
+	ProfileAssumeRole        = "assume_role"
+	ProfileCredentialProcess = "credential_process"
+)
+
+// Profile statuses, as of the last scan.
+const (
+	ProfileUnchecked = "unchecked"
+	ProfileOK        = "ok"
+	// ProfileExpired means the profile's SSO session or session token has
+	// expired; an SSO login (or fresh keys) is needed before it can scan.
+	ProfileExpired = "expired"
+	ProfileError   = "error"
+)
+
+// Profile is a named profile from the shared AWS config and credentials
+// files, with its status from the last scan.
+type Profile struct {
+	Name          string `json:"name"`
+	Kind          string `json:"kind"`
+	Region        string `json:"region,omitempty"`
+	SSOSession    string `json:"sso_session,omitempty"`
+	SSOStartURL   string `json:"sso_start_url,omitempty"`
+	SSORegion     string `json:"sso_region,omitempty"`
+	SSOAccountID  string `json:"sso_account_id,omitempty"`
+	SSORoleName   string `json:"sso_role_name,omitempty"`
+	RoleARN       string `json:"role_arn,omitempty"`
+	SourceProfile string `json:"source_profile,omitempty"`
+	// SSOProfile is the profile whose SSO session this profile depends on:
+	// itself, or the SSO profile at the root of its source_profile chain.
+	SSOProfile     string     `json:"sso_profile,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+
+	ssoScopes []string
+}
+
+// ssoCacheKey is the key the SDK and the aws CLI derive the token cache
+// file name from.
+func (p Profile) ssoCacheKey() string {
+	if p.SSOSession != "" {
+		return p.SSOSession
+	}
+	return p.SSOStartURL
+}
+
+// iniSection is one [section] of a shared config file.
+type iniSection map[string]string
+
+// parseINI reads the sections of an AWS shared config file. Nested
+// sub-properties (the indented lines after a key like "s3 =") are skipped.
+func parseINI(path string) (map[string]iniSection, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]iniSection)
+	var order []string
+	var cur iniSection
+	nested := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if _, ok := sections[name]; !ok {
+				sections[name] = iniSection{}
+				order = append(order, name)
+			}
+			cur, nested = sections[name], false
+			continue
+		}
+		if cur == nil || (nested && (raw[0] == ' ' || raw[0] == '\t')) {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		nested = v == ""
+		cur[strings.ToLower(strings.TrimSpace(k))] = v
+	}
+	return sections, order, scanner.Err()
+}
+
+// sharedConfigFiles returns the shared config and credentials file paths,
+// honoring the same environment overrides as the SDK.
+func sharedConfigFiles() (configFile, credentialsFile string) {
+	configFile = os.Getenv("AWS_CONFIG_FILE")
+	credentialsFile = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if configFile == "" {
+		configFile = awsconfig.DefaultSharedConfigFilename()
+	}
+	if credentialsFile == "" {
+		credentialsFile = awsconfig.DefaultSharedCredentialsFilename()
+	}
+	return configFile, credentialsFile
+}
+
+// loadProfiles reads every profile that can produce credentials from the
+// shared config and credentials files: static keys, IAM Identity Center
+// (sso_session or legacy sso_start_url), role_arn with source_profile or
+// credential_source, and credential_process.
+func loadProfiles() []Profile {
+	configFile, credentialsFile := sharedConfigFiles()
+	creds, credOrder, _ := parseINI(credentialsFile)
+	cfg, cfgOrder, _ := parseINI(configFile)
+
+	merged := make(map[string]iniSection)
+	var names []string
+	add := func(name string, sec iniSection) {
+		m, ok := merged[name]
+		if !ok {
+			m = iniSection{}
+			merged[name] = m
+			names = append(names, name)
+		}
+		for k, v := range sec {
+			m[k] = v
+		}
+	}
+	sessions := make(map[string]iniSection)
+	for _, sec := range cfgOrder {
+		switch {
+		case sec == "default":
+			add(sec, cfg[sec])
+		case strings.HasPrefix(sec, "profile "):
+			add(strings.TrimPrefix(sec, "profile "), cfg[sec])
+		case strings.HasPrefix(sec, "sso-session "):
+			sessions[strings.TrimPrefix(sec, "sso-session ")] = cfg[sec]
+		}
+	}
+	// The credentials file takes precedence over the config file.
+	for _, name := range credOrder {
+		add(name, creds[name])
+	}
+
+	byName := make(map[string]Profile, len(names))
+	for _, name := range names {
+		sec := merged[name]
+		p := Profile{
+			Name:          name,
+			Region:        sec["region"],
+			SSOSession:    sec["sso_session"],
+			SSOStartURL:   sec["sso_start_url"],
+			SSORegion:     sec["sso_region"],
+			SSOAccountID:  sec["sso_account_id"],
+			SSORoleName:   sec["sso_role_name"],
+			RoleARN:       sec["role_arn"],
+			SourceProfile: sec["source_profile"],
+			Status:        ProfileUnchecked,
+		}
+		if s, ok := sessions[p.SSOSession]; ok && p.SSOSession != "" {
+			p.SSOStartURL = s["sso_start_url"]
+			p.SSORegion = s["sso_region"]
+			if scopes := s["sso_registration_scopes"]; scopes != "" {
+				for _, sc := range strings.Split(scopes, ",") {
+					p.ssoScopes = append(p.ssoScopes, strings.TrimSpace(sc))
+				}
+			}
+		}
+		switch {
+		case sec["aws_access_key_id"] != "":
+			p.Kind = ProfileStatic
+		case p.RoleARN != "" && (p.SourceProfile != "" || sec["credential_source"] != ""):
+			p.Kind = ProfileAssumeRole
+		case p.SSOStartURL != "":
+			p.Kind = ProfileSSO
+		case sec["credential_process"] != "":
+			p.Kind = ProfileCredentialProcess
+		default:
+			// Region-only or incomplete profiles have nothing to scan with.
+			continue
+		}
+		byName[name] = p
+	}
+
+	profiles := make([]Profile, 0, len(byName))
+	for _, name := range names {
+		p, ok := byName[name]
+		if !ok {
+			continue
+		}
+		// Follow source_profile to the SSO profile an assumed role depends on.
+		root, seen := p, map[string]bool{}
+		for root.Kind == ProfileAssumeRole && root.SourceProfile != "" && !seen[root.Name] {
+			seen[root.Name] = true
+			next, ok := byName[root.SourceProfile]
+			if !ok {
+				break
+			}
+			root = next
+		}
+		if root.Kind == ProfileSSO {
+			p.SSOProfile = root.Name
+			if p.Kind != ProfileSSO {
+				p.SSOSession, p.SSOStartURL, p.SSORegion, p.ssoScopes = root.SSOSession, root.SSOStartURL, root.SSORegion, root.ssoScopes
+			}
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// ssoCachedToken is the token cache file shared with the SDK and aws CLI.
+type ssoCachedToken struct {
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	Region                string `json:"region,omitempty"`
+	StartURL              string `json:"startUrl,omitempty"`
+}
+
+// ssoTokenState reports when the cached SSO token of a profile expires and
+// whether it can still be used: unexpired, or (for sso_session profiles,
+// which the SDK refreshes) holding a refresh token.
+func ssoTokenState(p Profile) (expires time.Time, usable bool) {
+	path, err := ssocreds.StandardCachedTokenFilepath(p.ssoCacheKey())
+	if err != nil {
+		return time.Time{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var tok ssoCachedToken
+	if json.Unmarshal(data, &tok) != nil || tok.AccessToken == "" {
+		return time.Time{}, false
+	}
+	expires, _ = time.Parse(time.RFC3339, tok.ExpiresAt)
+	if time.Now().Before(expires) {
+		return expires, true
+	}
+	if p.SSOSession == "" || tok.RefreshToken == "" || tok.ClientID == "" {
+		return expires, false
+	}
+	if reg, err := time.Parse(time.RFC3339, tok.RegistrationExpiresAt); err == nil && time.Now().After(reg) {
+		return expires, false
+	}
+	return expires, true
+}
+
+// writeSSOToken stores a token where the SDK and aws CLI look for it.
+func writeSSOToken(p Profile, tok ssoCachedToken) error {
+	path, err := ssocreds.StandardCachedTokenFilepath(p.ssoCacheKey())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create sso cache dir: %w", err)
+	}
+	data, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write sso token: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// isExpiredCredentials reports whether a credential error means the
+// profile's session has expired rather than being misconfigured.
+func isExpiredCredentials(err error) bool {
+	var invalid *ssocreds.InvalidTokenError
+	if errors.As(err, &invalid) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ExpiredToken", "ExpiredTokenException", "UnauthorizedException", "RequestExpired":
+			return true
+		}
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "SSO token") || strings.Contains(msg, "token has expired")
+}
+
+// checkProfile resolves a profile's credentials once, so a scan can skip
+// profiles that cannot authenticate instead of failing in every region.
+func checkProfile(ctx context.Context, p Profile) (status, errMsg string) {
+	if p.SSOProfile != "" {
+		if _, ok := ssoTokenState(p); !ok {
+			return ProfileExpired, "SSO session expired or not logged in"
+		}
+	}
+	region := p.Region
+	if region == "" {
+		region = stsRegion
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(region),
+		awsconfig.WithSharedConfigProfile(p.Name),
+		awsconfig.WithHTTPClient(leanHTTPClient),
+	)
+	if err != nil {
+		return ProfileError, err.Error()
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		if isExpiredCredentials(err) {
+			return ProfileExpired, err.Error()
+		}
+		return ProfileError, err.Error()
+	}
+	return ProfileOK, ""
+}
+
+// Profiles lists the shared config profiles with their status from the
+// last scan. SSO profiles whose session was renewed since are reported as
+// unchecked until the next scan.
+func (d *Discovery) Profiles() []Profile {
+	profiles := loadProfiles()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for i := range profiles {
+		p := &profiles[i]
+		if last, ok := d.profileStatus[p.Name]; ok {
+			p.Status, p.Error = last.Status, last.Error
+		}
+		if p.SSOProfile == "" {
+			continue
+		}
+		expires, usable := ssoTokenState(*p)
+		if !expires.IsZero() {
+			p.TokenExpiresAt = &expires
+		}
+		switch {
+		case !usable:
+			p.Status, p.Error = ProfileExpired, "SSO session expired or not logged in"
+		case p.Status == ProfileExpired:
+			p.Status, p.Error = ProfileUnchecked, ""
+		}
+	}
+	sort.SliceStable(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
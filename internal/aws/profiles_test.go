@@ -0,0 +1,230 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	ssooidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+const testConfig = `[default]
+region = us-east-1
+
+[profile dev]
+sso_session = corp
+sso_account_id = 111111111111
+sso_role_name = ReadOnly
+region = eu-west-1
+s3 =
+  max_concurrent_requests = 10
+
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = us-west-2
+sso_account_id = 222222222222
+sso_role_name = Admin
+
+[profile prod]
+role_arn = arn:aws:iam::333333333333:role/CloudTerm
+source_profile = dev
+
+[profile tool]
+credential_process = /usr/local/bin/creds
+
+[profile region-only]
+region = ap-south-1
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-1
+sso_registration_scopes = sso:account:access
+`
+
+const testCredentials = `[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = secret
+`
+
+// setupAWSDir points the shared config files and the SSO token cache at a
+// temporary home directory.
+func setupAWSDir(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".aws")
+	os.MkdirAll(dir, 0700)
+	os.WriteFile(filepath.Join(dir, "config"), []byte(testConfig), 0600)
+	os.WriteFile(filepath.Join(dir, "credentials"), []byte(testCredentials), 0600)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	// leanHTTPClient cannot take a custom CA bundle from the environment.
+	t.Setenv("AWS_CA_BUNDLE", "")
+	return home
+}
+
+func TestLoadProfiles(t *testing.T) {
+	setupAWSDir(t)
+	byName := map[string]Profile{}
+	for _, p := range loadProfiles() {
+		byName[p.Name] = p
+	}
+	if len(byName) != 5 {
+		t.Fatalf("profiles = %+v", byName)
+	}
+	if _, ok := byName["region-only"]; ok {
+		t.Error("profile without credentials listed")
+	}
+	if p := byName["default"]; p.Kind != ProfileStatic || p.Region != "us-east-1" {
+		t.Errorf("default = %+v", p)
+	}
+	dev := byName["dev"]
+	if dev.Kind != ProfileSSO || dev.SSOStartURL != "https://corp.awsapps.com/start" || dev.SSORegion != "us-east-1" || dev.SSOProfile != "dev" || dev.Region != "eu-west-1" {
+		t.Errorf("dev = %+v", dev)
+	}
+	if p := byName["legacy"]; p.Kind != ProfileSSO || p.ssoCacheKey() != "https://legacy.awsapps.com/start" {
+		t.Errorf("legacy = %+v", p)
+	}
+	if p := byName["prod"]; p.Kind != ProfileAssumeRole || p.SSOProfile != "dev" || p.ssoCacheKey() != "corp" {
+		t.Errorf("prod = %+v", p)
+	}
+	if p := byName["tool"]; p.Kind != ProfileCredentialProcess {
+		t.Errorf("tool = %+v", p)
+	}
+}
+
+func writeTestToken(t *testing.T, key string, tok ssoCachedToken) {
+	path, err := ssocreds.StandardCachedTokenFilepath(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0700)
+	data, _ := json.Marshal(tok)
+	os.WriteFile(path, data, 0600)
+}
+
+func TestProfilesReportExpiredSSO(t *testing.T) {
+	setupAWSDir(t)
+	d := NewDiscovery(nil, log.New(io.Discard, "", 0))
+	d.profileStatus["legacy"] = profileCheck{Status: ProfileExpired}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	// The corp session expired but can be refreshed; the legacy one cannot.
+	writeTestToken(t, "corp", ssoCachedToken{AccessToken: "a", ExpiresAt: past, RefreshToken: "r", ClientID: "c", ClientSecret: "s"})
+	writeTestToken(t, "https://legacy.awsapps.com/start", ssoCachedToken{AccessToken: "a", ExpiresAt: past, RefreshToken: "r", ClientID: "c"})
+
+	status := map[string]string{}
+	for _, p := range d.Profiles() {
+		status[p.Name] = p.Status
+	}
+	if status["dev"] != ProfileUnchecked || status["prod"] != ProfileUnchecked || status["legacy"] != ProfileExpired {
+		t.Errorf("status = %v", status)
+	}
+
+	// A renewed session clears the expired status until the next scan.
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	writeTestToken(t, "https://legacy.awsapps.com/start", ssoCachedToken{AccessToken: "b", ExpiresAt: future})
+	for _, p := range d.Profiles() {
+		if p.Name == "legacy" && (p.Status != ProfileUnchecked || p.TokenExpiresAt == nil) {
+			t.Errorf("legacy after login = %+v", p)
+		}
+	}
+}
+
+type fakeOIDC struct {
+	pending int
+	scopes  []string
+}
+
+func (f *fakeOIDC) RegisterClient(ctx context.Context, in *ssooidc.RegisterClientInput, _ ...func(*ssooidc.Options)) (*ssooidc.RegisterClientOutput, error) {
+	f.scopes = in.Scopes
+	return &ssooidc.RegisterClientOutput{
+		ClientId:              aws.String("client"),
+		ClientSecret:          aws.String("client-secret"),
+		ClientSecretExpiresAt: time.Now().Add(90 * 24 * time.Hour).Unix(),
+	}, nil
+}
+
+func (f *fakeOIDC) StartDeviceAuthorization(ctx context.Context, in *ssooidc.StartDeviceAuthorizationInput, _ ...func(*ssooidc.Options)) (*ssooidc.StartDeviceAuthorizationOutput, error) {
+	return &ssooidc.StartDeviceAuthorizationOutput{
+		DeviceCode:              aws.String("device"),
+		UserCode:                aws.String("ABCD-EFGH"),
+		VerificationUri:         aws.String("https://device.sso.us-east-1.amazonaws.com/"),
+		VerificationUriComplete: aws.String("https://device.sso.us-east-1.amazonaws.com/?user_code=ABCD-EFGH"),
+		ExpiresIn:               60,
+	}, nil
+}
+
+func (f *fakeOIDC) CreateToken(ctx context.Context, in *ssooidc.CreateTokenInput, _ ...func(*ssooidc.Options)) (*ssooidc.CreateTokenOutput, error) {
+	if f.pending > 0 {
+		f.pending--
+		return nil, &ssooidctypes.AuthorizationPendingException{}
+	}
+	return &ssooidc.CreateTokenOutput{
+		AccessToken:  aws.String("access"),
+		RefreshToken: aws.String("refresh"),
+		ExpiresIn:    3600,
+	}, nil
+}
+
+func TestSSOLogin(t *testing.T) {
+	setupAWSDir(t)
+	fake := &fakeOIDC{pending: 3}
+	origClient, origInterval := newSSOOIDCClient, ssoPollInterval
+	newSSOOIDCClient = func(string) ssoOIDCAPI { return fake }
+	ssoPollInterval = 10 * time.Millisecond
+	defer func() { newSSOOIDCClient, ssoPollInterval = origClient, origInterval }()
+
+	d := NewDiscovery(nil, log.New(io.Discard, "", 0))
+	if _, err := d.StartSSOLogin(context.Background(), "tool"); err == nil {
+		t.Error("login started for a non-SSO profile")
+	}
+
+	// prod assumes a role from dev, so it logs in to the corp session.
+	login, err := d.StartSSOLogin(context.Background(), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.UserCode != "ABCD-EFGH" || login.StartURL != "https://corp.awsapps.com/start" || login.Status != SSOLoginPending {
+		t.Errorf("login = %+v", login)
+	}
+	if again, _ := d.StartSSOLogin(context.Background(), "dev"); again.ID != login.ID {
+		t.Error("pending login for the same session not reused")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := d.GetSSOLogin(login.ID)
+		if got.Status != SSOLoginPending {
+			if got.Status != SSOLoginComplete {
+				t.Fatalf("login = %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("login did not complete")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	path, _ := ssocreds.StandardCachedTokenFilepath("corp")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tok ssoCachedToken
+	json.Unmarshal(data, &tok)
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" || tok.ClientID != "client" || tok.StartURL != "https://corp.awsapps.com/start" {
+		t.Errorf("cached token = %+v", tok)
+	}
+	if _, ok := ssoTokenState(Profile{SSOSession: "corp"}); !ok {
+		t.Error("cached token not usable")
+	}
+}
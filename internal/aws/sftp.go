@@ -0,0 +1,173 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpChunkSize is the unit of SFTP reads and writes between progress
+// updates.
+const sftpChunkSize = 256 * 1024
+
+// withSFTP dials the instance over SSH as osUser and runs fn with an SFTP
+// client. The connection is closed when fn returns or timeout passes.
+func (d *Discovery) withSFTP(profile, region, instanceID, osUser string, timeout time.Duration, fn func(*sftp.Client) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := d.DialSSH(ctx, profile, region, instanceID, osUser)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	fs, err := client.SFTP()
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	return fn(fs)
+}
+
+// BrowseDirectorySFTP lists a directory over SFTP as osUser.
+func (d *Discovery) BrowseDirectorySFTP(profile, region, instanceID, osUser, dir string) ([]FileEntry, error) {
+	var entries []FileEntry
+	err := d.withSFTP(profile, region, instanceID, osUser, 90*time.Second, func(fs *sftp.Client) error {
+		var err error
+		entries, err = sftpList(fs, dir)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("browse failed: %w", err)
+	}
+	return entries, nil
+}
+
+// UploadFileSFTP writes data to remotePath over SFTP as osUser.
+func (d *Discovery) UploadFileSFTP(profile, region, instanceID, osUser, remotePath string, data []byte, onProgress func(TransferProgress)) error {
+	timeout := 10*time.Minute + time.Duration(len(data)/(1024*1024))*2*time.Minute
+	onProgress(TransferProgress{Progress: 0, Message: "Connecting over SSH...", Status: "progress"})
+	return d.withSFTP(profile, region, instanceID, osUser, timeout, func(fs *sftp.Client) error {
+		return sftpUpload(fs, remotePath, data, onProgress)
+	})
+}
+
+// DownloadFileSFTP reads remotePath over SFTP as osUser, returning its
+// contents and base name.
+func (d *Discovery) DownloadFileSFTP(profile, region, instanceID, osUser, remotePath string, onProgress func(TransferProgress)) ([]byte, string, error) {
+	var data []byte
+	onProgress(TransferProgress{Progress: 0, Message: "Connecting over SSH...", Status: "progress"})
+	// Downloads are streamed without a SendCommand round trip per chunk, so
+	// one generous bound covers any file size the browser can hold.
+	err := d.withSFTP(profile, region, instanceID, osUser, 30*time.Minute, func(fs *sftp.Client) error {
+		var err error
+		data, err = sftpDownload(fs, remotePath, onProgress)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, path.Base(remotePath), nil
+}
+
+func sftpList(fs *sftp.Client, dir string) ([]FileEntry, error) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]FileEntry, 0, len(infos))
+	for _, fi := range infos {
+		size := fi.Size()
+		if fi.IsDir() {
+			size = 0
+		}
+		entries = append(entries, FileEntry{
+			Name:        fi.Name(),
+			Size:        size,
+			IsDir:       fi.IsDir(),
+			Modified:    fi.ModTime().Format("2006-01-02 15:04"),
+			Permissions: fi.Mode().String(),
+		})
+	}
+	sortFileEntries(entries)
+	return entries, nil
+}
+
+func sftpUpload(fs *sftp.Client, remotePath string, data []byte, onProgress func(TransferProgress)) error {
+	f, err := fs.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", remotePath, err)
+	}
+	start := time.Now()
+	total := int64(len(data))
+	for off := 0; off < len(data); off += sftpChunkSize {
+		end := min(off+sftpChunkSize, len(data))
+		if _, err := f.Write(data[off:end]); err != nil {
+			f.Close()
+			return fmt.Errorf("write %s: %w", remotePath, err)
+		}
+		onProgress(sftpProgress("Uploading", int64(end), total, start))
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func sftpDownload(fs *sftp.Client, remotePath string, onProgress func(TransferProgress)) ([]byte, error) {
+	f, err := fs.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", remotePath, err)
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", remotePath)
+	}
+
+	total := fi.Size()
+	data := make([]byte, 0, total)
+	buf := make([]byte, sftpChunkSize)
+	start := time.Now()
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if n > 0 {
+			onProgress(sftpProgress("Downloading", int64(len(data)), total, start))
+		}
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", remotePath, err)
+		}
+	}
+}
+
+// sftpProgress reports done of total bytes, keeping the last percent for
+// the caller's completion message.
+func sftpProgress(verb string, done, total int64, start time.Time) TransferProgress {
+	p := TransferProgress{Status: "progress", TotalBytes: total}
+	if total > 0 {
+		p.Progress = int(done * 99 / total)
+	}
+	p.Message = fmt.Sprintf("%s %d/%d bytes", verb, done, total)
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		p.SpeedBps = int64(float64(done) / elapsed)
+		if p.SpeedBps > 0 {
+			p.ETASec = (total - done) / p.SpeedBps
+		}
+	}
+	return p
+}
@@ -0,0 +1,79 @@
+package aws
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeRWC joins a reader and writer into the stream sftp.NewServer serves.
+type pipeRWC struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newSFTPPair serves the local filesystem over in-memory pipes.
+func newSFTPPair(t *testing.T) *sftp.Client {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	server, err := sftp.NewServer(pipeRWC{serverR, serverW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientR, clientW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// Closing the server's end first lets the client's reader finish.
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func TestSFTPTransfers(t *testing.T) {
+	fs := newSFTPPair(t)
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "logs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), sftpChunkSize/4) // spans several chunks
+	var updates []TransferProgress
+	remote := filepath.Join(dir, "app.tar")
+	if err := sftpUpload(fs, remote, data, func(p TransferProgress) { updates = append(updates, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(remote); !bytes.Equal(got, data) {
+		t.Fatalf("uploaded %d bytes, want %d", len(got), len(data))
+	}
+	if len(updates) < 2 || updates[len(updates)-1].Progress != 99 || updates[0].TotalBytes != int64(len(data)) {
+		t.Fatalf("upload progress %+v", updates)
+	}
+
+	entries, err := sftpList(fs, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "logs" || !entries[0].IsDir || entries[1].Name != "app.tar" ||
+		entries[1].Size != int64(len(data)) || entries[1].Permissions[0] != '-' {
+		t.Fatalf("entries %+v", entries)
+	}
+
+	got, err := sftpDownload(fs, remote, func(TransferProgress) {})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, %v", len(got), err)
+	}
+	if _, err := sftpDownload(fs, filepath.Join(dir, "logs"), func(TransferProgress) {}); err == nil {
+		t.Fatal("expected an error downloading a directory")
+	}
+	if _, err := sftpDownload(fs, filepath.Join(dir, "missing"), func(TransferProgress) {}); err == nil {
+		t.Fatal("expected an error downloading a missing file")
+	}
+}
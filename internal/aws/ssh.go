@@ -0,0 +1,91 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"cloudterm-go/internal/sshclient"
+	"cloudterm-go/internal/ssmclient"
+)
+
+// SSHKeyFunc returns the stored private key to log in to instanceID as
+// osUser, or nil when there is none.
+type SSHKeyFunc func(instanceID, osUser string) (*sshclient.Key, error)
+
+// SetSSHKeys makes DialSSH log in with stored keys where fn has one.
+func (d *Discovery) SetSSHKeys(fn SSHKeyFunc) {
+	d.sshKeys = fn
+}
+
+// SetHostKeys makes DialSSH pin host keys in keys instead of in memory.
+func (d *Discovery) SetHostKeys(keys sshclient.HostKeys) {
+	d.hostKeys = keys
+}
+
+// SetHostKeyHook registers fn to be called when DialSSH refuses an instance
+// because its host key differs from the pinned one.
+func (d *Discovery) SetHostKeyHook(fn func(instanceID string, err error)) {
+	d.hostKeyChanged = fn
+}
+
+// DialSSH connects to an instance's SSH server as osUser. Without a stored
+// key from SetSSHKeys, an ephemeral key is pushed with EC2 Instance Connect
+// (valid on the instance for 60 seconds, long enough for the handshake).
+// The connection is tunnelled over an AWS-StartSSHSession stream so the
+// instance needs no inbound port. The first host key an instance presents
+// is pinned and any other is refused. Closing the client ends the tunnel.
+func (d *Discovery) DialSSH(ctx context.Context, profile, region, instanceID, osUser string) (*sshclient.Client, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, d.awsConfigOpts(profile, region)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	var key *sshclient.Key
+	if d.sshKeys != nil {
+		if key, err = d.sshKeys(instanceID, osUser); err != nil {
+			return nil, err
+		}
+	}
+	if key == nil {
+		if key, err = d.pushEphemeralKey(ctx, awsCfg, instanceID, osUser); err != nil {
+			return nil, err
+		}
+	}
+
+	tunnel, err := ssmclient.StartSSH(ctx, ssm.NewFromConfig(awsCfg), instanceID, 22)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sshclient.Dial(ctx, tunnel.Conn(), osUser, key, sshclient.TrustOnFirstUse(d.hostKeys, instanceID))
+	if errors.Is(err, sshclient.ErrHostKeyChanged) && d.hostKeyChanged != nil {
+		d.hostKeyChanged(instanceID, err)
+	}
+	return client, err
+}
+
+// pushEphemeralKey generates a key and authorizes it for osUser with EC2
+// Instance Connect.
+func (d *Discovery) pushEphemeralKey(ctx context.Context, awsCfg aws.Config, instanceID, osUser string) (*sshclient.Key, error) {
+	key, err := sshclient.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	out, err := ec2instanceconnect.NewFromConfig(awsCfg).SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(instanceID),
+		InstanceOSUser: aws.String(osUser),
+		SSHPublicKey:   aws.String(key.AuthorizedKey()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EC2 Instance Connect SendSSHPublicKey: %w", err)
+	}
+	if !out.Success {
+		return nil, fmt.Errorf("EC2 Instance Connect did not accept the key for %s", osUser)
+	}
+	return key, nil
+}
@@ -0,0 +1,18 @@
+package aws
+
+import (
+	"context"
+
+	"cloudterm-go/internal/ssmclient"
+)
+
+// StartShellSession opens an interactive Session Manager shell on an
+// instance over a native data channel, using the credentials of profile
+// (including manual accounts).
+func (d *Discovery) StartShellSession(ctx context.Context, profile, region, instanceID string) (*ssmclient.Session, error) {
+	client, err := d.newSSMClient(ctx, profile, region)
+	if err != nil {
+		return nil, err
+	}
+	return ssmclient.StartShell(ctx, client, instanceID)
+}
@@ -0,0 +1,232 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	ssooidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+// SSO login states.
+const (
+	SSOLoginPending  = "pending"
+	SSOLoginComplete = "complete"
+	SSOLoginFailed   = "failed"
+)
+
+const (
+	ssoClientName    = "cloudterm"
+	ssoDeviceGrant   = "urn:ietf:params:oauth:grant-type:device_code"
+	ssoDefaultScopes = "sso:account:access"
+)
+
+// ErrNoSSOProfile is returned by StartSSOLogin for a profile that is
+// missing or does not use IAM Identity Center.
+var ErrNoSSOProfile = errors.New("no IAM Identity Center profile")
+
+// ssoPollInterval is used when StartDeviceAuthorization suggests none;
+// shortened in tests.
+var ssoPollInterval = 5 * time.Second
+
+// ssoOIDCAPI is the subset of the SSO OIDC client the login flow uses.
+type ssoOIDCAPI interface {
+	RegisterClient(ctx context.Context, in *ssooidc.RegisterClientInput, optFns ...func(*ssooidc.Options)) (*ssooidc.RegisterClientOutput, error)
+	StartDeviceAuthorization(ctx context.Context, in *ssooidc.StartDeviceAuthorizationInput, optFns ...func(*ssooidc.Options)) (*ssooidc.StartDeviceAuthorizationOutput, error)
+	CreateToken(ctx context.Context, in *ssooidc.CreateTokenInput, optFns ...func(*ssooidc.Options)) (*ssooidc.CreateTokenOutput, error)
+}
+
+// newSSOOIDCClient builds the (unauthenticated) SSO OIDC client for a
+// region; replaced in tests.
+var newSSOOIDCClient = func(region string) ssoOIDCAPI {
+	return ssooidc.NewFromConfig(aws.Config{Region: region, HTTPClient: leanHTTPClient})
+}
+
+// SSOLogin is an in-progress or finished IAM Identity Center device
+// authorization. The user opens VerificationURIComplete (or enters
+// UserCode at VerificationURI) to approve it.
+type SSOLogin struct {
+	ID                      string    `json:"id"`
+	Profile                 string    `json:"profile"`
+	StartURL                string    `json:"start_url"`
+	UserCode                string    `json:"user_code"`
+	VerificationURI         string    `json:"verification_uri"`
+	VerificationURIComplete string    `json:"verification_uri_complete"`
+	ExpiresAt               time.Time `json:"expires_at"`
+	Status                  string    `json:"status"`
+	Error                   string    `json:"error,omitempty"`
+
+	cacheKey string
+}
+
+// StartSSOLogin begins a device authorization for the SSO session behind
+// profile (directly, or through its source_profile chain). The returned
+// login completes in the background once the user approves it, writing
+// the token to the cache shared with the SDK and aws CLI. A pending login
+// for the same session is reused.
+func (d *Discovery) StartSSOLogin(ctx context.Context, profile string) (SSOLogin, error) {
+	var p *Profile
+	for _, cand := range loadProfiles() {
+		if cand.Name == profile {
+			p = &cand
+			break
+		}
+	}
+	if p == nil {
+		return SSOLogin{}, fmt.Errorf("%w: profile %s not found", ErrNoSSOProfile, profile)
+	}
+	if p.SSOProfile == "" || p.SSOStartURL == "" || p.SSORegion == "" {
+		return SSOLogin{}, fmt.Errorf("%w: profile %s does not use IAM Identity Center", ErrNoSSOProfile, profile)
+	}
+
+	d.ssoMu.Lock()
+	for _, l := range d.ssoLogins {
+		if l.cacheKey == p.ssoCacheKey() && l.Status == SSOLoginPending && time.Now().Before(l.ExpiresAt) {
+			login := *l
+			d.ssoMu.Unlock()
+			return login, nil
+		}
+	}
+	d.ssoMu.Unlock()
+
+	client := newSSOOIDCClient(p.SSORegion)
+	scopes := p.ssoScopes
+	if len(scopes) == 0 {
+		scopes = []string{ssoDefaultScopes}
+	}
+	reg, err := client.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String(ssoClientName),
+		ClientType: aws.String("public"),
+		Scopes:     scopes,
+	})
+	if err != nil {
+		return SSOLogin{}, fmt.Errorf("register sso client: %w", err)
+	}
+	auth, err := client.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     reg.ClientId,
+		ClientSecret: reg.ClientSecret,
+		StartUrl:     aws.String(p.SSOStartURL),
+	})
+	if err != nil {
+		return SSOLogin{}, fmt.Errorf("start device authorization: %w", err)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return SSOLogin{}, err
+	}
+	expiresIn := time.Duration(auth.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 10 * time.Minute
+	}
+	login := &SSOLogin{
+		ID:                      id,
+		Profile:                 profile,
+		StartURL:                p.SSOStartURL,
+		UserCode:                aws.ToString(auth.UserCode),
+		VerificationURI:         aws.ToString(auth.VerificationUri),
+		VerificationURIComplete: aws.ToString(auth.VerificationUriComplete),
+		ExpiresAt:               time.Now().Add(expiresIn),
+		Status:                  SSOLoginPending,
+		cacheKey:                p.ssoCacheKey(),
+	}
+	d.ssoMu.Lock()
+	for k, l := range d.ssoLogins {
+		// Finished logins are kept until they would have expired anyway.
+		if time.Now().After(l.ExpiresAt) {
+			delete(d.ssoLogins, k)
+		}
+	}
+	d.ssoLogins[id] = login
+	d.ssoMu.Unlock()
+
+	go d.pollSSOLogin(client, *p, login, reg, auth)
+	return *login, nil
+}
+
+// GetSSOLogin returns a login started by StartSSOLogin.
+func (d *Discovery) GetSSOLogin(id string) (SSOLogin, bool) {
+	d.ssoMu.Lock()
+	defer d.ssoMu.Unlock()
+	l, ok := d.ssoLogins[id]
+	if !ok {
+		return SSOLogin{}, false
+	}
+	return *l, true
+}
+
+// pollSSOLogin waits for the user to approve a device authorization and
+// caches the resulting token.
+func (d *Discovery) pollSSOLogin(client ssoOIDCAPI, p Profile, login *SSOLogin, reg *ssooidc.RegisterClientOutput, auth *ssooidc.StartDeviceAuthorizationOutput) {
+	ctx, cancel := context.WithDeadline(context.Background(), login.ExpiresAt)
+	defer cancel()
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = ssoPollInterval
+	}
+	finish := func(status string, err error) {
+		d.ssoMu.Lock()
+		login.Status = status
+		if err != nil {
+			login.Error = err.Error()
+		}
+		d.ssoMu.Unlock()
+		if err != nil {
+			d.logger.Printf("SSO login for profile %s failed: %v", p.Name, err)
+		} else {
+			d.logger.Printf("SSO login for profile %s complete", p.Name)
+		}
+	}
+
+	for {
+		tok, err := client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     reg.ClientId,
+			ClientSecret: reg.ClientSecret,
+			DeviceCode:   auth.DeviceCode,
+			GrantType:    aws.String(ssoDeviceGrant),
+		})
+		if err == nil {
+			cached := ssoCachedToken{
+				AccessToken: aws.ToString(tok.AccessToken),
+				ExpiresAt:   time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second).UTC().Format(time.RFC3339),
+				Region:      p.SSORegion,
+				StartURL:    p.SSOStartURL,
+			}
+			// Only sso_session profiles refresh their token, so only they
+			// keep the client registration.
+			if p.SSOSession != "" {
+				cached.RefreshToken = aws.ToString(tok.RefreshToken)
+				cached.ClientID = aws.ToString(reg.ClientId)
+				cached.ClientSecret = aws.ToString(reg.ClientSecret)
+				cached.RegistrationExpiresAt = time.Unix(reg.ClientSecretExpiresAt, 0).UTC().Format(time.RFC3339)
+			}
+			if err := writeSSOToken(p, cached); err != nil {
+				finish(SSOLoginFailed, err)
+				return
+			}
+			finish(SSOLoginComplete, nil)
+			return
+		}
+
+		var pending *ssooidctypes.AuthorizationPendingException
+		var slowDown *ssooidctypes.SlowDownException
+		switch {
+		case errors.As(err, &pending):
+		case errors.As(err, &slowDown):
+			interval += 5 * time.Second
+		default:
+			finish(SSOLoginFailed, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			finish(SSOLoginFailed, errors.New("device authorization expired before it was approved"))
+			return
+		case <-time.After(interval):
+		}
+	}
+}
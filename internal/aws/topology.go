@@ -11,7 +11,6 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
@@ -269,7 +268,7 @@ func (d *Discovery) FetchVPCTopology(ctx context.Context, instanceID string) (*V
 		awsconfig.WithRegion(inst.AWSRegion),
 	}
 	if inst.AWSProfile != "" {
-		opts = append(opts, awsconfig.WithSharedConfigProfile(inst.AWSProfile))
+		opts = d.awsConfigOpts(inst.AWSProfile, inst.AWSRegion)
 	}
 	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
@@ -1301,15 +1300,15 @@ func (d *Discovery) buildCredentialResolver(ctx context.Context, fallbackRegion
 	// 2. Manually-added accounts (UI-added) — used if not already covered by a profile.
 	if d.accounts != nil {
 		for _, acct := range d.accounts.ListRaw() {
+			creds, err := d.accounts.Credentials(acct.ID)
+			if err != nil {
+				continue
+			}
 			// The ManualAccount.ID is an internal random UUID, not the AWS account ID.
 			// We need to call GetCallerIdentity to find the actual AWS account ID.
 			opts := []func(*awsconfig.LoadOptions) error{
 				awsconfig.WithRegion(fallbackRegion),
-				awsconfig.WithCredentialsProvider(
-					credentials.NewStaticCredentialsProvider(
-						acct.AccessKeyID, acct.SecretAccessKey, acct.SessionToken,
-					),
-				),
+				awsconfig.WithCredentialsProvider(creds),
 			}
 			cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 			if err != nil {
@@ -0,0 +1,313 @@
+// Package catalog keeps a persistent history of terminal sessions: who
+// opened which instance when, how much data moved, which recordings were
+// made and which commands were run.
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MaxCommands caps how many commands are kept per session.
+const MaxCommands = 2000
+
+// EndInterrupted marks sessions that were open when the server stopped.
+const EndInterrupted = "interrupted"
+
+var (
+	sessionsBucket = []byte("sessions")
+	idsBucket      = []byte("ids")
+	commandsBucket = []byte("commands")
+)
+
+// ErrNotFound is returned for unknown session IDs.
+var ErrNotFound = errors.New("session not found")
+
+// Record is one session in the catalog.
+type Record struct {
+	SessionID    string     `json:"session_id"`
+	User         string     `json:"user"`
+	InstanceID   string     `json:"instance_id"`
+	InstanceName string     `json:"instance_name,omitempty"`
+	AccountID    string     `json:"account_id,omitempty"`
+	Profile      string     `json:"profile,omitempty"`
+	Region       string     `json:"region,omitempty"`
+	SSHUser      string     `json:"ssh_user,omitempty"`
+	Started      time.Time  `json:"started"`
+	Ended        *time.Time `json:"ended,omitempty"` // nil while the session is open
+	EndReason    string     `json:"end_reason,omitempty"`
+	BytesIn      int64      `json:"bytes_in"`
+	BytesOut     int64      `json:"bytes_out"`
+	Recordings   []string   `json:"recordings,omitempty"`
+	CommandCount int        `json:"command_count"`
+	Commands     []Command  `json:"commands,omitempty"`
+}
+
+// Command is a command line detected in a session.
+type Command struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+}
+
+// Ending is how a session finished.
+type Ending struct {
+	Reason     string
+	Ended      time.Time
+	BytesIn    int64
+	BytesOut   int64
+	Recordings []string
+}
+
+// Filter selects records for List. Zero fields match everything.
+type Filter struct {
+	User       string
+	InstanceID string
+	AccountID  string
+	// Since and Until select sessions that were open at some point in the
+	// window.
+	Since time.Time
+	Until time.Time
+	// Query matches sessions that ran a command containing it
+	// (case-insensitive); the matching commands are returned with them.
+	Query  string
+	Active bool // only sessions that are still open
+	Limit  int
+}
+
+// Store persists session records in a bbolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the catalog at path. Sessions that were open when
+// the server stopped are closed as interrupted at their last known
+// activity.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open session catalog: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{idsBucket, commandsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		sessions, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		var open []Record
+		var keys [][]byte
+		err = sessions.ForEach(func(k, v []byte) error {
+			var rec Record
+			if json.Unmarshal(v, &rec) == nil && rec.Ended == nil {
+				open = append(open, rec)
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, rec := range open {
+			ended := rec.Started
+			if cmds := loadCommands(tx, keys[i]); len(cmds) > 0 {
+				ended = cmds[len(cmds)-1].Time
+			}
+			rec.Ended, rec.EndReason = &ended, EndInterrupted
+			if err := putRecord(sessions, keys[i], &rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init session catalog: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Start adds an open session. It does nothing if rec.SessionID is already
+// open, which is the case when a client reattaches.
+func (s *Store) Start(rec Record) error {
+	if rec.SessionID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+	if rec.Started.IsZero() {
+		rec.Started = time.Now()
+	}
+	rec.Ended, rec.EndReason, rec.CommandCount, rec.Commands = nil, "", 0, nil
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, cur, ok := lookup(tx, rec.SessionID); ok && cur.Ended == nil {
+			return nil
+		}
+		key := recordKey(rec.Started, rec.SessionID)
+		if err := putRecord(tx.Bucket(sessionsBucket), key, &rec); err != nil {
+			return err
+		}
+		return tx.Bucket(idsBucket).Put([]byte(rec.SessionID), key)
+	})
+}
+
+// AddCommand appends a command to an open session. Commands beyond
+// MaxCommands are counted but not kept.
+func (s *Store) AddCommand(sessionID, command string, at time.Time) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, rec, ok := lookup(tx, sessionID)
+		if !ok || rec.Ended != nil {
+			return ErrNotFound
+		}
+		if rec.CommandCount < MaxCommands {
+			data, err := json.Marshal(Command{Time: at, Command: command})
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(commandsBucket).Put(commandKey(key, rec.CommandCount), data); err != nil {
+				return err
+			}
+		}
+		rec.CommandCount++
+		return putRecord(tx.Bucket(sessionsBucket), key, rec)
+	})
+}
+
+// End closes an open session.
+func (s *Store) End(sessionID string, e Ending) error {
+	if e.Ended.IsZero() {
+		e.Ended = time.Now()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, rec, ok := lookup(tx, sessionID)
+		if !ok || rec.Ended != nil {
+			return ErrNotFound
+		}
+		rec.Ended, rec.EndReason = &e.Ended, e.Reason
+		rec.BytesIn, rec.BytesOut = e.BytesIn, e.BytesOut
+		rec.Recordings = e.Recordings
+		return putRecord(tx.Bucket(sessionsBucket), key, rec)
+	})
+}
+
+// Get returns the latest session with the given ID, with its commands.
+func (s *Store) Get(sessionID string) (*Record, error) {
+	var rec *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, r, ok := lookup(tx, sessionID)
+		if !ok {
+			return ErrNotFound
+		}
+		r.Commands = loadCommands(tx, key)
+		rec = r
+		return nil
+	})
+	return rec, err
+}
+
+// List returns the sessions matching f, newest first, without their
+// commands unless f.Query is set.
+func (s *Store) List(f Filter) ([]Record, error) {
+	query := strings.ToLower(strings.TrimSpace(f.Query))
+	records := []Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(sessionsBucket).Cursor()
+		for k, v := c.Last(); k != nil && (f.Limit <= 0 || len(records) < f.Limit); k, v = c.Prev() {
+			var rec Record
+			if json.Unmarshal(v, &rec) != nil || !f.matches(&rec) {
+				continue
+			}
+			if query != "" {
+				for _, cmd := range loadCommands(tx, k) {
+					if strings.Contains(strings.ToLower(cmd.Command), query) {
+						rec.Commands = append(rec.Commands, cmd)
+					}
+				}
+				if len(rec.Commands) == 0 {
+					continue
+				}
+			}
+			records = append(records, rec)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// matches reports whether rec passes every filter except Query.
+func (f Filter) matches(rec *Record) bool {
+	switch {
+	case f.User != "" && !strings.EqualFold(rec.User, f.User),
+		f.InstanceID != "" && rec.InstanceID != f.InstanceID,
+		f.AccountID != "" && rec.AccountID != f.AccountID,
+		f.Active && rec.Ended != nil,
+		!f.Until.IsZero() && rec.Started.After(f.Until),
+		!f.Since.IsZero() && rec.Ended != nil && rec.Ended.Before(f.Since):
+		return false
+	}
+	return true
+}
+
+// lookup finds the latest record for sessionID.
+func lookup(tx *bolt.Tx, sessionID string) ([]byte, *Record, bool) {
+	key := tx.Bucket(idsBucket).Get([]byte(sessionID))
+	if key == nil {
+		return nil, nil, false
+	}
+	data := tx.Bucket(sessionsBucket).Get(key)
+	if data == nil {
+		return nil, nil, false
+	}
+	var rec Record
+	if json.Unmarshal(data, &rec) != nil {
+		return nil, nil, false
+	}
+	return append([]byte(nil), key...), &rec, true
+}
+
+func loadCommands(tx *bolt.Tx, key []byte) []Command {
+	var cmds []Command
+	prefix := append(append([]byte(nil), key...), 0)
+	c := tx.Bucket(commandsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var cmd Command
+		if json.Unmarshal(v, &cmd) == nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func putRecord(b *bolt.Bucket, key []byte, rec *Record) error {
+	r := *rec
+	r.Commands = nil
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// recordKey sorts records by start time.
+func recordKey(started time.Time, sessionID string) []byte {
+	return []byte(started.UTC().Format("20060102T150405.000000000") + "\x00" + sessionID)
+}
+
+func commandKey(recordKey []byte, n int) []byte {
+	return []byte(fmt.Sprintf("%s\x00%06d", recordKey, n))
+}
@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+
+	tue := time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)
+	for _, rec := range []Record{
+		{SessionID: "term-1", User: "alice", InstanceID: "i-0abc", AccountID: "111111111111", Started: tue},
+		{SessionID: "term-2", User: "bob", InstanceID: "i-0abc", AccountID: "111111111111", Started: tue.Add(2 * time.Hour)},
+		{SessionID: "term-3", User: "alice", InstanceID: "i-0def", AccountID: "222222222222", Started: tue.Add(48 * time.Hour)},
+	} {
+		if err := s.Start(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reattaching does not restart the record.
+	if err := s.Start(Record{SessionID: "term-1", User: "alice", Started: tue.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	s.AddCommand("term-1", "sudo systemctl restart nginx", tue.Add(time.Minute))
+	s.AddCommand("term-1", "  ", tue.Add(2*time.Minute))
+	s.AddCommand("term-2", "uptime", tue.Add(2*time.Hour+time.Minute))
+	if err := s.End("term-1", Ending{Reason: "user", Ended: tue.Add(30 * time.Minute), BytesIn: 42, BytesOut: 4096, Recordings: []string{"r.cast"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddCommand("term-1", "ls", tue.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("command after end = %v", err)
+	}
+
+	rec, err := s.Get("term-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Started.Equal(tue) || rec.EndReason != "user" || rec.BytesOut != 4096 || len(rec.Recordings) != 1 {
+		t.Errorf("record = %+v", rec)
+	}
+	if rec.CommandCount != 1 || len(rec.Commands) != 1 || rec.Commands[0].Command != "sudo systemctl restart nginx" {
+		t.Errorf("commands = %d %+v", rec.CommandCount, rec.Commands)
+	}
+
+	ids := func(f Filter) string {
+		t.Helper()
+		list, err := s.List(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, r := range list {
+			out += r.SessionID + " "
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		f    Filter
+		want string
+	}{
+		{"all, newest first", Filter{}, "term-3 term-2 term-1 "},
+		{"limit", Filter{Limit: 1}, "term-3 "},
+		{"user", Filter{User: "Alice"}, "term-3 term-1 "},
+		{"instance on tuesday", Filter{InstanceID: "i-0abc", Since: tue, Until: tue.Add(24 * time.Hour)}, "term-2 term-1 "},
+		{"after term-1 ended", Filter{Since: tue.Add(time.Hour)}, "term-3 term-2 "},
+		{"account", Filter{AccountID: "222222222222"}, "term-3 "},
+		{"active", Filter{Active: true}, "term-3 term-2 "},
+		{"command", Filter{Query: "SYSTEMCTL"}, "term-1 "},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.f); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Sessions still open when the server stops are closed on reopen.
+	s.Close()
+	if s, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = s.Get("term-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.EndReason != EndInterrupted || rec.Ended == nil || !rec.Ended.Equal(tue.Add(2*time.Hour+time.Minute)) {
+		t.Errorf("interrupted record = %+v", rec)
+	}
+	if _, err := s.Get("term-9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get unknown = %v", err)
+	}
+}
@@ -16,16 +16,65 @@ type Config struct {
 	GuacCryptSecret     string
 	SSMForwarderHost    string
 	SSMForwarderPort    int
+	SSMClient           string // "native" (data channel in Go) or "cli" (aws ssm start-session)
 	PortRangeStart      int
 	PortRangeEnd        int
 	Debug               bool
 	CacheTTLSeconds     int
 	InstancesFile       string
 	AuditLogFile        string
+	AuditMaxSizeMB      int
+	AuditMaxAgeHours    int
+	AuditSyslogAddr     string
+	AuditCEFAddr        string
+	AuditWebhookURL     string
+	AuditWebhookSecret  string
+	AuditSpoolDir       string
 	PreferencesFile     string
 	SessionRecordingDir string
 	TerminalExportDir   string
 	AutoRecord          bool
+	RecordInput         bool
+	RecordInputPrompt   string
+	// Minutes a session survives its browser disconnecting; 0 closes it
+	// immediately, -1 keeps it until the remote shell exits.
+	SessionDetachGraceMinutes int
+	// Session time limits; 0 disables. The *By rules ("prod=15,123456789012=60")
+	// override them per environment tag value or account ID.
+	SessionIdleTimeoutMinutes   int
+	SessionIdleTimeoutBy        string
+	SessionMaxDurationMinutes   int
+	SessionMaxDurationBy        string
+	SessionExpiryWarningMinutes int
+	SessionExtendMinutes        int // 0 makes the maximum duration a hard limit
+	// Recording retention and offload
+	RecordingRetentionDays   int
+	RecordingRetentionByEnv  string // "prod=365,dev=14"
+	RecordingMaxLocalMB      int
+	RecordingLocalKeepHours  int
+	RecordingSweepMinutes    int
+	RecordingS3Bucket        string
+	RecordingS3Prefix        string
+	RecordingS3Region        string
+	RecordingS3Profile       string
+	RecordingS3Endpoint      string
+	RecordingS3PathStyle     bool
+	RecordingS3AccessKey     string
+	RecordingS3SecretKey     string
+	RecordingS3SSE           string // "AES256", "aws:kms" or "none"
+	RecordingS3KMSKeyID      string
+	RecordingIndexFile       string
+	RecordingSigningKey      string // "none" disables signed manifests
+	FleetJobsFile            string
+	FleetMaxTargets          int
+	GuardPolicyFile          string // "" disables the command guard; "builtin" uses the bundled policy
+	RunbookDir               string
+	SessionCatalogFile       string // "" disables the session catalog
+	// AWS Organizations discovery; an empty management profile disables it.
+	OrgManagementProfile string
+	OrgMemberRole        string
+	OrgIncludeOUs        string // comma-separated OU IDs or name paths ("/Prod/Web")
+	OrgExcludeOUs        string
 	AWSAccountsFile     string
 	ConverterHost          string
 	ConverterPort          int
@@ -44,7 +93,23 @@ type Config struct {
 	AITemperature        float64
 	SuggestEnabled       bool
 	SuggestDataDir       string
-	SuggestEncryptionKey string
+	SuggestEncryptionKey string // passphrase the data key is derived from
+	EncryptionKeyFile    string // keyring (salt or wrapped key); "" keeps it in SuggestDataDir
+	EncryptionKMSKeyID   string // protect the data key with this KMS key instead
+	// Authentication
+	AuthMode             string // "none", "local" or "oidc"
+	AuthUsersFile        string
+	AuthSessionSecret    string
+	AuthSessionTTLHours  int
+	AllowedOrigins       string // comma-separated extra origins allowed for WebSocket/CSRF checks
+	TrustedProxies       string // comma-separated CIDRs of reverse proxies whose X-Forwarded-* headers are honoured
+	OIDCIssuer           string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCScopes           string
+	OIDCGroupsClaim      string
+	RBACPolicyFile       string
 }
 
 func Load() *Config {
@@ -59,16 +124,59 @@ func Load() *Config {
 		GuacCryptSecret:      envStr("GUAC_CRYPT_SECRET", "cloudterm-guac-secret-key-32byte"),
 		SSMForwarderHost:     envStr("SSM_FORWARDER_HOST", "ssm-forwarder"),
 		SSMForwarderPort:     envInt("SSM_FORWARDER_PORT", 5001),
+		SSMClient:            envStr("SSM_CLIENT", "native"),
 		PortRangeStart:       envInt("PORT_RANGE_START", 33890),
 		PortRangeEnd:         envInt("PORT_RANGE_END", 33999),
 		Debug:                envStr("DEBUG", "false") == "true",
 		CacheTTLSeconds:      1800, // 30 minutes
 		InstancesFile:        envStr("INSTANCES_FILE", "instances_list.yaml"),
 		AuditLogFile:         envStr("AUDIT_LOG_FILE", "audit.log"),
+		AuditMaxSizeMB:       envInt("AUDIT_MAX_SIZE_MB", 50),
+		AuditMaxAgeHours:     envInt("AUDIT_MAX_AGE_HOURS", 24),
+		AuditSyslogAddr:      envStr("AUDIT_SYSLOG_ADDR", ""),
+		AuditCEFAddr:         envStr("AUDIT_CEF_ADDR", ""),
+		AuditWebhookURL:      envStr("AUDIT_WEBHOOK_URL", ""),
+		AuditWebhookSecret:   envStr("AUDIT_WEBHOOK_SECRET", ""),
+		AuditSpoolDir:        envStr("AUDIT_SPOOL_DIR", "audit-spool"),
 		PreferencesFile:      envStr("PREFERENCES_FILE", "preferences.json"),
 		SessionRecordingDir:  envStr("SESSION_RECORDING_DIR", "/app/recordings"),
 		TerminalExportDir:    envStr("TERMINAL_EXPORT_DIR", "/app/exports"),
 		AutoRecord:           envStr("AUTO_RECORD", "false") == "true",
+		RecordInput:          envStr("RECORD_INPUT", "false") == "true",
+		RecordInputPrompt:    envStr("RECORD_INPUT_PROMPT", ""),
+		SessionDetachGraceMinutes: envInt("SESSION_DETACH_GRACE_MINUTES", 30),
+		SessionIdleTimeoutMinutes:   envInt("SESSION_IDLE_TIMEOUT_MINUTES", 0),
+		SessionIdleTimeoutBy:        envStr("SESSION_IDLE_TIMEOUT_BY", ""),
+		SessionMaxDurationMinutes:   envInt("SESSION_MAX_DURATION_MINUTES", 0),
+		SessionMaxDurationBy:        envStr("SESSION_MAX_DURATION_BY", ""),
+		SessionExpiryWarningMinutes: envInt("SESSION_EXPIRY_WARNING_MINUTES", 5),
+		SessionExtendMinutes:        envInt("SESSION_EXTEND_MINUTES", 30),
+		RecordingRetentionDays:  envInt("RECORDING_RETENTION_DAYS", 0),
+		RecordingRetentionByEnv: envStr("RECORDING_RETENTION_BY_ENV", ""),
+		RecordingMaxLocalMB:     envInt("RECORDING_MAX_LOCAL_MB", 0),
+		RecordingLocalKeepHours: envInt("RECORDING_LOCAL_KEEP_HOURS", 24),
+		RecordingSweepMinutes:   envInt("RECORDING_SWEEP_MINUTES", 10),
+		RecordingS3Bucket:       envStr("RECORDING_S3_BUCKET", ""),
+		RecordingS3Prefix:       envStr("RECORDING_S3_PREFIX", "recordings/"),
+		RecordingS3Region:       envStr("RECORDING_S3_REGION", "us-east-1"),
+		RecordingS3Profile:      envStr("RECORDING_S3_PROFILE", ""),
+		RecordingS3Endpoint:     envStr("RECORDING_S3_ENDPOINT", ""),
+		RecordingS3PathStyle:    envStr("RECORDING_S3_PATH_STYLE", "false") == "true",
+		RecordingS3AccessKey:    envStr("RECORDING_S3_ACCESS_KEY", ""),
+		RecordingS3SecretKey:    envStr("RECORDING_S3_SECRET_KEY", ""),
+		RecordingS3SSE:          envStr("RECORDING_S3_SSE", "AES256"),
+		RecordingS3KMSKeyID:     envStr("RECORDING_S3_KMS_KEY_ID", ""),
+		RecordingIndexFile:      envStr("RECORDING_INDEX_FILE", "recording-index.db"),
+		RecordingSigningKey:     envStr("RECORDING_SIGNING_KEY", "recording-signing.key"),
+		FleetJobsFile:           envStr("FLEET_JOBS_FILE", "fleet-jobs.db"),
+		FleetMaxTargets:         envInt("FLEET_MAX_TARGETS", 500),
+		GuardPolicyFile:         envStr("GUARD_POLICY_FILE", ""),
+		RunbookDir:              envStr("RUNBOOK_DIR", "runbooks"),
+		SessionCatalogFile:      envStr("SESSION_CATALOG_FILE", "session-catalog.db"),
+		OrgManagementProfile:    envStr("ORG_MANAGEMENT_PROFILE", ""),
+		OrgMemberRole:           envStr("ORG_MEMBER_ROLE", "OrganizationAccountAccessRole"),
+		OrgIncludeOUs:           envStr("ORG_INCLUDE_OUS", ""),
+		OrgExcludeOUs:           envStr("ORG_EXCLUDE_OUS", ""),
 		AWSAccountsFile:      envStr("AWS_ACCOUNTS_FILE", "aws_accounts.json"),
 		ConverterHost:        envStr("CONVERTER_HOST", "converter"),
 		ConverterPort:        envInt("CONVERTER_PORT", 5002),
@@ -87,6 +195,21 @@ func Load() *Config {
 		SuggestEnabled:       envStr("SUGGEST_ENABLED", "true") == "true",
 		SuggestDataDir:       envStr("SUGGEST_DATA_DIR", "/app/suggestdata"),
 		SuggestEncryptionKey: envStr("SUGGEST_ENCRYPTION_KEY", ""),
+		EncryptionKeyFile:    envStr("ENCRYPTION_KEY_FILE", ""),
+		EncryptionKMSKeyID:   envStr("ENCRYPTION_KMS_KEY_ID", ""),
+		AuthMode:             envStr("AUTH_MODE", "none"),
+		AuthUsersFile:        envStr("AUTH_USERS_FILE", "users.json"),
+		AuthSessionSecret:    envStr("AUTH_SESSION_SECRET", ""),
+		AuthSessionTTLHours:  envInt("AUTH_SESSION_TTL_HOURS", 12),
+		AllowedOrigins:       envStr("ALLOWED_ORIGINS", ""),
+		TrustedProxies:       envStr("TRUSTED_PROXIES", ""),
+		OIDCIssuer:           envStr("OIDC_ISSUER", ""),
+		OIDCClientID:         envStr("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:     envStr("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:      envStr("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:           envStr("OIDC_SCOPES", "openid,profile,email"),
+		OIDCGroupsClaim:      envStr("OIDC_GROUPS_CLAIM", "groups"),
+		RBACPolicyFile:       envStr("RBAC_POLICY_FILE", ""),
 	}
 }
 
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ResealBuckets re-encrypts the values of the named buckets in tx with
+// r.Key, or of every bucket when none are named. Values already sealed with
+// r.Key are left alone.
+func ResealBuckets(tx *bolt.Tx, r Rotation, buckets ...[]byte) error {
+	reseal := func(name []byte, b *bolt.Bucket) error {
+		resealed := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			enc, err := r.Reseal(v)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", name, k, err)
+			}
+			if enc != nil {
+				resealed[string(k)] = enc
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range resealed {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(buckets) == 0 {
+		return tx.ForEach(reseal)
+	}
+	for _, name := range buckets {
+		if b := tx.Bucket(name); b != nil {
+			if err := reseal(name, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
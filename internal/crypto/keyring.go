@@ -0,0 +1,395 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Sources of the data key recorded in the keyring file.
+const (
+	SourceArgon2id = "argon2id" // derived from a passphrase
+	SourceKMS      = "kms"      // generated and wrapped by a KMS key
+)
+
+var (
+	// ErrWrongKey is returned by OpenKeyring when the passphrase (or KMS
+	// key) does not unlock the keyring.
+	ErrWrongKey = errors.New("encryption key does not match the keyring")
+	// ErrNoKey is returned by Rotate when no encryption key is configured.
+	ErrNoKey = errors.New("no encryption key configured")
+)
+
+// argon2Params are the Argon2id costs for new data keys (RFC 9106's
+// memory-constrained recommendation). Keyrings keep the costs they were
+// created with, so these can be raised without breaking existing ones.
+var argon2Params = kdfParams{Time: 3, MemoryKiB: 64 * 1024, Threads: 4}
+
+type kdfParams struct {
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+}
+
+// checkValue is sealed with the data key so a wrong passphrase is caught
+// when the keyring is opened rather than when data fails to decrypt.
+const checkValue = "cloudterm keyring"
+
+// KMS generates and unwraps data keys with a key held in a key management
+// service.
+type KMS interface {
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext, wrapped []byte, err error)
+	Decrypt(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// keyFile is the keyring as written to disk. It holds everything needed to
+// recover the data key except the passphrase or KMS access.
+type keyFile struct {
+	Source     string           `json:"source"`
+	Salt       []byte           `json:"salt,omitempty"`
+	KDF        *kdfParams       `json:"kdf,omitempty"`
+	KMSKeyID   string           `json:"kms_key_id,omitempty"`
+	WrappedKey []byte           `json:"wrapped_key,omitempty"`
+	Check      []byte           `json:"check"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Pending    *pendingRotation `json:"pending,omitempty"`
+}
+
+// pendingRotation records a rotation whose stores have not all been
+// re-encrypted yet, so it can be finished after a restart.
+type pendingRotation struct {
+	Keys      [][]byte `json:"keys,omitempty"` // earlier data keys, sealed with the current one
+	Plaintext bool     `json:"plaintext,omitempty"`
+}
+
+// Rotation is a change of data key, handed to stores that re-encrypt their
+// data.
+type Rotation struct {
+	Key       []byte   // the new data key
+	Previous  [][]byte // keys data may still be sealed with
+	Plaintext bool     // data may still be unencrypted
+}
+
+// Open decrypts a value sealed with the new key or any previous one.
+func (r Rotation) Open(v []byte) ([]byte, error) {
+	data, err := Decrypt(r.Key, v)
+	if err == nil {
+		return data, nil
+	}
+	for _, k := range r.Previous {
+		if data, perr := Decrypt(k, v); perr == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// Reseal returns v sealed with the new key, or nil when it already is.
+func (r Rotation) Reseal(v []byte) ([]byte, error) {
+	if _, err := Decrypt(r.Key, v); err == nil {
+		return nil, nil
+	}
+	for _, k := range r.Previous {
+		if data, err := Decrypt(k, v); err == nil {
+			return Encrypt(r.Key, data)
+		}
+	}
+	if r.Plaintext {
+		return Encrypt(r.Key, v)
+	}
+	return nil, errors.New("value is not sealed with the current or a previous key")
+}
+
+// Resealer is a store that can re-encrypt its data in place. Once every
+// store of a rotation has been resealed, ForgetPreviousKeys tells each to
+// drop the earlier keys; they are wiped afterwards.
+type Resealer interface {
+	RotateKey(r Rotation) error
+	ForgetPreviousKeys()
+}
+
+// Keyring holds the data key shared by the encrypted stores. The key is
+// either derived from a passphrase with Argon2id and a stored salt, or
+// generated by KMS and kept wrapped; the keyring file never contains it.
+type Keyring struct {
+	path       string
+	passphrase string
+	kms        KMS
+	kmsKeyID   string
+
+	mu       sync.Mutex
+	file     keyFile
+	key      []byte
+	rotation *Rotation // unfinished rotation, if any
+}
+
+// OpenKeyring loads the keyring at path, creating it on first use. With a
+// kmsKeyID the data key comes from kms, otherwise from passphrase; with
+// neither and no keyring file, Key returns nil and data stays unencrypted.
+//
+// A new keyring starts a rotation from whatever protected the data before
+// it (the passphrase zero-padded to 32 bytes, or nothing), and a keyring
+// whose source no longer matches the configuration starts one to the
+// configured source. Callers finish it with Resume.
+func OpenKeyring(ctx context.Context, path, passphrase string, kms KMS, kmsKeyID string) (*Keyring, error) {
+	k := &Keyring{path: path, passphrase: passphrase, kms: kms, kmsKeyID: kmsKeyID}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if passphrase == "" && kmsKeyID == "" {
+			return k, nil
+		}
+		var previous [][]byte
+		if passphrase != "" {
+			previous = append(previous, legacyKey(passphrase))
+		}
+		if err := k.begin(ctx, passphrase, previous, true); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %w", err)
+	}
+	if err := json.Unmarshal(data, &k.file); err != nil {
+		return nil, fmt.Errorf("parse keyring %s: %w", path, err)
+	}
+	if k.key, err = k.unlock(ctx, k.file); err != nil {
+		return nil, err
+	}
+	if p := k.file.Pending; p != nil {
+		r := &Rotation{Key: k.key, Plaintext: p.Plaintext}
+		for _, sealed := range p.Keys {
+			prev, err := Decrypt(k.key, sealed)
+			if err != nil {
+				return nil, fmt.Errorf("unseal previous key: %w", err)
+			}
+			r.Previous = append(r.Previous, prev)
+		}
+		k.rotation = r
+	}
+	if k.source() != k.file.Source || (k.file.Source == SourceKMS && k.file.KMSKeyID != kmsKeyID) {
+		if err := k.begin(ctx, passphrase, k.previousKeys(), k.rotation != nil && k.rotation.Plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Key returns the data key, or nil when encryption is not configured.
+func (k *Keyring) Key() []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.key
+}
+
+// Source returns how the data key is protected ("" when there is none).
+func (k *Keyring) Source() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.file.Source
+}
+
+// Pending returns the rotation Resume has still to apply, if any.
+func (k *Keyring) Pending() (Rotation, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.rotation == nil {
+		return Rotation{}, false
+	}
+	return *k.rotation, true
+}
+
+// Resume re-encrypts the stores with the pending rotation and, once they
+// all succeed, drops the previous keys from the keyring and from memory.
+func (k *Keyring) Resume(stores ...Resealer) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.finish(stores)
+}
+
+// Rotate switches to a new data key and re-encrypts the stores with it
+// while they stay in use. A non-empty passphrase replaces the configured
+// one; otherwise the key is derived again with a fresh salt, or a new one
+// is generated by KMS. If a store fails, the rotation stays pending and is
+// retried by the next Rotate or Resume.
+func (k *Keyring) Rotate(ctx context.Context, passphrase string, stores ...Resealer) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.key == nil {
+		return ErrNoKey
+	}
+	if passphrase != "" && k.file.Source == SourceKMS {
+		return errors.New("the data key is protected by KMS, not a passphrase")
+	}
+	if passphrase == "" {
+		passphrase = k.passphrase
+	}
+	if err := k.begin(ctx, passphrase, k.previousKeys(), k.rotation != nil && k.rotation.Plaintext); err != nil {
+		return err
+	}
+	k.passphrase = passphrase
+	return k.finish(stores)
+}
+
+// source is the key source the configuration asks for.
+func (k *Keyring) source() string {
+	if k.kmsKeyID != "" {
+		return SourceKMS
+	}
+	return SourceArgon2id
+}
+
+// previousKeys returns the current key and any still pending; k.mu must
+// be held (or k not yet shared).
+func (k *Keyring) previousKeys() [][]byte {
+	var keys [][]byte
+	if k.rotation != nil {
+		keys = append(keys, k.rotation.Previous...)
+	}
+	if k.key != nil {
+		keys = append(keys, k.key)
+	}
+	return keys
+}
+
+// begin creates a new data key and records the rotation to it, so that it
+// can be finished even if the process stops first.
+func (k *Keyring) begin(ctx context.Context, passphrase string, previous [][]byte, plaintext bool) error {
+	file, key, err := k.newKey(ctx, passphrase)
+	if err != nil {
+		return err
+	}
+	file.Pending = &pendingRotation{Plaintext: plaintext}
+	for _, prev := range previous {
+		sealed, err := Encrypt(key, prev)
+		if err != nil {
+			return err
+		}
+		file.Pending.Keys = append(file.Pending.Keys, sealed)
+	}
+	if err := k.write(file); err != nil {
+		return err
+	}
+	k.file, k.key = file, key
+	k.rotation = &Rotation{Key: key, Previous: previous, Plaintext: plaintext}
+	return nil
+}
+
+// finish applies the pending rotation to the stores; k.mu must be held.
+func (k *Keyring) finish(stores []Resealer) error {
+	if k.rotation == nil {
+		return nil
+	}
+	for _, s := range stores {
+		if err := s.RotateKey(*k.rotation); err != nil {
+			return fmt.Errorf("re-encrypt: %w", err)
+		}
+	}
+	file := k.file
+	file.Pending = nil
+	if err := k.write(file); err != nil {
+		return err
+	}
+	for _, s := range stores {
+		s.ForgetPreviousKeys()
+	}
+	for _, prev := range k.rotation.Previous {
+		clear(prev)
+	}
+	k.file, k.rotation = file, nil
+	return nil
+}
+
+func (k *Keyring) newKey(ctx context.Context, passphrase string) (keyFile, []byte, error) {
+	file := keyFile{Source: k.source(), CreatedAt: time.Now().UTC()}
+	var key []byte
+	switch file.Source {
+	case SourceKMS:
+		if k.kms == nil {
+			return keyFile{}, nil, errors.New("no KMS client configured")
+		}
+		plaintext, wrapped, err := k.kms.GenerateDataKey(ctx, k.kmsKeyID)
+		if err != nil {
+			return keyFile{}, nil, fmt.Errorf("generate data key with %s: %w", k.kmsKeyID, err)
+		}
+		key, file.KMSKeyID, file.WrappedKey = plaintext, k.kmsKeyID, wrapped
+	default:
+		if passphrase == "" {
+			return keyFile{}, nil, errors.New("a passphrase is required")
+		}
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return keyFile{}, nil, err
+		}
+		params := argon2Params
+		file.Salt, file.KDF = salt, &params
+		key = deriveKey(passphrase, salt, params)
+	}
+	check, err := Encrypt(key, []byte(checkValue))
+	if err != nil {
+		return keyFile{}, nil, err
+	}
+	file.Check = check
+	return file, key, nil
+}
+
+// unlock recovers the data key of an existing keyring.
+func (k *Keyring) unlock(ctx context.Context, file keyFile) ([]byte, error) {
+	var key []byte
+	switch file.Source {
+	case SourceArgon2id:
+		if k.passphrase == "" || file.KDF == nil {
+			return nil, errors.New("the keyring is protected by a passphrase but none is configured")
+		}
+		key = deriveKey(k.passphrase, file.Salt, *file.KDF)
+	case SourceKMS:
+		if k.kms == nil {
+			return nil, fmt.Errorf("the keyring is protected by KMS key %s but KMS is not configured", file.KMSKeyID)
+		}
+		var err error
+		if key, err = k.kms.Decrypt(ctx, file.KMSKeyID, file.WrappedKey); err != nil {
+			return nil, fmt.Errorf("unwrap data key with %s: %w", file.KMSKeyID, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown keyring source %q", file.Source)
+	}
+	if check, err := Decrypt(key, file.Check); err != nil || string(check) != checkValue {
+		return nil, ErrWrongKey
+	}
+	return key, nil
+}
+
+func (k *Keyring) write(file keyFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write keyring: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("write keyring: %w", err)
+	}
+	return nil
+}
+
+func deriveKey(passphrase string, salt []byte, p kdfParams) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.MemoryKiB, p.Threads, 32)
+}
+
+// legacyKey is how keys were made before the keyring: the passphrase
+// zero-padded or truncated to 32 bytes.
+func legacyKey(passphrase string) []byte {
+	key := make([]byte, 32)
+	copy(key, passphrase)
+	return key
+}
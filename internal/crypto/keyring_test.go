@@ -0,0 +1,273 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeKMS wraps data keys with a local master key.
+type fakeKMS struct {
+	master []byte
+	calls  int
+}
+
+func newFakeKMS() *fakeKMS {
+	master, _ := GenerateKey()
+	return &fakeKMS{master: master}
+}
+
+func (f *fakeKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	f.calls++
+	key, _ := GenerateKey()
+	wrapped, err := Encrypt(f.master, append([]byte(keyID+":"), key...))
+	return key, wrapped, err
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	f.calls++
+	data, err := Decrypt(f.master, wrapped)
+	if err != nil || !bytes.HasPrefix(data, []byte(keyID+":")) {
+		return nil, errors.New("kms: access denied")
+	}
+	return data[len(keyID)+1:], nil
+}
+
+// memStore is a Resealer holding sealed values in memory.
+type memStore struct {
+	values   map[string][]byte
+	fail     bool
+	previous [][]byte
+}
+
+func (m *memStore) RotateKey(r Rotation) error {
+	if m.fail {
+		return errors.New("store unavailable")
+	}
+	for k, v := range m.values {
+		enc, err := r.Reseal(v)
+		if err != nil {
+			return err
+		}
+		if enc != nil {
+			m.values[k] = enc
+		}
+	}
+	m.previous = r.Previous
+	return nil
+}
+
+func (m *memStore) ForgetPreviousKeys() { m.previous = nil }
+
+func (m *memStore) open(t *testing.T, key []byte, name string) string {
+	t.Helper()
+	data, err := Decrypt(key, m.values[name])
+	if err != nil {
+		t.Fatalf("%s not sealed with the current key: %v", name, err)
+	}
+	return string(data)
+}
+
+func fastKDF(t *testing.T) {
+	orig := argon2Params
+	argon2Params = kdfParams{Time: 1, MemoryKiB: 1024, Threads: 1}
+	t.Cleanup(func() { argon2Params = orig })
+}
+
+func TestKeyringMigratesLegacyData(t *testing.T) {
+	fastKDF(t)
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	legacy, _ := Encrypt(legacyKey("hunter2"), []byte("sealed"))
+	store := &memStore{values: map[string][]byte{"legacy": legacy, "plain": []byte("plain")}}
+
+	k, err := OpenKeyring(context.Background(), path, "hunter2", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k.Key(), legacyKey("hunter2")) || k.Source() != SourceArgon2id {
+		t.Fatalf("key not derived: source %q", k.Source())
+	}
+	if r, ok := k.Pending(); !ok || len(r.Previous) != 1 || !r.Plaintext {
+		t.Fatalf("pending = %+v, %v", r, ok)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hunter2") || bytes.Contains(data, k.Key()) {
+		t.Error("keyring file holds the passphrase or key")
+	}
+
+	if err := k.Resume(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.open(t, k.Key(), "legacy") != "sealed" || store.open(t, k.Key(), "plain") != "plain" {
+		t.Error("values not migrated")
+	}
+	if _, ok := k.Pending(); ok {
+		t.Error("rotation still pending")
+	}
+
+	again, err := OpenKeyring(context.Background(), path, "hunter2", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Key(), k.Key()) {
+		t.Error("reopened keyring derived a different key")
+	}
+	if _, ok := again.Pending(); ok {
+		t.Error("reopened keyring has a pending rotation")
+	}
+	if _, err := OpenKeyring(context.Background(), path, "wrong", nil, ""); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong passphrase: err = %v", err)
+	}
+	if _, err := OpenKeyring(context.Background(), path, "", nil, ""); err == nil {
+		t.Error("keyring opened without a passphrase")
+	}
+}
+
+func TestKeyringWithoutKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	k, err := OpenKeyring(context.Background(), path, "", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Key() != nil {
+		t.Error("key without a passphrase")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("keyring file written without a key")
+	}
+	if err := k.Rotate(context.Background(), ""); !errors.Is(err, ErrNoKey) {
+		t.Errorf("rotate: err = %v", err)
+	}
+}
+
+func TestKeyringKMS(t *testing.T) {
+	fastKDF(t)
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	kms := newFakeKMS()
+
+	// Moving a passphrase keyring to KMS re-encrypts with a KMS data key.
+	k, err := OpenKeyring(context.Background(), path, "hunter2", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memStore{values: map[string][]byte{}}
+	store.values["secret"], _ = Encrypt(k.Key(), []byte("secret"))
+	if err := k.Resume(store); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = OpenKeyring(context.Background(), path, "hunter2", kms, "alias/cloudterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Pending(); !ok || k.Source() != SourceKMS {
+		t.Fatalf("source %q, no pending rotation", k.Source())
+	}
+	if err := k.Resume(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.open(t, k.Key(), "secret") != "secret" {
+		t.Error("value not re-encrypted")
+	}
+
+	// The passphrase is no longer needed, but KMS is.
+	again, err := OpenKeyring(context.Background(), path, "", kms, "alias/cloudterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Key(), k.Key()) {
+		t.Error("unwrapped a different key")
+	}
+	if _, err := OpenKeyring(context.Background(), path, "", nil, ""); err == nil {
+		t.Error("KMS keyring opened without KMS")
+	}
+	if err := again.Rotate(context.Background(), "new passphrase", store); err == nil {
+		t.Error("passphrase accepted for a KMS keyring")
+	}
+	calls := kms.calls
+	oldKey := again.Key()
+	if err := again.Rotate(context.Background(), "", store); err != nil {
+		t.Fatal(err)
+	}
+	if store.previous != nil || !bytes.Equal(oldKey, make([]byte, len(oldKey))) {
+		t.Error("previous key kept after every store was resealed")
+	}
+	if kms.calls != calls+1 || bytes.Equal(again.Key(), k.Key()) {
+		t.Error("rotation did not generate a new data key")
+	}
+	if store.open(t, again.Key(), "secret") != "secret" {
+		t.Error("value not re-encrypted after rotation")
+	}
+}
+
+func TestKeyringRotateResumesAfterFailure(t *testing.T) {
+	fastKDF(t)
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	k, err := OpenKeyring(context.Background(), path, "old", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	first := &memStore{values: map[string][]byte{}}
+	second := &memStore{values: map[string][]byte{}, fail: true}
+	first.values["a"], _ = Encrypt(k.Key(), []byte("a"))
+	second.values["b"], _ = Encrypt(k.Key(), []byte("b"))
+
+	// The second store fails: the first is already on the new key, the
+	// second still on the old one.
+	if err := k.Rotate(context.Background(), "new", first, second); err == nil {
+		t.Fatal("rotation succeeded with a failing store")
+	}
+
+	// After a restart with the new passphrase the rotation is finished.
+	k, err = OpenKeyring(context.Background(), path, "new", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Pending(); !ok {
+		t.Fatal("rotation not pending after restart")
+	}
+	second.fail = false
+	if err := k.Resume(first, second); err != nil {
+		t.Fatal(err)
+	}
+	if first.open(t, k.Key(), "a") != "a" || second.open(t, k.Key(), "b") != "b" {
+		t.Error("values not re-encrypted")
+	}
+	if _, err := OpenKeyring(context.Background(), path, "old", nil, ""); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("old passphrase: err = %v", err)
+	}
+}
+
+func TestRotationReseal(t *testing.T) {
+	oldKey, _ := GenerateKey()
+	newKey, _ := GenerateKey()
+	otherKey, _ := GenerateKey()
+	r := Rotation{Key: newKey, Previous: [][]byte{oldKey}}
+
+	current, _ := Encrypt(newKey, []byte("v"))
+	if out, err := r.Reseal(current); err != nil || out != nil {
+		t.Errorf("current value resealed: %v, %v", out, err)
+	}
+	old, _ := Encrypt(oldKey, []byte("v"))
+	out, err := r.Reseal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := Decrypt(newKey, out); err != nil || string(data) != "v" {
+		t.Errorf("resealed = %q, %v", data, err)
+	}
+	foreign, _ := Encrypt(otherKey, []byte("v"))
+	if _, err := r.Reseal(foreign); err == nil {
+		t.Error("value sealed with an unknown key resealed")
+	}
+	if data, err := r.Open(old); err != nil || string(data) != "v" {
+		t.Errorf("open previous = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,235 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloudterm-go/internal/aws"
+	"cloudterm-go/internal/types"
+)
+
+type fakeExec struct {
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	calls    atomic.Int32
+	outcome  func(instanceID string) (*aws.CommandResult, error)
+	block    chan struct{}
+}
+
+func (f *fakeExec) RunCommand(ctx context.Context, profile, region, instanceID, platform string, commands []string, timeout time.Duration) (*aws.CommandResult, error) {
+	f.calls.Add(1)
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.peak {
+		f.peak = f.inFlight
+	}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return f.outcome(instanceID)
+}
+
+func targets(n int) []Target {
+	out := make([]Target, n)
+	for i := range out {
+		out[i] = Target{InstanceID: fmt.Sprintf("i-%02d", i), Name: fmt.Sprintf("web-%02d", i)}
+	}
+	return out
+}
+
+func newTestManager(t *testing.T, exec Executor) (*Manager, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fleet.db")
+	m, err := NewManager(path, exec, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, path
+}
+
+func drain(t *testing.T, ch <-chan Event) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatal("job did not finish")
+		}
+	}
+}
+
+func TestJobRunsWithConcurrencyAndGroupsOutput(t *testing.T) {
+	exec := &fakeExec{outcome: func(id string) (*aws.CommandResult, error) {
+		if id == "i-03" {
+			return &aws.CommandResult{Status: ResultFailed, ExitCode: 1, Stderr: "disk full"}, nil
+		}
+		return &aws.CommandResult{Status: ResultSuccess, Stdout: "ok\n"}, nil
+	}}
+	m, path := newTestManager(t, exec)
+
+	job, err := m.Start("alice", Spec{Command: "df -h\nuptime", Concurrency: 3}, targets(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ch, _, err := m.Subscribe(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := drain(t, ch)
+	last := events[len(events)-1]
+	if last.Type != "done" || last.Status != StatusCompleted || last.Succeeded != 7 || last.Failed != 1 {
+		t.Fatalf("unexpected final event %+v", last)
+	}
+	if exec.peak > 3 {
+		t.Errorf("concurrency exceeded: %d in flight", exec.peak)
+	}
+
+	got, err := m.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := got.Groups()
+	if len(groups) != 2 || len(groups[0].Instances) != 7 || groups[1].Stderr != "disk full" || groups[1].Instances[0] != "i-03" {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	// Jobs and their results survive a restart.
+	m.Close()
+	m2, err := NewManager(path, exec, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+	reloaded, err := m2.Get(job.ID)
+	if err != nil || len(reloaded.Results) != 8 || reloaded.Status != StatusCompleted {
+		t.Fatalf("reloaded job %+v, %v", reloaded, err)
+	}
+	if list, _ := m2.List("bob", 10); len(list) != 0 {
+		t.Errorf("bob should not see alice's jobs: %+v", list)
+	}
+	if list, _ := m2.List("alice", 10); len(list) != 1 || list[0].Results != nil {
+		t.Errorf("unexpected listing %+v", list)
+	}
+}
+
+func TestJobStopsAtErrorThreshold(t *testing.T) {
+	exec := &fakeExec{outcome: func(string) (*aws.CommandResult, error) {
+		return nil, fmt.Errorf("agent offline")
+	}}
+	m, _ := newTestManager(t, exec)
+	defer m.Close()
+
+	job, err := m.Start("alice", Spec{Command: "true", Concurrency: 1, MaxErrors: "20%"}, targets(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ch, _, _ := m.Subscribe(job.ID)
+	drain(t, ch)
+	got, _ := m.Get(job.ID)
+	if got.Status != StatusAborted || got.Failed != 3 || got.Skipped != 7 || exec.calls.Load() != 3 {
+		t.Errorf("expected abort after 3 failures: status=%s failed=%d skipped=%d calls=%d", got.Status, got.Failed, got.Skipped, exec.calls.Load())
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	exec := &fakeExec{block: make(chan struct{}), outcome: func(string) (*aws.CommandResult, error) {
+		return &aws.CommandResult{Status: ResultSuccess}, nil
+	}}
+	m, _ := newTestManager(t, exec)
+	defer m.Close()
+
+	job, _ := m.Start("alice", Spec{Command: "sleep 600", Concurrency: 2}, targets(5))
+	_, ch, _, _ := m.Subscribe(job.ID)
+	for deadline := time.Now().Add(5 * time.Second); exec.calls.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := m.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	drain(t, ch)
+	got, _ := m.Get(job.ID)
+	if got.Status != StatusCancelled || len(got.Results) != 5 || got.Succeeded != 0 {
+		t.Errorf("unexpected cancelled job %+v", got)
+	}
+	cancelled := 0
+	for _, res := range got.Results {
+		switch res.Status {
+		case ResultCancelled:
+			cancelled++
+		case ResultSkipped:
+		default:
+			t.Errorf("%s: status %s after cancel", res.InstanceID, res.Status)
+		}
+	}
+	if cancelled == 0 {
+		t.Error("no running command was reported as cancelled")
+	}
+	if err := m.Cancel(job.ID); err != ErrNotFound {
+		t.Errorf("cancelling a finished job: %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	instances := []types.EC2Instance{
+		{InstanceID: "i-1", Name: "web-01", State: "running", AccountID: "111", AWSRegion: "us-east-1", Platform: "linux", Tags: map[string]string{"Environment": "prod", "Role": "web"}},
+		{InstanceID: "i-2", Name: "web-02", State: "stopped", AccountID: "111", AWSRegion: "us-east-1", Platform: "linux", Tags: map[string]string{"Environment": "prod", "Role": "web"}},
+		{InstanceID: "i-3", Name: "db-01", State: "running", AccountID: "222", AWSRegion: "eu-west-1", Platform: "linux", Tags: map[string]string{"Environment": "prod", "Role": "db"}},
+		{InstanceID: "i-4", Name: "win-01", State: "running", AccountID: "111", AWSRegion: "us-east-1", Platform: "windows", Tags: map[string]string{"Environment": "dev"}},
+	}
+	tags, err := ParseTagQuery("Environment=prod, Role=w*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(q Query) []string {
+		var out []string
+		for _, inst := range Select(instances, q) {
+			out = append(out, inst.InstanceID)
+		}
+		return out
+	}
+	for name, c := range map[string]struct {
+		q    Query
+		want []string
+	}{
+		"tags":     {Query{Tags: tags}, []string{"i-1"}},
+		"account":  {Query{AccountIDs: []string{"111"}}, []string{"i-1", "i-4"}},
+		"region":   {Query{Regions: []string{"eu-west-1"}}, []string{"i-3"}},
+		"platform": {Query{Platform: "Windows"}, []string{"i-4"}},
+		"name":     {Query{Name: "*-01", AccountIDs: []string{"111"}}, []string{"i-1", "i-4"}},
+	} {
+		if got := ids(c.q); fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", name, got, c.want)
+		}
+	}
+	if _, err := ParseTagQuery("Environment"); err == nil {
+		t.Error("expected error for a filter without '='")
+	}
+	if _, err := errorThreshold("abc", 10); err == nil {
+		t.Error("expected error for invalid max_errors")
+	}
+}
@@ -0,0 +1,144 @@
+package fleet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job states.
+const (
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"   // every target ran; some may have failed
+	StatusAborted     = "aborted"     // the error threshold was exceeded
+	StatusCancelled   = "cancelled"   // stopped by a user
+	StatusInterrupted = "interrupted" // the server restarted mid-run
+)
+
+// Per-instance result states: SSM's own invocation statuses, plus two for
+// instances the command never ran on.
+const (
+	ResultSuccess   = "Success"
+	ResultFailed    = "Failed"
+	ResultTimedOut  = "TimedOut"
+	ResultCancelled = "Cancelled"
+	ResultError     = "Error"   // the command could not be delivered
+	ResultSkipped   = "Skipped" // not run because the job stopped early
+)
+
+// Spec is what a user asks to run.
+type Spec struct {
+	Command        string `json:"command"`
+	Query          Query  `json:"query"`
+	Concurrency    int    `json:"concurrency"`
+	MaxErrors      string `json:"max_errors,omitempty"` // "3" or "10%"; empty means no limit
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// Target is an instance a job runs on.
+type Target struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	AccountID  string `json:"account_id"`
+	Region     string `json:"region"`
+	Profile    string `json:"profile"`
+	Platform   string `json:"platform"`
+}
+
+// Result is the outcome on one instance.
+type Result struct {
+	InstanceID string    `json:"instance_id"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Stdout     string    `json:"stdout,omitempty"`
+	Stderr     string    `json:"stderr,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started,omitempty"`
+	Finished   time.Time `json:"finished,omitempty"`
+}
+
+// Failed reports whether the result counts towards the error threshold.
+func (r *Result) Failed() bool {
+	return r.Status != ResultSkipped && (r.Status != ResultSuccess || r.ExitCode != 0)
+}
+
+// Job is one run of a command across a set of instances.
+type Job struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	Spec      Spec       `json:"spec"`
+	Status    string     `json:"status"`
+	Created   time.Time  `json:"created"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Targets   []Target   `json:"targets"`
+	Succeeded int        `json:"succeeded"`
+	Failed    int        `json:"failed"`
+	Skipped   int        `json:"skipped"`
+	Results   []Result   `json:"results,omitempty"`
+}
+
+// OutputGroup collects the instances that produced identical output.
+type OutputGroup struct {
+	Status    string   `json:"status"`
+	ExitCode  int      `json:"exit_code"`
+	Stdout    string   `json:"stdout,omitempty"`
+	Stderr    string   `json:"stderr,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Instances []string `json:"instances"`
+}
+
+// Groups buckets the job's results by identical status, exit code and
+// output, largest group first.
+func (j *Job) Groups() []OutputGroup {
+	index := make(map[string]int)
+	var groups []OutputGroup
+	for _, r := range j.Results {
+		key := strings.Join([]string{r.Status, strconv.Itoa(r.ExitCode), r.Stdout, r.Stderr, r.Error}, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, OutputGroup{Status: r.Status, ExitCode: r.ExitCode, Stdout: r.Stdout, Stderr: r.Stderr, Error: r.Error})
+		}
+		groups[i].Instances = append(groups[i].Instances, r.InstanceID)
+	}
+	sort.SliceStable(groups, func(a, b int) bool { return len(groups[a].Instances) > len(groups[b].Instances) })
+	return groups
+}
+
+// record adds a result and updates the counters.
+func (j *Job) record(r Result) {
+	j.Results = append(j.Results, r)
+	switch {
+	case r.Status == ResultSkipped:
+		j.Skipped++
+	case r.Failed():
+		j.Failed++
+	default:
+		j.Succeeded++
+	}
+}
+
+// errorThreshold converts Spec.MaxErrors into an absolute number of failed
+// instances to tolerate for n targets; -1 means unlimited.
+func errorThreshold(spec string, n int) (int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return -1, nil
+	}
+	if pct, ok := strings.CutSuffix(spec, "%"); ok {
+		p, err := strconv.Atoi(pct)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid max_errors %q", spec)
+		}
+		return n * p / 100, nil
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid max_errors %q", spec)
+	}
+	return v, nil
+}
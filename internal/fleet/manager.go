@@ -0,0 +1,428 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudterm-go/internal/aws"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultConcurrency is used when a spec does not set one.
+	DefaultConcurrency = 10
+	// MaxConcurrency caps how many instances run a command at once.
+	MaxConcurrency = 100
+	defaultTimeout = 10 * time.Minute
+)
+
+var (
+	jobsBucket    = []byte("jobs")
+	resultsBucket = []byte("results")
+)
+
+// ErrNotFound is returned for unknown job IDs.
+var ErrNotFound = errors.New("job not found")
+
+// Executor runs commands on one instance; *aws.Discovery implements it.
+type Executor interface {
+	RunCommand(ctx context.Context, profile, region, instanceID, platform string, commands []string, timeout time.Duration) (*aws.CommandResult, error)
+}
+
+// Event is streamed to subscribers while a job runs.
+type Event struct {
+	Type      string  `json:"type"` // "result" or "done"
+	JobID     string  `json:"job_id"`
+	Result    *Result `json:"result,omitempty"`
+	Status    string  `json:"status,omitempty"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Skipped   int     `json:"skipped"`
+}
+
+// run is the in-memory state of a running job.
+type run struct {
+	job    *Job
+	cancel context.CancelFunc
+	subs   map[chan Event]struct{}
+}
+
+// Manager starts jobs and persists them in a bbolt database.
+type Manager struct {
+	db     *bolt.DB
+	exec   Executor
+	logger *log.Logger
+
+	mu      sync.Mutex
+	running map[string]*run
+	wg      sync.WaitGroup
+}
+
+// NewManager opens (or creates) the job database at path. Jobs that were
+// running when the server stopped are marked interrupted.
+func NewManager(path string, exec Executor, logger *log.Logger) (*Manager, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open fleet job store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(resultsBucket); err != nil {
+			return err
+		}
+		jobs, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return jobs.ForEach(func(k, v []byte) error {
+			var j Job
+			if json.Unmarshal(v, &j) != nil || j.Status != StatusRunning {
+				return nil
+			}
+			j.Status = StatusInterrupted
+			data, _ := json.Marshal(j)
+			return jobs.Put(k, data)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init fleet job store: %w", err)
+	}
+	return &Manager{db: db, exec: exec, logger: logger, running: make(map[string]*run)}, nil
+}
+
+// Start launches spec on targets in the background and returns the new job.
+func (m *Manager) Start(user string, spec Spec, targets []Target) (*Job, error) {
+	if strings.TrimSpace(spec.Command) == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no instances selected")
+	}
+	threshold, err := errorThreshold(spec.MaxErrors, len(targets))
+	if err != nil {
+		return nil, err
+	}
+	if spec.Concurrency <= 0 {
+		spec.Concurrency = DefaultConcurrency
+	}
+	if spec.Concurrency > MaxConcurrency {
+		spec.Concurrency = MaxConcurrency
+	}
+
+	job := &Job{
+		ID:      newJobID(),
+		User:    user,
+		Spec:    spec,
+		Status:  StatusRunning,
+		Created: time.Now().UTC(),
+		Targets: targets,
+	}
+	if err := m.saveJob(job); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{job: job, cancel: cancel, subs: make(map[chan Event]struct{})}
+	m.mu.Lock()
+	m.running[job.ID] = r
+	snapshot := *job
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.run(ctx, r, threshold)
+	return &snapshot, nil
+}
+
+// run executes the job with bounded concurrency, stopping early once more
+// than threshold instances have failed.
+func (m *Manager) run(ctx context.Context, r *run, threshold int) {
+	defer m.wg.Done()
+	job := r.job
+	commands := strings.Split(strings.ReplaceAll(job.Spec.Command, "\r\n", "\n"), "\n")
+	timeout := defaultTimeout
+	if job.Spec.TimeoutSeconds > 0 {
+		timeout = time.Duration(job.Spec.TimeoutSeconds) * time.Second
+	}
+
+	sem := make(chan struct{}, job.Spec.Concurrency)
+	var wg sync.WaitGroup
+	stopped := ""
+	for i, t := range job.Targets {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		m.mu.Lock()
+		failed := job.Failed
+		m.mu.Unlock()
+		switch {
+		case ctx.Err() != nil:
+			stopped = StatusCancelled
+		case threshold >= 0 && failed > threshold:
+			stopped = StatusAborted
+		}
+		if stopped != "" {
+			for _, rest := range job.Targets[i:] {
+				m.record(r, Result{InstanceID: rest.InstanceID, Name: rest.Name, Status: ResultSkipped})
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			m.record(r, m.execute(ctx, t, commands, timeout))
+		}(t)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	switch {
+	case stopped != "":
+		job.Status = stopped
+	case ctx.Err() != nil:
+		job.Status = StatusCancelled
+	case threshold >= 0 && job.Failed > threshold:
+		job.Status = StatusAborted
+	default:
+		job.Status = StatusCompleted
+	}
+	now := time.Now().UTC()
+	job.Finished = &now
+	done := Event{Type: "done", JobID: job.ID, Status: job.Status, Succeeded: job.Succeeded, Failed: job.Failed, Skipped: job.Skipped}
+	for ch := range r.subs {
+		ch <- done
+		close(ch)
+	}
+	r.subs = nil
+	err := m.saveJobLocked(job)
+	delete(m.running, job.ID)
+	m.mu.Unlock()
+	r.cancel()
+	if err != nil {
+		m.logger.Printf("fleet job %s: save: %v", job.ID, err)
+	}
+	m.logger.Printf("fleet job %s %s: %d succeeded, %d failed, %d skipped", job.ID, job.Status, job.Succeeded, job.Failed, job.Skipped)
+}
+
+func (m *Manager) execute(ctx context.Context, t Target, commands []string, timeout time.Duration) Result {
+	res := Result{InstanceID: t.InstanceID, Name: t.Name, Started: time.Now().UTC()}
+	// Allow for delivery and polling on top of the execution timeout.
+	cctx, cancel := context.WithTimeout(ctx, timeout+2*time.Minute)
+	defer cancel()
+	out, err := m.exec.RunCommand(cctx, t.Profile, t.Region, t.InstanceID, t.Platform, commands, timeout)
+	res.Finished = time.Now().UTC()
+	if err != nil {
+		res.Status = ResultError
+		res.ExitCode = -1
+		res.Error = err.Error()
+		if ctx.Err() != nil {
+			res.Status = ResultCancelled
+		}
+		return res
+	}
+	res.Status = out.Status
+	res.ExitCode = out.ExitCode
+	res.Stdout = out.Stdout
+	res.Stderr = out.Stderr
+	if out.Status != ResultSuccess && out.Stderr == "" {
+		res.Error = out.Details
+	}
+	return res
+}
+
+// record stores a result and streams it to subscribers.
+func (m *Manager) record(r *run, res Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.job.record(res)
+	ev := Event{Type: "result", JobID: r.job.ID, Result: &res, Succeeded: r.job.Succeeded, Failed: r.job.Failed, Skipped: r.job.Skipped}
+	for ch := range r.subs {
+		ch <- ev // buffered for every target, never blocks
+	}
+	if err := m.saveResultLocked(r.job, res); err != nil {
+		m.logger.Printf("fleet job %s: save result for %s: %v", r.job.ID, res.InstanceID, err)
+	}
+}
+
+// Subscribe returns the results so far and, while the job is running, a
+// channel of further events that is closed after the final "done" event.
+// The returned function unsubscribes early.
+func (m *Manager) Subscribe(id string) (*Job, <-chan Event, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.running[id]
+	if !ok {
+		job, err := m.loadJob(id)
+		return job, nil, func() {}, err
+	}
+	snapshot := copyJob(r.job)
+	ch := make(chan Event, len(r.job.Targets)+1)
+	r.subs[ch] = struct{}{}
+	return snapshot, ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := r.subs[ch]; ok {
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}, nil
+}
+
+// Get returns a job with all of its results.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.running[id]; ok {
+		return copyJob(r.job), nil
+	}
+	return m.loadJob(id)
+}
+
+// List returns up to limit jobs started by user (all users if empty),
+// newest first, without their results.
+func (m *Manager) List(user string, limit int) ([]Job, error) {
+	m.mu.Lock()
+	live := make(map[string]Job, len(m.running))
+	for id, r := range m.running {
+		j := *r.job
+		j.Results = nil
+		live[id] = j
+	}
+	m.mu.Unlock()
+
+	jobs := []Job{}
+	err := m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(jobsBucket).Cursor()
+		for k, v := c.Last(); k != nil && (limit <= 0 || len(jobs) < limit); k, v = c.Prev() {
+			var j Job
+			if err := json.Unmarshal(v, &j); err != nil {
+				continue
+			}
+			if user != "" && j.User != user {
+				continue
+			}
+			if l, ok := live[j.ID]; ok {
+				j = l
+			}
+			jobs = append(jobs, j)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// Cancel stops a running job; instances that have not started are skipped.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	r, ok := m.running[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	r.cancel()
+	return nil
+}
+
+// Close cancels running jobs, waits for them to finish and closes the
+// database.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	for _, r := range m.running {
+		r.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return m.db.Close()
+}
+
+func (m *Manager) saveJob(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveJobLocked(job)
+}
+
+// saveJobLocked writes the job without its results; m.mu must be held.
+func (m *Manager) saveJobLocked(job *Job) error {
+	j := *job
+	j.Results = nil
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+// saveResultLocked writes one result along with the job's counters; m.mu
+// must be held.
+func (m *Manager) saveResultLocked(job *Job, res Result) error {
+	j := *job
+	j.Results = nil
+	jobData, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	resData, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return m.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(resultsBucket).Put(resultKey(job.ID, len(job.Results)-1), resData); err != nil {
+			return err
+		}
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), jobData)
+	})
+}
+
+func (m *Manager) loadJob(id string) (*Job, error) {
+	var job *Job
+	err := m.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		job = &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			return err
+		}
+		prefix := []byte(id + "\x00")
+		c := tx.Bucket(resultsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Result
+			if json.Unmarshal(v, &r) == nil {
+				job.Results = append(job.Results, r)
+			}
+		}
+		return nil
+	})
+	return job, err
+}
+
+func resultKey(jobID string, n int) []byte {
+	return []byte(fmt.Sprintf("%s\x00%06d", jobID, n))
+}
+
+func copyJob(j *Job) *Job {
+	c := *j
+	c.Results = append([]Result(nil), j.Results...)
+	return &c
+}
+
+// newJobID returns a sortable, unique job ID.
+func newJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
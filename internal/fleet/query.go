@@ -0,0 +1,102 @@
+// Package fleet runs shell commands across many instances through SSM
+// SendCommand and keeps the results of each run as a job.
+package fleet
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"cloudterm-go/internal/types"
+)
+
+// Query selects instances. Empty fields match everything; tag values and
+// names may be glob patterns such as "web-*".
+type Query struct {
+	Tags        map[string]string `json:"tags,omitempty"`
+	AccountIDs  []string          `json:"account_ids,omitempty"`
+	Regions     []string          `json:"regions,omitempty"`
+	InstanceIDs []string          `json:"instance_ids,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Platform    string            `json:"platform,omitempty"` // "linux" or "windows"
+}
+
+// ParseTagQuery parses "Environment=prod,Role=web*" into a tag filter.
+func ParseTagQuery(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid tag filter %q, want key=value", part)
+		}
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+// Empty reports whether q has no conditions, i.e. would select the whole
+// fleet.
+func (q Query) Empty() bool {
+	return len(q.Tags) == 0 && len(q.AccountIDs) == 0 && len(q.Regions) == 0 &&
+		len(q.InstanceIDs) == 0 && q.Name == "" && q.Platform == ""
+}
+
+// Match reports whether inst is selected by q. Only running instances match.
+func (q Query) Match(inst *types.EC2Instance) bool {
+	if inst.State != "" && inst.State != "running" {
+		return false
+	}
+	if len(q.InstanceIDs) > 0 && !contains(q.InstanceIDs, inst.InstanceID) {
+		return false
+	}
+	if len(q.AccountIDs) > 0 && !contains(q.AccountIDs, inst.AccountID) {
+		return false
+	}
+	if len(q.Regions) > 0 && !contains(q.Regions, inst.AWSRegion) {
+		return false
+	}
+	if q.Platform != "" && !strings.EqualFold(q.Platform, inst.Platform) {
+		return false
+	}
+	if q.Name != "" && !glob(q.Name, inst.Name) {
+		return false
+	}
+	for k, want := range q.Tags {
+		got, ok := inst.Tags[k]
+		if !ok || !glob(want, got) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the instances matched by q.
+func Select(instances []types.EC2Instance, q Query) []types.EC2Instance {
+	var out []types.EC2Instance
+	for i := range instances {
+		if q.Match(&instances[i]) {
+			out = append(out, instances[i])
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func glob(pattern, s string) bool {
+	if ok, err := path.Match(pattern, s); err == nil && ok {
+		return true
+	}
+	return pattern == s
+}
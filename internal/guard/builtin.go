@@ -0,0 +1,40 @@
+package guard
+
+// BuiltinPolicy is used when GUARD_POLICY_FILE is "builtin": destructive
+// commands are blocked on production instances and need confirmation
+// everywhere else.
+const BuiltinPolicy = `
+default: allow
+rules:
+  - name: destructive-production
+    action: block
+    environments: ["prod", "prod-*", "production", "prd"]
+    message: Destructive commands are blocked on production instances.
+    patterns: &destructive
+      - '\brm\s+(-[a-z]*[rf][a-z]*\s+)+'
+      - '\brm\s+--(force|recursive)\b'
+      - '\bmkfs(\.\w+)?\b'
+      - '\bdd\s+.*\bof=/dev/'
+      - '>\s*/dev/(sd[a-z]|nvme\d|xvd[a-z])'
+      - ':\(\)\s*\{\s*:\|:&\s*\};:'
+      - '^\s*(shutdown|reboot|halt|poweroff)\b'
+      - '^\s*init\s+[06]\b'
+      - '\bsystemctl\s+(stop|disable|mask|poweroff|reboot|halt)\b'
+      - '\biptables\s+(-F|--flush)\b'
+      - '\bchmod\s+-R\s+0?777\b'
+      - '\bkill\s+-9\s+-1\b'
+      - '\bfdisk\b'
+      - '\bparted\s.*\brm\b'
+      - '\bdrop\s+(database|schema|table)\b'
+      - '\btruncate\s+table\b'
+      - '\bdelete\s+from\s+\w+\s*;?\s*$'
+      - '\bStop-Computer\b'
+      - '\bRestart-Computer\b'
+      - '^\s*format\b.*\b[a-z]:'
+      - '^\s*del\s+/s\b'
+      - '\bRemove-Item\b.*-Recurse\b'
+  - name: destructive
+    action: warn
+    message: This command is potentially destructive.
+    patterns: *destructive
+`
@@ -0,0 +1,244 @@
+package guard
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+
+	"cloudterm-go/internal/suggest"
+)
+
+// Keystrokes the filter injects or interprets.
+const (
+	ctrlC = 0x03
+	ctrlU = 0x15
+	esc   = 0x1b
+)
+
+// RuleUnverified is the rule of a Block decision for a command line the
+// filter could not reconstruct, in an environment with block rules.
+const RuleUnverified = "unverified-command"
+
+// promptEnd finds the end of a shell prompt on an echoed line.
+var promptEnd = regexp.MustCompile(`[$#%>] `)
+
+// Outcomes of a warned command.
+const (
+	Confirmed = "confirmed"
+	Declined  = "declined"
+)
+
+// Event reports a policy decision that the caller should surface and audit.
+type Event struct {
+	Decision
+	// Outcome is set when a pending warned command is resolved.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Filter screens one session's keystrokes. It tracks the line being typed
+// and, on Enter, holds back the Enter keystroke until the policy allows the
+// command: warned commands wait for Resolve, blocked ones are cancelled
+// with Ctrl+C.
+//
+// Keys that let the shell change the line, such as Tab completion, history
+// recall (Up, Ctrl+R) and cursor movement, make the typed line unreliable.
+// The command is then read back from the shell's echo (FeedOutput); if
+// that is not possible either, the command is blocked in environments
+// with block rules.
+type Filter struct {
+	engine *Engine
+	env    string
+
+	mu           sync.Mutex
+	line         suggest.LineBuffer
+	screen       screenLine
+	edited       bool // the shell may have changed the line since it was typed
+	echoed       bool // output arrived after the last keystroke
+	escSeq       []byte
+	pending      *Decision
+	pendingEnter byte
+}
+
+// NewFilter returns a filter for a session on an instance in env.
+func (e *Engine) NewFilter(env string) *Filter {
+	return &Filter{engine: e, env: env}
+}
+
+// FeedOutput passes the session's terminal output to the filter, which
+// reads edited command lines back from the shell's echo.
+func (f *Filter) FeedOutput(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.screen.Write(data)
+	f.echoed = true
+}
+
+// Feed screens keystrokes and returns the bytes to write to the PTY along
+// with any decisions made by a rule or a non-allow default. Input that
+// follows a held command in the same chunk (e.g. the rest of a paste) is
+// dropped.
+func (f *Filter) Feed(data []byte) ([]byte, []Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending != nil {
+		// Ctrl+C abandons the warned command; anything else waits for
+		// the confirmation.
+		if bytes.IndexByte(data, ctrlC) >= 0 {
+			out, ev := f.resolveLocked(false)
+			return out, []Event{ev}
+		}
+		return nil, nil
+	}
+
+	out := make([]byte, 0, len(data))
+	var events []Event
+	for _, c := range data {
+		if c != '\r' && c != '\n' {
+			f.keystroke(c)
+			out = append(out, c)
+			continue
+		}
+		line, _ := f.line.Feed(c)
+		d, ok := f.check(strings.TrimSpace(line))
+		f.edited, f.echoed = false, false
+		if !ok {
+			out = append(out, c)
+			continue
+		}
+		if d.Rule != "" || d.Action != Allow {
+			events = append(events, Event{Decision: d})
+		}
+		switch d.Action {
+		case Warn:
+			f.pending = &d
+			f.pendingEnter = c
+			return out, events
+		case Block:
+			return append(out, ctrlC), events
+		}
+		out = append(out, c)
+	}
+	return out, events
+}
+
+// keystroke tracks one byte of the line being typed.
+func (f *Filter) keystroke(c byte) {
+	f.echoed = false
+	if len(f.escSeq) > 0 {
+		f.escSeq = append(f.escSeq, c)
+		// ESC [ or ESC O start a sequence ending in a final byte; any
+		// other byte after ESC is an Alt+key chord.
+		if len(f.escSeq) == 2 && (c == '[' || c == 'O') {
+			return
+		}
+		if len(f.escSeq) > 2 && (c < 0x40 || c > 0x7e) {
+			return
+		}
+		// Up and Down recall history; every other sequence moves the
+		// cursor, deletes or runs a readline command.
+		f.escSeq = f.escSeq[:0]
+		f.edited = true
+		return
+	}
+	switch {
+	case c == esc:
+		f.escSeq = append(f.escSeq, c)
+	case c == ctrlC:
+		// The shell discards the line.
+		f.line.Reset()
+		f.edited = false
+	case c == ctrlU && !f.edited:
+		f.line.Reset()
+	case c == 0x7f || c == 0x08:
+		f.line.Feed(c)
+	case c < 0x20:
+		// Tab completion, Ctrl+R/P/N history and other readline
+		// commands change the line without the filter seeing how.
+		f.edited = true
+	default:
+		f.line.Feed(c)
+	}
+}
+
+// check decides on the command line ending with Enter. ok is false for an
+// empty line that needs no decision.
+func (f *Filter) check(typed string) (Decision, bool) {
+	if !f.edited {
+		if typed == "" {
+			return Decision{}, false
+		}
+		return f.engine.Check(f.env, typed), true
+	}
+	if cmd, ok := f.echoedCommand(); ok {
+		if cmd == "" {
+			return Decision{}, false
+		}
+		return f.engine.Check(f.env, cmd), true
+	}
+	if f.engine.blocks(f.env) {
+		return Decision{
+			Action:  Block,
+			Rule:    RuleUnverified,
+			Message: "the command line was changed by completion, history or cursor keys and could not be verified; type it out in full",
+			Command: typed,
+			Env:     f.env,
+		}, true
+	}
+	if typed == "" {
+		return Decision{}, false
+	}
+	return f.engine.Check(f.env, typed), true
+}
+
+// echoedCommand reads the command from the shell's echo of the current
+// line, after the prompt. ok is false when the echo has not caught up
+// with the keystrokes or no prompt is on the line.
+func (f *Filter) echoedCommand() (cmd string, ok bool) {
+	if !f.echoed {
+		return "", false
+	}
+	line := f.screen.String()
+	loc := promptEnd.FindStringIndex(line)
+	if loc == nil {
+		return "", false
+	}
+	return strings.TrimSpace(line[loc[1]:]), true
+}
+
+// Pending returns the warned command awaiting confirmation, if any.
+func (f *Filter) Pending() *Decision {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pending == nil {
+		return nil
+	}
+	d := *f.pending
+	return &d
+}
+
+// Resolve answers a pending warning: confirmed commands are submitted,
+// declined ones cancelled. ok is false if nothing was pending.
+func (f *Filter) Resolve(confirmed bool) (out []byte, ev Event, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pending == nil {
+		return nil, Event{}, false
+	}
+	out, ev = f.resolveLocked(confirmed)
+	return out, ev, true
+}
+
+func (f *Filter) resolveLocked(confirmed bool) ([]byte, Event) {
+	ev := Event{Decision: *f.pending, Outcome: Declined}
+	out := []byte{ctrlC}
+	if confirmed {
+		ev.Outcome = Confirmed
+		out = []byte{f.pendingEnter}
+	}
+	f.pending = nil
+	f.line.Reset()
+	f.edited = false
+	return out, ev
+}
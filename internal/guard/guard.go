@@ -0,0 +1,200 @@
+// Package guard screens command lines typed into interactive terminals
+// against a per-environment allow/warn/block policy.
+package guard
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a rule can take.
+const (
+	Allow = "allow"
+	Warn  = "warn" // the user must confirm before the command runs
+	Block = "block"
+)
+
+// Policy is the on-disk guard document (GUARD_POLICY_FILE).
+type Policy struct {
+	// Default applies when no rule matches: "allow" (default), "warn" or "block".
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule applies an action to commands matching any of its patterns in the
+// listed environments. Rules are evaluated in order; the first match wins,
+// so narrow allow rules can precede broad block rules.
+type Rule struct {
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"`
+	// Environments are matched case-insensitively against the instance's
+	// TAG2 value and may be globs ("prod*"); empty matches every instance.
+	Environments []string `yaml:"environments"`
+	// Patterns are case-insensitive regular expressions.
+	Patterns []string `yaml:"patterns"`
+	Message  string   `yaml:"message"`
+}
+
+// Decision is the outcome of checking one command line.
+type Decision struct {
+	Action  string `json:"action"`
+	Rule    string `json:"rule,omitempty"` // empty when the default applied
+	Message string `json:"message,omitempty"`
+	Command string `json:"command"`
+	Env     string `json:"env,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	patterns []*regexp.Regexp
+}
+
+// Engine evaluates a Policy. A nil Engine allows everything.
+type Engine struct {
+	defaultAction string
+	rules         []compiledRule
+}
+
+// Load reads a policy file. An empty path disables the guard (nil engine);
+// "builtin" selects BuiltinPolicy.
+func Load(p string) (*Engine, error) {
+	switch p {
+	case "":
+		return nil, nil
+	case "builtin":
+		return Parse([]byte(BuiltinPolicy))
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("read guard policy: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse builds an Engine from a YAML policy document.
+func Parse(data []byte) (*Engine, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse guard policy: %w", err)
+	}
+	e := &Engine{defaultAction: strings.ToLower(p.Default)}
+	if e.defaultAction == "" {
+		e.defaultAction = Allow
+	}
+	if !validAction(e.defaultAction) {
+		return nil, fmt.Errorf("guard policy: invalid default %q", p.Default)
+	}
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		r.Action = strings.ToLower(r.Action)
+		if !validAction(r.Action) {
+			return nil, fmt.Errorf("guard policy: rule %s: invalid action %q", r.Name, r.Action)
+		}
+		if len(r.Patterns) == 0 {
+			return nil, fmt.Errorf("guard policy: rule %s has no patterns", r.Name)
+		}
+		cr := compiledRule{Rule: r}
+		for _, pat := range r.Patterns {
+			re, err := regexp.Compile("(?i)" + pat)
+			if err != nil {
+				return nil, fmt.Errorf("guard policy: rule %s: %w", r.Name, err)
+			}
+			cr.patterns = append(cr.patterns, re)
+		}
+		e.rules = append(e.rules, cr)
+	}
+	return e, nil
+}
+
+func validAction(a string) bool {
+	return a == Allow || a == Warn || a == Block
+}
+
+// Check decides what to do with a command typed on an instance in env.
+func (e *Engine) Check(env, command string) Decision {
+	d := Decision{Action: Allow, Command: command, Env: env}
+	if e == nil {
+		return d
+	}
+	candidates := segments(command)
+	for _, r := range e.rules {
+		if !r.appliesTo(env) {
+			continue
+		}
+		for _, re := range r.patterns {
+			for _, c := range candidates {
+				if re.MatchString(c) {
+					d.Action = r.Action
+					d.Rule = r.Name
+					d.Message = r.Message
+					return d
+				}
+			}
+		}
+	}
+	d.Action = e.defaultAction
+	return d
+}
+
+// blocks reports whether any command can be blocked in env.
+func (e *Engine) blocks(env string) bool {
+	if e == nil {
+		return false
+	}
+	if e.defaultAction == Block {
+		return true
+	}
+	for _, r := range e.rules {
+		if r.Action == Block && r.appliesTo(env) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *compiledRule) appliesTo(env string) bool {
+	if len(r.Environments) == 0 {
+		return true
+	}
+	env = strings.ToLower(env)
+	for _, pat := range r.Environments {
+		pat = strings.ToLower(pat)
+		if pat == env {
+			return true
+		}
+		if ok, _ := path.Match(pat, env); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	separators = regexp.MustCompile(`\|\||&&|[|;&]|\$\(|` + "`")
+	sudoPrefix = regexp.MustCompile(`^(?:sudo|doas)(?:\s+-\S+)*\s+`)
+)
+
+// segments returns the strings patterns are matched against: the whole
+// line plus each command in a pipeline or list, with any leading sudo
+// removed, so "cd /; sudo shutdown -h now" still matches "^shutdown".
+func segments(command string) []string {
+	out := []string{command}
+	for _, seg := range separators.Split(command, -1) {
+		seg = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(seg), ")"))
+		if seg == "" {
+			continue
+		}
+		out = append(out, seg)
+		if stripped := sudoPrefix.ReplaceAllString(seg, ""); stripped != seg {
+			out = append(out, stripped)
+		}
+	}
+	return out
+}
@@ -0,0 +1,268 @@
+package guard
+
+import (
+	"testing"
+)
+
+const testPolicy = `
+default: allow
+rules:
+  - name: allow-tmp-cleanup
+    action: allow
+    patterns: ['^rm -rf /tmp/build\b']
+  - name: prod-destructive
+    action: block
+    environments: ["prod*"]
+    patterns: ['\brm\s+-rf\b', '^shutdown\b', 'drop\s+database']
+    message: not in production
+  - name: destructive
+    action: warn
+    patterns: ['\brm\s+-rf\b', '^shutdown\b', 'drop\s+database']
+`
+
+func mustParse(t *testing.T, doc string) *Engine {
+	t.Helper()
+	e, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return e
+}
+
+func TestCheck(t *testing.T) {
+	e := mustParse(t, testPolicy)
+	cases := []struct {
+		env, cmd, action, rule string
+	}{
+		{"production", "rm -rf /var/lib", Block, "prod-destructive"},
+		{"PROD-EU", "rm -rf /var/lib", Block, "prod-destructive"},
+		{"dev", "rm -rf /var/lib", Warn, "destructive"},
+		{"prod", "rm -rf /tmp/build", Allow, "allow-tmp-cleanup"},
+		{"prod", "cd / && sudo -n shutdown -h now", Block, "prod-destructive"},
+		{"prod", "echo hi | mysql -e 'DROP DATABASE app'", Block, "prod-destructive"},
+		{"prod", "ls -la", Allow, ""},
+		{"", "shutdown -r now", Warn, "destructive"},
+	}
+	for _, c := range cases {
+		d := e.Check(c.env, c.cmd)
+		if d.Action != c.action || d.Rule != c.rule {
+			t.Errorf("Check(%q, %q) = %s/%s, want %s/%s", c.env, c.cmd, d.Action, d.Rule, c.action, c.rule)
+		}
+	}
+}
+
+func TestNilEngineAllows(t *testing.T) {
+	var e *Engine
+	if d := e.Check("prod", "rm -rf /"); d.Action != Allow {
+		t.Fatalf("nil engine action = %s", d.Action)
+	}
+	if e, err := Load(""); err != nil || e != nil {
+		t.Fatalf("Load(\"\") = %v, %v", e, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, doc := range []string{
+		"default: maybe",
+		"rules: [{name: x, action: deny, patterns: [a]}]",
+		"rules: [{name: x, action: warn}]",
+		"rules: [{name: x, action: warn, patterns: ['(']}]",
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Parse(%q) succeeded", doc)
+		}
+	}
+}
+
+func TestBuiltinPolicy(t *testing.T) {
+	e, err := Load("builtin")
+	if err != nil {
+		t.Fatalf("Load builtin: %v", err)
+	}
+	if d := e.Check("prod", "sudo rm -rf /data"); d.Action != Block {
+		t.Errorf("prod rm -rf = %s", d.Action)
+	}
+	if d := e.Check("staging", "systemctl stop nginx"); d.Action != Warn {
+		t.Errorf("staging systemctl stop = %s", d.Action)
+	}
+	if d := e.Check("prod", "tail -f /var/log/syslog"); d.Action != Allow {
+		t.Errorf("prod tail = %s", d.Action)
+	}
+}
+
+func TestFilterWarnConfirm(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("dev")
+
+	out, events := f.Feed([]byte("rm -rf x\r"))
+	if string(out) != "rm -rf x" {
+		t.Fatalf("forwarded %q, want the line without Enter", out)
+	}
+	if len(events) != 1 || events[0].Action != Warn || f.Pending() == nil {
+		t.Fatalf("events = %+v, pending = %v", events, f.Pending())
+	}
+
+	// Input is held while the prompt is open.
+	if out, _ := f.Feed([]byte("ls\r")); out != nil {
+		t.Fatalf("forwarded %q while pending", out)
+	}
+
+	out, ev, ok := f.Resolve(true)
+	if !ok || string(out) != "\r" || ev.Outcome != Confirmed {
+		t.Fatalf("Resolve(true) = %q, %+v, %v", out, ev, ok)
+	}
+	if f.Pending() != nil {
+		t.Fatal("still pending after Resolve")
+	}
+	if _, _, ok := f.Resolve(true); ok {
+		t.Fatal("second Resolve succeeded")
+	}
+}
+
+func TestFilterWarnDecline(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("dev")
+	f.Feed([]byte("shutdown now\n"))
+
+	out, events := f.Feed([]byte{ctrlC})
+	if string(out) != "\x03" || len(events) != 1 || events[0].Outcome != Declined {
+		t.Fatalf("Ctrl+C while pending = %q, %+v", out, events)
+	}
+
+	// The line buffer starts fresh after the cancelled command.
+	out, events = f.Feed([]byte("ls\r"))
+	if string(out) != "ls\r" || len(events) != 0 {
+		t.Fatalf("next command = %q, %+v", out, events)
+	}
+}
+
+func TestFilterBlock(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("prod")
+	out, events := f.Feed([]byte("rm -rf /var\rls\r"))
+	if string(out) != "rm -rf /var\x03" {
+		t.Fatalf("forwarded %q", out)
+	}
+	if len(events) != 1 || events[0].Action != Block || events[0].Message != "not in production" {
+		t.Fatalf("events = %+v", events)
+	}
+	if f.Pending() != nil {
+		t.Fatal("blocked command left pending")
+	}
+}
+
+func TestFilterTracksEdits(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("prod")
+	// "rm -rf" typed, then erased back to "rm " and completed as "rm file".
+	out, events := f.Feed([]byte("rm -rf\x7f\x7f\x7ffile\r"))
+	if len(events) != 0 || out[len(out)-1] != '\r' {
+		t.Fatalf("edited command = %q, %+v", out, events)
+	}
+}
+
+func TestFilterTabCompletion(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("prod")
+	f.FeedOutput([]byte("\x1b]0;ec2-user@web\x07[ec2-user@web ~]$ "))
+
+	// "shutd<TAB>" is completed by the shell to "shutdown ".
+	f.Feed([]byte("shutd"))
+	f.FeedOutput([]byte("shutd"))
+	f.Feed([]byte("\t"))
+	f.FeedOutput([]byte("own "))
+	f.Feed([]byte("-h now"))
+	f.FeedOutput([]byte("-h now"))
+	out, events := f.Feed([]byte("\r"))
+	if len(events) != 1 || events[0].Action != Block || events[0].Command != "shutdown -h now" {
+		t.Fatalf("tab-completed command: events = %+v", events)
+	}
+	if out[len(out)-1] != ctrlC {
+		t.Fatalf("forwarded %q", out)
+	}
+
+	// "rm -r<TAB>" completing a flag, with the echo of the typed text
+	// and the completion arriving together.
+	f.FeedOutput([]byte("^C\r\n[ec2-user@web ~]$ "))
+	f.Feed([]byte("rm -r\t"))
+	f.FeedOutput([]byte("rm -rf "))
+	f.Feed([]byte("/"))
+	f.FeedOutput([]byte("/"))
+	if _, events := f.Feed([]byte("\r")); len(events) != 1 || events[0].Command != "rm -rf /" {
+		t.Fatalf("completed flag: events = %+v", events)
+	}
+}
+
+func TestFilterHistoryRecall(t *testing.T) {
+	f := mustParse(t, testPolicy).NewFilter("dev")
+	f.FeedOutput([]byte("ubuntu@db:~$ "))
+
+	// Up arrow: bash erases the typed "ls" and draws the recalled command.
+	f.Feed([]byte("ls"))
+	f.FeedOutput([]byte("ls"))
+	f.Feed([]byte("\x1b[A"))
+	f.FeedOutput([]byte("\b\bmysql -e 'DROP DATABASE app'\x1b[K"))
+	_, events := f.Feed([]byte("\r"))
+	if len(events) != 1 || events[0].Action != Warn || events[0].Command != "mysql -e 'DROP DATABASE app'" {
+		t.Fatalf("recalled command: events = %+v", events)
+	}
+	f.Resolve(false)
+
+	// Ctrl+R: the search prompt replaces the shell prompt, so the
+	// command is read from the line the shell redraws on accept.
+	f.FeedOutput([]byte("^C\r\nubuntu@db:~$ "))
+	f.Feed([]byte{0x12})
+	f.FeedOutput([]byte("\r(reverse-i-search)`': \x1b[K"))
+	f.Feed([]byte("shut"))
+	f.FeedOutput([]byte("\x1b[1@s\x1b[1@h\x1b[1@u\x1b[1@t': shutdown -r now"))
+	f.Feed([]byte{esc, '[', 'C'})
+	f.FeedOutput([]byte("\r\x1b[Kubuntu@db:~$ shutdown -r now"))
+	if _, events := f.Feed([]byte("\r")); len(events) != 1 || events[0].Action != Warn || events[0].Command != "shutdown -r now" {
+		t.Fatalf("reverse search: events = %+v", events)
+	}
+}
+
+func TestFilterUnverifiedLine(t *testing.T) {
+	e := mustParse(t, testPolicy)
+	// Up and Enter in one chunk: the echo cannot have arrived.
+	f := e.NewFilter("prod")
+	f.FeedOutput([]byte("$ "))
+	out, events := f.Feed([]byte("\x1b[A\r"))
+	if len(events) != 1 || events[0].Rule != RuleUnverified || out[len(out)-1] != ctrlC {
+		t.Fatalf("prod: forwarded %q, events = %+v", out, events)
+	}
+
+	// Without a prompt on the line the command cannot be located either.
+	f = e.NewFilter("prod")
+	f.Feed([]byte("\t"))
+	f.FeedOutput([]byte("echo hi"))
+	if _, events := f.Feed([]byte("\r")); len(events) != 1 || events[0].Rule != RuleUnverified {
+		t.Fatalf("no prompt: events = %+v", events)
+	}
+
+	// Environments without block rules fall back to the typed line.
+	f = e.NewFilter("dev")
+	out, events = f.Feed([]byte("ls\x1b[A\r"))
+	if string(out) != "ls\x1b[A\r" || len(events) != 0 {
+		t.Fatalf("dev: forwarded %q, events = %+v", out, events)
+	}
+
+	// A plain line after Ctrl+C is checked as typed again.
+	f = e.NewFilter("prod")
+	f.Feed([]byte("\t\x03"))
+	if out, events := f.Feed([]byte("ls\r")); string(out) != "ls\r" || len(events) != 0 {
+		t.Fatalf("after Ctrl+C: forwarded %q, events = %+v", out, events)
+	}
+}
+
+func TestScreenLine(t *testing.T) {
+	var l screenLine
+	l.Write([]byte("$ git statsu\b\b\x1b[Kus"))
+	if got := l.String(); got != "$ git status" {
+		t.Errorf("backspace edit = %q", got)
+	}
+	l.Write([]byte("\r\n$ ech hi\x1b[3D\x1b[1@o"))
+	if got := l.String(); got != "$ echo hi" {
+		t.Errorf("insert = %q", got)
+	}
+	l.Write([]byte("\x1b[H\x1b[2J$ caf\xc3"))
+	l.Write([]byte("\xa9\b\x1b[P"))
+	if got := l.String(); got != "$ caf" {
+		t.Errorf("utf-8 delete = %q", got)
+	}
+}
@@ -0,0 +1,179 @@
+package guard
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// screenLine follows the terminal row the cursor is on, as drawn by the
+// shell's output: enough of a VT100 to replay a line editor's echo of
+// completions, history recall and cursor edits. Moving to another row
+// starts an empty line.
+type screenLine struct {
+	buf []rune
+	cur int
+
+	state  int    // escape parser state, see the esc* constants
+	params []byte // CSI parameter bytes
+	utf    []byte // incomplete UTF-8 sequence
+}
+
+const (
+	escNone = iota
+	escStart
+	escCSI
+	escOSC
+	escOSCEnd // ESC seen inside an OSC string
+	escSkip   // one more byte, e.g. the charset of ESC ( B
+)
+
+// Write applies terminal output to the line.
+func (l *screenLine) Write(data []byte) {
+	for _, c := range data {
+		l.feed(c)
+	}
+}
+
+// String returns the text on the line.
+func (l *screenLine) String() string {
+	return strings.TrimRight(string(l.buf), " ")
+}
+
+func (l *screenLine) feed(c byte) {
+	switch l.state {
+	case escStart:
+		switch c {
+		case '[':
+			l.state, l.params = escCSI, l.params[:0]
+		case ']':
+			l.state = escOSC
+		case '(', ')', '#':
+			l.state = escSkip
+		default:
+			l.state = escNone
+		}
+		return
+	case escCSI:
+		if c >= 0x40 && c <= 0x7e {
+			l.state = escNone
+			l.csi(c)
+		} else {
+			l.params = append(l.params, c)
+		}
+		return
+	case escOSC:
+		switch c {
+		case 0x07:
+			l.state = escNone
+		case 0x1b:
+			l.state = escOSCEnd
+		}
+		return
+	case escOSCEnd:
+		l.state = escNone
+		return
+	case escSkip:
+		l.state = escNone
+		return
+	}
+
+	if len(l.utf) > 0 || c >= utf8.RuneSelf {
+		l.utf = append(l.utf, c)
+		if !utf8.FullRune(l.utf) {
+			return
+		}
+		r, _ := utf8.DecodeRune(l.utf)
+		l.utf = l.utf[:0]
+		l.put(r)
+		return
+	}
+	switch c {
+	case 0x1b:
+		l.state = escStart
+	case '\r':
+		l.cur = 0
+	case '\n':
+		l.clear()
+	case '\b':
+		if l.cur > 0 {
+			l.cur--
+		}
+	default:
+		if c >= 0x20 && c != 0x7f {
+			l.put(rune(c))
+		}
+	}
+}
+
+func (l *screenLine) put(r rune) {
+	l.pad(l.cur)
+	if l.cur < len(l.buf) {
+		l.buf[l.cur] = r
+	} else {
+		l.buf = append(l.buf, r)
+	}
+	l.cur++
+}
+
+// pad extends the line with spaces up to n runes.
+func (l *screenLine) pad(n int) {
+	for len(l.buf) < n {
+		l.buf = append(l.buf, ' ')
+	}
+}
+
+func (l *screenLine) clear() {
+	l.buf, l.cur = l.buf[:0], 0
+}
+
+// csi applies a control sequence. Sequences that leave the row the line
+// is on clear it.
+func (l *screenLine) csi(final byte) {
+	p := string(l.params)
+	n, err := strconv.Atoi(strings.TrimPrefix(p, "?"))
+	if err != nil || n < 1 {
+		n = 1
+	}
+	switch final {
+	case 'C':
+		l.cur += n
+	case 'D':
+		l.cur = max(l.cur-n, 0)
+	case 'G':
+		l.cur = n - 1
+	case 'K':
+		switch p {
+		case "", "0":
+			if l.cur < len(l.buf) {
+				l.buf = l.buf[:l.cur]
+			}
+		case "1":
+			for i := 0; i < l.cur && i < len(l.buf); i++ {
+				l.buf[i] = ' '
+			}
+		case "2":
+			l.buf = l.buf[:0]
+		}
+	case 'P':
+		if l.cur < len(l.buf) {
+			end := min(l.cur+n, len(l.buf))
+			l.buf = append(l.buf[:l.cur], l.buf[end:]...)
+		}
+	case '@':
+		if l.cur < len(l.buf) {
+			blanks := []rune(strings.Repeat(" ", n))
+			l.buf = append(l.buf[:l.cur], append(blanks, l.buf[l.cur:]...)...)
+		}
+	case 'J':
+		if p == "" || p == "0" {
+			if l.cur < len(l.buf) {
+				l.buf = l.buf[:l.cur]
+			}
+		} else {
+			l.clear()
+		}
+	case 'A', 'B', 'E', 'F', 'H', 'f':
+		l.clear()
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+)
+
+// logAudit records ev attributed to the caller of r. Actor, login session,
+// client address and user agent are filled from the request unless already
+// set; X-Request-ID is used as the correlation ID when none is given, and
+// the account is looked up from the instance.
+func (h *Handler) logAudit(r *http.Request, ev audit.AuditEvent) {
+	id := auth.FromContext(r.Context())
+	if ev.Actor == "" {
+		ev.Actor = id.DisplayName()
+	}
+	if ev.SessionID == "" && id != nil {
+		ev.SessionID = id.SessionID
+	}
+	if ev.CorrelationID == "" {
+		ev.CorrelationID = r.Header.Get("X-Request-ID")
+	}
+	if ev.AccountID == "" && ev.InstanceID != "" && h.discovery != nil {
+		if inst := h.findInstance(ev.InstanceID); inst != nil {
+			ev.AccountID = inst.AccountID
+		}
+	}
+	ev.SourceIP = h.auth.ClientIP(r)
+	ev.UserAgent = r.UserAgent()
+	h.audit.Log(ev)
+}
+
+// outcomeForStatus maps an upstream HTTP status to an audit outcome.
+func outcomeForStatus(code int) string {
+	if code >= 200 && code < 300 {
+		return audit.OutcomeSuccess
+	}
+	return audit.OutcomeFailure
+}
+
+// parseAuditFilter reads /audit-log query parameters. Times are RFC 3339
+// or YYYY-MM-DD.
+func parseAuditFilter(q url.Values) (audit.Filter, error) {
+	f := audit.Filter{
+		Action:     q.Get("action"),
+		InstanceID: q.Get("instance"),
+		Account:    q.Get("account"),
+		Actor:      q.Get("user"),
+		Outcome:    q.Get("outcome"),
+		Text:       q.Get("q"),
+	}
+	var err error
+	if f.Since, err = parseAuditTime(q.Get("since"), false); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseAuditTime(q.Get("until"), true); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+	return f, nil
+}
+
+// parseAuditTime parses v; a bare date used as an upper bound covers the
+// whole day.
+func parseAuditTime(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// exportAuditLog streams matching events as CSV or NDJSON.
+func (h *Handler) exportAuditLog(w http.ResponseWriter, r *http.Request, f audit.Filter, format string, limit, offset int) {
+	var write func(audit.AuditEvent) error
+	var flush func() error
+	switch format {
+	case "csv":
+		cw := audit.NewCSVWriter(w)
+		write, flush = cw.Write, cw.Flush
+		w.Header().Set("Content-Type", "text/csv")
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		write = func(ev audit.AuditEvent) error { return enc.Encode(ev) }
+		flush = func() error { return nil }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		jsonError(w, "format must be csv or ndjson", http.StatusBadRequest)
+		return
+	}
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	count, skipped := 0, 0
+	h.audit.Scan(f, func(ev audit.AuditEvent) bool {
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		if write(ev) != nil {
+			return false
+		}
+		count++
+		return limit <= 0 || count < limit
+	})
+	flush()
+	h.logAudit(r, audit.AuditEvent{Action: "audit_export", Details: fmt.Sprintf("format=%s events=%d query=%s", format, count, r.URL.RawQuery)})
+}
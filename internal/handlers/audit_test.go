@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+)
+
+func TestLogAuditAttributesRequest(t *testing.T) {
+	h := &Handler{audit: audit.NewLogger(filepath.Join(t.TempDir(), "audit.log"), 0, 0)}
+
+	req := httptest.NewRequest(http.MethodDelete, "/recordings/x.cast", nil)
+	req.RemoteAddr = "10.0.0.5:51234"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1") // no trusted proxies: ignored
+	id := &auth.Identity{Subject: "u1", Email: "alice@example.com", SessionID: "sess-1"}
+	req = req.WithContext(auth.WithIdentity(req.Context(), id))
+
+	h.logAudit(req, audit.AuditEvent{Action: "recording_delete", Details: "file=x.cast"})
+	h.logAudit(req, audit.AuditEvent{Action: "recording_delete", Outcome: audit.OutcomeFailure, CorrelationID: "term-9"})
+
+	events := h.audit.Recent(10, 0)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	failed, ok := events[0], events[1]
+	if ok.Actor != "alice@example.com" || ok.SourceIP != "10.0.0.5" || ok.UserAgent != "test-agent" ||
+		ok.SessionID != "sess-1" || ok.CorrelationID != "req-1" || ok.Outcome != audit.OutcomeSuccess {
+		t.Errorf("unexpected event %+v", ok)
+	}
+	if failed.Outcome != audit.OutcomeFailure || failed.CorrelationID != "term-9" {
+		t.Errorf("unexpected event %+v", failed)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+)
+
+// publicPaths are reachable without a login session.
+var publicPaths = map[string]bool{
+	"/health":          true,
+	"/auth/login":      true,
+	"/auth/oidc/start": true,
+	"/auth/callback":   true,
+	"/auth/logout":     true,
+}
+
+// requireAuth wraps the router so that every route except publicPaths needs
+// a valid session cookie, and state-changing requests must come from an
+// allowed origin.
+func (h *Handler) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !h.auth.CheckOrigin(r) {
+				jsonError(w, "cross-origin request rejected", http.StatusForbidden)
+				return
+			}
+		}
+
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := h.auth.Identify(r)
+		if id == nil {
+			if wantsHTML(r) {
+				http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			jsonError(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// wantsHTML reports whether r is a top-level browser navigation rather than
+// an API or WebSocket call.
+func wantsHTML(r *http.Request) bool {
+	if r.Method != http.MethodGet || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// safeNext only allows same-site relative redirects after login.
+func safeNext(next string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (h *Handler) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	h.renderLogin(w, r, "")
+}
+
+func (h *Handler) renderLogin(w http.ResponseWriter, r *http.Request, errMsg string) {
+	if !h.auth.Enabled() {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	data := map[string]interface{}{
+		"Next":         safeNext(r.FormValue("next")),
+		"Error":        errMsg,
+		"SSOEnabled":   h.auth.OIDC() != nil,
+		"LocalEnabled": h.auth.LocalLoginEnabled(),
+	}
+	if errMsg != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := h.templates.ExecuteTemplate(w, "login.html", data); err != nil {
+		h.logger.Printf("template login.html: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.LocalLoginEnabled() {
+		jsonError(w, "local login disabled", http.StatusNotFound)
+		return
+	}
+	username := r.FormValue("username")
+	id, err := h.auth.Authenticate(username, r.FormValue("password"))
+	if err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "login", Outcome: audit.OutcomeFailure, Actor: username, Details: "provider=local"})
+		h.renderLogin(w, r, "Invalid username or password")
+		return
+	}
+	h.finishLogin(w, r, id, r.FormValue("next"))
+}
+
+func (h *Handler) handleOIDCStart(w http.ResponseWriter, r *http.Request) {
+	target, err := h.auth.BeginLogin(w, r, safeNext(r.URL.Query().Get("next")))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (h *Handler) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	id, next, err := h.auth.CompleteLogin(w, r)
+	if err != nil {
+		h.logger.Printf("oidc callback: %v", err)
+		h.logAudit(r, audit.AuditEvent{Action: "login", Outcome: audit.OutcomeFailure, Details: fmt.Sprintf("provider=oidc error=%s", err)})
+		h.renderLogin(w, r, "Single sign-on failed: "+err.Error())
+		return
+	}
+	h.finishLogin(w, r, id, next)
+}
+
+func (h *Handler) finishLogin(w http.ResponseWriter, r *http.Request, id *auth.Identity, next string) {
+	if err := h.auth.IssueSession(w, r, id); err != nil {
+		jsonError(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "login", Actor: id.DisplayName(), SessionID: id.SessionID, Details: "provider=" + id.Provider})
+	http.Redirect(w, r, safeNext(next), http.StatusFound)
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if id := h.auth.Identify(r); id != nil && h.auth.Enabled() {
+		h.logAudit(r, audit.AuditEvent{Action: "logout", Actor: id.DisplayName(), SessionID: id.SessionID})
+	}
+	h.auth.ClearSession(w, r)
+	http.Redirect(w, r, "/auth/login", http.StatusFound)
+}
+
+func (h *Handler) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	id := auth.FromContext(r.Context())
+	jsonResponse(w, map[string]interface{}{
+		"identity":  id,
+		"auth_mode": h.auth.Mode(),
+		"roles":     h.rbac.Roles(id),
+	})
+}
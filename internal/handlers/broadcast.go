@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxBroadcastSessions caps the size of a broadcast group.
+const maxBroadcastSessions = 50
+
+// broadcastGroup is a named set of sessions that receive the same input.
+type broadcastGroup struct {
+	sessions []string
+	excluded map[string]bool
+	audited  string // target list last written to the audit log
+}
+
+// broadcastGroups holds one WebSocket client's groups by name. It is only
+// used from that client's read loop, so it needs no locking.
+type broadcastGroups map[string]*broadcastGroup
+
+// set defines or replaces a group; an empty member list deletes it.
+func (g broadcastGroups) set(name string, sessionIDs, excluded []string) {
+	if len(sessionIDs) == 0 {
+		delete(g, name)
+		return
+	}
+	seen := make(map[string]bool, len(sessionIDs))
+	grp := &broadcastGroup{excluded: make(map[string]bool)}
+	for _, id := range sessionIDs {
+		if id != "" && !seen[id] && len(grp.sessions) < maxBroadcastSessions {
+			seen[id] = true
+			grp.sessions = append(grp.sessions, id)
+		}
+	}
+	for _, id := range excluded {
+		if seen[id] {
+			grp.excluded[id] = true
+		}
+	}
+	g[name] = grp
+}
+
+// exclude toggles whether a member receives broadcast input.
+func (g broadcastGroups) exclude(name, sessionID string, excluded bool) {
+	grp, ok := g[name]
+	if !ok {
+		return
+	}
+	for _, id := range grp.sessions {
+		if id == sessionID {
+			if excluded {
+				grp.excluded[id] = true
+			} else {
+				delete(grp.excluded, id)
+			}
+			return
+		}
+	}
+}
+
+// targets returns a group's members that are not excluded.
+func (g broadcastGroups) targets(name string) []string {
+	grp, ok := g[name]
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(grp.sessions))
+	for _, id := range grp.sessions {
+		if !grp.excluded[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// state describes a group for the client.
+func (g broadcastGroups) state(name string) types.BroadcastGroupMsg {
+	msg := types.BroadcastGroupMsg{Group: name, SessionIDs: []string{}}
+	if grp, ok := g[name]; ok {
+		msg.SessionIDs = grp.sessions
+		for id := range grp.excluded {
+			msg.Excluded = append(msg.Excluded, id)
+		}
+		sort.Strings(msg.Excluded)
+	}
+	return msg
+}
+
+// wsBroadcastGroup defines a group and echoes its state back.
+func (h *Handler) wsBroadcastGroup(conn *websocket.Conn, writeMu *sync.Mutex, groups broadcastGroups, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg types.BroadcastGroupMsg
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Group == "" {
+		return
+	}
+	groups.set(msg.Group, msg.SessionIDs, msg.Excluded)
+	h.sendBroadcastState(conn, writeMu, groups, msg.Group)
+}
+
+// wsBroadcastExclude toggles a group member and echoes the group's state.
+func (h *Handler) wsBroadcastExclude(conn *websocket.Conn, writeMu *sync.Mutex, groups broadcastGroups, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg types.BroadcastExcludeMsg
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+	groups.exclude(msg.Group, msg.SessionID, msg.Excluded)
+	h.sendBroadcastState(conn, writeMu, groups, msg.Group)
+}
+
+func (h *Handler) sendBroadcastState(conn *websocket.Conn, writeMu *sync.Mutex, groups broadcastGroups, name string) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.WriteJSON(types.WSMessage{Type: "broadcast_group", Payload: groups.state(name)})
+}
+
+// wsBroadcastInput writes keystrokes to every active member of a group the
+// caller controls. Each time the set of receiving sessions changes it is
+// recorded in the audit log.
+func (h *Handler) wsBroadcastInput(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, groups broadcastGroups, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg types.BroadcastInputMsg
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+	grp, ok := groups[msg.Group]
+	if !ok {
+		return
+	}
+
+	user := auth.FromContext(r.Context()).DisplayName()
+	var sent []string
+	for _, id := range groups.targets(msg.Group) {
+		if !h.sessions.CanWrite(id, user) {
+			continue
+		}
+		input := h.guardInput(r, conn, writeMu, id, []byte(msg.Input))
+		if len(input) == 0 {
+			continue
+		}
+		h.obsMu.Lock()
+		obs := h.observers[id]
+		h.obsMu.Unlock()
+		if obs != nil {
+			obs.FeedInput(input)
+		}
+		if err := h.writeInput(r, conn, writeMu, id, input); err != nil {
+			h.logger.Printf("broadcast input session %s: %v", id, err)
+			continue
+		}
+		sent = append(sent, id)
+	}
+
+	if list := strings.Join(sent, ","); list != grp.audited {
+		grp.audited = list
+		if list != "" {
+			h.logAudit(r, audit.AuditEvent{Action: "broadcast_input", Details: "group=" + msg.Group + " sessions=" + list})
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBroadcastGroups(t *testing.T) {
+	g := broadcastGroups{}
+	g.set("web", []string{"t1", "t2", "t2", "", "t3"}, []string{"t3", "other"})
+
+	if got := g.targets("web"); !reflect.DeepEqual(got, []string{"t1", "t2"}) {
+		t.Errorf("targets = %v", got)
+	}
+	g.exclude("web", "t1", true)
+	g.exclude("web", "t3", false)
+	g.exclude("web", "missing", true)
+	if got := g.targets("web"); !reflect.DeepEqual(got, []string{"t2", "t3"}) {
+		t.Errorf("targets after toggles = %v", got)
+	}
+	st := g.state("web")
+	if !reflect.DeepEqual(st.SessionIDs, []string{"t1", "t2", "t3"}) || !reflect.DeepEqual(st.Excluded, []string{"t1"}) {
+		t.Errorf("state = %+v", st)
+	}
+
+	g.set("web", nil, nil)
+	if _, ok := g["web"]; ok || g.targets("web") != nil {
+		t.Error("empty member list should delete the group")
+	}
+
+	ids := make([]string, maxBroadcastSessions+5)
+	for i := range ids {
+		ids[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	g.set("big", ids, nil)
+	if n := len(g.targets("big")); n != maxBroadcastSessions {
+		t.Errorf("group size %d, want cap %d", n, maxBroadcastSessions)
+	}
+}
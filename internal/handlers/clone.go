@@ -7,6 +7,7 @@ import (
 
 	"cloudterm-go/internal/audit"
 	"cloudterm-go/internal/aws"
+	"cloudterm-go/internal/rbac"
 )
 
 // handleCloneStart creates an AMI from the source instance and starts background polling.
@@ -24,15 +25,19 @@ func (h *Handler) handleCloneStart(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "instance_id and clone_name required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionCloneStart, req.InstanceID) {
+		return
+	}
 
 	cloneID, err := h.discovery.StartClone(r.Context(), req.InstanceID, req.CloneName)
 	if err != nil {
 		h.logger.Printf("clone start error: %v", err)
+		h.logAudit(r, audit.AuditEvent{Action: "clone_start", Outcome: audit.OutcomeFailure, InstanceID: req.InstanceID, Details: err.Error()})
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{Action: "clone_start", InstanceID: req.InstanceID, Details: fmt.Sprintf("Clone %s, AMI name: %s", cloneID, req.CloneName)})
+	h.logAudit(r, audit.AuditEvent{Action: "clone_start", CorrelationID: cloneID, InstanceID: req.InstanceID, Details: fmt.Sprintf("Clone %s, AMI name: %s", cloneID, req.CloneName)})
 	jsonResponse(w, map[string]string{"id": cloneID, "message": "Clone started"})
 }
 
@@ -82,6 +87,15 @@ func (h *Handler) handleCloneLaunch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, err := h.discovery.GetCloneStatus(id)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, rbac.ActionCloneLaunch, status.SourceInstanceID) {
+		return
+	}
+
 	var settings aws.CloneSettings
 	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -91,10 +105,11 @@ func (h *Handler) handleCloneLaunch(w http.ResponseWriter, r *http.Request) {
 	newID, err := h.discovery.LaunchClone(r.Context(), id, settings)
 	if err != nil {
 		h.logger.Printf("clone launch error: %v", err)
+		h.logAudit(r, audit.AuditEvent{Action: "clone_launch", Outcome: audit.OutcomeFailure, CorrelationID: id, InstanceID: status.SourceInstanceID, Details: err.Error()})
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{Action: "clone_launch", InstanceID: newID, Details: fmt.Sprintf("Cloned from %s", id)})
+	h.logAudit(r, audit.AuditEvent{Action: "clone_launch", CorrelationID: id, InstanceID: newID, Details: fmt.Sprintf("Cloned from %s", id)})
 	jsonResponse(w, map[string]string{"instance_id": newID, "message": "Instance launched"})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/crypto"
+	"cloudterm-go/internal/rbac"
+)
+
+// handleEncryptionStatus reports how the data key is protected and whether
+// a rotation is still pending.
+func (h *Handler) handleEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	_, pending := h.keyring.Pending()
+	jsonResponse(w, map[string]interface{}{
+		"encrypted": h.keyring.Key() != nil,
+		"source":    h.keyring.Source(),
+		"pending":   pending,
+	})
+}
+
+// handleRotateKey switches to a new data key and re-encrypts the vault,
+// the suggestion store and the manual account secrets while they stay in
+// use. An optional passphrase replaces SUGGEST_ENCRYPTION_KEY, which must
+// then be updated before the next restart.
+func (h *Handler) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionKeysRotate) {
+		return
+	}
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	stores := []crypto.Resealer{h.accounts, h.suggest}
+	if h.vault != nil {
+		stores = append(stores, h.vault)
+	}
+	if err := h.keyring.Rotate(r.Context(), req.Passphrase, stores...); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "key_rotate", Outcome: audit.OutcomeFailure, Details: err.Error()})
+		status := http.StatusInternalServerError
+		if errors.Is(err, crypto.ErrNoKey) {
+			status = http.StatusConflict
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+	details := "source=" + h.keyring.Source()
+	if req.Passphrase != "" {
+		details += " passphrase=changed"
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "key_rotate", Details: details})
+	jsonResponse(w, map[string]string{"status": "ok", "source": h.keyring.Source()})
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/fleet"
+	"cloudterm-go/internal/rbac"
+)
+
+// fleetTargets resolves a query to the running instances the caller is
+// granted action on. denied counts matches excluded by RBAC.
+func (h *Handler) fleetTargets(r *http.Request, action string, q fleet.Query) (targets []fleet.Target, denied int) {
+	instances, _ := h.discovery.GetAllInstances()
+	id := auth.FromContext(r.Context())
+	for _, inst := range fleet.Select(instances, q) {
+		if !h.rbac.Allowed(id, action, rbac.InstanceResource(&inst)) {
+			denied++
+			continue
+		}
+		targets = append(targets, fleet.Target{
+			InstanceID: inst.InstanceID,
+			Name:       inst.Name,
+			AccountID:  inst.AccountID,
+			Region:     inst.AWSRegion,
+			Profile:    inst.AWSProfile,
+			Platform:   inst.Platform,
+		})
+	}
+	return targets, denied
+}
+
+// handleFleetPreview lists the instances a query would target.
+func (h *Handler) handleFleetPreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query fleet.Query `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	targets, denied := h.fleetTargets(r, rbac.ActionFleetRun, req.Query)
+	if targets == nil {
+		targets = []fleet.Target{}
+	}
+	jsonResponse(w, map[string]interface{}{"count": len(targets), "denied": denied, "targets": targets})
+}
+
+// handleFleetRun starts a command on every instance matched by the query.
+func (h *Handler) handleFleetRun(w http.ResponseWriter, r *http.Request) {
+	var spec fleet.Spec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if spec.Query.Empty() {
+		jsonError(w, "select instances by tag, account, region, name or ID", http.StatusBadRequest)
+		return
+	}
+	targets, denied := h.fleetTargets(r, rbac.ActionFleetRun, spec.Query)
+	if len(targets) == 0 {
+		msg := "no running instances match the query"
+		if denied > 0 {
+			msg = "permission denied: " + rbac.ActionFleetRun
+		}
+		jsonError(w, msg, http.StatusBadRequest)
+		return
+	}
+	if max := h.cfg.FleetMaxTargets; max > 0 && len(targets) > max {
+		jsonError(w, fmt.Sprintf("query matches %d instances; the limit is %d", len(targets), max), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.fleet.Start(auth.FromContext(r.Context()).DisplayName(), spec, targets)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	command := spec.Command
+	if len(command) > 200 {
+		command = command[:200] + "…"
+	}
+	h.logAudit(r, audit.AuditEvent{
+		Action:        "fleet_run",
+		CorrelationID: job.ID,
+		Details:       fmt.Sprintf("targets=%d denied=%d command=%q", len(targets), denied, command),
+	})
+	jsonResponse(w, job)
+}
+
+// handleFleetJobs lists the caller's jobs, newest first.
+func (h *Handler) handleFleetJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	jobs, err := h.fleet.List(auth.FromContext(r.Context()).DisplayName(), limit)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, jobs)
+}
+
+// fleetJob loads a job from the path, restricted to the user who ran it.
+func (h *Handler) fleetJob(w http.ResponseWriter, r *http.Request) (*fleet.Job, bool) {
+	job, err := h.fleet.Get(r.PathValue("id"))
+	if err == nil && job.User != auth.FromContext(r.Context()).DisplayName() {
+		err = fleet.ErrNotFound
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, fleet.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		jsonError(w, err.Error(), status)
+		return nil, false
+	}
+	return job, true
+}
+
+// handleFleetJob returns a job with its per-instance results and the
+// results grouped by identical output.
+func (h *Handler) handleFleetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.fleetJob(w, r)
+	if !ok {
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"job": job, "groups": job.Groups()})
+}
+
+// handleFleetStream streams a job's results as NDJSON: results recorded so
+// far first, then each new result as it arrives, ending with a "done" event.
+func (h *Handler) handleFleetStream(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.fleetJob(w, r); !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	job, events, unsubscribe, err := h.fleet.Subscribe(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	send := func(ev fleet.Event) {
+		line, _ := json.Marshal(ev)
+		w.Write(line)
+		w.Write([]byte("\n"))
+		flusher.Flush()
+	}
+	for i := range job.Results {
+		send(fleet.Event{Type: "result", JobID: job.ID, Result: &job.Results[i]})
+	}
+	if events == nil {
+		send(fleet.Event{Type: "done", JobID: job.ID, Status: job.Status, Succeeded: job.Succeeded, Failed: job.Failed, Skipped: job.Skipped})
+		return
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			send(ev)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleFleetCancel stops a running job.
+func (h *Handler) handleFleetCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.fleetJob(w, r)
+	if !ok {
+		return
+	}
+	if err := h.fleet.Cancel(job.ID); err != nil {
+		jsonError(w, "job is not running", http.StatusConflict)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "fleet_cancel", CorrelationID: job.ID})
+	jsonResponse(w, map[string]string{"status": "cancelling"})
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/guard"
+	"cloudterm-go/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// guardFilter returns the command guard for a session, creating it on first
+// use with the environment (TAG2) of the session's instance. It returns nil
+// when no guard policy is configured.
+func (h *Handler) guardFilter(sessionID string) *guard.Filter {
+	if h.guard == nil {
+		return nil
+	}
+	h.guardMu.Lock()
+	defer h.guardMu.Unlock()
+	if f := h.guards[sessionID]; f != nil {
+		return f
+	}
+	env := ""
+	if sess, ok := h.sessions.GetSession(sessionID); ok {
+		if inst := h.findInstance(sess.InstanceID); inst != nil {
+			env = inst.Tag2Value
+		}
+	}
+	f := h.guard.NewFilter(env)
+	h.guards[sessionID] = f
+	return f
+}
+
+// guardInput screens keystrokes bound for a session and returns the bytes
+// that may be written to it. Rule matches are audited and reported to the
+// typing client.
+func (h *Handler) guardInput(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, sessionID string, data []byte) []byte {
+	f := h.guardFilter(sessionID)
+	if f == nil {
+		return data
+	}
+	out, events := f.Feed(data)
+	for _, ev := range events {
+		h.guardEvent(r, conn, writeMu, sessionID, ev)
+	}
+	return out
+}
+
+// wsGuardConfirm answers a held command: confirmed commands are submitted,
+// declined ones cancelled.
+func (h *Handler) wsGuardConfirm(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg types.GuardConfirmMsg
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+	if !h.sessions.CanWrite(msg.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		return
+	}
+	f := h.guardFilter(msg.SessionID)
+	if f == nil {
+		return
+	}
+	out, ev, ok := f.Resolve(msg.Confirmed)
+	if !ok {
+		return
+	}
+	h.guardEvent(r, conn, writeMu, msg.SessionID, ev)
+	if err := h.writeInput(r, conn, writeMu, msg.SessionID, out); err != nil {
+		h.logger.Printf("guard input session %s: %v", msg.SessionID, err)
+	}
+}
+
+// sendGuardPending repeats an unanswered guard_warn prompt, e.g. to a client
+// reattaching to a session.
+func (h *Handler) sendGuardPending(conn *websocket.Conn, writeMu *sync.Mutex, sessionID string) {
+	h.guardMu.Lock()
+	f := h.guards[sessionID]
+	h.guardMu.Unlock()
+	if f == nil {
+		return
+	}
+	if d := f.Pending(); d != nil {
+		sendGuardDecision(conn, writeMu, "guard_warn", sessionID, guard.Event{Decision: *d})
+	}
+}
+
+func (h *Handler) guardEvent(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, sessionID string, ev guard.Event) {
+	action, msgType := "command_"+ev.Action, ""
+	switch {
+	case ev.Outcome == guard.Confirmed:
+		action, msgType = "command_confirm", "guard_resolved"
+	case ev.Outcome == guard.Declined:
+		action, msgType = "command_decline", "guard_resolved"
+	case ev.Action == guard.Warn:
+		msgType = "guard_warn"
+	case ev.Action == guard.Block:
+		msgType = "guard_block"
+	}
+
+	outcome := audit.OutcomeSuccess
+	if ev.Action == guard.Block || ev.Outcome == guard.Declined {
+		outcome = audit.OutcomeDenied
+	}
+	auditEv := audit.AuditEvent{
+		Action:        action,
+		CorrelationID: sessionID,
+		Details:       fmt.Sprintf("rule=%s env=%s command=%q", ev.Rule, ev.Env, ev.Command),
+		Outcome:       outcome,
+	}
+	if sess, ok := h.sessions.GetSession(sessionID); ok {
+		auditEv.InstanceID = sess.InstanceID
+		auditEv.InstanceName = sess.InstanceName
+		auditEv.Profile = sess.Profile
+		auditEv.Region = sess.Region
+	}
+	h.logAudit(r, auditEv)
+
+	if msgType != "" {
+		sendGuardDecision(conn, writeMu, msgType, sessionID, ev)
+	}
+}
+
+func sendGuardDecision(conn *websocket.Conn, writeMu *sync.Mutex, msgType, sessionID string, ev guard.Event) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.WriteJSON(types.WSMessage{
+		Type: msgType,
+		Payload: types.GuardDecisionMsg{
+			SessionID: sessionID,
+			Command:   ev.Command,
+			Action:    ev.Action,
+			Rule:      ev.Rule,
+			Message:   ev.Message,
+			Outcome:   ev.Outcome,
+		},
+	})
+}
+
+// forgetSession drops the per-session observer and guard state once a
+// session has ended.
+func (h *Handler) forgetSession(sessionID string) {
+	h.obsMu.Lock()
+	if obs, ok := h.observers[sessionID]; ok {
+		obs.Close()
+		delete(h.observers, sessionID)
+	}
+	h.obsMu.Unlock()
+
+	h.guardMu.Lock()
+	delete(h.guards, sessionID)
+	h.guardMu.Unlock()
+}
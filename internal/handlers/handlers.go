@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -23,10 +24,18 @@ import (
 	"time"
 
 	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
 	"cloudterm-go/internal/aws"
+	"cloudterm-go/internal/catalog"
 	"cloudterm-go/internal/config"
+	"cloudterm-go/internal/crypto"
+	"cloudterm-go/internal/fleet"
 	"cloudterm-go/internal/guacamole"
+	"cloudterm-go/internal/guard"
 	"cloudterm-go/internal/llm"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/recordings"
+	"cloudterm-go/internal/runbook"
 	"cloudterm-go/internal/session"
 	"cloudterm-go/internal/suggest"
 	"cloudterm-go/internal/teleport"
@@ -51,9 +60,20 @@ type Handler struct {
 	sessions     *session.Manager
 	logger       *log.Logger
 	audit        *audit.Logger
+	auth         *auth.Service
+	rbac         *rbac.Engine
 	accounts     *aws.AccountStore
 	suggest      *suggest.Engine
 	vault        *vault.Store
+	keyring      *crypto.Keyring
+	recordings   *recordings.Store
+	fleet        *fleet.Manager
+	guard        *guard.Engine
+	guards       map[string]*guard.Filter // per-session command guard state
+	guardMu      sync.Mutex
+	runbooks     *runbook.Store
+	runbookRuns  *runbook.Manager
+	catalog      *catalog.Store // nil when the session catalog is disabled
 	costExplorer *aws.CostExplorerService
 	eksService   *aws.EKSService
 	k8sPool      *k8s.ClientPool
@@ -62,12 +82,13 @@ type Handler struct {
 	obsMu        sync.Mutex
 	upgrader     websocket.Upgrader
 	clients      map[*websocket.Conn][]string
+	watching     map[*websocket.Conn][]string // sessions each client watches read-only
 	clientsMu    sync.Mutex
 	templates    *template.Template
 }
 
 // New creates a Handler wired to the given dependencies.
-func New(cfg *config.Config, discovery *aws.Discovery, sessions *session.Manager, logger *log.Logger, auditLogger *audit.Logger, accounts *aws.AccountStore, suggestEngine *suggest.Engine, vaultStore *vault.Store) *Handler {
+func New(cfg *config.Config, discovery *aws.Discovery, sessions *session.Manager, logger *log.Logger, auditLogger *audit.Logger, authSvc *auth.Service, policy *rbac.Engine, accounts *aws.AccountStore, suggestEngine *suggest.Engine, vaultStore *vault.Store, recordingStore *recordings.Store, fleetJobs *fleet.Manager, guardPolicy *guard.Engine, runbooks *runbook.Store, runbookRuns *runbook.Manager, sessionCatalog *catalog.Store, keyring *crypto.Keyring) *Handler {
 	tmpl := template.Must(template.ParseGlob(filepath.Join("web", "templates", "*.html")))
 
 	costSvc := aws.NewCostExplorerService(cfg, accounts, logger)
@@ -84,23 +105,36 @@ func New(cfg *config.Config, discovery *aws.Discovery, sessions *session.Manager
 		sessions:     sessions,
 		logger:       logger,
 		audit:        auditLogger,
+		auth:         authSvc,
+		rbac:         policy,
 		accounts:     accounts,
 		suggest:      suggestEngine,
 		vault:        vaultStore,
+		keyring:      keyring,
+		recordings:   recordingStore,
+		fleet:        fleetJobs,
+		guard:        guardPolicy,
+		runbooks:     runbooks,
+		runbookRuns:  runbookRuns,
+		catalog:      sessionCatalog,
 		costExplorer: costSvc,
 		eksService:   eksSvc,
 		k8sPool:      k8sPool,
 		teleport:     teleport.NewService(logger),
 		observers:    make(map[string]*suggest.Observer),
+		guards:       make(map[string]*guard.Filter),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
+			CheckOrigin: authSvc.CheckOrigin,
 		},
 		clients:   make(map[*websocket.Conn][]string),
+		watching:  make(map[*websocket.Conn][]string),
 		templates: tmpl,
 	}
 }
 
 // Router returns an http.Handler with all application routes registered.
+// Every route except login and health checks requires an authenticated
+// session (see requireAuth).
 func (h *Handler) Router() http.Handler {
 	mux := http.NewServeMux()
 
@@ -114,6 +148,15 @@ func (h *Handler) Router() http.Handler {
 		_, _ = w.Write([]byte(`{"status":"ok"}`))
 	})
 
+	// Authentication
+	mux.HandleFunc("GET /auth/login", h.handleLoginPage)
+	mux.HandleFunc("POST /auth/login", h.handleLoginSubmit)
+	mux.HandleFunc("GET /auth/oidc/start", h.handleOIDCStart)
+	mux.HandleFunc("GET /auth/callback", h.handleOIDCCallback)
+	mux.HandleFunc("GET /auth/logout", h.handleLogout)
+	mux.HandleFunc("POST /auth/logout", h.handleLogout)
+	mux.HandleFunc("GET /auth/me", h.handleWhoAmI)
+
 	// API — read
 	mux.HandleFunc("GET /instances", h.handleInstances)
 	mux.HandleFunc("GET /scan-instances", h.handleScanInstances)
@@ -138,27 +181,65 @@ func (h *Handler) Router() http.Handler {
 mux.HandleFunc("POST /express-upload", h.handleExpressUpload)
 	mux.HandleFunc("POST /express-download", h.handleExpressDownload)
 	mux.HandleFunc("POST /export-session", h.handleExportSession)
-	mux.Handle("GET /exports/", http.StripPrefix("/exports/", http.FileServer(http.Dir(h.cfg.TerminalExportDir))))
+	mux.HandleFunc("GET /exports/{name}", h.handleServeExport)
 
 	// Port forwarding proxy
 	mux.HandleFunc("POST /start-port-forward", h.handleStartPortForward)
 	mux.HandleFunc("POST /stop-port-forward", h.handleStopPortForward)
 	mux.HandleFunc("GET /active-tunnels", h.handleActiveTunnels)
 
+	// Detached and shared terminal sessions
+	mux.HandleFunc("GET /sessions/detached", h.handleDetachedSessions)
+	mux.HandleFunc("DELETE /sessions/detached/{id}", h.handleTerminateDetachedSession)
+	mux.HandleFunc("GET /sessions/history", h.handleSessionHistory)
+	mux.HandleFunc("GET /sessions/{id}/search", h.handleSearchSession)
+	mux.HandleFunc("GET /sessions/history/{id}", h.handleSessionRecord)
+	mux.HandleFunc("GET /sessions/shared", h.handleSharedSessions)
+	mux.HandleFunc("POST /sessions/{id}/watchers", h.handleInviteWatcher)
+	mux.HandleFunc("DELETE /sessions/{id}/watchers/{user}", h.handleRevokeWatcher)
+
 	// Recordings
 	mux.HandleFunc("GET /recordings", h.handleListRecordings)
+	mux.HandleFunc("GET /recordings/search", h.handleSearchRecordings)
+	mux.HandleFunc("GET /recordings/{name}/verify", h.handleVerifyRecording)
 	mux.HandleFunc("GET /recordings/", h.handleServeRecording)
 	mux.HandleFunc("DELETE /recordings/", h.handleDeleteRecording)
 	mux.HandleFunc("POST /toggle-recording", h.handleToggleRecording)
 	mux.HandleFunc("POST /convert-recording", h.handleConvertRecording)
 	mux.HandleFunc("GET /convert-status/", h.handleConvertStatus)
 
+	// Fleet command execution
+	mux.HandleFunc("POST /fleet/preview", h.handleFleetPreview)
+	mux.HandleFunc("POST /fleet/jobs", h.handleFleetRun)
+	mux.HandleFunc("GET /fleet/jobs", h.handleFleetJobs)
+	mux.HandleFunc("GET /fleet/jobs/{id}", h.handleFleetJob)
+	mux.HandleFunc("GET /fleet/jobs/{id}/stream", h.handleFleetStream)
+	mux.HandleFunc("POST /fleet/jobs/{id}/cancel", h.handleFleetCancel)
+
+	// Runbooks
+	mux.HandleFunc("GET /runbooks", h.handleListRunbooks)
+	mux.HandleFunc("POST /runbooks", h.handleSaveRunbook)
+	mux.HandleFunc("GET /runbooks/{name}", h.handleGetRunbook)
+	mux.HandleFunc("DELETE /runbooks/{name}", h.handleDeleteRunbook)
+	mux.HandleFunc("POST /runbooks/{name}/run", h.handleRunRunbook)
+	mux.HandleFunc("GET /runbook-runs", h.handleRunbookRuns)
+	mux.HandleFunc("GET /runbook-runs/{id}", h.handleRunbookRun)
+	mux.HandleFunc("GET /runbook-runs/{id}/stream", h.handleRunbookStream)
+	mux.HandleFunc("POST /runbook-runs/{id}/resume", h.handleRunbookResume)
+	mux.HandleFunc("POST /runbook-runs/{id}/cancel", h.handleRunbookCancel)
+
 	// AWS accounts management
 	mux.HandleFunc("GET /aws-accounts", h.handleListAWSAccounts)
 	mux.HandleFunc("POST /aws-accounts", h.handleAddAWSAccount)
 	mux.HandleFunc("DELETE /aws-accounts/", h.handleDeleteAWSAccount)
 	mux.HandleFunc("POST /aws-accounts/scan/", h.handleScanAWSAccount)
 
+	// Shared-config AWS profiles and IAM Identity Center login
+	mux.HandleFunc("GET /aws-profiles", h.handleListAWSProfiles)
+	mux.HandleFunc("POST /aws-profiles/sso-login", h.handleStartSSOLogin)
+	mux.HandleFunc("GET /aws-profiles/sso-login/{id}", h.handleSSOLoginStatus)
+	mux.HandleFunc("GET /aws-org-accounts", h.handleListOrgAccounts)
+
 	// API — audit & metrics
 	mux.HandleFunc("GET /audit-log", h.handleAuditLog)
 	mux.HandleFunc("GET /instance-metrics", h.handleInstanceMetrics)
@@ -168,6 +249,9 @@ mux.HandleFunc("POST /express-upload", h.handleExpressUpload)
 	mux.HandleFunc("POST /vault/credentials", h.handleVaultSave)
 	mux.HandleFunc("DELETE /vault/credentials", h.handleVaultDelete)
 	mux.HandleFunc("GET /vault/match", h.handleVaultMatch)
+	mux.HandleFunc("DELETE /vault/host-keys", h.handleForgetHostKey)
+	mux.HandleFunc("GET /encryption", h.handleEncryptionStatus)
+	mux.HandleFunc("POST /encryption/rotate-key", h.handleRotateKey)
 	mux.HandleFunc("GET /db-viewer", h.handleDBViewer)
 	mux.HandleFunc("DELETE /db-viewer", h.handleDBViewerDelete)
 	mux.HandleFunc("PUT /db-viewer", h.handleDBViewerUpdate)
@@ -232,7 +316,7 @@ mux.HandleFunc("POST /express-upload", h.handleExpressUpload)
 
 	mux.HandleFunc("GET /", h.serveSPA)
 
-	return mux
+	return h.requireAuth(mux)
 }
 
 // ---------------------------------------------------------------------------
@@ -291,7 +375,7 @@ func (h *Handler) handleInstances(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	jsonResponse(w, data)
+	jsonResponse(w, h.filterInstanceTree(r, data))
 }
 
 func (h *Handler) handleFleetStats(w http.ResponseWriter, r *http.Request) {
@@ -347,6 +431,12 @@ func (h *Handler) handleStartGuacamoleRDP(w http.ResponseWriter, r *http.Request
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionRDP, req.InstanceID) {
+		return
+	}
+	if req.VaultEntryID != "" && !h.authorize(w, r, rbac.ActionVaultRead, req.InstanceID) {
+		return
+	}
 
 	// Ask SSM forwarder to start port forwarding
 	fwdReq := types.ForwarderStartRequest{
@@ -357,13 +447,15 @@ func (h *Handler) handleStartGuacamoleRDP(w http.ResponseWriter, r *http.Request
 		PortNumber:   3389,
 	}
 	// Resolve credentials for manual accounts.
-	if strings.HasPrefix(req.AWSProfile, "manual:") {
-		acctID := strings.TrimPrefix(req.AWSProfile, "manual:")
-		if acct, ok := h.accounts.Get(acctID); ok {
-			fwdReq.AWSAccessKeyID = acct.AccessKeyID
-			fwdReq.AWSSecretAccessKey = acct.SecretAccessKey
-			fwdReq.AWSSessionToken = acct.SessionToken
-		}
+	creds, err := h.manualCreds(r.Context(), req.AWSProfile)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if creds != nil {
+		fwdReq.AWSAccessKeyID = creds.AccessKeyID
+		fwdReq.AWSSecretAccessKey = creds.SecretAccessKey
+		fwdReq.AWSSessionToken = creds.SessionToken
 	}
 	body, err := json.Marshal(fwdReq)
 	if err != nil {
@@ -444,6 +536,20 @@ func (h *Handler) handleStartGuacamoleRDP(w http.ResponseWriter, r *http.Request
 		connParams.RecordingName = session.RecordingFilename(req.InstanceID, req.InstanceName, "guac")
 		connParams.CreateRecordingPath = "true"
 		recording = true
+		manifest := recordings.Manifest{
+			InstanceID:   req.InstanceID,
+			InstanceName: req.InstanceName,
+			Profile:      req.AWSProfile,
+			Region:       req.AWSRegion,
+			User:         auth.FromContext(r.Context()).DisplayName(),
+			Started:      time.Now().UTC().Format(time.RFC3339),
+		}
+		if inst := h.findInstance(req.InstanceID); inst != nil {
+			manifest.AccountID = inst.AccountID
+		}
+		if err := h.recordings.Begin(connParams.RecordingName, manifest); err != nil {
+			h.logger.Printf("recording manifest for %s: %v", connParams.RecordingName, err)
+		}
 	}
 	token, err := guacamole.GenerateToken(h.cfg.GuacCryptSecret, connParams)
 	if err != nil {
@@ -458,6 +564,15 @@ func (h *Handler) handleStartGuacamoleRDP(w http.ResponseWriter, r *http.Request
 		url.QueryEscape(h.cfg.GuacWSURL),
 	)
 
+	h.logAudit(r, audit.AuditEvent{
+		Action:       "rdp_start",
+		InstanceID:   req.InstanceID,
+		InstanceName: req.InstanceName,
+		Profile:      req.AWSProfile,
+		Region:       req.AWSRegion,
+		Details:      fmt.Sprintf("port=%d recording=%t", fwdResp.Port, recording),
+	})
+
 	jsonResponse(w, types.GuacamoleTokenResponse{
 		Token:        token,
 		URL:          rdpClientURL,
@@ -476,6 +591,9 @@ func (h *Handler) handleStopGuacamoleRDP(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionRDP, req.InstanceID) {
+		return
+	}
 
 	body, _ := json.Marshal(req)
 	fwdURL := fmt.Sprintf("%s/stop", h.forwarderURL())
@@ -491,6 +609,8 @@ func (h *Handler) handleStopGuacamoleRDP(w http.ResponseWriter, r *http.Request)
 	io.Copy(w, resp.Body)
 }
 
+// handleGuacamoleSessions lists the forwarder's RDP sessions on instances
+// the caller may use RDP on.
 func (h *Handler) handleGuacamoleSessions(w http.ResponseWriter, r *http.Request) {
 	fwdURL := fmt.Sprintf("%s/sessions", h.forwarderURL())
 	resp, err := http.Get(fwdURL)
@@ -500,9 +620,28 @@ func (h *Handler) handleGuacamoleSessions(w http.ResponseWriter, r *http.Request
 	}
 	defer resp.Body.Close()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	var sessions []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		jsonError(w, "invalid response from SSM forwarder", http.StatusBadGateway)
+		return
+	}
+	id := auth.FromContext(r.Context())
+	visible := make([]json.RawMessage, 0, len(sessions))
+	for _, raw := range sessions {
+		var sess struct {
+			InstanceID string `json:"instance_id"`
+		}
+		if json.Unmarshal(raw, &sess) == nil && h.rbac.Allowed(id, rbac.ActionRDP, h.instanceResource(sess.InstanceID)) {
+			visible = append(visible, raw)
+		}
+	}
+	jsonResponse(w, visible)
 }
 
 func (h *Handler) handleGuacWebSocketProxy(w http.ResponseWriter, r *http.Request) {
@@ -523,7 +662,7 @@ func (h *Handler) handleGuacWebSocketProxy(w http.ResponseWriter, r *http.Reques
 	defer backendConn.Close()
 
 	upgrader := websocket.Upgrader{
-		CheckOrigin:  func(r *http.Request) bool { return true },
+		CheckOrigin:  h.auth.CheckOrigin,
 		Subprotocols: []string{"guacamole"},
 	}
 	clientConn, err := upgrader.Upgrade(w, r, nil)
@@ -693,6 +832,9 @@ func (h *Handler) handleUploadFile(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "instance_id and remote_path are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionFileUpload, instanceID) {
+		return
+	}
 
 	file, fileHeader, err := r.FormFile("file")
 	if err != nil {
@@ -750,17 +892,31 @@ func (h *Handler) handleUploadFile(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[handleUploadFile] starting upload: instance=%s profile=%q region=%q platform=%q path=%q size=%d",
 		instanceID, profile, region, platform, remotePath, len(data))
 
-	if err := h.discovery.UploadFile(profile, region, instanceID, remotePath, platform, data, sendProgress); err != nil {
+	sshUser := r.FormValue("ssh_user")
+	if sshUser != "" {
+		err = h.discovery.UploadFileSFTP(profile, region, instanceID, sshUser, remotePath, data, sendProgress)
+	} else {
+		err = h.discovery.UploadFile(profile, region, instanceID, remotePath, platform, data, sendProgress)
+	}
+	if err != nil {
+		h.logAudit(r, audit.AuditEvent{
+			Action:     "file_upload",
+			Outcome:    audit.OutcomeFailure,
+			InstanceID: instanceID,
+			Profile:    profile,
+			Region:     region,
+			Details:    fmt.Sprintf("path=%s%s error=%s", remotePath, sftpDetail(sshUser), err),
+		})
 		sendProgress(aws.TransferProgress{Progress: 100, Message: err.Error(), Status: "error", Error: err.Error(), Done: true})
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{
+	h.logAudit(r, audit.AuditEvent{
 		Action:     "file_upload",
 		InstanceID: instanceID,
 		Profile:    profile,
 		Region:     region,
-		Details:    fmt.Sprintf("path=%s", remotePath),
+		Details:    fmt.Sprintf("path=%s%s", remotePath, sftpDetail(sshUser)),
 	})
 
 	sendProgress(aws.TransferProgress{Progress: 100, Message: "Upload complete", Status: "complete", Done: true})
@@ -779,6 +935,9 @@ func (h *Handler) handleExpressUpload(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "instance_id, remote_path, and s3_bucket are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionFileExpressUpload, instanceID) {
+		return
+	}
 
 	file, fileHeader, err := r.FormFile("file")
 	if err != nil {
@@ -826,11 +985,19 @@ func (h *Handler) handleExpressUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.discovery.ExpressUpload(profile, region, bucket, instanceID, remotePath, platform, file, fileHeader.Size, sendProgress); err != nil {
+		h.logAudit(r, audit.AuditEvent{
+			Action:     "express_upload",
+			Outcome:    audit.OutcomeFailure,
+			InstanceID: instanceID,
+			Profile:    profile,
+			Region:     region,
+			Details:    fmt.Sprintf("path=%s bucket=%s error=%s", remotePath, bucket, err),
+		})
 		sendProgress(aws.TransferProgress{Progress: 100, Message: err.Error(), Status: "error", Error: err.Error(), Done: true})
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{
+	h.logAudit(r, audit.AuditEvent{
 		Action:     "express_upload",
 		InstanceID: instanceID,
 		Profile:    profile,
@@ -848,6 +1015,7 @@ func (h *Handler) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 		AWSProfile string `json:"aws_profile"`
 		AWSRegion  string `json:"aws_region"`
 		Platform   string `json:"platform"`
+		SSHUser    string `json:"ssh_user"` // download over SFTP as this user
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -858,6 +1026,9 @@ func (h *Handler) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "instance_id and remote_path are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionFileDownload, req.InstanceID) {
+		return
+	}
 
 	profile := req.AWSProfile
 	region := req.AWSRegion
@@ -890,18 +1061,33 @@ func (h *Handler) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 		flusher.Flush()
 	}
 
-	fileData, filename, err := h.discovery.DownloadFile(profile, region, req.InstanceID, req.RemotePath, platform, sendProgress)
+	var fileData []byte
+	var filename string
+	var err error
+	if req.SSHUser != "" {
+		fileData, filename, err = h.discovery.DownloadFileSFTP(profile, region, req.InstanceID, req.SSHUser, req.RemotePath, sendProgress)
+	} else {
+		fileData, filename, err = h.discovery.DownloadFile(profile, region, req.InstanceID, req.RemotePath, platform, sendProgress)
+	}
 	if err != nil {
+		h.logAudit(r, audit.AuditEvent{
+			Action:     "file_download",
+			Outcome:    audit.OutcomeFailure,
+			InstanceID: req.InstanceID,
+			Profile:    profile,
+			Region:     region,
+			Details:    fmt.Sprintf("path=%s%s error=%s", req.RemotePath, sftpDetail(req.SSHUser), err),
+		})
 		sendProgress(aws.TransferProgress{Progress: 100, Message: err.Error(), Status: "error", Error: err.Error(), Done: true})
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{
+	h.logAudit(r, audit.AuditEvent{
 		Action:     "file_download",
 		InstanceID: req.InstanceID,
 		Profile:    profile,
 		Region:     region,
-		Details:    fmt.Sprintf("path=%s", req.RemotePath),
+		Details:    fmt.Sprintf("path=%s%s", req.RemotePath, sftpDetail(req.SSHUser)),
 	})
 
 	// Send file data as base64 in the final NDJSON message.
@@ -944,6 +1130,9 @@ func (h *Handler) handleExpressDownload(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "instance_id, remote_path, and s3_bucket are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionFileExpressDownload, req.InstanceID) {
+		return
+	}
 
 	profile := req.AWSProfile
 	region := req.AWSRegion
@@ -978,11 +1167,19 @@ func (h *Handler) handleExpressDownload(w http.ResponseWriter, r *http.Request)
 
 	fileData, filename, err := h.discovery.ExpressDownload(profile, region, req.S3Bucket, req.InstanceID, req.RemotePath, platform, sendProgress)
 	if err != nil {
+		h.logAudit(r, audit.AuditEvent{
+			Action:     "express_download",
+			Outcome:    audit.OutcomeFailure,
+			InstanceID: req.InstanceID,
+			Profile:    profile,
+			Region:     region,
+			Details:    fmt.Sprintf("path=%s bucket=%s error=%s", req.RemotePath, req.S3Bucket, err),
+		})
 		sendProgress(aws.TransferProgress{Progress: 100, Message: err.Error(), Status: "error", Error: err.Error(), Done: true})
 		return
 	}
 
-	h.audit.Log(audit.AuditEvent{
+	h.logAudit(r, audit.AuditEvent{
 		Action:     "express_download",
 		InstanceID: req.InstanceID,
 		Profile:    profile,
@@ -1015,9 +1212,13 @@ func (h *Handler) handleExpressDownload(w http.ResponseWriter, r *http.Request)
 // Session Export
 // ---------------------------------------------------------------------------
 
+// handleExportSession writes a session's output to a file as plain text,
+// HTML, Markdown or JSON, from the replay buffer or the whole recording.
 func (h *Handler) handleExportSession(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		SessionID string `json:"session_id"`
+		Format    string `json:"format"`
+		Source    string `json:"source"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -1027,10 +1228,28 @@ func (h *Handler) handleExportSession(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "session_id is required", http.StatusBadRequest)
 		return
 	}
+	switch req.Format {
+	case "", session.FormatText, session.FormatHTML, session.FormatMarkdown, session.FormatJSON:
+	default:
+		jsonError(w, "format must be text, html, markdown or json", http.StatusBadRequest)
+		return
+	}
+	if req.Source != "" && req.Source != session.SourceBuffer && req.Source != session.SourceRecording {
+		jsonError(w, "source must be buffer or recording", http.StatusBadRequest)
+		return
+	}
+	if !h.sessions.CanView(req.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		jsonError(w, "session not found", http.StatusNotFound)
+		return
+	}
 
-	filename, err := h.sessions.ExportSession(req.SessionID, h.cfg.TerminalExportDir)
+	filename, err := h.sessions.ExportSession(req.SessionID, h.cfg.TerminalExportDir, session.ExportOptions{Format: req.Format, Source: req.Source})
 	if err != nil {
-		jsonError(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, session.ErrNoRecording) {
+			status = http.StatusConflict
+		}
+		jsonError(w, err.Error(), status)
 		return
 	}
 
@@ -1040,95 +1259,156 @@ func (h *Handler) handleExportSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleServeExport serves an export written by handleExportSession. Export
+// names start with the session ID; they are served to the session's viewers
+// while it runs and otherwise need recordings:view.
+func (h *Handler) handleServeExport(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.PathValue("name"))
+	i := strings.LastIndex(name, "_")
+	if i <= 0 || strings.HasPrefix(name, ".") {
+		jsonError(w, "export not found", http.StatusNotFound)
+		return
+	}
+	if !h.sessions.CanView(name[:i], auth.FromContext(r.Context()).DisplayName()) &&
+		!h.authorizeGlobal(w, r, rbac.ActionRecordingsView) {
+		return
+	}
+	path := filepath.Join(h.cfg.TerminalExportDir, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		jsonError(w, "export not found", http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
 // ---------------------------------------------------------------------------
 // Recordings handlers
 // ---------------------------------------------------------------------------
 
 func (h *Handler) handleListRecordings(w http.ResponseWriter, r *http.Request) {
-	dir := h.cfg.SessionRecordingDir
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		jsonResponse(w, []interface{}{})
+	if !h.authorizeGlobal(w, r, rbac.ActionRecordingsView) {
 		return
 	}
+	jsonResponse(w, h.recordings.List())
+}
 
-	type recording struct {
-		Name    string `json:"name"`
-		Size    int64  `json:"size"`
-		ModTime string `json:"mod_time"`
-		Type    string `json:"type"`    // "ssh" or "rdp"
-		HasMP4  bool   `json:"has_mp4"` // true if converted .mp4 exists
+// handleSearchRecordings runs a full-text query over indexed SSH
+// recordings. Each match carries the offset (seconds) the player can seek to.
+func (h *Handler) handleSearchRecordings(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRecordingsView) {
+		return
 	}
-
-	var recs []recording
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		name := e.Name()
-		ext := filepath.Ext(name)
-		var recType string
-		switch ext {
-		case ".cast":
-			recType = "ssh"
-		case ".guac":
-			recType = "rdp"
-		default:
-			continue
-		}
-		info, err := e.Info()
-		if err != nil {
-			continue
-		}
-		// Check if a converted .mp4 file exists.
-		base := strings.TrimSuffix(name, ext)
-		mp4Path := filepath.Join(dir, base+".mp4")
-		_, mp4Err := os.Stat(mp4Path)
-		recs = append(recs, recording{
-			Name:    name,
-			Size:    info.Size(),
-			ModTime: info.ModTime().Format("2006-01-02T15:04:05Z"),
-			Type:    recType,
-			HasMP4:  mp4Err == nil,
-		})
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind != "" && kind != recordings.KindCommand && kind != recordings.KindOutput {
+		jsonError(w, "kind must be command or output", http.StatusBadRequest)
+		return
 	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	results, err := h.recordings.Search(q.Get("q"), kind, limit)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResponse(w, results)
+}
 
-	// Sort newest first.
-	sort.Slice(recs, func(i, j int) bool {
-		return recs[i].ModTime > recs[j].ModTime
-	})
-
-	if recs == nil {
-		recs = []recording{}
+// handleVerifyRecording checks a recording against its signed manifest.
+func (h *Handler) handleVerifyRecording(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRecordingsView) {
+		return
+	}
+	name := filepath.Base(r.PathValue("name"))
+	m, err := h.recordings.Verify(r.Context(), name)
+	resp := map[string]interface{}{"name": name, "valid": err == nil, "manifest": m}
+	ev := audit.AuditEvent{Action: "recording_verify", Details: "file=" + name}
+	if m != nil {
+		ev.InstanceID = m.InstanceID
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+		ev.Outcome = audit.OutcomeFailure
+		ev.Details += " error=" + err.Error()
 	}
-	jsonResponse(w, recs)
+	h.logAudit(r, ev)
+	if m == nil && err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, recordings.ErrNotFound) || os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+	jsonResponse(w, resp)
 }
 
+// handleServeRecording serves the local copy of a recording, or streams it
+// from the offload bucket once the local copy has been pruned.
 func (h *Handler) handleServeRecording(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRecordingsView) {
+		return
+	}
 	filename := filepath.Base(r.URL.Path[len("/recordings/"):])
 	if filename == "" || filename == "." {
 		jsonError(w, "filename required", http.StatusBadRequest)
 		return
 	}
-	path := filepath.Join(h.cfg.SessionRecordingDir, filename)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if path, ok := h.recordings.LocalPath(filename); ok {
+		http.ServeFile(w, r, path)
+		return
+	}
+	obj, err := h.recordings.OpenRemote(r.Context(), filename, r.Header.Get("Range"))
+	if errors.Is(err, recordings.ErrNotFound) {
 		jsonError(w, "recording not found", http.StatusNotFound)
 		return
 	}
-	http.ServeFile(w, r, path)
+	if err != nil {
+		h.logger.Printf("recording %s: %v", filename, err)
+		jsonError(w, "failed to fetch recording", http.StatusBadGateway)
+		return
+	}
+	defer obj.Body.Close()
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if obj.ETag != "" {
+		w.Header().Set("ETag", obj.ETag)
+	}
+	if obj.LastModified != "" {
+		w.Header().Set("Last-Modified", obj.LastModified)
+	}
+	if obj.ContentRange != "" {
+		w.Header().Set("Content-Range", obj.ContentRange)
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.Copy(w, obj.Body)
 }
 
 func (h *Handler) handleDeleteRecording(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRecordingsDelete) {
+		return
+	}
 	filename := filepath.Base(r.URL.Path[len("/recordings/"):])
 	if filename == "" || filename == "." {
 		jsonError(w, "filename required", http.StatusBadRequest)
 		return
 	}
-	path := filepath.Join(h.cfg.SessionRecordingDir, filename)
-	if err := os.Remove(path); err != nil {
-		jsonError(w, err.Error(), http.StatusInternalServerError)
+	if err := h.recordings.Delete(r.Context(), filename); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "recording_delete", Outcome: audit.OutcomeFailure, Details: fmt.Sprintf("file=%s error=%s", filename, err)})
+		status := http.StatusInternalServerError
+		if errors.Is(err, recordings.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, recordings.ErrInvalidName) {
+			status = http.StatusBadRequest
+		}
+		jsonError(w, err.Error(), status)
 		return
 	}
+	h.logAudit(r, audit.AuditEvent{Action: "recording_delete", Details: "file=" + filename})
 	jsonResponse(w, map[string]string{"status": "deleted"})
 }
 
@@ -1221,57 +1501,107 @@ func (h *Handler) handleListAWSAccounts(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *Handler) handleAddAWSAccount(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionAccountsManage) {
+		return
+	}
 	var req struct {
 		Name            string `json:"name"`
 		AccessKeyID     string `json:"access_key_id"`
 		SecretAccessKey string `json:"secret_access_key"`
 		SessionToken    string `json:"session_token"`
+		RoleARN         string `json:"role_arn"`
+		ExternalID      string `json:"external_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if req.AccessKeyID == "" || req.SecretAccessKey == "" {
-		jsonError(w, "access_key_id and secret_access_key are required", http.StatusBadRequest)
-		return
-	}
 	if req.Name == "" {
-		req.Name = "Account " + req.AccessKeyID[:4] + "..."
+		switch {
+		case req.RoleARN != "":
+			req.Name = req.RoleARN[strings.LastIndex(req.RoleARN, "/")+1:]
+		case len(req.AccessKeyID) >= 4:
+			req.Name = "Account " + req.AccessKeyID[:4] + "..."
+		}
 	}
 
-	acct, err := h.accounts.Add(req.Name, req.AccessKeyID, req.SecretAccessKey, req.SessionToken)
+	acct, err := h.accounts.Add(aws.ManualAccount{
+		Name:            req.Name,
+		AccessKeyID:     req.AccessKeyID,
+		SecretAccessKey: req.SecretAccessKey,
+		SessionToken:    req.SessionToken,
+		RoleARN:         req.RoleARN,
+		ExternalID:      req.ExternalID,
+	})
 	if err != nil {
-		jsonError(w, err.Error(), http.StatusInternalServerError)
+		h.logAudit(r, audit.AuditEvent{Action: "account_add", Outcome: audit.OutcomeFailure, Details: fmt.Sprintf("name=%s error=%s", req.Name, err)})
+		status := http.StatusInternalServerError
+		if errors.Is(err, aws.ErrInvalidAccount) {
+			status = http.StatusBadRequest
+		}
+		if errors.Is(err, aws.ErrNoKey) {
+			jsonError(w, "add failed: set SUGGEST_ENCRYPTION_KEY or ENCRYPTION_KMS_KEY_ID to store account secrets", http.StatusServiceUnavailable)
+			return
+		}
+		jsonError(w, err.Error(), status)
 		return
 	}
+	details := "name=" + acct.Name
+	if acct.RoleARN != "" {
+		details += " role=" + acct.RoleARN
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "account_add", Profile: "manual:" + acct.ID, Details: details})
+	jsonResponse(w, acct.Masked())
+}
 
-	// Return masked version
-	masked := acct
-	if len(masked.SecretAccessKey) > 4 {
-		masked.SecretAccessKey = "****" + masked.SecretAccessKey[len(masked.SecretAccessKey)-4:]
+// manualCreds resolves the credentials of a "manual:<id>" profile, assuming
+// the account's role when it has one, or of an "org:<id>" profile through
+// the Organizations member role. It returns nil for shared-config profiles.
+func (h *Handler) manualCreds(ctx context.Context, profile string) (*session.AWSCreds, error) {
+	var creds awssdk.Credentials
+	var err error
+	switch {
+	case strings.HasPrefix(profile, "manual:"):
+		creds, err = h.accounts.Retrieve(ctx, strings.TrimPrefix(profile, "manual:"))
+	case strings.HasPrefix(profile, aws.OrgProfilePrefix):
+		creds, err = h.discovery.OrgCredentials(ctx, profile)
+	default:
+		return nil, nil
 	}
-	if masked.SessionToken != "" {
-		masked.SessionToken = "****"
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", profile, err)
 	}
-	jsonResponse(w, masked)
+	return &session.AWSCreds{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, nil
 }
 
 func (h *Handler) handleDeleteAWSAccount(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionAccountsManage) {
+		return
+	}
 	id := filepath.Base(r.URL.Path)
 	if id == "" {
 		jsonError(w, "account id required", http.StatusBadRequest)
 		return
 	}
 	if err := h.accounts.Remove(id); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "account_delete", Outcome: audit.OutcomeFailure, Profile: "manual:" + id, Details: err.Error()})
 		jsonError(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	h.logAudit(r, audit.AuditEvent{Action: "account_delete", Profile: "manual:" + id})
 	// Also remove this account's instances from the discovery cache.
 	h.discovery.RemoveAccountInstances(id)
 	jsonResponse(w, map[string]string{"status": "deleted"})
 }
 
 func (h *Handler) handleScanAWSAccount(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionAccountsManage) {
+		return
+	}
 	id := filepath.Base(r.URL.Path)
 	if id == "" {
 		jsonError(w, "account id required", http.StatusBadRequest)
@@ -1315,6 +1645,9 @@ func (h *Handler) handleStartPortForward(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "instance_id and port_number are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionPortForward, req.InstanceID) {
+		return
+	}
 
 	// Look up profile/region if not provided.
 	if req.AWSProfile == "" || req.AWSRegion == "" {
@@ -1332,27 +1665,49 @@ func (h *Handler) handleStartPortForward(w http.ResponseWriter, r *http.Request)
 		PortNumber:   req.PortNumber,
 	}
 	// Resolve credentials for manual accounts.
-	if strings.HasPrefix(req.AWSProfile, "manual:") {
-		acctID := strings.TrimPrefix(req.AWSProfile, "manual:")
-		if acct, ok := h.accounts.Get(acctID); ok {
-			fwdReq.AWSAccessKeyID = acct.AccessKeyID
-			fwdReq.AWSSecretAccessKey = acct.SecretAccessKey
-			fwdReq.AWSSessionToken = acct.SessionToken
-		}
+	creds, err := h.manualCreds(r.Context(), req.AWSProfile)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if creds != nil {
+		fwdReq.AWSAccessKeyID = creds.AccessKeyID
+		fwdReq.AWSSecretAccessKey = creds.SecretAccessKey
+		fwdReq.AWSSessionToken = creds.SessionToken
 	}
 	body, _ := json.Marshal(fwdReq)
 
 	fwdURL := fmt.Sprintf("%s/start", h.forwarderURL())
 	resp, err := http.Post(fwdURL, "application/json", bytes.NewReader(body))
+	ev := audit.AuditEvent{
+		Action:        "port_forward_start",
+		CorrelationID: fmt.Sprintf("%s:%d", req.InstanceID, req.PortNumber),
+		InstanceID:    req.InstanceID,
+		InstanceName:  req.InstanceName,
+		Profile:       req.AWSProfile,
+		Region:        req.AWSRegion,
+		Details:       fmt.Sprintf("port=%d", req.PortNumber),
+	}
 	if err != nil {
+		ev.Outcome = audit.OutcomeFailure
+		h.logAudit(r, ev)
 		jsonError(w, fmt.Sprintf("failed to contact SSM forwarder: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	ev.Outcome = outcomeForStatus(resp.StatusCode)
+	h.logAudit(r, ev)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	data, _ := io.ReadAll(resp.Body)
+	var fwdResp types.ForwarderStartResponse
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(data, &fwdResp) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		w.Write(data)
+		return
+	}
+	fwdResp.DBLink, fwdResp.DBLabel = h.vaultDBLink(r, req.InstanceID, req.PortNumber, fwdResp.Port)
+	jsonResponse(w, fwdResp)
 }
 
 func (h *Handler) handleStopPortForward(w http.ResponseWriter, r *http.Request) {
@@ -1364,15 +1719,28 @@ func (h *Handler) handleStopPortForward(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionPortForward, req.InstanceID) {
+		return
+	}
 
 	body, _ := json.Marshal(req)
 	fwdURL := fmt.Sprintf("%s/stop", h.forwarderURL())
 	resp, err := http.Post(fwdURL, "application/json", bytes.NewReader(body))
+	ev := audit.AuditEvent{
+		Action:        "port_forward_stop",
+		CorrelationID: fmt.Sprintf("%s:%d", req.InstanceID, req.PortNumber),
+		InstanceID:    req.InstanceID,
+		Details:       fmt.Sprintf("port=%d", req.PortNumber),
+	}
 	if err != nil {
+		ev.Outcome = audit.OutcomeFailure
+		h.logAudit(r, ev)
 		jsonError(w, fmt.Sprintf("failed to contact SSM forwarder: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	ev.Outcome = outcomeForStatus(resp.StatusCode)
+	h.logAudit(r, ev)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(resp.StatusCode)
@@ -1417,10 +1785,30 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		h.clientsMu.Lock()
 		sessionIDs := h.clients[conn]
+		watched := h.watching[conn]
 		delete(h.clients, conn)
+		delete(h.watching, conn)
 		h.clientsMu.Unlock()
 
-		h.sessions.CloseSessionsForClient(sessionIDs)
+		for _, id := range watched {
+			h.sessions.Unwatch(id, watcherID(conn))
+		}
+
+		if h.cfg.SessionDetachGraceMinutes != 0 {
+			// Leave the shells running so the user can reattach later.
+			for _, id := range sessionIDs {
+				if err := h.sessions.Detach(id); err != nil {
+					h.logger.Printf("detach session %s: %v", id, err)
+					continue
+				}
+				h.logAudit(r, audit.AuditEvent{Action: "session_detach", CorrelationID: id, Details: "reason=disconnect"})
+			}
+		} else {
+			h.sessions.CloseSessionsForClient(sessionIDs)
+			for _, id := range sessionIDs {
+				h.logAudit(r, audit.AuditEvent{Action: "session_end", CorrelationID: id, Details: "reason=disconnect"})
+			}
+		}
 		conn.Close()
 	}()
 
@@ -1452,6 +1840,9 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	h.wsSendDetachedSessions(r, conn, &writeMu)
+	groups := broadcastGroups{}
+
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
@@ -1472,19 +1863,46 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		switch msg.Type {
 		case "start_session":
-			h.wsStartSession(conn, &writeMu, msg.Payload)
+			h.wsStartSession(r, conn, &writeMu, msg.Payload)
+
+		case "attach_session":
+			h.wsAttachSession(r, conn, &writeMu, msg.Payload)
+
+		case "watch_session":
+			h.wsWatchSession(r, conn, &writeMu, msg.Payload)
+
+		case "unwatch_session":
+			h.wsUnwatchSession(r, conn, msg.Payload)
+
+		case "request_control", "grant_control", "release_control":
+			h.wsControl(r, conn, &writeMu, msg.Type, msg.Payload)
 
 		case "terminal_input":
-			h.wsTerminalInput(msg.Payload)
+			h.wsTerminalInput(r, conn, &writeMu, msg.Payload)
+
+		case "broadcast_group":
+			h.wsBroadcastGroup(conn, &writeMu, groups, msg.Payload)
+
+		case "broadcast_exclude":
+			h.wsBroadcastExclude(conn, &writeMu, groups, msg.Payload)
+
+		case "broadcast_input":
+			h.wsBroadcastInput(r, conn, &writeMu, groups, msg.Payload)
+
+		case "guard_confirm":
+			h.wsGuardConfirm(r, conn, &writeMu, msg.Payload)
 
 		case "terminal_resize":
-			h.wsTerminalResize(msg.Payload)
+			h.wsTerminalResize(r, msg.Payload)
 
 		case "terminal_interrupt":
-			h.wsTerminalInterrupt(msg.Payload)
+			h.wsTerminalInterrupt(r, msg.Payload)
+
+		case "extend_session":
+			h.wsExtendSession(r, conn, &writeMu, msg.Payload)
 
 		case "close_session":
-			h.wsCloseSession(conn, msg.Payload)
+			h.wsCloseSession(r, conn, msg.Payload)
 
 		case "keepalive":
 
@@ -1501,7 +1919,7 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 }
 
 // wsStartSession launches an SSM session and wires output back to the WebSocket.
-func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
+func (h *Handler) wsStartSession(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
 	raw, err := json.Marshal(payload)
 	if err != nil {
 		h.logger.Printf("wsStartSession marshal payload: %v", err)
@@ -1515,6 +1933,7 @@ func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payl
 		AWSRegion    string `json:"aws_region"`
 		Cols         uint16 `json:"cols"`
 		Rows         uint16 `json:"rows"`
+		SSHUser      string `json:"ssh_user"` // set for SSH over SSM instead of a Session Manager shell
 	}
 	if err := json.Unmarshal(raw, &msg); err != nil {
 		h.logger.Printf("wsStartSession unmarshal: %v", err)
@@ -1527,53 +1946,58 @@ func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payl
 	awsProfile := msg.AWSProfile
 	awsRegion := msg.AWSRegion
 
+	action := rbac.ActionTerminal
+	if msg.SSHUser != "" {
+		action = rbac.ActionTerminalSSH
+	}
+	if !h.allowed(r, action, h.instanceResource(instanceID)) {
+		writeMu.Lock()
+		conn.WriteJSON(types.WSMessage{
+			Type: "session_error",
+			Payload: types.SessionEventMsg{
+				InstanceID: instanceID,
+				SessionID:  sessionID,
+				Error:      "permission denied: " + action,
+			},
+		})
+		writeMu.Unlock()
+		return
+	}
+
+	// Reconnecting to a running session is only allowed for its owner.
+	if owner, ok := h.sessions.Owner(sessionID); ok && owner != auth.FromContext(r.Context()).DisplayName() {
+		writeMu.Lock()
+		conn.WriteJSON(types.WSMessage{
+			Type: "session_error",
+			Payload: types.SessionEventMsg{
+				InstanceID: instanceID,
+				SessionID:  sessionID,
+				Error:      "session belongs to another user",
+			},
+		})
+		writeMu.Unlock()
+		return
+	}
+
 	// If the client didn't send profile/region, look them up from cached instance data.
 	if awsProfile == "" || awsRegion == "" {
-		if p, r, err := h.discovery.GetInstanceConfig(instanceID); err == nil {
+		if p, rg, err := h.discovery.GetInstanceConfig(instanceID); err == nil {
 			awsProfile = p
-			awsRegion = r
+			awsRegion = rg
 		} else {
 			h.logger.Printf("wsStartSession: instance config lookup failed for %s: %v", instanceID, err)
 		}
 	}
 
 	// Resolve credentials for manual accounts (profile = "manual:<id>").
-	var creds *session.AWSCreds
-	if strings.HasPrefix(awsProfile, "manual:") {
-		acctID := strings.TrimPrefix(awsProfile, "manual:")
-		if acct, ok := h.accounts.Get(acctID); ok {
-			creds = &session.AWSCreds{
-				AccessKeyID:     acct.AccessKeyID,
-				SecretAccessKey: acct.SecretAccessKey,
-				SessionToken:    acct.SessionToken,
-			}
-			h.logger.Printf("Using manual credentials for account %s (%s)", acctID, acct.Name)
-		} else {
-			h.logger.Printf("wsStartSession: manual account %s not found", acctID)
-		}
+	creds, err := h.manualCreds(context.Background(), awsProfile)
+	if err != nil {
+		h.logger.Printf("wsStartSession: %v", err)
+	} else if creds != nil {
+		h.logger.Printf("Using manual credentials for %s", awsProfile)
 	}
 
-	onOutput := func(data []byte) {
-		h.obsMu.Lock()
-		obs := h.observers[sessionID]
-		h.obsMu.Unlock()
-		if obs != nil {
-			obs.FeedOutput(data)
-		}
-		writeMu.Lock()
-		defer writeMu.Unlock()
-		outMsg := types.WSMessage{
-			Type: "terminal_output",
-			Payload: types.TerminalOutputMsg{
-				InstanceID: instanceID,
-				SessionID:  sessionID,
-				Output:     string(data),
-			},
-		}
-		if err := conn.WriteJSON(outMsg); err != nil {
-			h.logger.Printf("ws write output for session %s: %v", sessionID, err)
-		}
-	}
+	onOutput := h.terminalOutput(conn, writeMu, instanceID, sessionID)
 
 	cols, rows := msg.Cols, msg.Rows
 	if cols == 0 {
@@ -1582,8 +2006,25 @@ func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payl
 	if rows == 0 {
 		rows = 50
 	}
-	if err := h.sessions.StartSession(instanceID, instanceName, sessionID, awsProfile, awsRegion, creds, cols, rows, onOutput); err != nil {
+	var details string
+	if msg.SSHUser != "" {
+		err = h.sessions.StartSSHSession(instanceID, instanceName, sessionID, awsProfile, awsRegion, msg.SSHUser, cols, rows, onOutput)
+		details = "ssh_user=" + msg.SSHUser
+	} else {
+		err = h.sessions.StartSession(instanceID, instanceName, sessionID, awsProfile, awsRegion, creds, cols, rows, onOutput)
+	}
+	if err != nil {
 		h.logger.Printf("start session %s: %v", sessionID, err)
+		h.logAudit(r, audit.AuditEvent{
+			Action:        "session_start",
+			Outcome:       audit.OutcomeFailure,
+			CorrelationID: sessionID,
+			InstanceID:    instanceID,
+			InstanceName:  instanceName,
+			Profile:       awsProfile,
+			Region:        awsRegion,
+			Details:       strings.TrimSpace(details + " " + err.Error()),
+		})
 		writeMu.Lock()
 		conn.WriteJSON(types.WSMessage{
 			Type: "session_error",
@@ -1596,60 +2037,25 @@ func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payl
 		writeMu.Unlock()
 		return
 	}
+	h.sessions.SetSessionOwner(sessionID, auth.FromContext(r.Context()).DisplayName())
+	h.sessions.SetEventHandler(sessionID, h.collabEvents(conn, writeMu))
+	h.catalogStart(r, sessionID, instanceID, instanceName, awsProfile, awsRegion, msg.SSHUser)
 
-	if h.suggest != nil {
-		var obs *suggest.Observer
-		obs = suggest.NewObserver(
-			func(cmd, output string) {
-				if cmd == "" {
-					return
-				}
-				exitCode := 0
-				if suggest.ContainsErrorSignal(output) {
-					exitCode = 1
-				}
-				h.suggest.LearnCommand("", cmd, exitCode, "")
-
-				errOut, resCMD, resolved := obs.WasErrorResolved()
-				if resolved && errOut != "" && resCMD != "" {
-					h.suggest.LearnResolution(errOut, resCMD)
-				}
-			},
-			func(output string) {
-				insights := h.suggest.AnalyzeOutput("", output)
-				for _, insight := range insights {
-					writeMu.Lock()
-					conn.WriteJSON(types.WSMessage{
-						Type: "log_insight",
-						Payload: types.LogInsightMsg{
-							SessionID:    sessionID,
-							ErrorSummary: insight.ErrorSummary,
-							SuggestedFix: insight.SuggestedFix,
-							Confidence:   insight.Confidence,
-						},
-					})
-					writeMu.Unlock()
-				}
-			},
-		)
-		h.obsMu.Lock()
-		h.observers[sessionID] = obs
-		h.obsMu.Unlock()
-	}
+	h.startObserver(conn, writeMu, sessionID)
 
 	// Audit log the session start.
-	h.audit.Log(audit.AuditEvent{
-		Action:     "session_start",
-		InstanceID: instanceID,
-		Profile:    awsProfile,
-		Region:     awsRegion,
-		Details:    fmt.Sprintf("session_id=%s", sessionID),
+	h.logAudit(r, audit.AuditEvent{
+		Action:        "session_start",
+		CorrelationID: sessionID,
+		InstanceID:    instanceID,
+		InstanceName:  instanceName,
+		Profile:       awsProfile,
+		Region:        awsRegion,
+		Details:       details,
 	})
 
 	// Track this session against the connection for cleanup.
-	h.clientsMu.Lock()
-	h.clients[conn] = append(h.clients[conn], sessionID)
-	h.clientsMu.Unlock()
+	h.trackSession(conn, sessionID)
 
 	// Check if recording was auto-started.
 	isRecording := false
@@ -1657,19 +2063,136 @@ func (h *Handler) wsStartSession(conn *websocket.Conn, writeMu *sync.Mutex, payl
 		isRecording = sess.IsRecording()
 	}
 
+	started := types.SessionEventMsg{
+		InstanceID: instanceID,
+		SessionID:  sessionID,
+		Recording:  isRecording,
+	}
+	h.setExpiry(&started)
 	writeMu.Lock()
-	conn.WriteJSON(types.WSMessage{
-		Type: "session_started",
-		Payload: types.SessionEventMsg{
+	conn.WriteJSON(types.WSMessage{Type: "session_started", Payload: started})
+	writeMu.Unlock()
+}
+
+// terminalOutput returns the session output callback that forwards data to
+// a WebSocket client.
+func (h *Handler) terminalOutput(conn *websocket.Conn, writeMu *sync.Mutex, instanceID, sessionID string) func([]byte) {
+	return func(data []byte) {
+		h.obsMu.Lock()
+		obs := h.observers[sessionID]
+		h.obsMu.Unlock()
+		if obs != nil {
+			obs.FeedOutput(data)
+		}
+		if f := h.guardFilter(sessionID); f != nil {
+			f.FeedOutput(data)
+		}
+		h.sendOutput(conn, writeMu, instanceID, sessionID, data)
+	}
+}
+
+// sendOutput writes a terminal_output message to a WebSocket client.
+func (h *Handler) sendOutput(conn *websocket.Conn, writeMu *sync.Mutex, instanceID, sessionID string, data []byte) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	outMsg := types.WSMessage{
+		Type: "terminal_output",
+		Payload: types.TerminalOutputMsg{
 			InstanceID: instanceID,
 			SessionID:  sessionID,
-			Recording:  isRecording,
+			Output:     string(data),
 		},
-	})
-	writeMu.Unlock()
+	}
+	if err := conn.WriteJSON(outMsg); err != nil {
+		h.logger.Printf("ws write output for session %s: %v", sessionID, err)
+	}
 }
 
-func (h *Handler) wsTerminalInput(payload interface{}) {
+// startObserver attaches a command observer to a session, which records
+// commands in the session catalog and sends log insights to the given
+// client.
+func (h *Handler) startObserver(conn *websocket.Conn, writeMu *sync.Mutex, sessionID string) {
+	if h.suggest == nil && h.catalog == nil {
+		return
+	}
+	var obs *suggest.Observer
+	obs = suggest.NewObserver(
+		func(cmd, output string) {
+			if cmd == "" {
+				return
+			}
+			if h.catalog != nil {
+				if err := h.catalog.AddCommand(sessionID, cmd, time.Now()); err != nil {
+					h.logger.Printf("session catalog: command for %s: %v", sessionID, err)
+				}
+			}
+			if h.suggest == nil {
+				return
+			}
+			exitCode := 0
+			if suggest.ContainsErrorSignal(output) {
+				exitCode = 1
+			}
+			h.suggest.LearnCommand("", cmd, exitCode, "")
+
+			errOut, resCMD, resolved := obs.WasErrorResolved()
+			if resolved && errOut != "" && resCMD != "" {
+				h.suggest.LearnResolution(errOut, resCMD)
+			}
+		},
+		func(output string) {
+			if h.suggest == nil {
+				return
+			}
+			insights := h.suggest.AnalyzeOutput("", output)
+			for _, insight := range insights {
+				writeMu.Lock()
+				conn.WriteJSON(types.WSMessage{
+					Type: "log_insight",
+					Payload: types.LogInsightMsg{
+						SessionID:    sessionID,
+						ErrorSummary: insight.ErrorSummary,
+						SuggestedFix: insight.SuggestedFix,
+						Confidence:   insight.Confidence,
+					},
+				})
+				writeMu.Unlock()
+			}
+		},
+	)
+	h.obsMu.Lock()
+	if old := h.observers[sessionID]; old != nil {
+		old.Close()
+	}
+	h.observers[sessionID] = obs
+	h.obsMu.Unlock()
+}
+
+// trackSession records that conn owns sessionID so it is detached or closed
+// when conn drops; any other connection's claim on the session is released.
+func (h *Handler) trackSession(conn *websocket.Conn, sessionID string) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	for c, ids := range h.clients {
+		if c == conn {
+			continue
+		}
+		for i, id := range ids {
+			if id == sessionID {
+				h.clients[c] = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, id := range h.clients[conn] {
+		if id == sessionID {
+			return
+		}
+	}
+	h.clients[conn] = append(h.clients[conn], sessionID)
+}
+
+func (h *Handler) wsTerminalInput(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
 	raw, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -1678,18 +2201,25 @@ func (h *Handler) wsTerminalInput(payload interface{}) {
 	if err := json.Unmarshal(raw, &msg); err != nil {
 		return
 	}
+	if !h.sessions.CanWrite(msg.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		return
+	}
+	input := h.guardInput(r, conn, writeMu, msg.SessionID, []byte(msg.Input))
+	if len(input) == 0 {
+		return
+	}
 	h.obsMu.Lock()
 	obs := h.observers[msg.SessionID]
 	h.obsMu.Unlock()
 	if obs != nil {
-		obs.FeedInput([]byte(msg.Input))
+		obs.FeedInput(input)
 	}
-	if err := h.sessions.WriteInput(msg.SessionID, []byte(msg.Input)); err != nil {
+	if err := h.writeInput(r, conn, writeMu, msg.SessionID, input); err != nil {
 		h.logger.Printf("write input session %s: %v", msg.SessionID, err)
 	}
 }
 
-func (h *Handler) wsTerminalResize(payload interface{}) {
+func (h *Handler) wsTerminalResize(r *http.Request, payload interface{}) {
 	raw, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -1698,12 +2228,15 @@ func (h *Handler) wsTerminalResize(payload interface{}) {
 	if err := json.Unmarshal(raw, &msg); err != nil {
 		return
 	}
+	if !h.sessions.CanWrite(msg.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		return
+	}
 	if err := h.sessions.ResizeTerminal(msg.SessionID, msg.Rows, msg.Cols); err != nil {
 		h.logger.Printf("resize session %s: %v", msg.SessionID, err)
 	}
 }
 
-func (h *Handler) wsTerminalInterrupt(payload interface{}) {
+func (h *Handler) wsTerminalInterrupt(r *http.Request, payload interface{}) {
 	raw, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -1714,12 +2247,15 @@ func (h *Handler) wsTerminalInterrupt(payload interface{}) {
 	if err := json.Unmarshal(raw, &msg); err != nil {
 		return
 	}
+	if !h.sessions.CanWrite(msg.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		return
+	}
 	if err := h.sessions.SendInterrupt(msg.SessionID); err != nil {
 		h.logger.Printf("interrupt session %s: %v", msg.SessionID, err)
 	}
 }
 
-func (h *Handler) wsCloseSession(conn *websocket.Conn, payload interface{}) {
+func (h *Handler) wsCloseSession(r *http.Request, conn *websocket.Conn, payload interface{}) {
 	raw, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -1730,17 +2266,18 @@ func (h *Handler) wsCloseSession(conn *websocket.Conn, payload interface{}) {
 	if err := json.Unmarshal(raw, &msg); err != nil {
 		return
 	}
+	// Watchers share the session ID; only the owner may end the session.
+	if owner, ok := h.sessions.Owner(msg.SessionID); ok && owner != auth.FromContext(r.Context()).DisplayName() {
+		return
+	}
 
-	if err := h.sessions.CloseSession(msg.SessionID); err != nil {
+	if err := h.sessions.EndSession(msg.SessionID, session.EndUser); err != nil {
 		h.logger.Printf("close session %s: %v", msg.SessionID, err)
+	} else {
+		h.logAudit(r, audit.AuditEvent{Action: "session_end", CorrelationID: msg.SessionID})
 	}
 
-	h.obsMu.Lock()
-	if obs, ok := h.observers[msg.SessionID]; ok {
-		obs.Close()
-		delete(h.observers, msg.SessionID)
-	}
-	h.obsMu.Unlock()
+	h.forgetSession(msg.SessionID)
 
 	// Remove from the client's tracked sessions.
 	h.clientsMu.Lock()
@@ -1769,19 +2306,37 @@ func (h *Handler) findPlatform(instanceID string) string {
 }
 
 func (h *Handler) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionAuditView) {
+		return
+	}
+	q := r.URL.Query()
+	filter, err := parseAuditFilter(q)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	limit := 50
 	offset := 0
-	if v := r.URL.Query().Get("limit"); v != "" {
+	if v := q.Get("limit"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			limit = n
 		}
 	}
-	if v := r.URL.Query().Get("offset"); v != "" {
+	if v := q.Get("offset"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
 			offset = n
 		}
 	}
-	events := h.audit.Recent(limit, offset)
+
+	if format := q.Get("format"); format != "" {
+		if q.Get("limit") == "" {
+			limit = 0 // exports default to every matching event
+		}
+		h.exportAuditLog(w, r, filter, format, limit, offset)
+		return
+	}
+
+	events := h.audit.Query(filter, limit, offset)
 	if events == nil {
 		events = []audit.AuditEvent{}
 	}
@@ -1794,6 +2349,9 @@ func (h *Handler) handleInstanceMetrics(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "instance_id is required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionView, instanceID) {
+		return
+	}
 
 	profile, region, err := h.discovery.GetInstanceConfig(instanceID)
 	if err != nil {
@@ -1817,6 +2375,9 @@ func (h *Handler) handleInstanceDetails(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "id is required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionView, instanceID) {
+		return
+	}
 
 	details, err := h.discovery.GetInstanceDetails(r.Context(), instanceID)
 	if err != nil {
@@ -1833,6 +2394,7 @@ func (h *Handler) handleBrowseDirectory(w http.ResponseWriter, r *http.Request)
 		AWSProfile string `json:"aws_profile"`
 		AWSRegion  string `json:"aws_region"`
 		Platform   string `json:"platform"`
+		SSHUser    string `json:"ssh_user"` // browse over SFTP as this user
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -1842,6 +2404,9 @@ func (h *Handler) handleBrowseDirectory(w http.ResponseWriter, r *http.Request)
 		jsonError(w, "instance_id and path are required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorize(w, r, rbac.ActionFileBrowse, req.InstanceID) {
+		return
+	}
 
 	log.Printf("[handleBrowseDirectory] received: instanceID=%q path=%q profile=%q region=%q platform=%q",
 		req.InstanceID, req.Path, req.AWSProfile, req.AWSRegion, req.Platform)
@@ -1870,7 +2435,13 @@ func (h *Handler) handleBrowseDirectory(w http.ResponseWriter, r *http.Request)
 
 	log.Printf("[handleBrowseDirectory] resolved: profile=%q region=%q platform=%q", profile, region, platform)
 
-	entries, err := h.discovery.BrowseDirectory(profile, region, req.InstanceID, req.Path, platform)
+	var entries []aws.FileEntry
+	var err error
+	if req.SSHUser != "" {
+		entries, err = h.discovery.BrowseDirectorySFTP(profile, region, req.InstanceID, req.SSHUser, req.Path)
+	} else {
+		entries, err = h.discovery.BrowseDirectory(profile, region, req.InstanceID, req.Path, platform)
+	}
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1885,6 +2456,14 @@ func (h *Handler) handleBrowseDirectory(w http.ResponseWriter, r *http.Request)
 // Helpers
 // ---------------------------------------------------------------------------
 
+// sftpDetail notes an SFTP transfer's OS user in audit details.
+func sftpDetail(sshUser string) string {
+	if sshUser == "" {
+		return ""
+	}
+	return " sftp_user=" + sshUser
+}
+
 func jsonResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -2606,6 +3185,9 @@ func (h *Handler) handleSuggestStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleVaultList(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionVaultRead) {
+		return
+	}
 	if h.vault == nil {
 		jsonResponse(w, []vault.VaultEntry{})
 		return
@@ -2624,6 +3206,9 @@ func (h *Handler) handleVaultList(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleVaultSave(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionVaultWrite) {
+		return
+	}
 	if h.vault == nil {
 		jsonError(w, "vault not configured", http.StatusServiceUnavailable)
 		return
@@ -2636,6 +3221,9 @@ func (h *Handler) handleVaultSave(w http.ResponseWriter, r *http.Request) {
 	if entry.Rule.ID == "" {
 		entry.Rule.ID = fmt.Sprintf("v-%d", time.Now().UnixNano())
 	}
+	if entry.Kind == "" {
+		entry.Kind = vault.KindRDP
+	}
 	switch entry.Rule.Type {
 	case "instance":
 		entry.Rule.Priority = 1
@@ -2654,13 +3242,27 @@ func (h *Handler) handleVaultSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.vault.Save(entry); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "vault_save", Outcome: audit.OutcomeFailure, Details: fmt.Sprintf("id=%s error=%s", entry.Rule.ID, err)})
+		if errors.Is(err, vault.ErrInvalidEntry) {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, vault.ErrNoKey) {
+			jsonError(w, "save failed: set SUGGEST_ENCRYPTION_KEY or ENCRYPTION_KMS_KEY_ID to store credentials", http.StatusServiceUnavailable)
+			return
+		}
 		jsonError(w, "save failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	username, _ := entry.Field("username")
+	h.logAudit(r, audit.AuditEvent{Action: "vault_save", Details: fmt.Sprintf("id=%s kind=%s rule=%s:%s username=%s", entry.Rule.ID, entry.Kind, entry.Rule.Type, entry.Rule.Value, username)})
 	jsonResponse(w, map[string]string{"status": "ok", "id": entry.Rule.ID})
 }
 
 func (h *Handler) handleVaultDelete(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionVaultWrite) {
+		return
+	}
 	if h.vault == nil {
 		jsonError(w, "vault not configured", http.StatusServiceUnavailable)
 		return
@@ -2671,9 +3273,35 @@ func (h *Handler) handleVaultDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.vault.Delete(id); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "vault_delete", Outcome: audit.OutcomeFailure, Details: fmt.Sprintf("id=%s error=%s", id, err)})
 		jsonError(w, "delete failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.logAudit(r, audit.AuditEvent{Action: "vault_delete", Details: "id=" + id})
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// handleForgetHostKey removes an instance's pinned SSH host key, after it
+// was legitimately replaced, so the next SSH connection pins the new one.
+func (h *Handler) handleForgetHostKey(w http.ResponseWriter, r *http.Request) {
+	instanceID := r.URL.Query().Get("instance_id")
+	if instanceID == "" {
+		jsonError(w, "instance_id is required", http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, rbac.ActionVaultWrite, instanceID) {
+		return
+	}
+	if h.vault == nil {
+		jsonError(w, "vault not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.vault.ForgetHostKey(instanceID); err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "ssh_host_key_forget", InstanceID: instanceID, Outcome: audit.OutcomeFailure, Details: err.Error()})
+		jsonError(w, "forget failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "ssh_host_key_forget", InstanceID: instanceID})
 	jsonResponse(w, map[string]string{"status": "ok"})
 }
 
@@ -2686,14 +3314,21 @@ func (h *Handler) handleVaultMatch(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	env := r.URL.Query().Get("env")
 	account := r.URL.Query().Get("account")
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = vault.KindRDP
+	}
+	if !h.authorize(w, r, rbac.ActionVaultRead, instanceID) {
+		return
+	}
 
-	entry, err := h.vault.FindMatch(instanceID, name, env, account)
+	entry, err := h.vault.FindMatch(kind, instanceID, name, env, account)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		jsonResponse(w, map[string]string{"error": "no match"})
 		return
 	}
-	redacted := *entry
+	redacted := entry.Redacted()
 	redacted.Credential.Password = ""
 	jsonResponse(w, redacted)
 }
@@ -2704,6 +3339,9 @@ func (h *Handler) handleDBViewer(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, map[string][]string{"databases": {"suggest", "vault"}})
 		return
 	}
+	if dbName == "vault" && !h.authorizeGlobal(w, r, rbac.ActionVaultRead) {
+		return
+	}
 
 	if dbName == "suggest" {
 		if h.suggest == nil {
@@ -2724,6 +3362,9 @@ func (h *Handler) handleDBViewer(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleDBViewerDelete(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionSettingsManage) {
+		return
+	}
 	dbName := r.URL.Query().Get("db")
 	bucket := r.URL.Query().Get("bucket")
 	key := r.URL.Query().Get("key")
@@ -2745,6 +3386,7 @@ func (h *Handler) handleDBViewerDelete(w http.ResponseWriter, r *http.Request) {
 			jsonError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		h.logAudit(r, audit.AuditEvent{Action: "db_viewer_delete", Details: "db=suggest bucket=" + bucket})
 		jsonResponse(w, map[string]string{"status": "bucket_deleted", "bucket": bucket})
 		return
 	}
@@ -2752,10 +3394,14 @@ func (h *Handler) handleDBViewerDelete(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.logAudit(r, audit.AuditEvent{Action: "db_viewer_delete", Details: fmt.Sprintf("db=suggest bucket=%s key=%s", bucket, key)})
 	jsonResponse(w, map[string]string{"status": "key_deleted", "bucket": bucket, "key": key})
 }
 
 func (h *Handler) handleDBViewerUpdate(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionSettingsManage) {
+		return
+	}
 	dbName := r.URL.Query().Get("db")
 	if dbName != "suggest" {
 		jsonError(w, "update only supported for suggest.db", http.StatusBadRequest)
@@ -2782,5 +3428,6 @@ func (h *Handler) handleDBViewerUpdate(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.logAudit(r, audit.AuditEvent{Action: "db_viewer_update", Details: fmt.Sprintf("db=suggest bucket=%s key=%s", req.Bucket, req.Key)})
 	jsonResponse(w, map[string]string{"status": "updated", "bucket": req.Bucket, "key": req.Key})
 }
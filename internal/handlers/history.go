@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/catalog"
+	"cloudterm-go/internal/rbac"
+)
+
+// catalogStart adds a newly started terminal session to the session
+// catalog; reattaching to a running session leaves its record as is.
+func (h *Handler) catalogStart(r *http.Request, sessionID, instanceID, instanceName, profile, region, sshUser string) {
+	if h.catalog == nil {
+		return
+	}
+	rec := catalog.Record{
+		SessionID:    sessionID,
+		User:         auth.FromContext(r.Context()).DisplayName(),
+		InstanceID:   instanceID,
+		InstanceName: instanceName,
+		Profile:      profile,
+		Region:       region,
+		SSHUser:      sshUser,
+		Started:      time.Now(),
+	}
+	if inst := h.findInstance(instanceID); inst != nil {
+		rec.AccountID = inst.AccountID
+	}
+	if err := h.catalog.Start(rec); err != nil {
+		h.logger.Printf("session catalog: start %s: %v", sessionID, err)
+	}
+}
+
+// handleSessionHistory lists catalogued terminal sessions, newest first.
+// Callers without audit:view only see their own sessions.
+func (h *Handler) handleSessionHistory(w http.ResponseWriter, r *http.Request) {
+	if h.catalog == nil {
+		jsonError(w, "session catalog is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := auth.FromContext(r.Context())
+	if !h.rbac.Allowed(id, rbac.ActionAuditView, nil) {
+		f.User = id.DisplayName()
+	}
+	records, err := h.catalog.List(f)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, records)
+}
+
+// handleSessionRecord returns one catalogued session with its commands.
+func (h *Handler) handleSessionRecord(w http.ResponseWriter, r *http.Request) {
+	if h.catalog == nil {
+		jsonError(w, "session catalog is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	rec, err := h.catalog.Get(r.PathValue("id"))
+	id := auth.FromContext(r.Context())
+	if err == nil && rec.User != id.DisplayName() && !h.rbac.Allowed(id, rbac.ActionAuditView, nil) {
+		err = catalog.ErrNotFound
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, catalog.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+	jsonResponse(w, rec)
+}
+
+// parseHistoryFilter reads /sessions/history query parameters. Times are
+// RFC 3339 or YYYY-MM-DD, as for /audit-log.
+func parseHistoryFilter(q url.Values) (catalog.Filter, error) {
+	f := catalog.Filter{
+		User:       q.Get("user"),
+		InstanceID: q.Get("instance"),
+		AccountID:  q.Get("account"),
+		Query:      q.Get("q"),
+		Active:     q.Get("active") == "true",
+		Limit:      100,
+	}
+	var err error
+	if f.Since, err = parseAuditTime(q.Get("since"), false); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseAuditTime(q.Get("until"), true); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, fmt.Errorf("invalid limit: %q", v)
+		}
+	}
+	return f, nil
+}
@@ -8,7 +8,9 @@ import (
 	"strings"
 	"time"
 
+	"cloudterm-go/internal/audit"
 	"cloudterm-go/internal/k8s"
+	"cloudterm-go/internal/rbac"
 
 	"k8s.io/client-go/dynamic"
 )
@@ -21,6 +23,9 @@ func (h *Handler) handleK8sListClusters(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "accountId and region required", http.StatusBadRequest)
 		return
 	}
+	if !h.authorizeCluster(w, r, rbac.ActionK8sView, accountID+":"+region+":*") {
+		return
+	}
 
 	clusters, err := h.eksService.ListClusters(r.Context(), accountID, region)
 	if err != nil {
@@ -43,6 +48,9 @@ func (h *Handler) handleK8sConnect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !h.authorizeCluster(w, r, rbac.ActionK8sView, req.AccountID+":"+req.Region+":"+req.Cluster) {
+		return
+	}
 
 	// Get cluster info for endpoint and CA cert
 	clusters, err := h.eksService.ListClusters(r.Context(), req.AccountID, req.Region)
@@ -80,6 +88,7 @@ func (h *Handler) handleK8sConnect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	clusterID := fmt.Sprintf("%s:%s:%s", req.AccountID, req.Region, req.Cluster)
+	h.logAudit(r, audit.AuditEvent{Action: "k8s_connect", CorrelationID: clusterID, Region: req.Region, Details: "cluster=" + req.Cluster})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"cluster_id": clusterID,
@@ -96,6 +105,9 @@ func (h *Handler) handleK8sDisconnect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid cluster ID", http.StatusBadRequest)
 		return
 	}
+	if !h.authorizeCluster(w, r, rbac.ActionK8sView, clusterID) {
+		return
+	}
 	h.k8sPool.Disconnect(parts[0], parts[1], parts[2])
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "disconnected"})
@@ -103,6 +115,9 @@ func (h *Handler) handleK8sDisconnect(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sNamespaces lists namespaces in a connected cluster.
 func (h *Handler) handleK8sNamespaces(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -132,6 +147,9 @@ func (h *Handler) handleK8sNamespaces(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sCategories returns resource types organized by category.
 func (h *Handler) handleK8sCategories(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -148,6 +166,9 @@ func (h *Handler) handleK8sCategories(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sListResources lists instances of a resource type.
 func (h *Handler) handleK8sListResources(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -181,6 +202,9 @@ func (h *Handler) handleK8sListResources(w http.ResponseWriter, r *http.Request)
 
 // handleK8sGetResource returns the full YAML of a single resource.
 func (h *Handler) handleK8sGetResource(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -219,6 +243,9 @@ func (h *Handler) handleK8sGetResource(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sListCRDs lists all Custom Resource Definitions.
 func (h *Handler) handleK8sListCRDs(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -243,6 +270,9 @@ func (h *Handler) handleK8sListCRDs(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sCRDResources lists instances of a specific CRD.
 func (h *Handler) handleK8sCRDResources(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -11,12 +11,17 @@ import (
 	"os/exec"
 	"strings"
 
+	"cloudterm-go/internal/rbac"
+
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 
 // handleK8sKubeconfigUpload parses an uploaded kubeconfig and returns available clusters
 func (h *Handler) handleK8sKubeconfigUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeCluster(w, r, rbac.ActionK8sView, "") {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	
 	if r.Method != "POST" {
@@ -110,6 +115,9 @@ func (h *Handler) handleK8sKubeconfigUpload(w http.ResponseWriter, r *http.Reque
 // Like OpenLens, it first tries to execute the kubeconfig's exec command locally.
 // If that fails and the cluster is Teleport-managed, it falls back to Web SSO.
 func (h *Handler) handleK8sKubeconfigConnect(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeCluster(w, r, rbac.ActionK8sView, "") {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	
 	var req struct {
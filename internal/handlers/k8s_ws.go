@@ -9,6 +9,9 @@ import (
 	"strings"
 	"time"
 
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/rbac"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
@@ -16,6 +19,9 @@ import (
 
 // handleK8sLogs streams container logs over WebSocket.
 func (h *Handler) handleK8sLogs(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sView) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -83,6 +89,9 @@ func (h *Handler) handleK8sLogs(w http.ResponseWriter, r *http.Request) {
 
 // handleK8sExec provides a bi-directional terminal session into a container.
 func (h *Handler) handleK8sExec(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeK8s(w, r, rbac.ActionK8sExec) {
+		return
+	}
 	conn, err := h.getK8sConn(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -123,11 +132,20 @@ func (h *Handler) handleK8sExec(w http.ResponseWriter, r *http.Request) {
 			TTY:       true,
 		}, scheme.ParameterCodec)
 
+	ev := audit.AuditEvent{
+		Action:        "k8s_exec",
+		CorrelationID: r.URL.Query().Get("cluster"),
+		Details:       fmt.Sprintf("namespace=%s pod=%s container=%s command=%q", namespace, pod, container, command),
+	}
 	executor, err := remotecommand.NewSPDYExecutor(conn.RestCfg, "POST", req.URL())
 	if err != nil {
+		ev.Outcome = audit.OutcomeFailure
+		ev.Details += " error=" + err.Error()
+		h.logAudit(r, ev)
 		ws.WriteJSON(map[string]string{"error": fmt.Sprintf("exec setup: %v", err)})
 		return
 	}
+	h.logAudit(r, ev)
 
 	// Bidirectional pipe: WS ↔ K8s exec
 	stdinR, stdinW := io.Pipe()
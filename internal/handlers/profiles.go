@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/aws"
+	"cloudterm-go/internal/rbac"
+)
+
+// handleListAWSProfiles lists the profiles from ~/.aws/config and
+// ~/.aws/credentials with their kind and last scan status, so expired SSO
+// sessions can be spotted and renewed.
+func (h *Handler) handleListAWSProfiles(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.discovery.Profiles())
+}
+
+// handleStartSSOLogin starts an IAM Identity Center device authorization
+// for a profile. The response carries the verification URL and user code
+// to show; poll handleSSOLoginStatus for the outcome.
+func (h *Handler) handleStartSSOLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionAccountsManage) {
+		return
+	}
+	var req struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Profile == "" {
+		jsonError(w, "profile is required", http.StatusBadRequest)
+		return
+	}
+	login, err := h.discovery.StartSSOLogin(r.Context(), req.Profile)
+	if err != nil {
+		h.logAudit(r, audit.AuditEvent{Action: "sso_login", Outcome: audit.OutcomeFailure, Profile: req.Profile, Details: err.Error()})
+		status := http.StatusBadGateway
+		if errors.Is(err, aws.ErrNoSSOProfile) {
+			status = http.StatusBadRequest
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "sso_login", Profile: req.Profile, Details: "start_url=" + login.StartURL})
+	jsonResponse(w, login)
+}
+
+// handleSSOLoginStatus reports whether a device authorization was approved.
+func (h *Handler) handleSSOLoginStatus(w http.ResponseWriter, r *http.Request) {
+	login, ok := h.discovery.GetSSOLogin(r.PathValue("id"))
+	if !ok {
+		jsonError(w, "login not found", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, login)
+}
+
+// handleListOrgAccounts lists the accounts found by the last AWS
+// Organizations scan, with their OU path and tags.
+func (h *Handler) handleListOrgAccounts(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.discovery.OrgAccounts())
+}
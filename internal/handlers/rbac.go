@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/types"
+)
+
+// findInstance returns the cached instance with the given ID, or nil.
+func (h *Handler) findInstance(instanceID string) *types.EC2Instance {
+	instances, _ := h.discovery.GetAllInstances()
+	for i := range instances {
+		if instances[i].InstanceID == instanceID {
+			return &instances[i]
+		}
+	}
+	return nil
+}
+
+// allowed evaluates the RBAC policy for the caller of r. Denials are
+// written to the audit log.
+func (h *Handler) allowed(r *http.Request, action string, res *rbac.Resource) bool {
+	id := auth.FromContext(r.Context())
+	if h.rbac.Allowed(id, action, res) {
+		return true
+	}
+	ev := audit.AuditEvent{Action: "access_denied", Outcome: audit.OutcomeDenied, Details: "action=" + action}
+	if res != nil {
+		ev.InstanceID = res.InstanceID
+		ev.Region = res.Region
+		ev.AccountID = res.AccountID
+	}
+	h.logAudit(r, ev)
+	return false
+}
+
+// instanceResource builds the RBAC resource for instanceID. Instances that
+// are not in the discovery cache are marked unknown, so scoped deny rules
+// still apply to them.
+func (h *Handler) instanceResource(instanceID string) *rbac.Resource {
+	if res := rbac.InstanceResource(h.findInstance(instanceID)); res != nil {
+		return res
+	}
+	if instanceID == "" {
+		return nil
+	}
+	return &rbac.Resource{InstanceID: instanceID, Unknown: true}
+}
+
+// authorize checks action against instanceID and writes a 403 if denied.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action, instanceID string) bool {
+	if h.allowed(r, action, h.instanceResource(instanceID)) {
+		return true
+	}
+	jsonError(w, "permission denied: "+action, http.StatusForbidden)
+	return false
+}
+
+// authorizeGlobal checks an action that is not tied to an instance.
+func (h *Handler) authorizeGlobal(w http.ResponseWriter, r *http.Request, action string) bool {
+	if h.allowed(r, action, nil) {
+		return true
+	}
+	jsonError(w, "permission denied: "+action, http.StatusForbidden)
+	return false
+}
+
+// authorizeK8s checks action against the account and region encoded in the
+// "cluster" query parameter (account:region:name).
+func (h *Handler) authorizeK8s(w http.ResponseWriter, r *http.Request, action string) bool {
+	return h.authorizeCluster(w, r, action, r.URL.Query().Get("cluster"))
+}
+
+// authorizeCluster checks action against an EKS cluster ID. IDs that are not
+// account:region:name (e.g. uploaded kubeconfigs) are treated as global.
+func (h *Handler) authorizeCluster(w http.ResponseWriter, r *http.Request, action, clusterID string) bool {
+	var res *rbac.Resource
+	if parts := strings.SplitN(clusterID, ":", 3); len(parts) == 3 {
+		res = &rbac.Resource{AccountID: parts[0], Region: parts[1]}
+	}
+	if h.allowed(r, action, res) {
+		return true
+	}
+	http.Error(w, "permission denied: "+action, http.StatusForbidden)
+	return false
+}
+
+// filterInstanceTree drops every instance the caller cannot access, along
+// with any groups, regions and accounts left empty.
+func (h *Handler) filterInstanceTree(r *http.Request, tree *types.InstanceTree) *types.InstanceTree {
+	if !h.rbac.Enabled() || tree == nil {
+		return tree
+	}
+	id := auth.FromContext(r.Context())
+	out := &types.InstanceTree{Accounts: []types.AccountNode{}}
+	for _, acct := range tree.Accounts {
+		a := acct
+		a.Regions = nil
+		for _, region := range acct.Regions {
+			rg := region
+			rg.Groups = nil
+			for _, group := range region.Groups {
+				g := group
+				g.Instances = nil
+				for i := range group.Instances {
+					if h.rbac.CanAccess(id, rbac.InstanceResource(&group.Instances[i])) {
+						g.Instances = append(g.Instances, group.Instances[i])
+					}
+				}
+				if len(g.Instances) > 0 {
+					rg.Groups = append(rg.Groups, g)
+				}
+			}
+			if len(rg.Groups) > 0 {
+				a.Regions = append(a.Regions, rg)
+			}
+		}
+		if len(a.Regions) > 0 {
+			out.Accounts = append(out.Accounts, a)
+		}
+	}
+	return out
+}
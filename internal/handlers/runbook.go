@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/fleet"
+	"cloudterm-go/internal/guard"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/runbook"
+)
+
+// maxRunbookSize bounds an uploaded runbook.
+const maxRunbookSize = 256 * 1024
+
+// auditOutputLimit caps the step output copied into each runbook_step
+// audit event.
+const auditOutputLimit = 2048
+
+// handleListRunbooks lists saved runbooks. Files that fail to parse are
+// reported separately so they can be fixed.
+func (h *Handler) handleListRunbooks(w http.ResponseWriter, r *http.Request) {
+	runbooks, invalid, err := h.runbooks.List()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if runbooks == nil {
+		runbooks = []*runbook.Runbook{}
+	}
+	errs := make(map[string]string, len(invalid))
+	for file, err := range invalid {
+		errs[file] = err.Error()
+	}
+	jsonResponse(w, map[string]interface{}{"runbooks": runbooks, "invalid": errs})
+}
+
+// handleGetRunbook returns a runbook and its YAML source.
+func (h *Handler) handleGetRunbook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	src, err := h.runbooks.Raw(name)
+	if err == nil {
+		var rb *runbook.Runbook
+		if rb, err = runbook.Parse(src); err == nil {
+			jsonResponse(w, map[string]interface{}{"runbook": rb, "source": string(src)})
+			return
+		}
+	}
+	runbookError(w, err)
+}
+
+// handleSaveRunbook creates or replaces a runbook from the YAML request
+// body.
+func (h *Handler) handleSaveRunbook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRunbooksManage) {
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRunbookSize))
+	if err != nil {
+		jsonError(w, "runbook too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	rb, err := h.runbooks.Save(data)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "runbook_save", Details: "runbook=" + rb.Name})
+	jsonResponse(w, rb)
+}
+
+// handleDeleteRunbook removes a runbook.
+func (h *Handler) handleDeleteRunbook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGlobal(w, r, rbac.ActionRunbooksManage) {
+		return
+	}
+	name := r.PathValue("name")
+	if err := h.runbooks.Delete(name); err != nil {
+		runbookError(w, err)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "runbook_delete", Details: "runbook=" + name})
+	jsonResponse(w, map[string]string{"status": "deleted"})
+}
+
+// handleRunRunbook starts a runbook, either typed into one of the caller's
+// terminal sessions (session_id) or headless on the Linux instances a
+// fleet query selects. Rendered commands are checked against the command
+// guard first: blocked commands refuse the run and warned ones must be
+// confirmed.
+func (h *Handler) handleRunRunbook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Params      map[string]string `json:"params"`
+		SessionID   string            `json:"session_id"`
+		Query       fleet.Query       `json:"query"`
+		Concurrency int               `json:"concurrency"`
+		Confirm     bool              `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rb, err := h.runbooks.Get(r.PathValue("name"))
+	if err != nil {
+		runbookError(w, err)
+		return
+	}
+	commands, err := rb.Commands(req.Params)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := auth.FromContext(r.Context()).DisplayName()
+	run := runbook.Request{User: user, Runbook: rb, Params: req.Params, Concurrency: req.Concurrency}
+	var envs map[string]string // instance ID → environment, for the guard
+	if req.SessionID != "" {
+		sess, ok := h.sessions.GetSession(req.SessionID)
+		if !ok || !h.sessions.CanWrite(req.SessionID, user) {
+			jsonError(w, "session not found", http.StatusNotFound)
+			return
+		}
+		if !h.authorize(w, r, rbac.ActionRunbookRun, sess.InstanceID) {
+			return
+		}
+		run.SessionID = sess.SessionID
+		run.InstanceID = sess.InstanceID
+		envs = map[string]string{sess.InstanceID: h.instanceEnv(sess.InstanceID)}
+	} else {
+		if req.Query.Empty() {
+			jsonError(w, "choose a session or select instances by tag, account, region, name or ID", http.StatusBadRequest)
+			return
+		}
+		// Steps are POSIX shell.
+		req.Query.Platform = "linux"
+		targets, denied := h.fleetTargets(r, rbac.ActionRunbookRun, req.Query)
+		if len(targets) == 0 {
+			msg := "no running Linux instances match the query"
+			if denied > 0 {
+				msg = "permission denied: " + rbac.ActionRunbookRun
+			}
+			jsonError(w, msg, http.StatusBadRequest)
+			return
+		}
+		if max := h.cfg.FleetMaxTargets; max > 0 && len(targets) > max {
+			jsonError(w, fmt.Sprintf("query matches %d instances; the limit is %d", len(targets), max), http.StatusBadRequest)
+			return
+		}
+		envs = make(map[string]string, len(targets))
+		for _, t := range targets {
+			run.Targets = append(run.Targets, runbook.Target{InstanceID: t.InstanceID, Name: t.Name, Profile: t.Profile, Region: t.Region})
+			envs[t.InstanceID] = h.instanceEnv(t.InstanceID)
+		}
+	}
+
+	decisions := h.guardRunbook(envs, commands)
+	for _, d := range decisions {
+		if d.Action == guard.Block {
+			h.logAudit(r, audit.AuditEvent{
+				Action:     "runbook_start",
+				Outcome:    audit.OutcomeDenied,
+				InstanceID: run.InstanceID,
+				Details:    fmt.Sprintf("runbook=%s blocked by rule %q: %s", rb.Name, d.Rule, d.Command),
+			})
+			guardResponse(w, http.StatusForbidden, "runbook blocked by the command guard", decisions)
+			return
+		}
+	}
+	if len(decisions) > 0 && !req.Confirm {
+		guardResponse(w, http.StatusConflict, "runbook commands need confirmation", decisions)
+		return
+	}
+
+	run.OnStart = func(rn *runbook.Run) {
+		h.logAudit(r, audit.AuditEvent{
+			Action:        "runbook_start",
+			CorrelationID: rn.ID,
+			InstanceID:    rn.InstanceID,
+			Details:       fmt.Sprintf("runbook=%s mode=%s targets=%d params=%s confirmed=%d", rn.Runbook, rn.Mode, max(1, len(rn.Targets)), formatParams(rn.Params), len(decisions)),
+		})
+	}
+	run.OnStep = func(rn *runbook.Run, res runbook.StepResult) {
+		h.logAudit(r, runbookStepEvent(rn, res, len(rb.Steps)))
+	}
+	run.OnDone = func(rn *runbook.Run) {
+		outcome := audit.OutcomeSuccess
+		if rn.Status != runbook.StatusCompleted {
+			outcome = audit.OutcomeFailure
+		}
+		h.logAudit(r, audit.AuditEvent{
+			Action:        "runbook_finish",
+			Outcome:       outcome,
+			CorrelationID: rn.ID,
+			InstanceID:    rn.InstanceID,
+			Details:       fmt.Sprintf("runbook=%s status=%s", rn.Runbook, rn.Status),
+		})
+	}
+	started, err := h.runbookRuns.Start(run)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResponse(w, started)
+}
+
+// guardResponse reports the guard decisions that stopped a run.
+func guardResponse(w http.ResponseWriter, status int, msg string, decisions []guard.Decision) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg, "decisions": decisions})
+}
+
+// instanceEnv returns the environment (TAG2 value) of an instance.
+func (h *Handler) instanceEnv(instanceID string) string {
+	if inst := h.findInstance(instanceID); inst != nil {
+		return inst.Tag2Value
+	}
+	return ""
+}
+
+// guardRunbook checks every line of every command in each environment and
+// returns the decisions that are not plain allows.
+func (h *Handler) guardRunbook(envs map[string]string, commands []string) []guard.Decision {
+	if h.guard == nil {
+		return nil
+	}
+	var out []guard.Decision
+	seen := make(map[string]bool)
+	for _, env := range envs {
+		if seen[env] {
+			continue
+		}
+		seen[env] = true
+		for _, command := range commands {
+			for _, line := range strings.Split(command, "\n") {
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				if d := h.guard.Check(env, line); d.Action != guard.Allow {
+					out = append(out, d)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// runbookStepEvent is the audit record of one step: together the events of
+// a run, linked by its ID, form its transcript.
+func runbookStepEvent(rn *runbook.Run, res runbook.StepResult, steps int) audit.AuditEvent {
+	outcome := ""
+	switch res.Status {
+	case runbook.StepPassed:
+		outcome = audit.OutcomeSuccess
+	case runbook.StepFailed:
+		outcome = audit.OutcomeFailure
+	}
+	output := res.Output
+	if len(output) > auditOutputLimit {
+		output = "…" + output[len(output)-auditOutputLimit:]
+	}
+	details := fmt.Sprintf("runbook=%s step=%d/%d name=%q status=%s", rn.Runbook, res.Step+1, steps, res.Name, res.Status)
+	if res.Status != runbook.StepSkipped {
+		details += fmt.Sprintf(" attempt=%d exit=%d command=%q output=%q", res.Attempt, res.ExitCode, res.Command, output)
+	}
+	if res.Error != "" {
+		details += fmt.Sprintf(" error=%q", res.Error)
+	}
+	return audit.AuditEvent{
+		Action:        "runbook_step",
+		Outcome:       outcome,
+		CorrelationID: rn.ID,
+		InstanceID:    res.InstanceID,
+		Details:       details,
+	}
+}
+
+// formatParams renders run parameters as name=value pairs in a stable order.
+func formatParams(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%q", name, params[name])
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// handleRunbookRuns lists the caller's runs, newest first.
+func (h *Handler) handleRunbookRuns(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.runbookRuns.List(auth.FromContext(r.Context()).DisplayName()))
+}
+
+// runbookRun loads a run from the path, restricted to the user who started
+// it.
+func (h *Handler) runbookRun(w http.ResponseWriter, r *http.Request) (*runbook.Run, bool) {
+	run, err := h.runbookRuns.Get(r.PathValue("id"))
+	if err == nil && run.User != auth.FromContext(r.Context()).DisplayName() {
+		err = runbook.ErrNotFound
+	}
+	if err != nil {
+		runbookError(w, err)
+		return nil, false
+	}
+	return run, true
+}
+
+// handleRunbookRun returns a run with its step results.
+func (h *Handler) handleRunbookRun(w http.ResponseWriter, r *http.Request) {
+	if run, ok := h.runbookRun(w, r); ok {
+		jsonResponse(w, run)
+	}
+}
+
+// handleRunbookStream streams a run as NDJSON: step results recorded so far
+// first, then each event as it happens, ending with a "done" event.
+func (h *Handler) handleRunbookStream(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.runbookRun(w, r); !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	run, events, unsubscribe, err := h.runbookRuns.Subscribe(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	send := func(ev runbook.Event) {
+		line, _ := json.Marshal(ev)
+		w.Write(line)
+		w.Write([]byte("\n"))
+		flusher.Flush()
+	}
+	for i := range run.Results {
+		send(runbook.Event{Type: "step", RunID: run.ID, Result: &run.Results[i], Status: run.Status})
+	}
+	if run.PausedAt != nil {
+		send(runbook.Event{Type: "paused", RunID: run.ID, Result: run.PausedAt, Status: run.Status})
+	}
+	if events == nil {
+		send(runbook.Event{Type: "done", RunID: run.ID, Status: run.Status})
+		return
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			send(ev)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleRunbookResume answers a paused run with retry, continue or abort.
+func (h *Handler) handleRunbookResume(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.runbookRun(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Answer string `json:"answer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.runbookRuns.Resume(run.ID, req.Answer); err != nil {
+		jsonError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{
+		Action:        "runbook_resume",
+		CorrelationID: run.ID,
+		InstanceID:    run.InstanceID,
+		Details:       fmt.Sprintf("runbook=%s answer=%s", run.Runbook, req.Answer),
+	})
+	jsonResponse(w, map[string]string{"status": req.Answer})
+}
+
+// handleRunbookCancel stops a run in progress.
+func (h *Handler) handleRunbookCancel(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.runbookRun(w, r)
+	if !ok {
+		return
+	}
+	if run.Finished != nil {
+		jsonError(w, "run is not in progress", http.StatusConflict)
+		return
+	}
+	if err := h.runbookRuns.Cancel(run.ID); err != nil {
+		runbookError(w, err)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "runbook_cancel", CorrelationID: run.ID, InstanceID: run.InstanceID, Details: "runbook=" + run.Runbook})
+	jsonResponse(w, map[string]string{"status": "cancelling"})
+}
+
+// runbookError writes err with 404 for unknown runbooks and runs.
+func runbookError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, runbook.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	jsonError(w, err.Error(), status)
+}
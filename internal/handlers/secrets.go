@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/types"
+	"cloudterm-go/internal/vault"
+
+	"github.com/gorilla/websocket"
+)
+
+// secretPlaceholder matches {{vault:<ref>.<field>}} in terminal input. ref
+// is a secret kind, resolved against the session's instance by the vault's
+// match rules, or the ID of a vault entry.
+var secretPlaceholder = regexp.MustCompile(`\{\{vault:([^.{}\s]+)\.([a-z_]+)\}\}`)
+
+var secretKinds = map[string]bool{vault.KindRDP: true, vault.KindSSH: true, vault.KindDB: true, vault.KindToken: true}
+
+// writeInput writes keystrokes that passed the command guard to a session.
+// Vault placeholders are replaced with their secrets on the way to the
+// terminal only: the guard, the observer and the recording see the
+// placeholder, and the session masks the secrets in its output. Input with
+// a placeholder that cannot be resolved is dropped and the client told why.
+func (h *Handler) writeInput(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, sessionID string, input []byte) error {
+	if !secretPlaceholder.Match(input) {
+		return h.sessions.WriteInput(sessionID, input)
+	}
+	expanded, secrets, err := h.expandSecrets(r, sessionID, input)
+	if err != nil {
+		writeMu.Lock()
+		conn.WriteJSON(types.WSMessage{
+			Type:    "session_error",
+			Payload: types.SessionEventMsg{SessionID: sessionID, Error: err.Error()},
+		})
+		writeMu.Unlock()
+		return err
+	}
+	return h.sessions.WriteSecretInput(sessionID, expanded, input, secrets...)
+}
+
+// expandSecrets resolves every placeholder in input for the session's
+// instance. Each use is audited without its value.
+func (h *Handler) expandSecrets(r *http.Request, sessionID string, input []byte) ([]byte, []string, error) {
+	sess, ok := h.sessions.GetSession(sessionID)
+	if !ok {
+		return nil, nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	if h.vault == nil {
+		return nil, nil, fmt.Errorf("vault not configured")
+	}
+	if !h.allowed(r, rbac.ActionVaultRead, h.instanceResource(sess.InstanceID)) {
+		return nil, nil, fmt.Errorf("permission denied: %s", rbac.ActionVaultRead)
+	}
+
+	ev := audit.AuditEvent{
+		Action:        "vault_use",
+		CorrelationID: sessionID,
+		InstanceID:    sess.InstanceID,
+		InstanceName:  sess.InstanceName,
+		Profile:       sess.Profile,
+		Region:        sess.Region,
+	}
+	var secrets []string
+	var resolveErr error
+	out := secretPlaceholder.ReplaceAllFunc(input, func(m []byte) []byte {
+		if resolveErr != nil {
+			return nil
+		}
+		sub := secretPlaceholder.FindSubmatch(m)
+		ref, field := string(sub[1]), string(sub[2])
+		entry, err := h.resolveSecret(r, sess.InstanceID, ref)
+		var value string
+		if err == nil {
+			value, err = entry.Field(field)
+		}
+		if err != nil {
+			resolveErr = fmt.Errorf("%s: %w", m, err)
+			return nil
+		}
+		ev.Details = fmt.Sprintf("id=%s field=%s", entry.Rule.ID, field)
+		h.logAudit(r, ev)
+		secrets = append(secrets, value)
+		return []byte(value)
+	})
+	if resolveErr != nil {
+		ev.Outcome = audit.OutcomeFailure
+		ev.Details = resolveErr.Error()
+		h.logAudit(r, ev)
+		return nil, nil, resolveErr
+	}
+	return out, secrets, nil
+}
+
+// resolveSecret finds the vault entry a placeholder refers to. Naming an
+// entry by ID bypasses the match rules, so it needs vault:read globally, as
+// resolving it through the vault API does.
+func (h *Handler) resolveSecret(r *http.Request, instanceID, ref string) (*vault.VaultEntry, error) {
+	if !secretKinds[ref] {
+		if !h.allowed(r, rbac.ActionVaultRead, nil) {
+			return nil, fmt.Errorf("permission denied: %s", rbac.ActionVaultRead)
+		}
+		return h.vault.Get(ref)
+	}
+	inst := h.findInstance(instanceID)
+	if inst == nil {
+		return h.vault.FindMatch(ref, instanceID, "", "", "")
+	}
+	return h.vault.FindMatch(ref, instanceID, inst.Name, inst.Tag2Value, inst.AccountID)
+}
+
+// vaultDBLink returns a connection URL for a port forward whose remote port
+// is that of a vault database credential matching the instance. The link
+// carries the password only for callers who may read the vault; label
+// never does. Both are empty when no credential matches.
+func (h *Handler) vaultDBLink(r *http.Request, instanceID string, remotePort, localPort int) (link, label string) {
+	if h.vault == nil {
+		return "", ""
+	}
+	inst := h.findInstance(instanceID)
+	if inst == nil {
+		return "", ""
+	}
+	entry, err := h.vault.FindMatch(vault.KindDB, instanceID, inst.Name, inst.Tag2Value, inst.AccountID)
+	if err != nil || entry.DB == nil || entry.DB.DefaultPort() != remotePort {
+		return "", ""
+	}
+	label = entry.DB.URL("localhost", localPort, false)
+	if !h.rbac.Allowed(auth.FromContext(r.Context()), rbac.ActionVaultRead, rbac.InstanceResource(inst)) {
+		return label, label
+	}
+	return entry.DB.URL("localhost", localPort, true), label
+}
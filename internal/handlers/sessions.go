@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudterm-go/internal/audit"
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/rbac"
+	"cloudterm-go/internal/session"
+	"cloudterm-go/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsSendDetachedSessions tells a newly connected client which of its
+// sessions are still running without a browser.
+func (h *Handler) wsSendDetachedSessions(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex) {
+	if h.cfg.SessionDetachGraceMinutes == 0 {
+		return
+	}
+	detached := h.sessions.DetachedSessions(auth.FromContext(r.Context()).DisplayName())
+	if len(detached) == 0 {
+		return
+	}
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.WriteJSON(types.WSMessage{Type: "detached_sessions", Payload: detached})
+}
+
+// wsAttachSession binds a running session to this connection and replays
+// its buffered output.
+func (h *Handler) wsAttachSession(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg struct {
+		SessionID string `json:"session_id"`
+		Cols      uint16 `json:"cols"`
+		Rows      uint16 `json:"rows"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+
+	fail := func(instanceID, reason string) {
+		wsSessionError(conn, writeMu, instanceID, msg.SessionID, reason)
+	}
+
+	sess, ok := h.sessions.GetSession(msg.SessionID)
+	if !ok {
+		fail("", "session not found")
+		return
+	}
+	if owner, _ := h.sessions.Owner(msg.SessionID); owner != auth.FromContext(r.Context()).DisplayName() {
+		fail(sess.InstanceID, "session belongs to another user")
+		return
+	}
+	if !h.allowed(r, rbac.ActionTerminal, h.instanceResource(sess.InstanceID)) {
+		fail(sess.InstanceID, "permission denied: "+rbac.ActionTerminal)
+		return
+	}
+
+	if msg.Cols > 0 && msg.Rows > 0 {
+		if err := h.sessions.ResizeTerminal(msg.SessionID, msg.Rows, msg.Cols); err != nil {
+			h.logger.Printf("resize session %s: %v", msg.SessionID, err)
+		}
+	}
+	h.startObserver(conn, writeMu, msg.SessionID)
+	h.sessions.SetEventHandler(msg.SessionID, h.collabEvents(conn, writeMu))
+	if err := h.sessions.Attach(msg.SessionID, h.terminalOutput(conn, writeMu, sess.InstanceID, msg.SessionID)); err != nil {
+		fail(sess.InstanceID, err.Error())
+		return
+	}
+	h.trackSession(conn, msg.SessionID)
+	h.logAudit(r, audit.AuditEvent{
+		Action:        "session_attach",
+		CorrelationID: msg.SessionID,
+		InstanceID:    sess.InstanceID,
+		InstanceName:  sess.InstanceName,
+		Profile:       sess.Profile,
+		Region:        sess.Region,
+	})
+
+	started := types.SessionEventMsg{
+		InstanceID: sess.InstanceID,
+		SessionID:  msg.SessionID,
+		Recording:  sess.IsRecording(),
+		Reattached: true,
+	}
+	h.setExpiry(&started)
+	writeMu.Lock()
+	conn.WriteJSON(types.WSMessage{Type: "session_started", Payload: started})
+	writeMu.Unlock()
+	h.sendGuardPending(conn, writeMu, msg.SessionID)
+}
+
+// handleDetachedSessions lists the caller's sessions that are running
+// without a browser attached.
+func (h *Handler) handleDetachedSessions(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.sessions.DetachedSessions(auth.FromContext(r.Context()).DisplayName()))
+}
+
+// handleTerminateDetachedSession closes one of the caller's detached
+// sessions without reattaching to it.
+func (h *Handler) handleTerminateDetachedSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	user := auth.FromContext(r.Context()).DisplayName()
+	for _, s := range h.sessions.DetachedSessions(user) {
+		if s.SessionID != id {
+			continue
+		}
+		if err := h.sessions.EndSession(id, session.EndTerminated); err != nil {
+			jsonError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.forgetSession(id)
+		h.logAudit(r, audit.AuditEvent{Action: "session_end", CorrelationID: id, InstanceID: s.InstanceID, InstanceName: s.InstanceName, Details: "reason=terminated"})
+		jsonResponse(w, map[string]string{"status": "closed"})
+		return
+	}
+	jsonError(w, "detached session not found", http.StatusNotFound)
+}
+
+// watcherID identifies a client connection among a session's watchers.
+func watcherID(conn *websocket.Conn) string {
+	return fmt.Sprintf("%p", conn)
+}
+
+// collabEvents returns a callback that forwards collaboration events for a
+// shared session to a WebSocket client.
+func (h *Handler) collabEvents(conn *websocket.Conn, writeMu *sync.Mutex) func(session.CollabEvent) {
+	return func(ev session.CollabEvent) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(types.WSMessage{Type: "collab_event", Payload: ev}); err != nil {
+			h.logger.Printf("ws write collab event for session %s: %v", ev.SessionID, err)
+		}
+	}
+}
+
+// setExpiry fills in when the session in msg will reach its time limit.
+func (h *Handler) setExpiry(msg *types.SessionEventMsg) {
+	if deadline, reason, ok := h.sessions.Deadline(msg.SessionID); ok {
+		msg.ExpiresAt = &deadline
+		msg.ExpiryReason = reason
+	}
+}
+
+// wsExtendSession answers the expiry warning's Extend action: the idle
+// timer restarts and, if the policy allows, the maximum duration is
+// extended. The new deadline is sent back as session_extended.
+func (h *Handler) wsExtendSession(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+	sess, ok := h.sessions.GetSession(msg.SessionID)
+	if !ok || !h.sessions.CanWrite(msg.SessionID, auth.FromContext(r.Context()).DisplayName()) {
+		return
+	}
+	deadline, reason, err := h.sessions.Extend(msg.SessionID)
+	ev := audit.AuditEvent{
+		Action:        "session_extend",
+		Outcome:       audit.OutcomeSuccess,
+		CorrelationID: msg.SessionID,
+		InstanceID:    sess.InstanceID,
+		InstanceName:  sess.InstanceName,
+		Details:       fmt.Sprintf("reason=%s expires_at=%s", reason, deadline.UTC().Format(time.RFC3339)),
+	}
+	if err != nil {
+		ev.Outcome = audit.OutcomeFailure
+		ev.Details += " error=" + err.Error()
+	}
+	h.logAudit(r, ev)
+
+	reply := types.SessionEventMsg{InstanceID: sess.InstanceID, SessionID: msg.SessionID}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	h.setExpiry(&reply)
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.WriteJSON(types.WSMessage{Type: "session_extended", Payload: reply})
+}
+
+// wsSessionError sends a session_error message to a client.
+func wsSessionError(conn *websocket.Conn, writeMu *sync.Mutex, instanceID, sessionID, reason string) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	conn.WriteJSON(types.WSMessage{
+		Type: "session_error",
+		Payload: types.SessionEventMsg{
+			InstanceID: instanceID,
+			SessionID:  sessionID,
+			Error:      reason,
+		},
+	})
+}
+
+// wsWatchSession subscribes this connection to another user's session as
+// a read-only watcher.
+func (h *Handler) wsWatchSession(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+
+	sess, ok := h.sessions.GetSession(msg.SessionID)
+	if !ok {
+		wsSessionError(conn, writeMu, "", msg.SessionID, "session not found")
+		return
+	}
+	if !h.allowed(r, rbac.ActionView, h.instanceResource(sess.InstanceID)) {
+		wsSessionError(conn, writeMu, sess.InstanceID, msg.SessionID, "permission denied: "+rbac.ActionView)
+		return
+	}
+	instanceID := sess.InstanceID
+	err = h.sessions.Watch(msg.SessionID, &session.Watcher{
+		ID:     watcherID(conn),
+		User:   auth.FromContext(r.Context()).DisplayName(),
+		Output: func(data []byte) { h.sendOutput(conn, writeMu, instanceID, msg.SessionID, data) },
+		Event:  h.collabEvents(conn, writeMu),
+	})
+	if err != nil {
+		reason := err.Error()
+		if errors.Is(err, session.ErrNotPermitted) {
+			reason = "you have not been invited to this session"
+		}
+		wsSessionError(conn, writeMu, instanceID, msg.SessionID, reason)
+		return
+	}
+
+	h.clientsMu.Lock()
+	h.watching[conn] = append(h.watching[conn], msg.SessionID)
+	h.clientsMu.Unlock()
+	h.logAudit(r, audit.AuditEvent{Action: "session_watch", CorrelationID: msg.SessionID, InstanceID: instanceID, InstanceName: sess.InstanceName})
+
+	writeMu.Lock()
+	conn.WriteJSON(types.WSMessage{
+		Type: "watch_started",
+		Payload: types.SessionEventMsg{
+			InstanceID: instanceID,
+			SessionID:  msg.SessionID,
+			Recording:  sess.IsRecording(),
+		},
+	})
+	writeMu.Unlock()
+}
+
+// wsUnwatchSession stops watching a session.
+func (h *Handler) wsUnwatchSession(r *http.Request, conn *websocket.Conn, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+
+	h.clientsMu.Lock()
+	ids := h.watching[conn]
+	found := false
+	for i, id := range ids {
+		if id == msg.SessionID {
+			h.watching[conn] = append(ids[:i], ids[i+1:]...)
+			found = true
+			break
+		}
+	}
+	h.clientsMu.Unlock()
+	if !found {
+		return
+	}
+	h.sessions.Unwatch(msg.SessionID, watcherID(conn))
+	h.logAudit(r, audit.AuditEvent{Action: "session_unwatch", CorrelationID: msg.SessionID})
+}
+
+// wsControl handles the control hand-off handshake: a watcher sends
+// request_control, the owner or current controller answers with
+// grant_control, and the controller may release_control back to the owner.
+func (h *Handler) wsControl(r *http.Request, conn *websocket.Conn, writeMu *sync.Mutex, kind string, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var msg struct {
+		SessionID string `json:"session_id"`
+		User      string `json:"user"` // grantee, for grant_control
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+
+	sess, ok := h.sessions.GetSession(msg.SessionID)
+	if !ok {
+		wsSessionError(conn, writeMu, "", msg.SessionID, "session not found")
+		return
+	}
+	user := auth.FromContext(r.Context()).DisplayName()
+	switch kind {
+	case "request_control":
+		if !h.allowed(r, rbac.ActionTerminal, h.instanceResource(sess.InstanceID)) {
+			err = fmt.Errorf("permission denied: %s", rbac.ActionTerminal)
+			break
+		}
+		err = h.sessions.RequestControl(msg.SessionID, user)
+	case "grant_control":
+		if err = h.sessions.GrantControl(msg.SessionID, user, msg.User); err == nil {
+			h.logAudit(r, audit.AuditEvent{Action: "session_control", CorrelationID: msg.SessionID, InstanceID: sess.InstanceID, InstanceName: sess.InstanceName, Details: "to=" + msg.User})
+		}
+	case "release_control":
+		if err = h.sessions.ReleaseControl(msg.SessionID, user); err == nil {
+			h.logAudit(r, audit.AuditEvent{Action: "session_control", CorrelationID: msg.SessionID, InstanceID: sess.InstanceID, InstanceName: sess.InstanceName, Details: "released"})
+		}
+	}
+	if err != nil {
+		wsSessionError(conn, writeMu, sess.InstanceID, msg.SessionID, err.Error())
+	}
+}
+
+// handleSharedSessions lists the sessions the caller has been invited to
+// watch.
+func (h *Handler) handleSharedSessions(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.sessions.Invitations(auth.FromContext(r.Context()).DisplayName()))
+}
+
+// ownSession resolves a session from the path and checks that the caller
+// started it.
+func (h *Handler) ownSession(w http.ResponseWriter, r *http.Request) (*session.SSMSession, bool) {
+	id := r.PathValue("id")
+	sess, ok := h.sessions.GetSession(id)
+	if !ok {
+		jsonError(w, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	if owner, _ := h.sessions.Owner(id); owner != auth.FromContext(r.Context()).DisplayName() {
+		jsonError(w, "only the session owner can manage watchers", http.StatusForbidden)
+		return nil, false
+	}
+	return sess, true
+}
+
+// handleInviteWatcher lets the session owner invite another user to watch.
+func (h *Handler) handleInviteWatcher(w http.ResponseWriter, r *http.Request) {
+	sess, ok := h.ownSession(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.User) == "" {
+		jsonError(w, "user is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.sessions.Invite(sess.SessionID, strings.TrimSpace(req.User)); err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "session_invite", CorrelationID: sess.SessionID, InstanceID: sess.InstanceID, InstanceName: sess.InstanceName, Details: "watcher=" + strings.TrimSpace(req.User)})
+	jsonResponse(w, map[string]string{"status": "invited"})
+}
+
+// handleRevokeWatcher withdraws an invitation and disconnects the watcher.
+func (h *Handler) handleRevokeWatcher(w http.ResponseWriter, r *http.Request) {
+	sess, ok := h.ownSession(w, r)
+	if !ok {
+		return
+	}
+	user := r.PathValue("user")
+	if err := h.sessions.Revoke(sess.SessionID, user); err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.logAudit(r, audit.AuditEvent{Action: "session_revoke", CorrelationID: sess.SessionID, InstanceID: sess.InstanceID, InstanceName: sess.InstanceName, Details: "watcher=" + user})
+	jsonResponse(w, map[string]string{"status": "revoked"})
+}
+
+// handleSearchSession searches the output a session's owner or watchers
+// can see. q is literal text unless regex=true; case=true makes it
+// case-sensitive. from (a line number) and dir ("next" or "prev") choose
+// the current match for find-next/find-previous.
+func (h *Handler) handleSearchSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.sessions.CanView(id, auth.FromContext(r.Context()).DisplayName()) {
+		jsonError(w, "session not found", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	opts := session.SearchOptions{
+		Pattern:    q.Get("q"),
+		Regex:      q.Get("regex") == "true",
+		IgnoreCase: q.Get("case") != "true",
+		From:       -1,
+		Direction:  q.Get("dir"),
+	}
+	if opts.Direction != "" && opts.Direction != "next" && opts.Direction != "prev" {
+		jsonError(w, "dir must be next or prev", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("from"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			jsonError(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		opts.From = n
+	}
+	res, err := h.sessions.SearchOutput(id, opts)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResponse(w, res)
+}
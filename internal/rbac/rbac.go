@@ -0,0 +1,296 @@
+package rbac
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloudterm-go/internal/auth"
+	"cloudterm-go/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions that policies can grant or deny. Rules may also use globs such as
+// "file:*" or "*".
+const (
+	ActionView                = "instance:view"
+	ActionTerminal            = "terminal"
+	ActionTerminalSSH         = "terminal:ssh"
+	ActionFileUpload          = "file:upload"
+	ActionFileDownload        = "file:download"
+	ActionFileBrowse          = "file:browse"
+	ActionFileExpressUpload   = "file:express-upload"
+	ActionFileExpressDownload = "file:express-download"
+	ActionPortForward         = "port-forward"
+	ActionRDP                 = "rdp"
+	ActionCloneStart          = "clone:start"
+	ActionCloneLaunch         = "clone:launch"
+	ActionVaultRead           = "vault:read"
+	ActionVaultWrite          = "vault:write"
+	ActionK8sView             = "k8s:view"
+	ActionK8sExec             = "k8s:exec"
+	ActionAccountsManage      = "accounts:manage"
+	ActionRecordingsView      = "recordings:view"
+	ActionRecordingsDelete    = "recordings:delete"
+	ActionAuditView           = "audit:view"
+	ActionFleetRun            = "fleet:run"
+	ActionRunbookRun          = "runbook:run"
+	ActionRunbooksManage      = "runbooks:manage"
+	ActionKeysRotate          = "keys:rotate"
+	ActionSettingsManage      = "settings:manage"
+)
+
+// Policy is the on-disk RBAC document (RBAC_POLICY_FILE).
+type Policy struct {
+	// Default is the decision when no rule matches: "deny" (default) or "allow".
+	Default string `yaml:"default"`
+	Roles   []Role `yaml:"roles"`
+}
+
+// Role binds a set of rules to users and groups.
+type Role struct {
+	Name   string   `yaml:"name"`
+	Users  []string `yaml:"users"`  // subject, email or name; "*" for everyone
+	Groups []string `yaml:"groups"` // identity provider / local groups
+	Rules  []Rule   `yaml:"rules"`
+}
+
+// Rule allows or denies actions within a scope. Empty scope fields match
+// anything; values may be glob patterns.
+type Rule struct {
+	Effect   string            `yaml:"effect"` // "allow" or "deny"
+	Actions  []string          `yaml:"actions"`
+	Accounts []string          `yaml:"accounts"`
+	Regions  []string          `yaml:"regions"`
+	Tag1     []string          `yaml:"tag1"` // matched against EC2Instance.Tag1Value
+	Tag2     []string          `yaml:"tag2"` // matched against EC2Instance.Tag2Value
+	Tags     map[string]string `yaml:"tags"`
+}
+
+// Resource is the target of an authorization check.
+type Resource struct {
+	// Unknown marks an instance missing from the discovery cache, whose
+	// account, region and tags cannot be checked. Scoped deny rules are
+	// assumed to match it and scoped allow rules not to.
+	Unknown    bool
+	InstanceID string
+	AccountID  string
+	Region     string
+	Tag1       string
+	Tag2       string
+	Tags       map[string]string
+}
+
+// InstanceResource converts a discovered instance into a Resource.
+func InstanceResource(inst *types.EC2Instance) *Resource {
+	if inst == nil {
+		return nil
+	}
+	return &Resource{
+		InstanceID: inst.InstanceID,
+		AccountID:  inst.AccountID,
+		Region:     inst.AWSRegion,
+		Tag1:       inst.Tag1Value,
+		Tag2:       inst.Tag2Value,
+		Tags:       inst.Tags,
+	}
+}
+
+// Engine evaluates a Policy. A nil Engine allows everything, which keeps
+// deployments without a policy file working as before.
+type Engine struct {
+	policy       Policy
+	defaultAllow bool
+}
+
+// Load reads a policy file. An empty path disables RBAC (nil engine).
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rbac policy: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse builds an Engine from a YAML policy document.
+func Parse(data []byte) (*Engine, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse rbac policy: %w", err)
+	}
+	switch strings.ToLower(p.Default) {
+	case "", "deny", "allow":
+	default:
+		return nil, fmt.Errorf("rbac policy: invalid default %q", p.Default)
+	}
+	for _, role := range p.Roles {
+		for i, rule := range role.Rules {
+			switch strings.ToLower(rule.Effect) {
+			case "allow", "deny":
+			default:
+				return nil, fmt.Errorf("rbac policy: role %q rule %d: invalid effect %q", role.Name, i, rule.Effect)
+			}
+			if len(rule.Actions) == 0 {
+				return nil, fmt.Errorf("rbac policy: role %q rule %d: no actions", role.Name, i)
+			}
+		}
+	}
+	return &Engine{policy: p, defaultAllow: strings.EqualFold(p.Default, "allow")}, nil
+}
+
+// Enabled reports whether a policy is in force.
+func (e *Engine) Enabled() bool { return e != nil }
+
+// Allowed reports whether id may perform action on res. Deny rules override
+// allow rules; if nothing matches the policy default applies. A nil res
+// represents a global (non-instance) action and only matches unscoped rules.
+func (e *Engine) Allowed(id *auth.Identity, action string, res *Resource) bool {
+	if e == nil {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	allowed := false
+	for _, role := range e.rolesFor(id) {
+		for _, rule := range role.Rules {
+			deny := strings.EqualFold(rule.Effect, "deny")
+			if !matchAny(rule.Actions, action) || !rule.applies(res, deny) {
+				continue
+			}
+			if deny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed || e.defaultAllow
+}
+
+// CanAccess reports whether id may perform at least one action on res. It
+// is used to filter instance listings down to what the caller can touch.
+func (e *Engine) CanAccess(id *auth.Identity, res *Resource) bool {
+	if e == nil {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	allowed := e.defaultAllow
+	for _, role := range e.rolesFor(id) {
+		for _, rule := range role.Rules {
+			deny := strings.EqualFold(rule.Effect, "deny")
+			if !rule.applies(res, deny) {
+				continue
+			}
+			if deny {
+				if matchAny(rule.Actions, "*") || matchAny(rule.Actions, ActionView) {
+					return false
+				}
+				continue
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// Roles returns the names of the roles bound to id.
+func (e *Engine) Roles(id *auth.Identity) []string {
+	if e == nil || id == nil {
+		return nil
+	}
+	var names []string
+	for _, r := range e.rolesFor(id) {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func (e *Engine) rolesFor(id *auth.Identity) []Role {
+	var out []Role
+	for _, role := range e.policy.Roles {
+		if role.boundTo(id) {
+			out = append(out, role)
+		}
+	}
+	return out
+}
+
+func (r Role) boundTo(id *auth.Identity) bool {
+	for _, u := range r.Users {
+		if u == "*" || strings.EqualFold(u, id.Subject) || (id.Email != "" && strings.EqualFold(u, id.Email)) {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		for _, have := range id.Groups {
+			if strings.EqualFold(g, have) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule Rule) scoped() bool {
+	return len(rule.Accounts) > 0 || len(rule.Regions) > 0 || len(rule.Tag1) > 0 || len(rule.Tag2) > 0 || len(rule.Tags) > 0
+}
+
+// applies reports whether a rule with the given effect covers res, erring
+// towards denial for unknown instances.
+func (rule Rule) applies(res *Resource, deny bool) bool {
+	if res != nil && res.Unknown && rule.scoped() {
+		return deny
+	}
+	return rule.matches(res)
+}
+
+func (rule Rule) matches(res *Resource) bool {
+	if !rule.scoped() {
+		return true
+	}
+	if res == nil {
+		return false
+	}
+	if len(rule.Accounts) > 0 && !matchAny(rule.Accounts, res.AccountID) {
+		return false
+	}
+	if len(rule.Regions) > 0 && !matchAny(rule.Regions, res.Region) {
+		return false
+	}
+	if len(rule.Tag1) > 0 && !matchAny(rule.Tag1, res.Tag1) {
+		return false
+	}
+	if len(rule.Tag2) > 0 && !matchAny(rule.Tag2, res.Tag2) {
+		return false
+	}
+	for k, want := range rule.Tags {
+		have, ok := res.Tags[k]
+		if !ok || !matchAny([]string{want}, have) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether value matches any of the glob patterns
+// (case-insensitive).
+func matchAny(patterns []string, value string) bool {
+	v := strings.ToLower(value)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == "*" || p == v {
+			return true
+		}
+		if ok, _ := filepath.Match(p, v); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package rbac
+
+import (
+	"testing"
+
+	"cloudterm-go/internal/auth"
+)
+
+const contractorPolicy = `
+default: deny
+roles:
+  - name: admins
+    groups: [platform]
+    rules:
+      - effect: allow
+        actions: ["*"]
+  - name: contractor
+    users: [contractor@example.com]
+    rules:
+      - effect: allow
+        actions: [instance:view, terminal, "file:*"]
+        accounts: ["1111"]
+        tags:
+          Environment: dev
+      - effect: deny
+        actions: [file:express-download, clone:launch, vault:read]
+`
+
+func TestContractorScope(t *testing.T) {
+	e, err := Parse([]byte(contractorPolicy))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	contractor := &auth.Identity{Subject: "c1", Email: "contractor@example.com"}
+	dev := &Resource{InstanceID: "i-dev", AccountID: "1111", Region: "us-east-1", Tags: map[string]string{"Environment": "dev"}}
+	prod := &Resource{InstanceID: "i-prod", AccountID: "1111", Region: "us-east-1", Tags: map[string]string{"Environment": "prod"}}
+	other := &Resource{InstanceID: "i-other", AccountID: "2222", Tags: map[string]string{"Environment": "dev"}}
+
+	cases := []struct {
+		action string
+		res    *Resource
+		want   bool
+	}{
+		{ActionTerminal, dev, true},
+		{ActionFileUpload, dev, true},
+		{ActionFileExpressDownload, dev, false},
+		{ActionCloneLaunch, dev, false},
+		{ActionVaultRead, dev, false},
+		{ActionPortForward, dev, false},
+		{ActionTerminal, prod, false},
+		{ActionTerminal, other, false},
+		{ActionAuditView, nil, false},
+	}
+	for _, c := range cases {
+		if got := e.Allowed(contractor, c.action, c.res); got != c.want {
+			id := "<global>"
+			if c.res != nil {
+				id = c.res.InstanceID
+			}
+			t.Errorf("Allowed(%s, %s) = %v, want %v", c.action, id, got, c.want)
+		}
+	}
+
+	if !e.CanAccess(contractor, dev) || e.CanAccess(contractor, prod) {
+		t.Error("expected contractor to see only the dev instance")
+	}
+
+	admin := &auth.Identity{Subject: "a1", Groups: []string{"Platform"}}
+	if !e.Allowed(admin, ActionVaultRead, prod) || !e.Allowed(admin, ActionAuditView, nil) {
+		t.Error("expected admin group to be allowed everything")
+	}
+	if got := e.Roles(admin); len(got) != 1 || got[0] != "admins" {
+		t.Errorf("Roles(admin) = %v", got)
+	}
+
+	stranger := &auth.Identity{Subject: "nobody"}
+	if e.Allowed(stranger, ActionView, dev) || e.CanAccess(stranger, dev) {
+		t.Error("expected default deny for unbound users")
+	}
+}
+
+func TestUnknownInstance(t *testing.T) {
+	e, err := Parse([]byte(contractorPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := &Resource{InstanceID: "i-unscanned", Unknown: true}
+	contractor := &auth.Identity{Subject: "c1", Email: "contractor@example.com"}
+	if e.Allowed(contractor, ActionTerminal, unknown) || e.CanAccess(contractor, unknown) {
+		t.Error("scoped allow rule matched an unknown instance")
+	}
+	admin := &auth.Identity{Subject: "a1", Groups: []string{"platform"}}
+	if !e.Allowed(admin, ActionTerminal, unknown) {
+		t.Error("unscoped allow rule should still apply to an unknown instance")
+	}
+
+	open, err := Parse([]byte(`
+default: allow
+roles:
+  - name: everyone
+    users: ["*"]
+    rules:
+      - effect: deny
+        actions: [terminal]
+        tags:
+          Environment: prod
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &auth.Identity{Subject: "u1"}
+	if open.Allowed(user, ActionTerminal, unknown) {
+		t.Error("scoped deny rule did not apply to an unknown instance")
+	}
+	if !open.Allowed(user, ActionFileUpload, unknown) {
+		t.Error("actions without a deny rule should fall back to the default")
+	}
+}
+
+func TestNilEngineAllowsEverything(t *testing.T) {
+	var e *Engine
+	if !e.Allowed(nil, ActionTerminal, nil) || !e.CanAccess(nil, nil) || e.Enabled() {
+		t.Error("nil engine should allow everything")
+	}
+	e, err := Load("")
+	if err != nil || e != nil {
+		t.Fatalf("Load(\"\") = %v, %v; want nil, nil", e, err)
+	}
+}
+
+func TestParseRejectsInvalidPolicy(t *testing.T) {
+	bad := []string{
+		"default: maybe",
+		"roles: [{name: r, rules: [{effect: permit, actions: [terminal]}]}]",
+		"roles: [{name: r, rules: [{effect: allow}]}]",
+	}
+	for _, doc := range bad {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("expected error for %q", doc)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package recordings
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestSuffix is appended to a recording's name to form its sidecar
+// manifest, e.g. i-0abc-web-01.cast.manifest.json.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes a finished recording. Once signed, Signature covers
+// every other field, including the SHA-256 digest and size of the file, so
+// neither the recording nor its metadata can be changed undetected.
+type Manifest struct {
+	Version      int    `json:"version"`
+	File         string `json:"file"`
+	Type         string `json:"type"` // "ssh" or "rdp"
+	InstanceID   string `json:"instance_id,omitempty"`
+	InstanceName string `json:"instance_name,omitempty"`
+	AccountID    string `json:"account_id,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+	Region       string `json:"region,omitempty"`
+	User         string `json:"user,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+	Started      string `json:"started,omitempty"` // RFC 3339
+	Ended        string `json:"ended,omitempty"`
+	Bytes        int64  `json:"bytes"`
+	SHA256       string `json:"sha256,omitempty"`
+	SignedAt     string `json:"signed_at,omitempty"`
+	KeyID        string `json:"key_id,omitempty"`
+	Signature    string `json:"signature,omitempty"` // base64 Ed25519
+}
+
+// Signed reports whether the manifest carries a signature.
+func (m *Manifest) Signed() bool { return m.Signature != "" }
+
+// payload is the signed byte string: the manifest as JSON without the
+// signature.
+func (m *Manifest) payload() []byte {
+	c := *m
+	c.Signature = ""
+	data, _ := json.Marshal(c)
+	return data
+}
+
+// ManifestPath returns the sidecar path for a recording.
+func ManifestPath(recordingPath string) string {
+	return recordingPath + ManifestSuffix
+}
+
+// ReadManifest loads a sidecar manifest.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// WriteManifest atomically writes a sidecar manifest.
+func WriteManifest(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Signer signs recording manifests with an Ed25519 server key.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// LoadSigner reads a PEM-encoded PKCS #8 Ed25519 private key from path,
+// generating and saving a new key if the file does not exist.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, fmt.Errorf("create key dir: %w", err)
+			}
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, fmt.Errorf("write signing key: %w", err)
+		}
+		return NewSigner(priv), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: expected a PEM PRIVATE KEY", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return NewSigner(priv), nil
+}
+
+// NewSigner wraps an Ed25519 private key.
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// PublicKey returns the key that verifies this signer's manifests.
+func (s *Signer) PublicKey() ed25519.PublicKey { return s.key.Public().(ed25519.PublicKey) }
+
+// KeyID returns the signer's key fingerprint.
+func (s *Signer) KeyID() string { return s.keyID }
+
+// KeyID fingerprints a public key (first 16 hex digits of its SHA-256).
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign digests content and signs m.
+func (s *Signer) Sign(m *Manifest, content io.Reader) error {
+	sum, n, err := digest(content)
+	if err != nil {
+		return err
+	}
+	m.Version = 1
+	m.Bytes = n
+	m.SHA256 = sum
+	m.SignedAt = time.Now().UTC().Format(time.RFC3339)
+	m.KeyID = s.keyID
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, m.payload()))
+	return nil
+}
+
+// LoadPublicKey reads an Ed25519 key from a PEM file holding either the
+// PUBLIC KEY or the PRIVATE KEY.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		var priv any
+		if priv, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if k, ok := priv.(ed25519.PrivateKey); ok {
+				key = k.Public()
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// Verification failures.
+var (
+	ErrUnsigned       = errors.New("recording manifest is not signed")
+	ErrWrongKey       = errors.New("manifest was signed with a different key")
+	ErrBadSignature   = errors.New("manifest signature is invalid")
+	ErrDigestMismatch = errors.New("recording does not match its manifest")
+)
+
+// VerifyManifest checks m's signature with pub and that content matches
+// the signed digest and size.
+func VerifyManifest(m *Manifest, content io.Reader, pub ed25519.PublicKey) error {
+	if !m.Signed() {
+		return ErrUnsigned
+	}
+	if m.KeyID != KeyID(pub) {
+		return fmt.Errorf("%w (manifest key %s, trusted key %s)", ErrWrongKey, m.KeyID, KeyID(pub))
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(pub, m.payload(), sig) {
+		return ErrBadSignature
+	}
+	sum, n, err := digest(content)
+	if err != nil {
+		return err
+	}
+	if n != m.Bytes || sum != m.SHA256 {
+		return fmt.Errorf("%w (sha256 %s, %d bytes; manifest %s, %d bytes)", ErrDigestMismatch, sum, n, m.SHA256, m.Bytes)
+	}
+	return nil
+}
+
+func digest(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// castStart returns the start time recorded in a .cast header.
+func castStart(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	line, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	var header struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if json.Unmarshal(line, &header) != nil || header.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(header.Timestamp, 0)
+}
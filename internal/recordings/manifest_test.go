@@ -0,0 +1,133 @@
+package recordings
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadSignerGeneratesAndReloadsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "signing.key")
+	s1, err := LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.KeyID() != s2.KeyID() {
+		t.Fatalf("reloaded key differs: %s vs %s", s1.KeyID(), s2.KeyID())
+	}
+	pub, err := LoadPublicKey(path)
+	if err != nil || !pub.Equal(s1.PublicKey()) {
+		t.Fatalf("LoadPublicKey = %v, %v", pub, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestSealAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	store := NewStore(dir, Policy{}, log.New(io.Discard, "", 0))
+	store.SetSigner(NewSigner(priv))
+	ctx := context.Background()
+
+	// RDP: metadata is saved at start and signed once the file settles.
+	name := "i-0abc-win-01.guac"
+	if err := store.Begin(name, Manifest{User: "alice@example.com", AccountID: "111122223333", Started: "2025-01-02T03:04:05Z"}); err != nil {
+		t.Fatal(err)
+	}
+	writeRecording(t, dir, name, 64, time.Hour)
+	if _, err := store.Verify(ctx, name); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned before sealing, got %v", err)
+	}
+	store.Sweep(ctx)
+	m, err := store.Verify(ctx, name)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if m.User != "alice@example.com" || m.AccountID != "111122223333" || m.InstanceID != "i-0abc" ||
+		m.Type != "rdp" || m.Bytes != 64 || m.Started != "2025-01-02T03:04:05Z" || m.Ended == "" {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	if recs := store.List(); len(recs) != 1 || !recs[0].Signed {
+		t.Errorf("listing should mark the recording signed: %+v", recs)
+	}
+
+	// Editing the recording breaks the digest.
+	path := filepath.Join(dir, name)
+	data, _ := os.ReadFile(path)
+	data[10] ^= 1
+	os.WriteFile(path, data, 0644)
+	if _, err := store.Verify(ctx, name); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("expected ErrDigestMismatch, got %v", err)
+	}
+	data[10] ^= 1
+	os.WriteFile(path, data, 0644)
+
+	// Editing the manifest breaks the signature.
+	mp := ManifestPath(path)
+	m.User = "mallory"
+	WriteManifest(mp, m)
+	if _, err := store.Verify(ctx, name); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature, got %v", err)
+	}
+
+	// Re-signing with another key is detected.
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	f, _ := os.Open(path)
+	NewSigner(other).Sign(m, f)
+	f.Close()
+	WriteManifest(mp, m)
+	if _, err := store.Verify(ctx, name); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+
+	if err := store.Delete(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(mp); !os.IsNotExist(err) {
+		t.Error("delete should remove the manifest")
+	}
+}
+
+func TestVerifyOffloadedRecording(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	fake := &fakeS3{objects: map[string][]byte{}}
+	store := NewStore(dir, Policy{LocalKeep: time.Minute}, log.New(io.Discard, "", 0))
+	store.SetSigner(NewSigner(priv))
+	store.SetBucket(newBucket(fake, BucketConfig{Bucket: "rec"}))
+	ctx := context.Background()
+
+	name := "i-0abc-web-01.cast"
+	writeRecording(t, dir, name, 32, time.Hour)
+	if err := store.Seal(name, &Manifest{User: "bob", SessionID: "term-1"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Sweep(ctx)
+	store.now = func() time.Time { return time.Now().Add(time.Hour) }
+	store.Sweep(ctx)
+	if _, ok := store.LocalPath(name); ok {
+		t.Fatal("local copy should be pruned")
+	}
+	if _, ok := fake.objects[name+ManifestSuffix]; !ok {
+		t.Fatal("manifest should be offloaded with the recording")
+	}
+
+	os.Remove(store.manifestPath(name))
+	m, err := store.Verify(ctx, name)
+	if err != nil || m.User != "bob" {
+		t.Fatalf("verify offloaded recording: %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,70 @@
+package recordings
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy controls how long recordings are kept and how much local disk they
+// may use.
+type Policy struct {
+	// MaxAge deletes recordings (local and offloaded copies) older than
+	// this. Zero keeps them forever.
+	MaxAge time.Duration
+	// MaxAgeByEnv overrides MaxAge for instances whose EnvTag value
+	// matches a key (case-insensitive). A zero value keeps them forever.
+	MaxAgeByEnv map[string]time.Duration
+	// EnvTag is the instance tag that selects a MaxAgeByEnv rule.
+	EnvTag string
+	// MaxLocalBytes caps the size of the recording directory. The oldest
+	// local copies are removed first; recordings that still have to be
+	// offloaded are never removed to make room. Zero disables the cap.
+	MaxLocalBytes int64
+	// LocalKeep is how long the local copy of an offloaded recording is
+	// kept after upload. Zero keeps it until another rule removes it.
+	LocalKeep time.Duration
+}
+
+// maxAgeFor returns the age limit for a recording from the given
+// environment.
+func (p Policy) maxAgeFor(env string) time.Duration {
+	if env != "" {
+		for k, v := range p.MaxAgeByEnv {
+			if strings.EqualFold(k, env) {
+				return v
+			}
+		}
+	}
+	return p.MaxAge
+}
+
+// ParseEnvRetention parses "prod=365,dev=14" (days per environment tag
+// value) into MaxAgeByEnv form.
+func ParseEnvRetention(s string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		env, days, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(days))
+		if !ok || strings.TrimSpace(env) == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid retention rule %q (want env=days)", part)
+		}
+		out[strings.TrimSpace(env)] = time.Duration(n) * 24 * time.Hour
+	}
+	return out, nil
+}
+
+// InstanceID extracts the instance ID from a recording name built
+// by session.RecordingFilename ("<instanceID>-<name>-<timestamp>.<ext>").
+func InstanceID(name string) string {
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) < 2 || parts[0] != "i" {
+		return ""
+	}
+	return "i-" + parts[1]
+}
@@ -0,0 +1,203 @@
+package recordings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Server-side encryption modes for offloaded recordings.
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+	SSENone   = "none"
+)
+
+// BucketConfig describes the S3-compatible bucket recordings are offloaded
+// to. Endpoint and PathStyle are only needed for non-AWS stores like MinIO.
+type BucketConfig struct {
+	Bucket    string
+	Prefix    string
+	Region    string
+	Profile   string
+	Endpoint  string
+	PathStyle bool
+	AccessKey string // optional static credentials; otherwise the default chain
+	SecretKey string
+	SSE       string // SSEAES256 (default), SSEKMS or SSENone
+	KMSKeyID  string
+}
+
+// objectAPI is the subset of the S3 client used by Bucket.
+type objectAPI interface {
+	PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// Bucket stores recordings as objects under a key prefix.
+type Bucket struct {
+	api      objectAPI
+	bucket   string
+	prefix   string
+	sse      string
+	kmsKeyID string
+}
+
+// NewBucket creates an S3 client for cfg.
+func NewBucket(ctx context.Context, cfg BucketConfig) (*Bucket, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("recording bucket name required")
+	}
+	switch cfg.SSE {
+	case "":
+		cfg.SSE = SSEAES256
+	case SSEAES256, SSEKMS, SSENone:
+	default:
+		return nil, fmt.Errorf("unsupported server-side encryption %q", cfg.SSE)
+	}
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(cfg.Region)}
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
+	return newBucket(client, cfg), nil
+}
+
+func newBucket(api objectAPI, cfg BucketConfig) *Bucket {
+	if cfg.SSE == "" {
+		cfg.SSE = SSEAES256
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &Bucket{api: api, bucket: cfg.Bucket, prefix: prefix, sse: cfg.SSE, kmsKeyID: cfg.KMSKeyID}
+}
+
+// Key returns the object key for a recording.
+func (b *Bucket) Key(name string) string {
+	return b.prefix + path.Base(name)
+}
+
+// Upload copies the local file at path to the recording's object.
+func (b *Bucket) Upload(ctx context.Context, name, path string, meta map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	in := &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(b.Key(name)),
+		Body:          f,
+		ContentLength: aws.Int64(info.Size()),
+		ContentType:   aws.String(contentType(name)),
+		Metadata:      meta,
+	}
+	switch b.sse {
+	case SSEAES256:
+		in.ServerSideEncryption = s3types.ServerSideEncryptionAes256
+	case SSEKMS:
+		in.ServerSideEncryption = s3types.ServerSideEncryptionAwsKms
+		if b.kmsKeyID != "" {
+			in.SSEKMSKeyId = aws.String(b.kmsKeyID)
+		}
+	}
+	if _, err := b.api.PutObject(ctx, in); err != nil {
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	return nil
+}
+
+// Object is an open recording streamed from the bucket.
+type Object struct {
+	Body         io.ReadCloser
+	Size         int64  // length of Body
+	ContentRange string // set for ranged reads
+	ContentType  string
+	ETag         string
+	LastModified string
+}
+
+// ErrNotFound is returned when a recording does not exist.
+var ErrNotFound = errors.New("recording not found")
+
+// Open streams a recording. byteRange is an HTTP Range header value or "".
+func (b *Bucket) Open(ctx context.Context, name, byteRange string) (*Object, error) {
+	in := &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(b.Key(name))}
+	if byteRange != "" {
+		in.Range = aws.String(byteRange)
+	}
+	out, err := b.api.GetObject(ctx, in)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound") {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("download %s: %w", name, err)
+	}
+	obj := &Object{
+		Body:         out.Body,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentRange: aws.ToString(out.ContentRange),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+	}
+	if out.LastModified != nil {
+		obj.LastModified = out.LastModified.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	}
+	if obj.ContentType == "" {
+		obj.ContentType = contentType(name)
+	}
+	return obj, nil
+}
+
+// Delete removes a recording's object. Deleting a missing object succeeds.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	_, err := b.api.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(b.Key(name))})
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	return nil
+}
+
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".cast":
+		return "application/x-asciicast"
+	case ".mp4":
+		return "video/mp4"
+	case ".json":
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
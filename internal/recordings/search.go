@@ -0,0 +1,471 @@
+package recordings
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cloudterm-go/internal/suggest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Segment kinds.
+const (
+	KindCommand = "command"
+	KindOutput  = "output"
+)
+
+const (
+	maxSegmentLen     = 1000 // characters kept per line
+	maxMatchesPerFile = 50
+	indexQueueSize    = 256
+)
+
+var (
+	docsBucket  = []byte("docs")
+	termsBucket = []byte("terms")
+)
+
+// promptLine matches a shell prompt followed by a command, e.g.
+// "[ec2-user@ip-10-0-0-1 ~]$ rm -rf /tmp/x", "root@host:/# df -h" or a
+// bare "$ ls".
+var promptLine = regexp.MustCompile(`^(?:[^\s$#%>][^$#%>]{0,80}[$#%>]|\$) (.+)$`)
+
+// Segment is one command or output line of a recording.
+type Segment struct {
+	Offset float64 `json:"offset"` // seconds from the start of the recording
+	Kind   string  `json:"kind"`
+	Text   string  `json:"text"`
+}
+
+type indexDoc struct {
+	Name      string    `json:"name"`
+	Started   time.Time `json:"started"`
+	Duration  float64   `json:"duration"`
+	IndexedAt time.Time `json:"indexed_at"`
+	Segments  []Segment `json:"segments"`
+}
+
+// SearchResult lists the matching moments in one recording.
+type SearchResult struct {
+	Name     string    `json:"name"`
+	Started  string    `json:"started"`
+	Duration float64   `json:"duration"`
+	Matches  []Segment `json:"matches"`
+	// Truncated is set when more than maxMatchesPerFile segments matched.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Index is a full-text index of SSH recordings stored in bbolt. Terms map
+// to recordings; matching segments are found by scanning the recording's
+// stored segments.
+type Index struct {
+	db     *bolt.DB
+	logger *log.Logger
+	queue  chan string
+	done   chan struct{}
+
+	mu     sync.Mutex // guards closed
+	closed bool
+}
+
+// OpenIndex opens or creates the index database at path and starts a
+// worker that indexes files passed to Enqueue.
+func OpenIndex(path string, logger *log.Logger) (*Index, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("create index dir: %w", err)
+		}
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bbolt: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(docsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(termsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init index: %w", err)
+	}
+	idx := &Index{db: db, logger: logger, queue: make(chan string, indexQueueSize), done: make(chan struct{})}
+	go idx.run()
+	return idx, nil
+}
+
+func (idx *Index) run() {
+	defer close(idx.done)
+	for path := range idx.queue {
+		if err := idx.IndexFile(path); err != nil {
+			idx.logger.Printf("recordings: index %s: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// Enqueue schedules a .cast file for indexing without blocking.
+func (idx *Index) Enqueue(path string) {
+	if filepath.Ext(path) != ".cast" {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.closed {
+		return
+	}
+	select {
+	case idx.queue <- path:
+	default:
+		idx.logger.Printf("recordings: index queue full, skipping %s", filepath.Base(path))
+	}
+}
+
+// Backfill indexes the .cast files in dir that are not indexed yet,
+// skipping those in active. It runs synchronously and stops once the index
+// is closed.
+func (idx *Index) Backfill(dir string, active map[string]bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".cast" || active[name] || idx.Has(name) {
+			continue
+		}
+		err := idx.IndexFile(filepath.Join(dir, name))
+		if errors.Is(err, bolt.ErrDatabaseNotOpen) {
+			return
+		}
+		if err != nil {
+			idx.logger.Printf("recordings: index %s: %v", name, err)
+		}
+	}
+}
+
+// Close stops the worker after pending files are indexed and closes the
+// database.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	idx.closed = true
+	close(idx.queue)
+	idx.mu.Unlock()
+	<-idx.done
+	return idx.db.Close()
+}
+
+// Has reports whether a recording is indexed.
+func (idx *Index) Has(name string) bool {
+	found := false
+	idx.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(docsBucket).Get([]byte(name)) != nil
+		return nil
+	})
+	return found
+}
+
+// IndexFile parses a .cast file and (re)indexes it.
+func (idx *Index) IndexFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	doc, err := parseCast(f)
+	if err != nil {
+		return err
+	}
+	doc.Name = filepath.Base(path)
+	doc.IndexedAt = time.Now().UTC()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		if err := removeDoc(tx, doc.Name); err != nil {
+			return err
+		}
+		terms := tx.Bucket(termsBucket)
+		for term := range docTerms(doc) {
+			if err := terms.Put(termKey(term, doc.Name), nil); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(docsBucket).Put([]byte(doc.Name), data)
+	})
+}
+
+// Remove drops a recording from the index.
+func (idx *Index) Remove(name string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return removeDoc(tx, name)
+	})
+}
+
+func removeDoc(tx *bolt.Tx, name string) error {
+	docs := tx.Bucket(docsBucket)
+	data := docs.Get([]byte(name))
+	if data == nil {
+		return nil
+	}
+	var doc indexDoc
+	if err := json.Unmarshal(data, &doc); err == nil {
+		terms := tx.Bucket(termsBucket)
+		for term := range docTerms(&doc) {
+			if err := terms.Delete(termKey(term, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return docs.Delete([]byte(name))
+}
+
+func termKey(term, name string) []byte {
+	return []byte(term + "\x00" + name)
+}
+
+// Search returns recordings containing every term of query, newest first,
+// with the matching segments. kind restricts matches to KindCommand or
+// KindOutput; "" matches both.
+func (idx *Index) Search(query, kind string, limit int) ([]SearchResult, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, errors.New("query required")
+	}
+	results := []SearchResult{}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		var candidates map[string]bool
+		for _, term := range terms {
+			names := make(map[string]bool)
+			prefix := termKey(term, "")
+			c := tx.Bucket(termsBucket).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				name := string(k[len(prefix):])
+				if candidates == nil || candidates[name] {
+					names[name] = true
+				}
+			}
+			candidates = names
+			if len(candidates) == 0 {
+				return nil
+			}
+		}
+
+		docs := tx.Bucket(docsBucket)
+		for name := range candidates {
+			var doc indexDoc
+			if err := json.Unmarshal(docs.Get([]byte(name)), &doc); err != nil {
+				continue
+			}
+			res := SearchResult{Name: name, Started: doc.Started.Format(time.RFC3339), Duration: doc.Duration}
+			for _, seg := range doc.Segments {
+				if (kind != "" && seg.Kind != kind) || !containsAll(seg.Text, terms) {
+					continue
+				}
+				if len(res.Matches) == maxMatchesPerFile {
+					res.Truncated = true
+					break
+				}
+				res.Matches = append(res.Matches, seg)
+			}
+			if len(res.Matches) > 0 {
+				results = append(results, res)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Started != results[j].Started {
+			return results[i].Started > results[j].Started
+		}
+		return results[i].Name < results[j].Name
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// tokenize splits text into lowercase words of letters, digits and '_'.
+func tokenize(text string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(w) > 64 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	return out
+}
+
+func docTerms(doc *indexDoc) map[string]bool {
+	terms := make(map[string]bool)
+	for _, seg := range doc.Segments {
+		for _, t := range tokenize(seg.Text) {
+			terms[t] = true
+		}
+	}
+	return terms
+}
+
+func containsAll(text string, terms []string) bool {
+	have := make(map[string]bool)
+	for _, t := range tokenize(text) {
+		have[t] = true
+	}
+	for _, t := range terms {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCast splits an asciicast v2 recording into timestamped commands and
+// output lines. Commands come from "i" events when the recording captured
+// keystrokes, otherwise from output lines that start with a shell prompt.
+func parseCast(f io.Reader) (*indexDoc, error) {
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		return nil, errors.New("empty recording")
+	}
+	var header struct {
+		Version   int   `json:"version"`
+		Timestamp int64 `json:"timestamp"`
+	}
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil || header.Version != 2 {
+		return nil, errors.New("not an asciicast v2 recording")
+	}
+
+	type event struct {
+		at   float64
+		code string
+		data string
+	}
+	var events []event
+	hasInput := false
+	for sc.Scan() {
+		var raw []json.RawMessage
+		if json.Unmarshal(sc.Bytes(), &raw) != nil || len(raw) < 3 {
+			continue
+		}
+		var ev event
+		if json.Unmarshal(raw[0], &ev.at) != nil || json.Unmarshal(raw[1], &ev.code) != nil || json.Unmarshal(raw[2], &ev.data) != nil {
+			continue
+		}
+		if ev.code == "i" {
+			hasInput = true
+		}
+		events = append(events, ev)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	doc := &indexDoc{Started: time.Unix(header.Timestamp, 0).UTC()}
+	out := &lineBuffer{}
+	in := &lineBuffer{enterOnCR: true}
+	emitOutput := func(at float64, line string) {
+		if m := promptLine.FindStringSubmatch(line); m != nil {
+			// With keystrokes recorded the prompt line is just the echo.
+			if !hasInput {
+				doc.Segments = append(doc.Segments, Segment{Offset: at, Kind: KindCommand, Text: strings.TrimSpace(m[1])})
+			}
+			return
+		}
+		doc.Segments = append(doc.Segments, Segment{Offset: at, Kind: KindOutput, Text: line})
+	}
+	emitInput := func(at float64, line string) {
+		doc.Segments = append(doc.Segments, Segment{Offset: at, Kind: KindCommand, Text: line})
+	}
+	for _, ev := range events {
+		switch ev.code {
+		case "o":
+			out.feed(ev.at, suggest.StripANSI([]byte(ev.data)), emitOutput)
+		case "i":
+			in.feed(ev.at, suggest.StripANSI([]byte(ev.data)), emitInput)
+		}
+		doc.Duration = ev.at
+	}
+	out.flush(emitOutput)
+	in.flush(emitInput)
+	sort.SliceStable(doc.Segments, func(i, j int) bool { return doc.Segments[i].Offset < doc.Segments[j].Offset })
+	return doc, nil
+}
+
+// lineBuffer assembles terminal text into lines, applying backspaces and
+// carriage returns, and remembers when each line started.
+type lineBuffer struct {
+	enterOnCR bool // keystrokes: Enter sends '\r'
+	line      []rune
+	start     float64
+	started   bool
+	cr        bool
+}
+
+func (b *lineBuffer) feed(at float64, data []byte, emit func(at float64, line string)) {
+	for _, r := range string(data) {
+		if b.cr && r != '\n' {
+			// A lone carriage return redraws the line.
+			b.line = b.line[:0]
+			b.started = false
+		}
+		b.cr = false
+		switch r {
+		case '\r':
+			if b.enterOnCR {
+				b.flush(emit)
+			} else {
+				b.cr = true
+			}
+		case '\n':
+			b.flush(emit)
+		case '\b', 0x7f:
+			if len(b.line) > 0 {
+				b.line = b.line[:len(b.line)-1]
+			}
+		default:
+			if !unicode.IsPrint(r) && r != '\t' {
+				continue
+			}
+			if !b.started {
+				b.start, b.started = at, true
+			}
+			if len(b.line) < maxSegmentLen {
+				b.line = append(b.line, r)
+			}
+		}
+	}
+}
+
+func (b *lineBuffer) flush(emit func(at float64, line string)) {
+	if text := strings.TrimSpace(string(b.line)); text != "" {
+		emit(b.start, text)
+	}
+	b.line = b.line[:0]
+	b.started = false
+	b.cr = false
+}
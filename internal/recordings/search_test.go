@@ -0,0 +1,103 @@
+package recordings
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCast(t *testing.T, dir, name string, events ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	body := `{"version":2,"width":80,"height":24,"timestamp":1760000000}` + "\n" + strings.Join(events, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openTestIndex(t *testing.T) *Index {
+	t.Helper()
+	idx, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func TestIndexSearchPromptCommands(t *testing.T) {
+	dir := t.TempDir()
+	idx := openTestIndex(t)
+	path := writeCast(t, dir, "i-0abc-web-01.cast",
+		`[0.5, "o", "\u001b[32m[ec2-user@ip-10-0-0-1 ~]$ \u001b[0m"]`,
+		`[1.2, "o", "rm -rf /tmp/cache\r\n"]`,
+		`[3.0, "o", "[ec2-user@ip-10-0-0-1 ~]$ dmesg | tail\r\n"]`,
+		`[3.4, "o", "Out of memory: Killed process 4242 (java)\r\n[  12.1] oom-kill:constraint=CONSTRAINT_NONE\r\n"]`,
+		`[4.0, "o", "progress 10%\rprogress 100%\r\n"]`,
+	)
+	if err := idx.IndexFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := idx.Search("rm", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || len(res[0].Matches) != 1 {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if m := res[0].Matches[0]; m.Kind != KindCommand || m.Text != "rm -rf /tmp/cache" || m.Offset != 0.5 {
+		t.Errorf("unexpected match %+v", m)
+	}
+
+	res, _ = idx.Search("OOM", KindOutput, 10)
+	if len(res) != 1 || len(res[0].Matches) != 1 || res[0].Matches[0].Offset != 3.4 {
+		t.Fatalf("unexpected OOM results %+v", res)
+	}
+	if res, _ := idx.Search("oom", KindCommand, 10); len(res) != 0 {
+		t.Errorf("kind filter ignored: %+v", res)
+	}
+	if res, _ := idx.Search("killed java", "", 10); len(res) != 1 || res[0].Matches[0].Text != "Out of memory: Killed process 4242 (java)" {
+		t.Errorf("unexpected multi-term results %+v", res)
+	}
+	if res, _ := idx.Search("progress 10", "", 10); len(res) != 0 {
+		t.Errorf("carriage return should overwrite the line: %+v", res)
+	}
+	if _, err := idx.Search("  ", "", 10); err == nil {
+		t.Error("expected an error for an empty query")
+	}
+
+	if err := idx.Remove("i-0abc-web-01.cast"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := idx.Search("rm", "", 10); len(res) != 0 || idx.Has("i-0abc-web-01.cast") {
+		t.Errorf("removed recording still found: %+v", res)
+	}
+}
+
+func TestIndexSearchCapturedInput(t *testing.T) {
+	dir := t.TempDir()
+	idx := openTestIndex(t)
+	writeCast(t, dir, "i-0def-db-01.cast",
+		`[0.1, "o", "$ "]`,
+		`[1.0, "i", "sudo systemctl restrat"]`,
+		`[1.5, "i", "\u007f\u007f\u007f\u007ftart nginx\r"]`,
+		`[1.6, "o", "sudo systemctl restart nginx\r\n$ "]`,
+	)
+	idx.Backfill(dir, nil)
+	if !idx.Has("i-0def-db-01.cast") {
+		t.Fatal("backfill did not index the recording")
+	}
+
+	res, _ := idx.Search("restart nginx", "", 10)
+	if len(res) != 1 || len(res[0].Matches) != 1 {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if m := res[0].Matches[0]; m.Kind != KindCommand || m.Text != "sudo systemctl restart nginx" || m.Offset != 1.0 {
+		t.Errorf("unexpected match %+v", m)
+	}
+}
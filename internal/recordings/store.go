@@ -0,0 +1,681 @@
+// Package recordings manages the session recording directory: retention
+// rules and offloading finished recordings to S3-compatible storage.
+package recordings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexFile records which recordings have been offloaded. It lives in the
+// recording directory; its leading dot keeps it out of listings.
+const indexFile = ".offload.json"
+
+// settleTime is how long a recording must be unmodified before it counts as
+// finished. Guacamole writes .guac files itself, so there is no other
+// signal that an RDP recording has ended.
+const settleTime = 5 * time.Minute
+
+// Recording describes a recording available to the browser.
+type Recording struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	ModTime   string `json:"mod_time"`
+	Type      string `json:"type"`      // "ssh" or "rdp"
+	HasMP4    bool   `json:"has_mp4"`   // true if converted .mp4 exists
+	Local     bool   `json:"local"`     // a copy is on local disk
+	Offloaded bool   `json:"offloaded"` // a copy is in the bucket
+	Signed    bool   `json:"signed"`    // a signed manifest exists
+}
+
+type offloadEntry struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	Env        string    `json:"env,omitempty"`
+	Manifest   bool      `json:"manifest,omitempty"` // signed manifest uploaded too
+}
+
+// Store applies a retention Policy to a recording directory and, when a
+// Bucket is set, offloads finished recordings and serves them once the
+// local copy is gone.
+type Store struct {
+	dir    string
+	policy Policy
+	logger *log.Logger
+	bucket *Bucket
+	search *Index
+	signer *Signer
+	now    func() time.Time
+
+	active   func() map[string]bool
+	tags     func(instanceID string) map[string]string
+	onRemove func(name, reason string)
+
+	sweepMu sync.Mutex // serializes Sweep
+	mu      sync.Mutex // guards index
+	index   map[string]offloadEntry
+}
+
+// NewStore manages the recordings in dir.
+func NewStore(dir string, policy Policy, logger *log.Logger) *Store {
+	s := &Store{dir: dir, policy: policy, logger: logger, now: time.Now, index: make(map[string]offloadEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &s.index); err != nil {
+			logger.Printf("recordings: ignoring corrupt offload index: %v", err)
+			s.index = make(map[string]offloadEntry)
+		}
+	}
+	return s
+}
+
+// SetBucket enables offloading to b.
+func (s *Store) SetBucket(b *Bucket) { s.bucket = b }
+
+// SetActiveFunc registers a function returning the recordings still being
+// written; they are never uploaded or removed.
+func (s *Store) SetActiveFunc(fn func() map[string]bool) { s.active = fn }
+
+// SetTagLookup registers a function returning an instance's tags, used to
+// pick per-environment retention rules.
+func (s *Store) SetTagLookup(fn func(instanceID string) map[string]string) { s.tags = fn }
+
+// SetRemoveHook registers a function called whenever a retention rule
+// deletes a recording for good.
+func (s *Store) SetRemoveHook(fn func(name, reason string)) { s.onRemove = fn }
+
+// SetIndex enables full-text search. Deleted recordings are dropped from
+// idx; the store closes it.
+func (s *Store) SetIndex(idx *Index) { s.search = idx }
+
+// SetSigner enables signed manifests for finished recordings.
+func (s *Store) SetSigner(signer *Signer) { s.signer = signer }
+
+// Search runs a full-text query against the recording index.
+func (s *Store) Search(query, kind string, limit int) ([]SearchResult, error) {
+	if s.search == nil {
+		return nil, errors.New("recording search is disabled")
+	}
+	return s.search.Search(query, kind, limit)
+}
+
+// Close closes the search index, if any.
+func (s *Store) Close() error {
+	if s.search != nil {
+		return s.search.Close()
+	}
+	return nil
+}
+
+// ErrInvalidName is returned by Delete for a name that is not a recording,
+// such as a manifest or the offload index; the store manages those itself.
+var ErrInvalidName = errors.New("not a recording")
+
+// OffloadEnabled reports whether a bucket is configured.
+func (s *Store) OffloadEnabled() bool { return s.bucket != nil }
+
+func recordingType(name string) string {
+	switch filepath.Ext(name) {
+	case ".cast":
+		return "ssh"
+	case ".guac":
+		return "rdp"
+	}
+	return ""
+}
+
+// servable reports whether name is a recording or its converted .mp4, the
+// files the browser may fetch.
+func servable(name string) bool {
+	return !strings.HasPrefix(name, ".") && (recordingType(name) != "" || filepath.Ext(name) == ".mp4")
+}
+
+type localFile struct {
+	name    string
+	recSize int64
+	size    int64 // including the converted .mp4
+	modTime time.Time
+	hasMP4  bool
+}
+
+func (s *Store) localFiles() []localFile {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	var files []localFile
+	for _, e := range entries {
+		if e.IsDir() || recordingType(e.Name()) == "" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		f := localFile{name: e.Name(), recSize: info.Size(), size: info.Size(), modTime: info.ModTime()}
+		if mp4, err := os.Stat(s.mp4Path(f.name)); err == nil {
+			f.hasMP4 = true
+			f.size += mp4.Size()
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func (s *Store) mp4Path(name string) string {
+	return filepath.Join(s.dir, strings.TrimSuffix(name, filepath.Ext(name))+".mp4")
+}
+
+// List returns local and offloaded recordings, newest first.
+func (s *Store) List() []Recording {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	recs := []Recording{}
+	for _, f := range s.localFiles() {
+		_, offloaded := s.index[f.name]
+		seen[f.name] = true
+		recs = append(recs, Recording{
+			Name:      f.name,
+			Size:      f.recSize,
+			ModTime:   f.modTime.Format("2006-01-02T15:04:05Z"),
+			Type:      recordingType(f.name),
+			HasMP4:    f.hasMP4,
+			Local:     true,
+			Offloaded: offloaded,
+			Signed:    s.signed(f.name),
+		})
+	}
+	for name, e := range s.index {
+		if seen[name] || s.bucket == nil {
+			continue
+		}
+		recs = append(recs, Recording{
+			Name:      name,
+			Size:      e.Size,
+			ModTime:   e.ModTime.Format("2006-01-02T15:04:05Z"),
+			Type:      recordingType(name),
+			Offloaded: true,
+			Signed:    e.Manifest || s.signed(name),
+		})
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].ModTime > recs[j].ModTime
+	})
+	return recs
+}
+
+// LocalPath returns the path of a recording's local copy, or of its
+// converted .mp4, if it exists. Manifests and other files in the directory
+// are never returned.
+func (s *Store) LocalPath(name string) (string, bool) {
+	name = filepath.Base(name)
+	if !servable(name) {
+		return "", false
+	}
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// OpenRemote streams an offloaded recording from the bucket.
+func (s *Store) OpenRemote(ctx context.Context, name, byteRange string) (*Object, error) {
+	name = filepath.Base(name)
+	s.mu.Lock()
+	_, ok := s.index[name]
+	s.mu.Unlock()
+	if s.bucket == nil || !ok {
+		return nil, ErrNotFound
+	}
+	return s.bucket.Open(ctx, name, byteRange)
+}
+
+// Delete removes a recording's local copy, converted .mp4 and manifest and,
+// if it was offloaded, its objects. It returns ErrInvalidName for anything
+// but a .cast or .guac recording and ErrNotFound if no copy exists.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	name = filepath.Base(name)
+	if strings.HasPrefix(name, ".") || recordingType(name) == "" {
+		return ErrInvalidName
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	err := os.Remove(filepath.Join(s.dir, name))
+	switch {
+	case err == nil:
+		found = true
+	case !os.IsNotExist(err):
+		return err
+	}
+	if e, ok := s.index[name]; ok && s.bucket != nil {
+		if err := s.deleteRemoteLocked(ctx, name, e); err != nil {
+			return err
+		}
+		found = true
+	}
+	os.Remove(s.mp4Path(name))
+	os.Remove(s.manifestPath(name))
+	if !found {
+		return ErrNotFound
+	}
+	s.unindex(name)
+	return nil
+}
+
+func (s *Store) unindex(name string) {
+	if s.search == nil {
+		return
+	}
+	if err := s.search.Remove(name); err != nil {
+		s.logger.Printf("recordings: unindex %s: %v", name, err)
+	}
+}
+
+// Run sweeps the directory every interval until ctx is cancelled.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep uploads finished recordings, then applies the retention policy:
+// expired recordings are deleted everywhere, local copies of offloaded
+// recordings are pruned after LocalKeep, and the oldest local copies are
+// removed while the directory exceeds MaxLocalBytes.
+func (s *Store) Sweep(ctx context.Context) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+
+	now := s.now()
+	active := map[string]bool{}
+	if s.active != nil {
+		active = s.active()
+	}
+	finished := func(f localFile) bool {
+		return !active[f.name] && now.Sub(f.modTime) >= settleTime
+	}
+
+	files := s.localFiles()
+	if s.signer != nil {
+		for _, f := range files {
+			if finished(f) && !s.signed(f.name) {
+				if err := s.seal(f.name, nil); err != nil {
+					s.logger.Printf("recordings: sign %s: %v", f.name, err)
+				}
+			}
+		}
+	}
+	if s.bucket != nil {
+		for _, f := range files {
+			if ctx.Err() != nil {
+				return
+			}
+			if finished(f) && !s.offloaded(f.name) {
+				s.upload(ctx, f)
+			}
+			if e, ok := s.entry(f.name); ok && !e.Manifest {
+				s.uploadManifest(ctx, f.name)
+			}
+		}
+	}
+
+	// Age limits apply to local and offloaded copies alike.
+	local := make(map[string]localFile, len(files))
+	for _, f := range files {
+		local[f.name] = f
+	}
+	s.mu.Lock()
+	type candidate struct {
+		name    string
+		modTime time.Time
+		env     string
+	}
+	var all []candidate
+	for _, f := range files {
+		all = append(all, candidate{f.name, f.modTime, s.index[f.name].Env})
+	}
+	for name, e := range s.index {
+		if _, ok := local[name]; !ok {
+			all = append(all, candidate{name, e.ModTime, e.Env})
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range all {
+		if f, ok := local[c.name]; ok && !finished(f) {
+			continue
+		}
+		env := c.env
+		if env == "" {
+			env = s.envFor(c.name)
+		}
+		if limit := s.policy.maxAgeFor(env); limit > 0 && now.Sub(c.modTime) > limit {
+			if s.remove(ctx, c.name, "expired") {
+				delete(local, c.name)
+			}
+		}
+	}
+
+	// Local copies that are safely offloaded.
+	if s.bucket != nil && s.policy.LocalKeep > 0 {
+		for name, f := range local {
+			e, ok := s.entry(name)
+			if ok && finished(f) && now.Sub(e.UploadedAt) > s.policy.LocalKeep {
+				s.pruneLocal(name)
+				delete(local, name)
+			}
+		}
+	}
+
+	if s.policy.MaxLocalBytes > 0 {
+		var total int64
+		var oldest []localFile
+		for _, f := range local {
+			total += f.size
+			oldest = append(oldest, f)
+		}
+		sort.Slice(oldest, func(i, j int) bool { return oldest[i].modTime.Before(oldest[j].modTime) })
+		for _, f := range oldest {
+			if total <= s.policy.MaxLocalBytes {
+				break
+			}
+			if !finished(f) {
+				continue
+			}
+			switch {
+			case s.offloaded(f.name):
+				s.pruneLocal(f.name)
+			case s.bucket != nil:
+				// Not offloaded yet (the upload failed): keep it.
+				continue
+			default:
+				if !s.remove(ctx, f.name, "size limit") {
+					continue
+				}
+			}
+			total -= f.size
+		}
+	}
+}
+
+func (s *Store) entry(name string) (offloadEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[name]
+	return e, ok
+}
+
+func (s *Store) offloaded(name string) bool {
+	_, ok := s.entry(name)
+	return ok
+}
+
+func (s *Store) envFor(name string) string {
+	if s.tags == nil || s.policy.EnvTag == "" {
+		return ""
+	}
+	id := InstanceID(name)
+	if id == "" {
+		return ""
+	}
+	return s.tags(id)[s.policy.EnvTag]
+}
+
+func (s *Store) upload(ctx context.Context, f localFile) {
+	env := s.envFor(f.name)
+	meta := map[string]string{"instance-id": InstanceID(f.name)}
+	if env != "" {
+		meta["environment"] = env
+	}
+	path := filepath.Join(s.dir, f.name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := s.bucket.Upload(ctx, f.name, path, meta); err != nil {
+		s.logger.Printf("recordings: offload failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		// Deleted while uploading.
+		s.bucket.Delete(ctx, f.name)
+		return
+	}
+	s.index[f.name] = offloadEntry{Size: info.Size(), ModTime: info.ModTime(), UploadedAt: s.now(), Env: env}
+	s.saveIndexLocked()
+	s.logger.Printf("recordings: offloaded %s to %s", f.name, s.bucket.Key(f.name))
+}
+
+// uploadManifest offloads the signed manifest of an offloaded recording.
+func (s *Store) uploadManifest(ctx context.Context, name string) {
+	if !s.signed(name) {
+		return
+	}
+	if err := s.bucket.Upload(ctx, name+ManifestSuffix, s.manifestPath(name), nil); err != nil {
+		s.logger.Printf("recordings: offload failed: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.index[name]; ok {
+		e.Manifest = true
+		s.index[name] = e
+		s.saveIndexLocked()
+	}
+}
+
+// pruneLocal removes the local copy (and converted .mp4) of an offloaded
+// recording.
+func (s *Store) pruneLocal(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		s.logger.Printf("recordings: prune %s: %v", name, err)
+		return
+	}
+	os.Remove(s.mp4Path(name))
+}
+
+// remove deletes a recording everywhere and reports whether it is gone.
+func (s *Store) remove(ctx context.Context, name, reason string) bool {
+	s.mu.Lock()
+	if e, ok := s.index[name]; ok && s.bucket != nil {
+		if err := s.deleteRemoteLocked(ctx, name, e); err != nil {
+			s.mu.Unlock()
+			s.logger.Printf("recordings: %v", err)
+			return false
+		}
+	}
+	err := os.Remove(filepath.Join(s.dir, name))
+	os.Remove(s.mp4Path(name))
+	os.Remove(s.manifestPath(name))
+	s.mu.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		s.logger.Printf("recordings: remove %s: %v", name, err)
+		return false
+	}
+	s.unindex(name)
+	s.logger.Printf("recordings: removed %s (%s)", name, reason)
+	if s.onRemove != nil {
+		s.onRemove(name, reason)
+	}
+	return true
+}
+
+// deleteRemoteLocked deletes an offloaded recording and its manifest; s.mu
+// must be held.
+func (s *Store) deleteRemoteLocked(ctx context.Context, name string, e offloadEntry) error {
+	if err := s.bucket.Delete(ctx, name); err != nil {
+		return err
+	}
+	if e.Manifest {
+		if err := s.bucket.Delete(ctx, name+ManifestSuffix); err != nil {
+			return err
+		}
+	}
+	delete(s.index, name)
+	s.saveIndexLocked()
+	return nil
+}
+
+func (s *Store) saveIndexLocked() {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(s.dir, indexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		s.logger.Printf("recordings: save offload index: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		s.logger.Printf("recordings: save offload index: %v", err)
+	}
+}
+
+func (s *Store) manifestPath(name string) string {
+	return ManifestPath(filepath.Join(s.dir, name))
+}
+
+func (s *Store) signed(name string) bool {
+	m, err := ReadManifest(s.manifestPath(name))
+	return err == nil && m.Signed()
+}
+
+// Begin records metadata for a recording that is written by another
+// process (Guacamole); the manifest is signed once the recording is
+// finished. It is a no-op when signing is disabled.
+func (s *Store) Begin(name string, m Manifest) error {
+	if s.signer == nil {
+		return nil
+	}
+	m.File = filepath.Base(name)
+	m.Type = recordingType(m.File)
+	return WriteManifest(s.manifestPath(m.File), &m)
+}
+
+// Seal signs a finished recording. meta supplies the session metadata;
+// when nil, metadata saved by Begin is used. It is a no-op when signing is
+// disabled.
+func (s *Store) Seal(name string, meta *Manifest) error {
+	if s.signer == nil {
+		return nil
+	}
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	return s.seal(filepath.Base(name), meta)
+}
+
+func (s *Store) seal(name string, meta *Manifest) error {
+	m := meta
+	if m == nil {
+		m = &Manifest{}
+		if pending, err := ReadManifest(s.manifestPath(name)); err == nil {
+			m = pending
+		}
+	}
+	path := filepath.Join(s.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	m.File = name
+	m.Type = recordingType(name)
+	if m.InstanceID == "" {
+		m.InstanceID = InstanceID(name)
+	}
+	if m.Started == "" {
+		if t := castStart(path); !t.IsZero() {
+			m.Started = t.UTC().Format(time.RFC3339)
+		}
+	}
+	if m.Ended == "" {
+		m.Ended = info.ModTime().UTC().Format(time.RFC3339)
+	}
+	m.Signature = ""
+	if err := s.signer.Sign(m, f); err != nil {
+		return err
+	}
+	return WriteManifest(s.manifestPath(name), m)
+}
+
+// Verify checks a recording (local or offloaded) against its signed
+// manifest and returns the manifest.
+func (s *Store) Verify(ctx context.Context, name string) (*Manifest, error) {
+	if s.signer == nil {
+		return nil, errors.New("recording signing is disabled")
+	}
+	name = filepath.Base(name)
+	m, err := ReadManifest(s.manifestPath(name))
+	if os.IsNotExist(err) {
+		m, err = s.remoteManifest(ctx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var content io.ReadCloser
+	if path, ok := s.LocalPath(name); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return m, err
+		}
+		content = f
+	} else {
+		obj, err := s.OpenRemote(ctx, name, "")
+		if err != nil {
+			return m, err
+		}
+		content = obj.Body
+	}
+	defer content.Close()
+	return m, VerifyManifest(m, content, s.signer.PublicKey())
+}
+
+func (s *Store) remoteManifest(ctx context.Context, name string) (*Manifest, error) {
+	e, ok := s.entry(name)
+	if s.bucket == nil || !ok || !e.Manifest {
+		return nil, fmt.Errorf("%w: no manifest for %s", ErrNotFound, name)
+	}
+	obj, err := s.bucket.Open(ctx, name+ManifestSuffix, "")
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	var m Manifest
+	if err := json.NewDecoder(obj.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
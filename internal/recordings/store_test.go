@@ -0,0 +1,241 @@
+package recordings
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    []*s3.PutObjectInput
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Key] = data
+	f.puts = append(f.puts, in)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data)), ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+func (f *fakeS3) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, *in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func writeRecording(t *testing.T, dir, name string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mt := time.Now().Add(-age)
+	if err := os.Chtimes(path, mt, mt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+func TestSweepOffloadsAndServesRemote(t *testing.T) {
+	dir := t.TempDir()
+	fake := &fakeS3{objects: map[string][]byte{}}
+	store := NewStore(dir, Policy{LocalKeep: time.Hour}, log.New(io.Discard, "", 0))
+	store.SetBucket(newBucket(fake, BucketConfig{Bucket: "rec", Prefix: "/cloudterm/", SSE: SSEKMS, KMSKeyID: "key-1"}))
+	store.SetActiveFunc(func() map[string]bool { return map[string]bool{"i-0aaa-live-01.cast": true} })
+
+	writeRecording(t, dir, "i-0abc-web-01.cast", 10, time.Hour)
+	writeRecording(t, dir, "i-0aaa-live-01.cast", 10, time.Hour) // still recording
+	writeRecording(t, dir, "i-0bbb-db-01.guac", 10, time.Minute) // still being written
+	store.Sweep(context.Background())
+
+	if len(fake.puts) != 1 || *fake.puts[0].Key != "cloudterm/i-0abc-web-01.cast" {
+		t.Fatalf("expected only the finished recording to be uploaded, got %d puts", len(fake.puts))
+	}
+	put := fake.puts[0]
+	if put.ServerSideEncryption != "aws:kms" || aws.ToString(put.SSEKMSKeyId) != "key-1" || put.Metadata["instance-id"] != "i-0abc" {
+		t.Errorf("unexpected put %+v", put)
+	}
+
+	// Reopened stores keep the offload index.
+	store2 := NewStore(dir, Policy{LocalKeep: time.Hour}, log.New(io.Discard, "", 0))
+	store2.SetBucket(store.bucket)
+	store2.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	store2.Sweep(context.Background())
+	if exists(dir, "i-0abc-web-01.cast") {
+		t.Fatal("local copy should be pruned after LocalKeep")
+	}
+	if len(fake.puts) != 3 {
+		t.Errorf("expected the other recordings to be uploaded once finished, got %d puts", len(fake.puts))
+	}
+
+	var found *Recording
+	for _, rec := range store2.List() {
+		if rec.Name == "i-0abc-web-01.cast" {
+			found = &rec
+		}
+	}
+	if found == nil || found.Local || !found.Offloaded || found.Size != 10 {
+		t.Fatalf("expected remote recording in listing, got %+v", found)
+	}
+	obj, err := store2.OpenRemote(context.Background(), "i-0abc-web-01.cast", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(obj.Body)
+	if len(data) != 10 || obj.ContentType != "application/x-asciicast" {
+		t.Errorf("unexpected object %d bytes, %s", len(data), obj.ContentType)
+	}
+
+	if err := store2.Delete(context.Background(), "i-0abc-web-01.cast"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["cloudterm/i-0abc-web-01.cast"]; ok {
+		t.Error("delete should remove the object")
+	}
+	if err := store2.Delete(context.Background(), "i-0abc-web-01.cast"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSweepAgeRetentionByEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	byEnv, err := ParseEnvRetention("prod=365, dev=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(dir, Policy{MaxAge: 7 * 24 * time.Hour, MaxAgeByEnv: byEnv, EnvTag: "Environment"}, log.New(io.Discard, "", 0))
+	store.SetTagLookup(func(id string) map[string]string {
+		return map[string]map[string]string{"i-0prod": {"Environment": "Prod"}, "i-0dev": {"Environment": "dev"}}[id]
+	})
+	var removed []string
+	store.SetRemoveHook(func(name, reason string) { removed = append(removed, name+":"+reason) })
+
+	old := 30 * 24 * time.Hour
+	writeRecording(t, dir, "i-0prod-a-01.cast", 1, old)
+	writeRecording(t, dir, "i-0dev-b-01.cast", 1, old)
+	writeRecording(t, dir, "i-0other-c-01.cast", 1, old)
+	writeRecording(t, dir, "i-0other-c-01.mp4", 1, old)
+	writeRecording(t, dir, "i-0other-d-02.cast", 1, time.Hour)
+	store.Sweep(context.Background())
+
+	if !exists(dir, "i-0prod-a-01.cast") || !exists(dir, "i-0dev-b-01.cast") || !exists(dir, "i-0other-d-02.cast") {
+		t.Error("recordings within their retention were removed")
+	}
+	if exists(dir, "i-0other-c-01.cast") || exists(dir, "i-0other-c-01.mp4") {
+		t.Error("expired recording and its mp4 should be removed")
+	}
+	if len(removed) != 1 || removed[0] != "i-0other-c-01.cast:expired" {
+		t.Errorf("unexpected remove hook calls %v", removed)
+	}
+}
+
+func TestSweepSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, Policy{MaxLocalBytes: 250}, log.New(io.Discard, "", 0))
+	writeRecording(t, dir, "i-01-a-01.cast", 100, 3*time.Hour)
+	writeRecording(t, dir, "i-02-b-01.cast", 100, 2*time.Hour)
+	writeRecording(t, dir, "i-03-c-01.cast", 100, time.Hour)
+	store.Sweep(context.Background())
+	if exists(dir, "i-01-a-01.cast") || !exists(dir, "i-02-b-01.cast") || !exists(dir, "i-03-c-01.cast") {
+		t.Error("expected only the oldest recording to be removed")
+	}
+
+	// With offload enabled, recordings that are not in the bucket yet are kept.
+	failing := &failingS3{fakeS3{objects: map[string][]byte{}}}
+	store.SetBucket(newBucket(failing, BucketConfig{Bucket: "rec"}))
+	store.policy.MaxLocalBytes = 50
+	store.Sweep(context.Background())
+	if !exists(dir, "i-02-b-01.cast") || !exists(dir, "i-03-c-01.cast") {
+		t.Error("recordings that failed to offload must not be removed")
+	}
+}
+
+type failingS3 struct{ fakeS3 }
+
+func (f *failingS3) PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "ServiceUnavailable"}
+}
+
+func TestParseEnvRetention(t *testing.T) {
+	for _, bad := range []string{"prod", "prod=x", "=3", "dev=-1"} {
+		if _, err := ParseEnvRetention(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	if got, _ := ParseEnvRetention(""); len(got) != 0 {
+		t.Errorf("expected no rules, got %v", got)
+	}
+}
+
+func TestStoreRejectsSidecars(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	store := NewStore(dir, Policy{}, log.New(io.Discard, "", 0))
+	store.SetSigner(NewSigner(priv))
+	name := "i-0abc-web-01.cast"
+	writeRecording(t, dir, name, 32, time.Hour)
+	writeRecording(t, dir, "i-0abc-web-01.mp4", 16, time.Hour)
+	if err := store.Seal(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, indexFile), []byte("{}"), 0644)
+
+	for _, sidecar := range []string{name + ManifestSuffix, indexFile, name + ".tmp"} {
+		if _, ok := store.LocalPath(sidecar); ok {
+			t.Errorf("LocalPath(%q) served a sidecar", sidecar)
+		}
+		if err := store.Delete(context.Background(), sidecar); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidName", sidecar, err)
+		}
+	}
+	if !store.signed(name) {
+		t.Fatal("manifest was removed by a rejected delete")
+	}
+	if _, ok := store.LocalPath("i-0abc-web-01.mp4"); !ok {
+		t.Error("converted .mp4 should be served")
+	}
+
+	if err := store.Delete(context.Background(), name); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"i-0abc-web-01.mp4", name + ManifestSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, f)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after delete", f)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package runbook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudterm-go/internal/aws"
+)
+
+// Run states.
+const (
+	StatusRunning   = "running"
+	StatusPaused    = "paused"    // waiting for the user after a failed step
+	StatusCompleted = "completed" // every step passed
+	StatusFailed    = "failed"    // finished with failed steps
+	StatusAborted   = "aborted"   // stopped by the user at a pause
+	StatusCancelled = "cancelled" // stopped by the user while running
+)
+
+// Step result states.
+const (
+	StepPassed  = "passed"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+)
+
+// Answers to a paused run.
+const (
+	ResumeRetry    = "retry"
+	ResumeContinue = "continue"
+	ResumeAbort    = "abort"
+)
+
+// Run modes.
+const (
+	ModeSession = "session" // typed into an interactive terminal session
+	ModeFleet   = "fleet"   // SendCommand on each target
+)
+
+const (
+	// DefaultConcurrency is how many instances a headless run works on at
+	// once when the request does not say.
+	DefaultConcurrency = 10
+	// maxOutput caps the output kept per step.
+	maxOutput = 16 * 1024
+	// keepRuns is how many finished runs stay in memory for status queries;
+	// the audit log holds the permanent transcript.
+	keepRuns = 200
+)
+
+// Executor runs commands on one instance; *aws.Discovery implements it.
+type Executor interface {
+	RunCommand(ctx context.Context, profile, region, instanceID, platform string, commands []string, timeout time.Duration) (*aws.CommandResult, error)
+}
+
+// Terminal types into interactive sessions; *session.Manager implements it.
+type Terminal interface {
+	WriteInput(sessionID string, data []byte) error
+	SendInterrupt(sessionID string) error
+	Tap(sessionID string, fn func([]byte)) (untap func(), ended <-chan struct{}, err error)
+}
+
+// Target is an instance a headless run works on.
+type Target struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	Profile    string `json:"profile"`
+	Region     string `json:"region"`
+}
+
+// StepResult is the outcome of one step on one instance.
+type StepResult struct {
+	InstanceID string    `json:"instance_id"`
+	Step       int       `json:"step"` // index into the runbook's steps
+	Name       string    `json:"name"`
+	Command    string    `json:"command"`
+	Attempt    int       `json:"attempt"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+}
+
+// Run is one execution of a runbook.
+type Run struct {
+	ID         string            `json:"id"`
+	Runbook    string            `json:"runbook"`
+	User       string            `json:"user"`
+	Mode       string            `json:"mode"`
+	SessionID  string            `json:"session_id,omitempty"`
+	InstanceID string            `json:"instance_id,omitempty"`
+	Params     map[string]string `json:"params"`
+	Targets    []Target          `json:"targets,omitempty"`
+	Status     string            `json:"status"`
+	PausedAt   *StepResult       `json:"paused_at,omitempty"`
+	Created    time.Time         `json:"created"`
+	Finished   *time.Time        `json:"finished,omitempty"`
+	Results    []StepResult      `json:"results"`
+}
+
+// Event is streamed to subscribers while a run is in progress.
+type Event struct {
+	Type   string      `json:"type"` // "step", "paused", "resumed" or "done"
+	RunID  string      `json:"run_id"`
+	Result *StepResult `json:"result,omitempty"`
+	Status string      `json:"status"`
+}
+
+// Request starts a run. Set SessionID and InstanceID for an interactive
+// run, or Targets for a headless one.
+type Request struct {
+	User        string
+	Runbook     *Runbook
+	Params      map[string]string
+	SessionID   string
+	InstanceID  string
+	Targets     []Target
+	Concurrency int
+	// OnStart is called with the new run before any step runs.
+	OnStart func(run *Run)
+	// OnStep is called after every step, e.g. to write the transcript to
+	// the audit log.
+	OnStep func(run *Run, res StepResult)
+	// OnDone is called once the run has finished.
+	OnDone func(run *Run)
+}
+
+type run struct {
+	run    *Run
+	req    Request
+	cancel context.CancelFunc
+	resume chan string
+	subs   map[chan Event]struct{}
+	done   chan struct{}
+}
+
+// Manager starts runs and tracks them in memory.
+type Manager struct {
+	exec   Executor
+	term   Terminal
+	logger *log.Logger
+
+	mu   sync.Mutex
+	runs map[string]*run
+	wg   sync.WaitGroup
+}
+
+// NewManager returns a manager that runs headless steps with exec and
+// interactive steps through term.
+func NewManager(exec Executor, term Terminal, logger *log.Logger) *Manager {
+	return &Manager{exec: exec, term: term, logger: logger, runs: make(map[string]*run)}
+}
+
+// Start validates the parameters and launches the run in the background.
+func (m *Manager) Start(req Request) (*Run, error) {
+	given, quoted, err := req.Runbook.Resolve(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	mode := ModeFleet
+	switch {
+	case req.SessionID != "" && len(req.Targets) > 0:
+		return nil, fmt.Errorf("choose a session or targets, not both")
+	case req.SessionID != "":
+		mode = ModeSession
+	case len(req.Targets) == 0:
+		return nil, fmt.Errorf("no instances selected")
+	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = DefaultConcurrency
+	}
+
+	rn := &Run{
+		ID:         newRunID(),
+		Runbook:    req.Runbook.Name,
+		User:       req.User,
+		Mode:       mode,
+		SessionID:  req.SessionID,
+		InstanceID: req.InstanceID,
+		Params:     given,
+		Targets:    req.Targets,
+		Status:     StatusRunning,
+		Created:    time.Now().UTC(),
+		Results:    []StepResult{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{
+		run:    rn,
+		req:    req,
+		cancel: cancel,
+		resume: make(chan string, 1),
+		subs:   make(map[chan Event]struct{}),
+		done:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.runs[rn.ID] = r
+	m.pruneLocked()
+	snapshot := copyRun(rn)
+	m.mu.Unlock()
+	if req.OnStart != nil {
+		req.OnStart(copyRun(rn))
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		var failed bool
+		if mode == ModeSession {
+			failed = m.runSteps(ctx, r, req.InstanceID, quoted, m.sessionStep(req.SessionID), true)
+		} else {
+			failed = m.runFleet(ctx, r, quoted)
+		}
+		m.finish(ctx, r, failed)
+	}()
+	return snapshot, nil
+}
+
+// stepFunc runs one rendered command, returning its output and exit code.
+type stepFunc func(ctx context.Context, command string, timeout time.Duration) (string, int, error)
+
+// runFleet runs every step on each target, several targets at a time.
+func (m *Manager) runFleet(ctx context.Context, r *run, quoted map[string]string) bool {
+	sem := make(chan struct{}, r.req.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+	for _, t := range r.req.Targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			if m.runSteps(ctx, r, t.InstanceID, quoted, m.fleetStep(t), false) {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return failed
+}
+
+// runSteps runs the runbook's steps in order on one instance and reports
+// whether any failed. A failing step with on_failure "pause" waits for the
+// user when interactive and stops this instance's run otherwise.
+func (m *Manager) runSteps(ctx context.Context, r *run, instanceID string, quoted map[string]string, step stepFunc, interactive bool) bool {
+	steps := r.req.Runbook.Steps
+	failed := false
+	for i := 0; i < len(steps); i++ {
+		s := &steps[i]
+		res := StepResult{InstanceID: instanceID, Step: i, Name: s.Name, Command: s.render(quoted), Attempt: 1}
+		for {
+			if ctx.Err() != nil {
+				m.skip(r, instanceID, i, quoted)
+				return failed
+			}
+			res.Started = time.Now().UTC()
+			output, code, err := step(ctx, res.Command, s.timeout())
+			res.Finished = time.Now().UTC()
+			res.ExitCode = code
+			res.Output = truncate(output)
+			res.Error = ""
+			if err != nil {
+				res.Status = StepFailed
+				res.Error = err.Error()
+			} else if ok, why := s.check(output, code); ok {
+				res.Status = StepPassed
+			} else {
+				res.Status = StepFailed
+				res.Error = why
+			}
+			m.record(r, res)
+			if res.Status == StepPassed || s.OnFailure == OnFailureContinue {
+				break
+			}
+			if !interactive || ctx.Err() != nil {
+				m.skip(r, instanceID, i+1, quoted)
+				return true
+			}
+			switch m.pause(ctx, r, res) {
+			case ResumeRetry:
+				res.Attempt++
+				continue
+			case ResumeContinue:
+			default:
+				m.skip(r, instanceID, i+1, quoted)
+				return true
+			}
+			break
+		}
+		if res.Status != StepPassed {
+			failed = true
+		}
+	}
+	return failed
+}
+
+// skip records the steps from index from onwards as not run.
+func (m *Manager) skip(r *run, instanceID string, from int, quoted map[string]string) {
+	steps := r.req.Runbook.Steps
+	for i := from; i < len(steps); i++ {
+		m.record(r, StepResult{InstanceID: instanceID, Step: i, Name: steps[i].Name, Command: steps[i].render(quoted), Status: StepSkipped})
+	}
+}
+
+// pause marks the run paused and waits for Resume or Cancel.
+func (m *Manager) pause(ctx context.Context, r *run, res StepResult) string {
+	m.mu.Lock()
+	r.run.Status = StatusPaused
+	r.run.PausedAt = &res
+	m.publishLocked(r, Event{Type: "paused", RunID: r.run.ID, Result: &res, Status: StatusPaused})
+	m.mu.Unlock()
+
+	var answer string
+	select {
+	case answer = <-r.resume:
+	case <-ctx.Done():
+		answer = ResumeAbort
+	}
+
+	m.mu.Lock()
+	r.run.Status = StatusRunning
+	r.run.PausedAt = nil
+	if answer == ResumeAbort {
+		r.run.Status = StatusAborted
+	}
+	m.publishLocked(r, Event{Type: "resumed", RunID: r.run.ID, Status: r.run.Status})
+	m.mu.Unlock()
+	return answer
+}
+
+// record stores a step result, streams it and hands it to OnStep.
+func (m *Manager) record(r *run, res StepResult) {
+	m.mu.Lock()
+	r.run.Results = append(r.run.Results, res)
+	m.publishLocked(r, Event{Type: "step", RunID: r.run.ID, Result: &res, Status: r.run.Status})
+	snapshot := copyRun(r.run)
+	m.mu.Unlock()
+	if r.req.OnStep != nil {
+		r.req.OnStep(snapshot, res)
+	}
+}
+
+func (m *Manager) finish(ctx context.Context, r *run, failed bool) {
+	m.mu.Lock()
+	switch {
+	case r.run.Status == StatusAborted:
+	case ctx.Err() != nil:
+		r.run.Status = StatusCancelled
+	case failed:
+		r.run.Status = StatusFailed
+	default:
+		r.run.Status = StatusCompleted
+	}
+	now := time.Now().UTC()
+	r.run.Finished = &now
+	m.publishLocked(r, Event{Type: "done", RunID: r.run.ID, Status: r.run.Status})
+	for ch := range r.subs {
+		close(ch)
+	}
+	r.subs = nil
+	close(r.done)
+	snapshot := copyRun(r.run)
+	m.mu.Unlock()
+
+	m.logger.Printf("runbook run %s (%s) %s", snapshot.ID, snapshot.Runbook, snapshot.Status)
+	if r.req.OnDone != nil {
+		r.req.OnDone(snapshot)
+	}
+}
+
+// publishLocked sends ev to every subscriber; one that has fallen too far
+// behind is dropped. m.mu must be held.
+func (m *Manager) publishLocked(r *run, ev Event) {
+	for ch := range r.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// fleetStep runs commands on a target with SendCommand.
+func (m *Manager) fleetStep(t Target) stepFunc {
+	return func(ctx context.Context, command string, timeout time.Duration) (string, int, error) {
+		// Allow for delivery and polling on top of the execution timeout.
+		cctx, cancel := context.WithTimeout(ctx, timeout+2*time.Minute)
+		defer cancel()
+		lines := strings.Split(strings.ReplaceAll(command, "\r\n", "\n"), "\n")
+		out, err := m.exec.RunCommand(cctx, t.Profile, t.Region, t.InstanceID, "linux", lines, timeout)
+		if err != nil {
+			return "", -1, err
+		}
+		output := out.Stdout
+		if out.Stderr != "" {
+			output += out.Stderr
+		}
+		if out.Status != "Success" && out.ExitCode == 0 {
+			return output, -1, fmt.Errorf("command %s: %s", strings.ToLower(out.Status), out.Details)
+		}
+		return output, out.ExitCode, nil
+	}
+}
+
+// Subscribe returns the run so far and, while it is in progress, a channel
+// of further events that is closed after the "done" event. The returned
+// function unsubscribes early.
+func (m *Manager) Subscribe(id string) (*Run, <-chan Event, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.runs[id]
+	if !ok {
+		return nil, nil, func() {}, ErrNotFound
+	}
+	snapshot := copyRun(r.run)
+	if r.subs == nil {
+		return snapshot, nil, func() {}, nil
+	}
+	ch := make(chan Event, 256)
+	r.subs[ch] = struct{}{}
+	return snapshot, ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := r.subs[ch]; ok {
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}, nil
+}
+
+// Get returns a run with its results.
+func (m *Manager) Get(id string) (*Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.runs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyRun(r.run), nil
+}
+
+// List returns runs started by user (all users if empty), newest first,
+// without their results.
+func (m *Manager) List(user string) []Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := []Run{}
+	for _, r := range m.runs {
+		if user != "" && r.run.User != user {
+			continue
+		}
+		rn := *r.run
+		rn.Results = nil
+		runs = append(runs, rn)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs
+}
+
+// Resume answers a paused run with ResumeRetry, ResumeContinue or
+// ResumeAbort.
+func (m *Manager) Resume(id, answer string) error {
+	switch answer {
+	case ResumeRetry, ResumeContinue, ResumeAbort:
+	default:
+		return fmt.Errorf("answer must be %q, %q or %q", ResumeRetry, ResumeContinue, ResumeAbort)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.runs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.run.Status != StatusPaused {
+		return fmt.Errorf("run is %s, not paused", r.run.Status)
+	}
+	select {
+	case r.resume <- answer:
+	default:
+		return fmt.Errorf("run is already resuming")
+	}
+	return nil
+}
+
+// Cancel stops a run; the current step is interrupted and the rest skipped.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	r, ok := m.runs[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	r.cancel()
+	return nil
+}
+
+// Close cancels runs in progress and waits for them to finish.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	for _, r := range m.runs {
+		r.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// pruneLocked forgets the oldest finished runs beyond keepRuns. m.mu must
+// be held.
+func (m *Manager) pruneLocked() {
+	if len(m.runs) <= keepRuns {
+		return
+	}
+	var finished []string
+	for id, r := range m.runs {
+		if r.run.Finished != nil {
+			finished = append(finished, id)
+		}
+	}
+	sort.Strings(finished)
+	for _, id := range finished[:max(0, min(len(finished), len(m.runs)-keepRuns))] {
+		delete(m.runs, id)
+	}
+}
+
+func copyRun(rn *Run) *Run {
+	c := *rn
+	c.Results = append([]StepResult{}, rn.Results...)
+	if rn.PausedAt != nil {
+		p := *rn.PausedAt
+		c.PausedAt = &p
+	}
+	return &c
+}
+
+func truncate(s string) string {
+	if len(s) <= maxOutput {
+		return s
+	}
+	return s[len(s)-maxOutput:]
+}
+
+// newRunID returns a sortable, unique run ID.
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
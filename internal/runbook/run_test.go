@@ -0,0 +1,368 @@
+package runbook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloudterm-go/internal/aws"
+)
+
+type fakeExec struct {
+	run func(instanceID, command string) (*aws.CommandResult, error)
+}
+
+func (f *fakeExec) RunCommand(ctx context.Context, profile, region, instanceID, platform string, commands []string, timeout time.Duration) (*aws.CommandResult, error) {
+	return f.run(instanceID, strings.Join(commands, "\n"))
+}
+
+var statusLine = regexp.MustCompile(`RB ([0-9a-f]+) "\$\?"$`)
+
+// fakeTerminal behaves like an interactive shell: it echoes what is typed
+// and answers each command with the output and exit code from run.
+type fakeTerminal struct {
+	mu         sync.Mutex
+	taps       map[int]func([]byte)
+	next       int
+	ended      chan struct{}
+	typed      []string
+	run        func(command string) (string, int)
+	interrupts int
+}
+
+func newFakeTerminal(run func(string) (string, int)) *fakeTerminal {
+	return &fakeTerminal{taps: make(map[int]func([]byte)), ended: make(chan struct{}), run: run}
+}
+
+func (f *fakeTerminal) Tap(sessionID string, fn func([]byte)) (func(), <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := f.next
+	f.next++
+	f.taps[id] = fn
+	return func() {
+		f.mu.Lock()
+		delete(f.taps, id)
+		f.mu.Unlock()
+	}, f.ended, nil
+}
+
+func (f *fakeTerminal) WriteInput(sessionID string, data []byte) error {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\r"), "\r")
+	m := statusLine.FindStringSubmatch(lines[len(lines)-1])
+	if m == nil {
+		return fmt.Errorf("no status printf in %q", data)
+	}
+	command := strings.Join(lines[:len(lines)-1], "\n")
+	f.mu.Lock()
+	f.typed = append(f.typed, command)
+	f.mu.Unlock()
+	output, code := f.run(command)
+
+	var out strings.Builder
+	for _, l := range lines {
+		out.WriteString("\x1b[32m$\x1b[0m " + l + "\r\n")
+	}
+	if output != "" {
+		out.WriteString(strings.ReplaceAll(output, "\n", "\r\n") + "\r\n")
+	}
+	if code >= 0 {
+		fmt.Fprintf(&out, "\r\n__CT_RB_%s:%d__\r\n", m[1], code)
+	}
+	go func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, fn := range f.taps {
+			fn([]byte(out.String()))
+		}
+	}()
+	return nil
+}
+
+func (f *fakeTerminal) SendInterrupt(sessionID string) error {
+	f.mu.Lock()
+	f.interrupts++
+	f.mu.Unlock()
+	return nil
+}
+
+func mustParse(t *testing.T, src string) *Runbook {
+	t.Helper()
+	rb, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rb
+}
+
+// collect subscribes to a run and returns its events up to "done".
+func collect(t *testing.T, m *Manager, id string, onEvent func(Event)) []Event {
+	t.Helper()
+	run, ch, unsubscribe, err := m.Subscribe(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	var events []Event
+	if run.PausedAt != nil && onEvent != nil {
+		onEvent(Event{Type: "paused", RunID: id, Result: run.PausedAt, Status: run.Status})
+	}
+	timeout := time.After(5 * time.Second)
+	for ch != nil {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+			if onEvent != nil {
+				onEvent(ev)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for run")
+		}
+	}
+	return events
+}
+
+func statuses(results []StepResult) string {
+	var s []string
+	for _, r := range results {
+		s = append(s, fmt.Sprintf("%s/%d:%s", r.InstanceID, r.Step, r.Status))
+	}
+	return strings.Join(s, " ")
+}
+
+const checkYAML = `
+name: check
+parameters: [{name: path, default: /var/log}]
+steps:
+  - name: Disk
+    command: df -h {{path}}
+    expect: '\d+%'
+  - name: Load
+    command: uptime
+    on_failure: continue
+  - name: Last
+    command: echo done
+`
+
+func TestFleetRun(t *testing.T) {
+	exec := &fakeExec{run: func(instanceID, command string) (*aws.CommandResult, error) {
+		switch {
+		case instanceID == "i-bad" && strings.HasPrefix(command, "df"):
+			return &aws.CommandResult{Status: "Success", Stdout: "no such file"}, nil
+		case instanceID == "i-slow" && command == "uptime":
+			return &aws.CommandResult{Status: "Failed", ExitCode: 1, Stderr: "boom"}, nil
+		}
+		return &aws.CommandResult{Status: "Success", Stdout: "/dev/xvda 42% " + command}, nil
+	}}
+	m := NewManager(exec, nil, log.New(io.Discard, "", 0))
+	defer m.Close()
+
+	var mu sync.Mutex
+	var steps []StepResult
+	done := make(chan *Run, 1)
+	run, err := m.Start(Request{
+		User:    "alice",
+		Runbook: mustParse(t, checkYAML),
+		Targets: []Target{{InstanceID: "i-ok"}, {InstanceID: "i-bad"}, {InstanceID: "i-slow"}},
+		OnStep: func(_ *Run, res StepResult) {
+			mu.Lock()
+			steps = append(steps, res)
+			mu.Unlock()
+		},
+		OnDone: func(r *Run) { done <- r },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Mode != ModeFleet || run.Params["path"] != "/var/log" {
+		t.Errorf("run = %+v", run)
+	}
+
+	var final *Run
+	select {
+	case final = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not finish")
+	}
+	if final.Status != StatusFailed {
+		t.Errorf("status = %s", final.Status)
+	}
+	byInstance := make(map[string][]string)
+	for _, r := range final.Results {
+		byInstance[r.InstanceID] = append(byInstance[r.InstanceID], r.Status)
+	}
+	want := map[string]string{
+		"i-ok":   "passed passed passed",
+		"i-bad":  "failed skipped skipped", // pause stops a headless instance
+		"i-slow": "passed failed passed",   // continue moves on
+	}
+	for id, w := range want {
+		if got := strings.Join(byInstance[id], " "); got != w {
+			t.Errorf("%s = %s, want %s", id, got, w)
+		}
+	}
+	mu.Lock()
+	if len(steps) != 9 {
+		t.Errorf("OnStep called %d times", len(steps))
+	}
+	mu.Unlock()
+	if final.Results[0].Command == "" || !strings.Contains(statuses(final.Results), "i-ok/0:passed") {
+		t.Errorf("results = %s", statuses(final.Results))
+	}
+}
+
+func TestSessionRun(t *testing.T) {
+	attempts := 0
+	term := newFakeTerminal(func(command string) (string, int) {
+		switch {
+		case strings.HasPrefix(command, "df"):
+			attempts++
+			if attempts == 1 {
+				return "df: cannot access", 1
+			}
+			return "/dev/xvda  8G  3G  5G  38% /var/log", 0
+		case command == "uptime":
+			return "load average: 9.00", 0
+		}
+		return "done", 0
+	})
+	m := NewManager(nil, term, log.New(io.Discard, "", 0))
+	defer m.Close()
+
+	run, err := m.Start(Request{User: "alice", Runbook: mustParse(t, checkYAML), SessionID: "s1", InstanceID: "i-1", Params: map[string]string{"path": "/var/log"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := collect(t, m, run.ID, func(ev Event) {
+		if ev.Type == "paused" {
+			if err := m.Resume(run.ID, ResumeRetry); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	if last := events[len(events)-1]; last.Type != "done" || last.Status != StatusCompleted {
+		t.Fatalf("last event = %+v", last)
+	}
+
+	final, err := m.Get(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(final.Results); got != "i-1/0:failed i-1/0:passed i-1/1:passed i-1/2:passed" {
+		t.Errorf("results = %s", got)
+	}
+	retry := final.Results[1]
+	if retry.Attempt != 2 || retry.Output != "/dev/xvda  8G  3G  5G  38% /var/log" {
+		t.Errorf("retry = %+v", retry)
+	}
+	if final.Results[0].Error != "exit code 1" {
+		t.Errorf("first attempt error = %q", final.Results[0].Error)
+	}
+	if term.typed[0] != "df -h '/var/log'" {
+		t.Errorf("typed = %q", term.typed[0])
+	}
+}
+
+func TestSessionRunAbort(t *testing.T) {
+	term := newFakeTerminal(func(string) (string, int) { return "nope", 3 })
+	m := NewManager(nil, term, log.New(io.Discard, "", 0))
+	defer m.Close()
+
+	run, err := m.Start(Request{User: "alice", Runbook: mustParse(t, checkYAML), SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resume(run.ID, ResumeAbort); err == nil {
+		t.Error("resumed a run that is not paused")
+	}
+	collect(t, m, run.ID, func(ev Event) {
+		if ev.Type == "paused" {
+			m.Resume(run.ID, ResumeAbort)
+		}
+	})
+	final, _ := m.Get(run.ID)
+	if final.Status != StatusAborted {
+		t.Errorf("status = %s", final.Status)
+	}
+	if got := statuses(final.Results); got != "/0:failed /1:skipped /2:skipped" {
+		t.Errorf("results = %s", got)
+	}
+}
+
+func TestSessionStepTimeout(t *testing.T) {
+	// A negative exit code makes the fake shell never print the marker.
+	term := newFakeTerminal(func(string) (string, int) { return "still going", -1 })
+	m := NewManager(nil, term, log.New(io.Discard, "", 0))
+	defer m.Close()
+
+	output, code, err := m.sessionStep("s1")(context.Background(), "sleep 100", 50*time.Millisecond)
+	if err == nil || code != -1 {
+		t.Fatalf("code = %d, err = %v", code, err)
+	}
+	if output != "still going" {
+		t.Errorf("output = %q", output)
+	}
+	if term.interrupts != 1 {
+		t.Errorf("interrupts = %d", term.interrupts)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	release := make(chan struct{})
+	exec := &fakeExec{run: func(string, string) (*aws.CommandResult, error) {
+		<-release
+		return &aws.CommandResult{Status: "Success"}, nil
+	}}
+	m := NewManager(exec, nil, log.New(io.Discard, "", 0))
+	defer m.Close()
+
+	run, err := m.Start(Request{User: "alice", Runbook: mustParse(t, checkYAML), Targets: []Target{{InstanceID: "i-1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cancel(run.ID); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	collect(t, m, run.ID, nil)
+	final, _ := m.Get(run.ID)
+	if final.Status != StatusCancelled {
+		t.Errorf("status = %s", final.Status)
+	}
+	if runs := m.List("bob"); len(runs) != 0 {
+		t.Errorf("bob sees %d runs", len(runs))
+	}
+	if runs := m.List("alice"); len(runs) != 1 || runs[0].Results != nil {
+		t.Errorf("alice's runs = %+v", runs)
+	}
+}
+
+func TestStartValidation(t *testing.T) {
+	m := NewManager(nil, nil, log.New(io.Discard, "", 0))
+	rb := mustParse(t, "name: x\nparameters: [{name: a, required: true}]\nsteps: [{command: 'echo {{a}}'}]")
+	for name, req := range map[string]Request{
+		"missing param": {Runbook: rb, Targets: []Target{{InstanceID: "i-1"}}},
+		"no target":     {Runbook: rb, Params: map[string]string{"a": "1"}},
+		"both":          {Runbook: rb, Params: map[string]string{"a": "1"}, SessionID: "s1", Targets: []Target{{InstanceID: "i-1"}}},
+	} {
+		if _, err := m.Start(req); err == nil {
+			t.Errorf("%s: started", name)
+		}
+	}
+}
+
+func TestCleanOutput(t *testing.T) {
+	text := "$ systemctl status nginx\n$ printf '\\n__CT_%s_%s:%d__\\n' RB ab \"$?\"\nactive (running)\n"
+	if got := cleanOutput(text, []string{"systemctl status nginx"}); got != "active (running)" {
+		t.Errorf("cleanOutput = %q", got)
+	}
+}
@@ -0,0 +1,297 @@
+// Package runbook stores parameterized, multi-step procedures as YAML and
+// runs them either by typing into an interactive terminal session or
+// headless through SSM SendCommand across a set of instances.
+package runbook
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parameter types.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+	TypeEnum   = "enum"
+)
+
+// What to do when a step fails.
+const (
+	// OnFailurePause stops before the next step. Interactive runs wait for
+	// the user to retry, continue or abort; headless runs stop on that
+	// instance.
+	OnFailurePause = "pause"
+	// OnFailureContinue records the failure and moves on.
+	OnFailureContinue = "continue"
+)
+
+// DefaultStepTimeout bounds a step that does not set a timeout.
+const DefaultStepTimeout = 5 * time.Minute
+
+var (
+	namePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	paramPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholder  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// Runbook is an on-call procedure: typed parameters and ordered steps.
+// Steps are POSIX shell; {{name}} in a command is replaced by the
+// parameter's value, shell-quoted.
+type Runbook struct {
+	Name        string      `yaml:"name" json:"name"`
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+	Parameters  []Parameter `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Steps       []Step      `yaml:"steps" json:"steps"`
+}
+
+// Parameter is a typed input to a runbook.
+type Parameter struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        string   `yaml:"type,omitempty" json:"type,omitempty"` // string (default), int, bool or enum
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Required    bool     `yaml:"required,omitempty" json:"required,omitempty"`
+	Default     string   `yaml:"default,omitempty" json:"default,omitempty"`
+	Choices     []string `yaml:"choices,omitempty" json:"choices,omitempty"` // enum values
+	Pattern     string   `yaml:"pattern,omitempty" json:"pattern,omitempty"` // regex a string value must match
+
+	re *regexp.Regexp
+}
+
+// Step is one command and the output it is expected to produce.
+type Step struct {
+	Name      string   `yaml:"name" json:"name"`
+	Command   string   `yaml:"command" json:"command"`
+	Expect    string   `yaml:"expect,omitempty" json:"expect,omitempty"` // regex on ANSI-stripped output
+	OnFailure string   `yaml:"on_failure,omitempty" json:"on_failure,omitempty"`
+	Timeout   Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	expect *regexp.Regexp
+}
+
+// Duration is a time.Duration written as "30s" or "5m" in YAML and JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	v, err := time.ParseDuration(n.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", n.Value)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Parse decodes and validates a runbook.
+func Parse(data []byte) (*Runbook, error) {
+	var rb Runbook
+	if err := yaml.Unmarshal(data, &rb); err != nil {
+		return nil, fmt.Errorf("parse runbook: %w", err)
+	}
+	if err := rb.compile(); err != nil {
+		return nil, fmt.Errorf("runbook %q: %w", rb.Name, err)
+	}
+	return &rb, nil
+}
+
+// compile validates the runbook and compiles its patterns.
+func (rb *Runbook) compile() error {
+	if !namePattern.MatchString(rb.Name) {
+		return fmt.Errorf("name must be lower-case letters, digits, '-' or '_'")
+	}
+	if len(rb.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	declared := make(map[string]bool)
+	for i := range rb.Parameters {
+		p := &rb.Parameters[i]
+		if !paramPattern.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if declared[p.Name] {
+			return fmt.Errorf("duplicate parameter %q", p.Name)
+		}
+		declared[p.Name] = true
+		if p.Type == "" {
+			p.Type = TypeString
+		}
+		switch p.Type {
+		case TypeString, TypeInt, TypeBool:
+		case TypeEnum:
+			if len(p.Choices) == 0 {
+				return fmt.Errorf("enum parameter %q has no choices", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %q: unknown type %q", p.Name, p.Type)
+		}
+		if p.Pattern != "" {
+			re, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				return fmt.Errorf("parameter %q: invalid pattern: %w", p.Name, err)
+			}
+			p.re = re
+		}
+		if p.Default != "" {
+			if _, err := p.value(p.Default); err != nil {
+				return fmt.Errorf("parameter %q: default: %w", p.Name, err)
+			}
+		}
+	}
+	for i := range rb.Steps {
+		s := &rb.Steps[i]
+		if strings.TrimSpace(s.Command) == "" {
+			return fmt.Errorf("step %d has no command", i+1)
+		}
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("Step %d", i+1)
+		}
+		switch s.OnFailure {
+		case "":
+			s.OnFailure = OnFailurePause
+		case OnFailurePause, OnFailureContinue:
+		default:
+			return fmt.Errorf("step %q: on_failure must be %q or %q", s.Name, OnFailurePause, OnFailureContinue)
+		}
+		if s.Expect != "" {
+			re, err := regexp.Compile(s.Expect)
+			if err != nil {
+				return fmt.Errorf("step %q: invalid expect: %w", s.Name, err)
+			}
+			s.expect = re
+		}
+		for _, m := range placeholder.FindAllStringSubmatch(s.Command, -1) {
+			if !declared[m[1]] {
+				return fmt.Errorf("step %q uses undeclared parameter %q", s.Name, m[1])
+			}
+		}
+	}
+	return nil
+}
+
+// value validates v against the parameter's type and returns it as it is
+// substituted into commands.
+func (p *Parameter) value(v string) (string, error) {
+	switch p.Type {
+	case TypeInt:
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "", fmt.Errorf("%q is not an integer", v)
+		}
+		return v, nil
+	case TypeBool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", v)
+		}
+		return strconv.FormatBool(b), nil
+	case TypeEnum:
+		for _, c := range p.Choices {
+			if v == c {
+				return shellQuote(v), nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", v, strings.Join(p.Choices, ", "))
+	}
+	if p.re != nil && !p.re.MatchString(v) {
+		return "", fmt.Errorf("%q does not match %s", v, p.Pattern)
+	}
+	return shellQuote(v), nil
+}
+
+// Resolve checks params against the runbook's parameters, filling in
+// defaults, and returns the values as given and as substituted.
+func (rb *Runbook) Resolve(params map[string]string) (given, quoted map[string]string, err error) {
+	given = make(map[string]string, len(rb.Parameters))
+	quoted = make(map[string]string, len(rb.Parameters))
+	for name := range params {
+		if !rb.hasParameter(name) {
+			return nil, nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	for i := range rb.Parameters {
+		p := &rb.Parameters[i]
+		v, ok := params[p.Name]
+		if !ok || v == "" {
+			v = p.Default
+		}
+		if v == "" {
+			if p.Required {
+				return nil, nil, fmt.Errorf("parameter %q is required", p.Name)
+			}
+			given[p.Name] = ""
+			quoted[p.Name] = "''"
+			continue
+		}
+		q, err := p.value(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parameter %q: %w", p.Name, err)
+		}
+		given[p.Name] = v
+		quoted[p.Name] = q
+	}
+	return given, quoted, nil
+}
+
+// Commands returns each step's command with params substituted, e.g. to
+// check them against the command guard before a run.
+func (rb *Runbook) Commands(params map[string]string) ([]string, error) {
+	_, quoted, err := rb.Resolve(params)
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]string, len(rb.Steps))
+	for i := range rb.Steps {
+		commands[i] = rb.Steps[i].render(quoted)
+	}
+	return commands, nil
+}
+
+func (rb *Runbook) hasParameter(name string) bool {
+	for _, p := range rb.Parameters {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// render substitutes resolved parameter values into a step's command.
+func (s *Step) render(quoted map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(s.Command, func(m string) string {
+		return quoted[placeholder.FindStringSubmatch(m)[1]]
+	})
+}
+
+// timeout returns the step's timeout or the default.
+func (s *Step) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return time.Duration(s.Timeout)
+	}
+	return DefaultStepTimeout
+}
+
+// check reports whether a step's output and exit code count as success.
+func (s *Step) check(output string, exitCode int) (bool, string) {
+	if exitCode != 0 {
+		return false, fmt.Sprintf("exit code %d", exitCode)
+	}
+	if s.expect != nil && !s.expect.MatchString(output) {
+		return false, fmt.Sprintf("output does not match %s", s.Expect)
+	}
+	return true, ""
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
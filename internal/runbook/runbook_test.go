@@ -0,0 +1,181 @@
+package runbook
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const restartYAML = `
+name: restart-service
+description: Restart a systemd unit and check it came back
+parameters:
+  - name: unit
+    required: true
+    pattern: '^[a-z0-9@._-]+$'
+  - name: wait
+    type: int
+    default: "5"
+  - name: mode
+    type: enum
+    choices: [restart, reload]
+    default: restart
+steps:
+  - name: Restart
+    command: sudo systemctl {{ mode }} {{unit}}
+  - command: |
+      sleep {{wait}}
+      systemctl is-active {{unit}}
+    expect: ^active$
+    on_failure: continue
+    timeout: 30s
+`
+
+func TestParse(t *testing.T) {
+	rb, err := Parse([]byte(restartYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb.Name != "restart-service" || len(rb.Parameters) != 3 || len(rb.Steps) != 2 {
+		t.Fatalf("runbook = %+v", rb)
+	}
+	if rb.Parameters[0].Type != TypeString {
+		t.Errorf("default type = %q", rb.Parameters[0].Type)
+	}
+	s := rb.Steps[1]
+	if s.Name != "Step 2" || s.OnFailure != OnFailureContinue || s.timeout() != 30*time.Second {
+		t.Errorf("step 2 = %+v", s)
+	}
+	if rb.Steps[0].OnFailure != OnFailurePause || rb.Steps[0].timeout() != DefaultStepTimeout {
+		t.Errorf("step 1 defaults = %+v", rb.Steps[0])
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"bad name":          "name: Restart Service\nsteps: [{command: uptime}]",
+		"no steps":          "name: x",
+		"empty command":     "name: x\nsteps: [{command: ' '}]",
+		"undeclared param":  "name: x\nsteps: [{command: 'echo {{who}}'}]",
+		"duplicate param":   "name: x\nparameters: [{name: a}, {name: a}]\nsteps: [{command: uptime}]",
+		"unknown type":      "name: x\nparameters: [{name: a, type: float}]\nsteps: [{command: uptime}]",
+		"enum no choices":   "name: x\nparameters: [{name: a, type: enum}]\nsteps: [{command: uptime}]",
+		"bad default":       "name: x\nparameters: [{name: a, type: int, default: ten}]\nsteps: [{command: uptime}]",
+		"bad expect":        "name: x\nsteps: [{command: uptime, expect: '('}]",
+		"bad on_failure":    "name: x\nsteps: [{command: uptime, on_failure: retry}]",
+		"bad timeout":       "name: x\nsteps: [{command: uptime, timeout: soon}]",
+		"bad param pattern": "name: x\nparameters: [{name: a, pattern: '['}]\nsteps: [{command: uptime}]",
+	}
+	for name, src := range tests {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	rb, err := Parse([]byte(restartYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, err := rb.Commands(map[string]string{"unit": "nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands[0] != "sudo systemctl 'restart' 'nginx'" {
+		t.Errorf("step 1 = %q", commands[0])
+	}
+	if commands[1] != "sleep 5\nsystemctl is-active 'nginx'\n" {
+		t.Errorf("step 2 = %q", commands[1])
+	}
+
+	for name, params := range map[string]map[string]string{
+		"missing required": {},
+		"pattern":          {"unit": "nginx; rm -rf /"},
+		"int":              {"unit": "nginx", "wait": "5s"},
+		"enum":             {"unit": "nginx", "mode": "stop"},
+		"unknown":          {"unit": "nginx", "force": "true"},
+	} {
+		if _, err := rb.Commands(params); err == nil {
+			t.Errorf("%s: accepted %v", name, params)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	rb, err := Parse([]byte("name: x\nparameters: [{name: msg}]\nsteps: [{command: 'echo {{msg}}'}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, err := rb.Commands(map[string]string{"msg": "it's $(whoami)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `echo 'it'\''s $(whoami)'`; commands[0] != want {
+		t.Errorf("command = %q, want %q", commands[0], want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rb, err := Parse([]byte("name: x\nsteps: [{command: uptime, expect: 'load average'}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &rb.Steps[0]
+	if ok, _ := s.check(" 10:00 up 3 days, load average: 0.1", 0); !ok {
+		t.Error("matching output failed")
+	}
+	if ok, why := s.check("load average: 0.1", 2); ok || why != "exit code 2" {
+		t.Errorf("non-zero exit = %v %q", ok, why)
+	}
+	if ok, _ := s.check("command not found", 0); ok {
+		t.Error("unexpected output passed")
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save([]byte(restartYAML)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save([]byte("name: x\nsteps: []")); err == nil {
+		t.Error("saved an invalid runbook")
+	}
+	// A file whose name does not match its runbook is reported, not loaded.
+	os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("name: x\nsteps: [{command: uptime}]"), 0o644)
+
+	list, invalid, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "restart-service" {
+		t.Errorf("list = %v", list)
+	}
+	if len(invalid) != 1 || invalid["other.yaml"] == nil {
+		t.Errorf("invalid = %v", invalid)
+	}
+
+	rb, err := s.Get("restart-service")
+	if err != nil || len(rb.Steps) != 2 {
+		t.Fatalf("get = %v, %v", rb, err)
+	}
+	raw, err := s.Raw("restart-service")
+	if err != nil || !strings.Contains(string(raw), "systemctl") {
+		t.Errorf("raw = %q, %v", raw, err)
+	}
+	if err := s.Delete("restart-service"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"restart-service", "../etc/passwd"} {
+		if _, err := s.Get(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get %q = %v", name, err)
+		}
+	}
+}
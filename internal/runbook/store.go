@@ -0,0 +1,129 @@
+package runbook
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned for unknown runbooks and runs.
+var ErrNotFound = errors.New("runbook not found")
+
+// Store keeps one YAML file per runbook in a directory, read on every
+// call so edits on disk take effect without a restart.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store for dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create runbook dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// List returns every valid runbook sorted by name. Files that fail to
+// parse are skipped and reported in invalid.
+func (s *Store) List() (runbooks []*Runbook, invalid map[string]error, err error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.yaml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	invalid = make(map[string]error)
+	for _, path := range paths {
+		rb, err := s.load(path)
+		if err != nil {
+			invalid[filepath.Base(path)] = err
+			continue
+		}
+		runbooks = append(runbooks, rb)
+	}
+	sort.Slice(runbooks, func(i, j int) bool { return runbooks[i].Name < runbooks[j].Name })
+	return runbooks, invalid, nil
+}
+
+// Get loads a runbook by name.
+func (s *Store) Get(name string) (*Runbook, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+	rb, err := s.load(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return rb, err
+}
+
+// Raw returns a runbook's YAML source.
+func (s *Store) Raw(name string) ([]byte, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Save validates data and writes it as <name>.yaml, replacing any runbook
+// of the same name.
+func (s *Store) Save(data []byte) (*Runbook, error) {
+	rb, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".runbook-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(rb.Name)); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return rb, nil
+}
+
+// Delete removes a runbook.
+func (s *Store) Delete(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+".yaml")
+}
+
+func (s *Store) load(path string) (*Runbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if want := strings.TrimSuffix(filepath.Base(path), ".yaml"); rb.Name != want {
+		return nil, fmt.Errorf("runbook %q is stored as %s.yaml", rb.Name, want)
+	}
+	return rb, nil
+}
@@ -0,0 +1,122 @@
+package runbook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudterm-go/internal/suggest"
+)
+
+const (
+	// maxCapture bounds the output buffered while a step runs.
+	maxCapture = 256 * 1024
+	// markerWindow is how much of the latest output is searched for the
+	// status marker.
+	markerWindow = 4096
+)
+
+// sessionStep types commands into an interactive session. After each
+// command a printf reports its exit status behind a random marker; the
+// marker is split across printf arguments so the echoed input never
+// matches it.
+func (m *Manager) sessionStep(sessionID string) stepFunc {
+	return func(ctx context.Context, command string, timeout time.Duration) (string, int, error) {
+		token := make([]byte, 6)
+		rand.Read(token)
+		tok := hex.EncodeToString(token)
+		marker := regexp.MustCompile(`__CT_RB_` + tok + `:(-?\d+)__`)
+
+		var mu sync.Mutex
+		var buf bytes.Buffer
+		found := make(chan struct{}, 1)
+		untap, ended, err := m.term.Tap(sessionID, func(p []byte) {
+			mu.Lock()
+			buf.Write(p)
+			if over := buf.Len() - maxCapture; over > 0 {
+				buf.Next(over)
+			}
+			tail := buf.Bytes()[max(0, buf.Len()-markerWindow):]
+			hit := marker.Match(suggest.StripANSI(tail))
+			mu.Unlock()
+			if hit {
+				select {
+				case found <- struct{}{}:
+				default:
+				}
+			}
+		})
+		if err != nil {
+			return "", -1, err
+		}
+		defer untap()
+
+		lines := strings.Split(strings.ReplaceAll(command, "\r\n", "\n"), "\n")
+		input := strings.Join(lines, "\r") + "\r" +
+			fmt.Sprintf(`printf '\n__CT_%%s_%%s:%%d__\n' RB %s "$?"`, tok) + "\r"
+		if err := m.term.WriteInput(sessionID, []byte(input)); err != nil {
+			return "", -1, err
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-found:
+		case <-timer.C:
+			m.term.SendInterrupt(sessionID)
+			return sessionOutput(&mu, &buf, lines), -1, fmt.Errorf("timed out after %s", timeout)
+		case <-ctx.Done():
+			m.term.SendInterrupt(sessionID)
+			return sessionOutput(&mu, &buf, lines), -1, ctx.Err()
+		case <-ended:
+			return sessionOutput(&mu, &buf, lines), -1, fmt.Errorf("session %s ended", sessionID)
+		}
+		mu.Lock()
+		text := string(suggest.StripANSI(buf.Bytes()))
+		mu.Unlock()
+		loc := marker.FindStringSubmatchIndex(text)
+		code, _ := strconv.Atoi(text[loc[2]:loc[3]])
+		return cleanOutput(text[:loc[0]], lines), code, nil
+	}
+}
+
+// sessionOutput returns what the session printed so far, for steps that
+// never reached their marker.
+func sessionOutput(mu *sync.Mutex, buf *bytes.Buffer, lines []string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return cleanOutput(string(suggest.StripANSI(buf.Bytes())), lines)
+}
+
+// cleanOutput removes the terminal's echo of the typed command lines and of
+// the status printf, leaving the command's own output.
+func cleanOutput(text string, lines []string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "")
+	pending := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			pending = append(pending, l)
+		}
+	}
+	var out []string
+	for _, l := range strings.Split(text, "\n") {
+		t := strings.TrimSpace(l)
+		if len(pending) > 0 && strings.HasSuffix(t, pending[0]) {
+			pending = pending[1:]
+			continue
+		}
+		if strings.Contains(t, "__CT_%s_%s:%d__") {
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
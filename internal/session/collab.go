@@ -0,0 +1,341 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Collaboration event types.
+const (
+	EventWatcherJoined    = "watcher_joined"
+	EventWatcherLeft      = "watcher_left"
+	EventControlRequested = "control_requested"
+	EventControlChanged   = "control_changed"
+	EventAccessRevoked    = "access_revoked"
+	EventSessionClosed    = "session_closed"
+	EventExpiryWarning    = "expiry_warning"  // the session will soon reach a time limit
+	EventSessionExpired   = "session_expired" // the session reached a time limit and is closing
+)
+
+// ErrNotPermitted is returned when a user may not watch or steer a session.
+var ErrNotPermitted = errors.New("not permitted")
+
+// CollabEvent tells the participants of a shared session who is watching
+// and who has control, and warns them before the session expires.
+type CollabEvent struct {
+	Type       string     `json:"type"`
+	SessionID  string     `json:"session_id"`
+	User       string     `json:"user,omitempty"` // user the event is about
+	Owner      string     `json:"owner"`
+	Controller string     `json:"controller"`
+	Watchers   []string   `json:"watchers"`
+	Requests   []string   `json:"requests,omitempty"`
+	Reason     string     `json:"reason,omitempty"`     // expiry events: which limit
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // expiry events
+	Extendable bool       `json:"extendable,omitempty"` // expiry warnings: whether Extend helps
+}
+
+// Watcher is a read-only subscriber to a session's output. ID identifies
+// the client connection; one user may watch from several browsers.
+type Watcher struct {
+	ID     string
+	User   string
+	Output func([]byte)
+	Event  func(CollabEvent)
+}
+
+// SharedSession describes a session another user has invited the caller to.
+type SharedSession struct {
+	SessionID    string `json:"session_id"`
+	InstanceID   string `json:"instance_id"`
+	InstanceName string `json:"instance_name"`
+	Owner        string `json:"owner"`
+	Controller   string `json:"controller"`
+}
+
+// SetEventHandler sets the callback that delivers collaboration events to
+// the owner's client. Detach clears it.
+func (m *Manager) SetEventHandler(sessionID string, fn func(CollabEvent)) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.onEvent = fn
+	s.mu.Unlock()
+}
+
+// Invite allows user to watch a session.
+func (m *Manager) Invite(sessionID, user string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.invited == nil {
+		s.invited = make(map[string]bool)
+	}
+	s.invited[user] = true
+	return nil
+}
+
+// Revoke withdraws user's invitation, disconnects their watchers and, if
+// they had control, hands it back to the owner.
+func (m *Manager) Revoke(sessionID, user string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	delete(s.invited, user)
+	delete(s.requests, user)
+	var kicked []func(CollabEvent)
+	for id, w := range s.watchers {
+		if w.User == user {
+			if w.Event != nil {
+				kicked = append(kicked, w.Event)
+			}
+			delete(s.watchers, id)
+		}
+	}
+	if s.controller == user {
+		s.controller = ""
+	}
+	ev, fns := s.eventLocked(EventAccessRevoked, user)
+	s.mu.Unlock()
+
+	deliver(ev, append(fns, kicked...))
+	return nil
+}
+
+// Invitations lists the sessions user has been invited to watch.
+func (m *Manager) Invitations(user string) []SharedSession {
+	out := []SharedSession{}
+	for _, s := range m.allSessions() {
+		s.mu.Lock()
+		if s.invited[user] {
+			out = append(out, SharedSession{
+				SessionID:    s.SessionID,
+				InstanceID:   s.InstanceID,
+				InstanceName: s.InstanceName,
+				Owner:        s.owner,
+				Controller:   s.controllerLocked(),
+			})
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SessionID < out[j].SessionID })
+	return out
+}
+
+// Watch subscribes w to a session's output and replays the buffered output.
+// Only the owner and invited users may watch.
+func (m *Manager) Watch(sessionID string, w *Watcher) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if w.User != s.owner && !s.invited[w.User] {
+		s.mu.Unlock()
+		return fmt.Errorf("watch session %s: %w", sessionID, ErrNotPermitted)
+	}
+	if s.watchers == nil {
+		s.watchers = make(map[string]*Watcher)
+	}
+	s.watchers[w.ID] = w
+	replay := make([]byte, s.outputBuf.Len())
+	copy(replay, s.outputBuf.Bytes())
+	ev, fns := s.eventLocked(EventWatcherJoined, w.User)
+	s.mu.Unlock()
+
+	if len(replay) > 0 {
+		w.Output(replay)
+	}
+	deliver(ev, fns)
+	return nil
+}
+
+// Unwatch removes a watcher. If its user has no other watcher left and had
+// control, control returns to the owner.
+func (m *Manager) Unwatch(sessionID, watcherID string) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	w, ok := s.watchers[watcherID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.watchers, watcherID)
+	if !s.watchingLocked(w.User) {
+		delete(s.requests, w.User)
+		if s.controller == w.User {
+			s.controller = ""
+		}
+	}
+	ev, fns := s.eventLocked(EventWatcherLeft, w.User)
+	s.mu.Unlock()
+
+	deliver(ev, fns)
+}
+
+// RequestControl asks the controller to hand over input to user, who must
+// be watching the session.
+func (m *Manager) RequestControl(sessionID, user string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if !s.watchingLocked(user) || s.controllerLocked() == user {
+		s.mu.Unlock()
+		return fmt.Errorf("request control of %s: %w", sessionID, ErrNotPermitted)
+	}
+	if s.requests == nil {
+		s.requests = make(map[string]bool)
+	}
+	s.requests[user] = true
+	ev, fns := s.eventLocked(EventControlRequested, user)
+	s.mu.Unlock()
+
+	deliver(ev, fns)
+	return nil
+}
+
+// GrantControl hands input to user. Only the owner or the current
+// controller may grant, and only to the owner or a user who asked for it.
+func (m *Manager) GrantControl(sessionID, by, user string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if (by != s.owner && by != s.controllerLocked()) || (user != s.owner && !s.requests[user]) {
+		s.mu.Unlock()
+		return fmt.Errorf("grant control of %s: %w", sessionID, ErrNotPermitted)
+	}
+	delete(s.requests, user)
+	s.controller = user
+	if user == s.owner {
+		s.controller = ""
+	}
+	ev, fns := s.eventLocked(EventControlChanged, user)
+	s.mu.Unlock()
+
+	deliver(ev, fns)
+	return nil
+}
+
+// ReleaseControl returns control to the owner if user holds it.
+func (m *Manager) ReleaseControl(sessionID, user string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if s.controller != user || user == "" {
+		s.mu.Unlock()
+		return fmt.Errorf("release control of %s: %w", sessionID, ErrNotPermitted)
+	}
+	s.controller = ""
+	ev, fns := s.eventLocked(EventControlChanged, s.owner)
+	s.mu.Unlock()
+
+	deliver(ev, fns)
+	return nil
+}
+
+// CanWrite reports whether user currently controls the session's input.
+func (m *Manager) CanWrite(sessionID, user string) bool {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.controllerLocked() == user
+}
+
+// CanView reports whether user may see a session's output: its owner and
+// invited watchers.
+func (m *Manager) CanView(sessionID, user string) bool {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return user == s.owner || s.invited[user]
+}
+
+// notifyClosed tells a closed session's watchers that it has ended.
+func (s *SSMSession) notifyClosed() {
+	s.mu.Lock()
+	ev, fns := s.eventLocked(EventSessionClosed, "")
+	s.watchers = nil
+	s.mu.Unlock()
+	deliver(ev, fns)
+}
+
+// controllerLocked returns the user allowed to send input; s.mu must be held.
+func (s *SSMSession) controllerLocked() string {
+	if s.controller != "" {
+		return s.controller
+	}
+	return s.owner
+}
+
+// watchingLocked reports whether user has a watcher on s; s.mu must be held.
+func (s *SSMSession) watchingLocked(user string) bool {
+	for _, w := range s.watchers {
+		if w.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+// eventLocked builds an event and collects the callbacks of everyone who
+// should receive it; s.mu must be held. Deliver it after unlocking.
+func (s *SSMSession) eventLocked(typ, user string) (CollabEvent, []func(CollabEvent)) {
+	ev := CollabEvent{
+		Type:       typ,
+		SessionID:  s.SessionID,
+		User:       user,
+		Owner:      s.owner,
+		Controller: s.controllerLocked(),
+		Watchers:   []string{},
+	}
+	seen := make(map[string]bool)
+	var fns []func(CollabEvent)
+	if s.onEvent != nil {
+		fns = append(fns, s.onEvent)
+	}
+	for _, w := range s.watchers {
+		if !seen[w.User] {
+			seen[w.User] = true
+			ev.Watchers = append(ev.Watchers, w.User)
+		}
+		if w.Event != nil {
+			fns = append(fns, w.Event)
+		}
+	}
+	for u := range s.requests {
+		ev.Requests = append(ev.Requests, u)
+	}
+	sort.Strings(ev.Watchers)
+	sort.Strings(ev.Requests)
+	return ev, fns
+}
+
+func deliver(ev CollabEvent, fns []func(CollabEvent)) {
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
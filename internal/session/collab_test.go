@@ -0,0 +1,103 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+type eventLog struct{ events []CollabEvent }
+
+func (l *eventLog) add(ev CollabEvent) { l.events = append(l.events, ev) }
+
+func (l *eventLog) last() CollabEvent {
+	if len(l.events) == 0 {
+		return CollabEvent{}
+	}
+	return l.events[len(l.events)-1]
+}
+
+func TestWatchersAndControlHandOff(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	s := fakeSession(m, "term-1", "alice")
+	s.outputBuf.WriteString("$ ")
+	var owner eventLog
+	m.SetEventHandler("term-1", owner.add)
+
+	var got string
+	var bob eventLog
+	w := &Watcher{ID: "c1", User: "bob", Output: func(b []byte) { got += string(b) }, Event: bob.add}
+	if err := m.Watch("term-1", w); !errors.Is(err, ErrNotPermitted) {
+		t.Fatalf("uninvited watch: %v", err)
+	}
+	m.Invite("term-1", "bob")
+	if err := m.Watch("term-1", w); err != nil {
+		t.Fatal(err)
+	}
+	if got != "$ " {
+		t.Errorf("watcher replay = %q", got)
+	}
+	if ev := owner.last(); ev.Type != EventWatcherJoined || len(ev.Watchers) != 1 || ev.Watchers[0] != "bob" {
+		t.Errorf("owner event %+v", ev)
+	}
+	if m.CanWrite("term-1", "bob") || !m.CanWrite("term-1", "alice") {
+		t.Fatal("only the owner may type initially")
+	}
+
+	// Control is only granted on request, and only by the controller.
+	if err := m.GrantControl("term-1", "alice", "bob"); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("grant without request: %v", err)
+	}
+	if err := m.RequestControl("term-1", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if ev := owner.last(); ev.Type != EventControlRequested || len(ev.Requests) != 1 {
+		t.Errorf("owner should see the request: %+v", ev)
+	}
+	if err := m.GrantControl("term-1", "bob", "bob"); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("watcher granted itself control: %v", err)
+	}
+	if err := m.GrantControl("term-1", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.CanWrite("term-1", "bob") || m.CanWrite("term-1", "alice") {
+		t.Fatal("bob should hold control")
+	}
+	if ev := bob.last(); ev.Type != EventControlChanged || ev.Controller != "bob" {
+		t.Errorf("watcher event %+v", ev)
+	}
+
+	// The owner can always take control back.
+	if err := m.GrantControl("term-1", "alice", "alice"); err != nil || !m.CanWrite("term-1", "alice") {
+		t.Fatalf("owner reclaim: %v", err)
+	}
+
+	// Leaving while in control hands it back to the owner.
+	m.RequestControl("term-1", "bob")
+	m.GrantControl("term-1", "alice", "bob")
+	m.Unwatch("term-1", "c1")
+	if !m.CanWrite("term-1", "alice") {
+		t.Error("control should return to the owner when the controller leaves")
+	}
+
+	// Revoking disconnects the watcher.
+	m.Watch("term-1", w)
+	m.Revoke("term-1", "bob")
+	if ev := bob.last(); ev.Type != EventAccessRevoked {
+		t.Errorf("revoked watcher event %+v", ev)
+	}
+	if invites := m.Invitations("bob"); len(invites) != 0 {
+		t.Errorf("invitation should be gone: %+v", invites)
+	}
+	if err := m.Watch("term-1", w); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("revoked user could watch again: %v", err)
+	}
+
+	m.Invite("term-1", "bob")
+	m.Watch("term-1", w)
+	m.CloseSession("term-1")
+	if ev := bob.last(); ev.Type != EventSessionClosed {
+		t.Errorf("watchers should be told the session closed: %+v", ev)
+	}
+}
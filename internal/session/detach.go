@@ -0,0 +1,153 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DetachedSession describes a session that is running without a client.
+type DetachedSession struct {
+	SessionID    string     `json:"session_id"`
+	InstanceID   string     `json:"instance_id"`
+	InstanceName string     `json:"instance_name"`
+	Owner        string     `json:"owner,omitempty"`
+	DetachedAt   time.Time  `json:"detached_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"` // nil when kept indefinitely
+	Recording    bool       `json:"recording"`
+}
+
+// SetDetachGrace sets how long a detached session is kept before it is
+// closed; a negative value keeps it until the remote shell exits. fn, if
+// non-nil, is called when a detached session is closed by the manager.
+// Call it before any session starts.
+func (m *Manager) SetDetachGrace(grace time.Duration, fn func(s DetachedSession, reason string)) {
+	m.detachGrace = grace
+	m.onDetachEnd = fn
+}
+
+// Owner returns the user who started a session.
+func (m *Manager) Owner(sessionID string) (string, bool) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.owner, true
+}
+
+// Detach unbinds a session from its client. The shell keeps running and its
+// output keeps accumulating in the replay buffer (and recording) until the
+// session is reattached, the grace period ends or the shell exits.
+func (m *Manager) Detach(sessionID string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if s.attached != nil {
+		close(s.attached)
+	}
+	attached := make(chan struct{})
+	s.attached = attached
+	s.detachedAt = time.Now()
+	s.onOutput = func([]byte) {}
+	s.onEvent = nil
+	s.mu.Unlock()
+
+	go m.expireDetached(s, attached)
+	m.logger.Printf("session %s detached", sessionID)
+	return nil
+}
+
+// expireDetached closes s once the grace period ends or the shell exits,
+// unless it is reattached first.
+func (m *Manager) expireDetached(s *SSMSession, attached chan struct{}) {
+	var timeout <-chan time.Time
+	if m.detachGrace >= 0 {
+		t := time.NewTimer(m.detachGrace)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var reason string
+	select {
+	case <-attached:
+		return
+	case <-timeout:
+		reason = "detach_expired"
+	case <-s.done:
+		reason = "exited"
+	}
+
+	s.mu.Lock()
+	if s.attached != attached {
+		s.mu.Unlock()
+		return
+	}
+	info := m.detachedInfoLocked(s)
+	s.mu.Unlock()
+	if err := m.EndSession(s.SessionID, reason); err != nil {
+		return
+	}
+	if m.onDetachEnd != nil {
+		m.onDetachEnd(info, reason)
+	}
+}
+
+// Attach binds a session to a new client and replays its buffered output.
+// It works for detached sessions as well as for sessions whose previous
+// client has not noticed that it is gone yet.
+func (m *Manager) Attach(sessionID string, onOutput func([]byte)) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	if s.attached != nil {
+		close(s.attached)
+		s.attached = nil
+	}
+	s.detachedAt = time.Time{}
+	s.onOutput = onOutput
+	replay := make([]byte, s.outputBuf.Len())
+	copy(replay, s.outputBuf.Bytes())
+	s.mu.Unlock()
+
+	if len(replay) > 0 {
+		onOutput(replay)
+	}
+	return nil
+}
+
+// DetachedSessions lists the detached sessions started by owner, most
+// recently detached first.
+func (m *Manager) DetachedSessions(owner string) []DetachedSession {
+	out := []DetachedSession{}
+	for _, s := range m.allSessions() {
+		s.mu.Lock()
+		if !s.detachedAt.IsZero() && s.owner == owner {
+			out = append(out, m.detachedInfoLocked(s))
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DetachedAt.After(out[j].DetachedAt) })
+	return out
+}
+
+// detachedInfoLocked describes s; s.mu must be held.
+func (m *Manager) detachedInfoLocked(s *SSMSession) DetachedSession {
+	info := DetachedSession{
+		SessionID:    s.SessionID,
+		InstanceID:   s.InstanceID,
+		InstanceName: s.InstanceName,
+		Owner:        s.owner,
+		DetachedAt:   s.detachedAt,
+		Recording:    s.recorder != nil,
+	}
+	if m.detachGrace >= 0 && !s.detachedAt.IsZero() {
+		exp := s.detachedAt.Add(m.detachGrace)
+		info.ExpiresAt = &exp
+	}
+	return info
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSession registers a session without a backing process.
+func fakeSession(m *Manager, id, owner string) *SSMSession {
+	s := &SSMSession{InstanceID: "i-1", InstanceName: "web", SessionID: id, owner: owner, done: make(chan struct{}), onOutput: func([]byte) {}}
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+	return s
+}
+
+func TestDetachAndReattach(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	m.SetDetachGrace(-1, nil)
+	s := fakeSession(m, "term-1", "alice")
+	s.outputBuf.WriteString("$ top\r\n")
+
+	if err := m.Detach("term-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.DetachedSessions("bob"); len(got) != 0 {
+		t.Fatalf("other users must not see alice's sessions: %+v", got)
+	}
+	got := m.DetachedSessions("alice")
+	if len(got) != 1 || got[0].SessionID != "term-1" || got[0].ExpiresAt != nil {
+		t.Fatalf("unexpected detached sessions %+v", got)
+	}
+
+	var replay string
+	if err := m.Attach("term-1", func(b []byte) { replay += string(b) }); err != nil {
+		t.Fatal(err)
+	}
+	if replay != "$ top\r\n" {
+		t.Errorf("replay = %q", replay)
+	}
+	if got := m.DetachedSessions("alice"); len(got) != 0 {
+		t.Errorf("reattached session still listed: %+v", got)
+	}
+	if _, ok := m.GetSession("term-1"); !ok {
+		t.Error("session should survive detach")
+	}
+}
+
+func TestDetachedSessionExpires(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	var mu sync.Mutex
+	var reasons []string
+	ended := make(chan struct{}, 2)
+	m.SetDetachGrace(20*time.Millisecond, func(s DetachedSession, reason string) {
+		mu.Lock()
+		reasons = append(reasons, s.SessionID+":"+reason)
+		mu.Unlock()
+		ended <- struct{}{}
+	})
+
+	fakeSession(m, "term-1", "alice")
+	fakeSession(m, "term-2", "alice")
+	s3 := fakeSession(m, "term-3", "alice")
+	m.Detach("term-1")
+	m.Detach("term-2")
+	m.Attach("term-2", func([]byte) {})
+	m.Detach("term-3")
+	close(s3.done) // shell exited while detached
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ended:
+		case <-time.After(time.Second):
+			t.Fatal("detached session was not closed")
+		}
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := m.GetSession("term-1"); ok {
+		t.Error("expired session should be closed")
+	}
+	if _, ok := m.GetSession("term-2"); !ok {
+		t.Error("reattached session must not expire")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) != 2 {
+		t.Fatalf("unexpected end reasons %v", reasons)
+	}
+	for _, r := range reasons {
+		if r != "term-1:detach_expired" && r != "term-3:exited" {
+			t.Errorf("unexpected end reason %s", r)
+		}
+	}
+}
@@ -0,0 +1,654 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Export formats.
+const (
+	FormatText     = "text"
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// Export sources.
+const (
+	SourceBuffer    = "buffer"    // the replay buffer: the last 512 KB of output
+	SourceRecording = "recording" // every recording made during the session
+)
+
+// maxSearchMatches caps the matches returned by SearchOutput.
+const maxSearchMatches = 1000
+
+// ErrNoRecording is returned when a recording export is requested for a
+// session that was never recorded.
+var ErrNoRecording = errors.New("session has no recording")
+
+// promptLine matches a shell prompt followed by a command, e.g.
+// "[ec2-user@ip-10-0-0-1 ~]$ df -h" or "root@host:/# ls".
+var promptLine = regexp.MustCompile(`^(?:[^\s$#%>][^$#%>]{0,80}[$#%>]|\$) (.+)$`)
+
+// ExportOptions selects what ExportSession writes.
+type ExportOptions struct {
+	Format string // FormatText (default), FormatHTML, FormatMarkdown or FormatJSON
+	Source string // SourceBuffer (default) or SourceRecording
+}
+
+// Transcript is a session's output split into command/output blocks; it is
+// the JSON export format.
+type Transcript struct {
+	SessionID    string    `json:"session_id"`
+	InstanceID   string    `json:"instance_id"`
+	InstanceName string    `json:"instance_name"`
+	Source       string    `json:"source"`
+	Exported     time.Time `json:"exported"`
+	Blocks       []Block   `json:"blocks"`
+}
+
+// Block is a command and the output that followed it. Output shown before
+// the first prompt has no command.
+type Block struct {
+	Command string     `json:"command,omitempty"`
+	Output  string     `json:"output"`
+	Time    *time.Time `json:"time,omitempty"` // known for recording exports
+}
+
+// chunk is a piece of terminal output and, from a recording, when it was
+// written.
+type chunk struct {
+	at   time.Time
+	data []byte
+}
+
+// ExportSession writes a session's output to exportDir in the requested
+// format and returns the file name.
+func (m *Manager) ExportSession(sessionID, exportDir string, opts ExportOptions) (string, error) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return "", fmt.Errorf("session %s not found", sessionID)
+	}
+	if opts.Source == "" {
+		opts.Source = SourceBuffer
+	}
+
+	var chunks []chunk
+	switch opts.Source {
+	case SourceBuffer:
+		s.mu.Lock()
+		chunks = []chunk{{data: bytes.Clone(s.outputBuf.Bytes())}}
+		s.mu.Unlock()
+	case SourceRecording:
+		var err error
+		if chunks, err = m.recordedOutput(s); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown export source %q", opts.Source)
+	}
+
+	var data []byte
+	var ext string
+	switch opts.Format {
+	case "", FormatText:
+		// Strip ANSI escape sequences so the export reads like plain terminal text.
+		data, ext = stripANSI(joinChunks(chunks)), "log"
+	case FormatHTML:
+		data, ext = renderHTML(s, joinChunks(chunks)), "html"
+	case FormatMarkdown:
+		data, ext = renderMarkdown(s, opts.Source, splitBlocks(chunks)), "md"
+	case FormatJSON:
+		t := Transcript{
+			SessionID:    s.SessionID,
+			InstanceID:   s.InstanceID,
+			InstanceName: s.InstanceName,
+			Source:       opts.Source,
+			Exported:     time.Now().UTC(),
+			Blocks:       splitBlocks(chunks),
+		}
+		var err error
+		if data, err = json.MarshalIndent(t, "", "  "); err != nil {
+			return "", err
+		}
+		ext = "json"
+	default:
+		return "", fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	filename := fmt.Sprintf("%s_%d.%s", sessionID, time.Now().Unix(), ext)
+	path := filepath.Join(exportDir, filename)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write export: %w", err)
+	}
+
+	m.logger.Printf("session %s exported to %s (%d bytes)", sessionID, filename, len(data))
+	return filename, nil
+}
+
+// recordedOutput reads the output events of every recording made during s.
+func (m *Manager) recordedOutput(s *SSMSession) ([]chunk, error) {
+	s.mu.Lock()
+	names := append([]string(nil), s.recordings...)
+	s.mu.Unlock()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("export session %s: %w", s.SessionID, ErrNoRecording)
+	}
+	var chunks []chunk
+	for _, name := range names {
+		c, err := readCastOutput(filepath.Join(m.recordingDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read recording %s: %w", name, err)
+		}
+		chunks = append(chunks, c...)
+	}
+	return chunks, nil
+}
+
+// readCastOutput returns the "o" events of an asciicast v2 file.
+func readCastOutput(path string) ([]chunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		return nil, errors.New("empty recording")
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil || header.Version != 2 {
+		return nil, errors.New("not an asciicast v2 recording")
+	}
+	start := time.Unix(header.Timestamp, 0).UTC()
+
+	var chunks []chunk
+	for sc.Scan() {
+		var ev []json.RawMessage
+		var at float64
+		var code, data string
+		if json.Unmarshal(sc.Bytes(), &ev) != nil || len(ev) < 3 ||
+			json.Unmarshal(ev[0], &at) != nil || json.Unmarshal(ev[1], &code) != nil || json.Unmarshal(ev[2], &data) != nil {
+			continue
+		}
+		if code == "o" {
+			chunks = append(chunks, chunk{at: start.Add(time.Duration(at * float64(time.Second))), data: []byte(data)})
+		}
+	}
+	return chunks, sc.Err()
+}
+
+func joinChunks(chunks []chunk) []byte {
+	var buf bytes.Buffer
+	for _, c := range chunks {
+		buf.Write(c.data)
+	}
+	return buf.Bytes()
+}
+
+// Match is one search hit in a session's output.
+type Match struct {
+	Line   int    `json:"line"`   // line number in the searched output, from 0
+	Column int    `json:"column"` // character offset of the match in the line
+	Length int    `json:"length"` // match length in characters
+	Text   string `json:"text"`   // the whole line
+}
+
+// SearchResult lists the matches of a search, oldest first.
+type SearchResult struct {
+	Matches   []Match `json:"matches"`
+	Lines     int     `json:"lines"` // lines searched; the last one is the terminal's current line
+	Truncated bool    `json:"truncated"`
+	// Current is the index in Matches of the match selected by the
+	// search's direction, or -1 if there are none.
+	Current int `json:"current"`
+}
+
+// SearchOptions describes a search of a session's output.
+type SearchOptions struct {
+	Pattern    string
+	Regex      bool // Pattern is a regular expression rather than literal text
+	IgnoreCase bool
+	// From and Direction ("next" or "prev") pick Current: the first match
+	// after line From, or the last match before it, wrapping around. A
+	// negative From is the end of the output.
+	From      int
+	Direction string
+}
+
+// SearchOutput searches the ANSI-stripped replay buffer of a session.
+func (m *Manager) SearchOutput(sessionID string, opts SearchOptions) (*SearchResult, error) {
+	if opts.Pattern == "" {
+		return nil, errors.New("search pattern is required")
+	}
+	expr := opts.Pattern
+	if !opts.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	text := stripANSI(s.outputBuf.Bytes())
+	s.mu.Unlock()
+
+	lines := strings.Split(string(text), "\n")
+	res := &SearchResult{Matches: []Match{}, Lines: len(lines), Current: -1}
+	for n, line := range lines {
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if len(res.Matches) == maxSearchMatches {
+				res.Truncated = true
+				break
+			}
+			res.Matches = append(res.Matches, Match{
+				Line:   n,
+				Column: utf8.RuneCountInString(line[:loc[0]]),
+				Length: utf8.RuneCountInString(line[loc[0]:loc[1]]),
+				Text:   line,
+			})
+		}
+	}
+	res.Current = currentMatch(res.Matches, opts.From, opts.Direction, res.Lines)
+	return res, nil
+}
+
+// currentMatch implements SearchOptions.From and Direction.
+func currentMatch(matches []Match, from int, direction string, lines int) int {
+	if len(matches) == 0 {
+		return -1
+	}
+	if from < 0 {
+		from = lines
+	}
+	if direction == "next" {
+		i := sort.Search(len(matches), func(i int) bool { return matches[i].Line > from })
+		if i == len(matches) {
+			return 0
+		}
+		return i
+	}
+	i := sort.Search(len(matches), func(i int) bool { return matches[i].Line >= from })
+	if i == 0 {
+		return len(matches) - 1
+	}
+	return i - 1
+}
+
+// splitBlocks splits terminal output into command/output blocks at lines
+// that start with a shell prompt.
+func splitBlocks(chunks []chunk) []Block {
+	blocks := []Block{}
+	var cur *Block
+	var out []string
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		cur.Output = strings.TrimRight(strings.Join(out, "\n"), "\n ")
+		if cur.Command != "" || cur.Output != "" {
+			blocks = append(blocks, *cur)
+		}
+		out = nil
+	}
+	var line bytes.Buffer
+	var lineAt time.Time
+	emit := func() {
+		text := strings.TrimRight(line.String(), " \t")
+		line.Reset()
+		if m := promptLine.FindStringSubmatch(text); m != nil {
+			flush()
+			cur = &Block{Command: strings.TrimSpace(m[1])}
+		} else {
+			if cur == nil {
+				cur = &Block{}
+			}
+			out = append(out, text)
+		}
+		if cur.Time == nil && !lineAt.IsZero() {
+			at := lineAt
+			cur.Time = &at
+		}
+		lineAt = time.Time{}
+	}
+	for _, c := range chunks {
+		scanTerminal(c.data, func(text []byte) {
+			for len(text) > 0 {
+				if line.Len() == 0 && lineAt.IsZero() {
+					lineAt = c.at
+				}
+				i := bytes.IndexByte(text, '\n')
+				if i < 0 {
+					line.Write(text)
+					return
+				}
+				line.Write(text[:i])
+				emit()
+				text = text[i+1:]
+			}
+		}, nil)
+	}
+	if line.Len() > 0 {
+		emit()
+	}
+	flush()
+	return blocks
+}
+
+// renderMarkdown writes blocks as a Markdown transcript with one fenced
+// console block per command.
+func renderMarkdown(s *SSMSession, source string, blocks []Block) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s\n\n", s.SessionID)
+	fmt.Fprintf(&b, "- Instance: %s (%s)\n", s.InstanceName, s.InstanceID)
+	fmt.Fprintf(&b, "- Exported: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if source == SourceRecording {
+		b.WriteString("- Source: session recording\n")
+	} else {
+		b.WriteString("- Source: replay buffer (most recent output only)\n")
+	}
+	for _, blk := range blocks {
+		body := blk.Output
+		if blk.Command != "" {
+			body = strings.TrimRight("$ "+blk.Command+"\n"+blk.Output, "\n")
+		}
+		fence := strings.Repeat("`", max(3, longestRun(body, '`')+1))
+		b.WriteString("\n")
+		if blk.Time != nil {
+			fmt.Fprintf(&b, "_%s_\n\n", blk.Time.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(&b, "%sconsole\n%s\n%s\n", fence, body, fence)
+	}
+	return []byte(b.String())
+}
+
+func longestRun(s string, c byte) int {
+	longest, n := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			n++
+			longest = max(longest, n)
+		} else {
+			n = 0
+		}
+	}
+	return longest
+}
+
+// scanTerminal walks raw terminal output, passing printable text (with
+// "\r\n" turned into "\n" and other control characters dropped) to text
+// and the parameters of each SGR (colour) sequence to sgr, which may be
+// nil. Other escape sequences are skipped.
+func scanTerminal(raw []byte, text func([]byte), sgr func(params string)) {
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			text(raw[start:end])
+		}
+	}
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case c == 0x1b:
+			flush(i)
+			i = skipEscape(raw, i, sgr)
+			start = i
+		case c == '\r' && i+1 < len(raw) && raw[i+1] == '\n':
+			flush(i)
+			i++
+			start = i
+		case c < 0x20 && c != '\n' && c != '\t', c == 0x7f:
+			flush(i)
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	flush(len(raw))
+}
+
+// skipEscape returns the index after the escape sequence at raw[i],
+// reporting SGR sequences to sgr.
+func skipEscape(raw []byte, i int, sgr func(string)) int {
+	if i+1 >= len(raw) {
+		return len(raw)
+	}
+	switch raw[i+1] {
+	case '[': // CSI: parameters, then a final byte in 0x40–0x7e
+		j := i + 2
+		for j < len(raw) && (raw[j] < 0x40 || raw[j] > 0x7e) {
+			j++
+		}
+		if j == len(raw) {
+			return j
+		}
+		if raw[j] == 'm' && sgr != nil {
+			sgr(string(raw[i+2 : j]))
+		}
+		return j + 1
+	case ']': // OSC: ends with BEL or ST
+		for j := i + 2; j < len(raw); j++ {
+			if raw[j] == 0x07 {
+				return j + 1
+			}
+			if raw[j] == 0x1b && j+1 < len(raw) && raw[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return len(raw)
+	case '(', ')': // charset designation
+		return min(i+3, len(raw))
+	}
+	return i + 2
+}
+
+// textStyle is the SGR state of rendered text.
+type textStyle struct {
+	fg, bg                               string
+	bold, dim, italic, underline, invert bool
+}
+
+// ansiColors are the xterm default colours for SGR 30–37 and 90–97.
+var ansiColors = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+const (
+	defaultFG = "#d4d4d4"
+	defaultBG = "#1e1e1e"
+)
+
+// apply updates st with the SGR parameters.
+func (st *textStyle) apply(params string) {
+	if params == "" {
+		params = "0"
+	}
+	codes := strings.Split(strings.ReplaceAll(params, ":", ";"), ";")
+	for i := 0; i < len(codes); i++ {
+		n, _ := strconv.Atoi(codes[i])
+		switch {
+		case n == 0:
+			*st = textStyle{}
+		case n == 1:
+			st.bold = true
+		case n == 2:
+			st.dim = true
+		case n == 3:
+			st.italic = true
+		case n == 4:
+			st.underline = true
+		case n == 7:
+			st.invert = true
+		case n == 22:
+			st.bold, st.dim = false, false
+		case n == 23:
+			st.italic = false
+		case n == 24:
+			st.underline = false
+		case n == 27:
+			st.invert = false
+		case n >= 30 && n <= 37:
+			st.fg = ansiColors[n-30]
+		case n >= 90 && n <= 97:
+			st.fg = ansiColors[n-90+8]
+		case n == 39:
+			st.fg = ""
+		case n >= 40 && n <= 47:
+			st.bg = ansiColors[n-40]
+		case n >= 100 && n <= 107:
+			st.bg = ansiColors[n-100+8]
+		case n == 49:
+			st.bg = ""
+		case n == 38 || n == 48:
+			color, used := extendedColor(codes[i+1:])
+			i += used
+			if n == 38 {
+				st.fg = color
+			} else {
+				st.bg = color
+			}
+		}
+	}
+}
+
+// extendedColor parses the arguments of SGR 38/48 ("5;n" or "2;r;g;b")
+// and returns the colour and how many codes it used.
+func extendedColor(args []string) (string, int) {
+	num := func(i int) int {
+		if i >= len(args) {
+			return 0
+		}
+		n, _ := strconv.Atoi(args[i])
+		return min(max(n, 0), 255)
+	}
+	if len(args) == 0 {
+		return "", 0
+	}
+	switch args[0] {
+	case "5":
+		return color256(num(1)), 2
+	case "2":
+		return fmt.Sprintf("#%02x%02x%02x", num(1), num(2), num(3)), 4
+	}
+	return "", 1
+}
+
+// color256 converts an xterm 256-colour index to a hex colour.
+func color256(n int) string {
+	switch {
+	case n < 16:
+		return ansiColors[n]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+}
+
+// css returns the inline style for st, or "" for default text.
+func (st textStyle) css() string {
+	fg, bg := st.fg, st.bg
+	if st.invert {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = defaultBG
+		}
+		if bg == "" {
+			bg = defaultFG
+		}
+	}
+	var parts []string
+	if fg != "" {
+		parts = append(parts, "color:"+fg)
+	}
+	if bg != "" {
+		parts = append(parts, "background:"+bg)
+	}
+	if st.bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if st.dim {
+		parts = append(parts, "opacity:.7")
+	}
+	if st.italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if st.underline {
+		parts = append(parts, "text-decoration:underline")
+	}
+	return strings.Join(parts, ";")
+}
+
+// renderHTML writes terminal output as a standalone HTML page that keeps
+// its colours and text attributes.
+func renderHTML(s *SSMSession, raw []byte) []byte {
+	var b strings.Builder
+	title := html.EscapeString(fmt.Sprintf("%s (%s) — %s", s.InstanceName, s.InstanceID, s.SessionID))
+	fmt.Fprintf(&b, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; background: %s; color: %s; }
+pre { margin: 0; padding: 16px; font: 13px/1.4 Menlo, Consolas, "DejaVu Sans Mono", monospace; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<pre>`, title, defaultBG, defaultFG)
+
+	var st textStyle
+	open := ""
+	scanTerminal(raw, func(text []byte) {
+		if css := st.css(); css != open {
+			if open != "" {
+				b.WriteString("</span>")
+			}
+			if css != "" {
+				fmt.Fprintf(&b, `<span style="%s">`, css)
+			}
+			open = css
+		}
+		b.WriteString(html.EscapeString(strings.ToValidUTF8(string(text), "�")))
+	}, st.apply)
+	if open != "" {
+		b.WriteString("</span>")
+	}
+	b.WriteString("</pre>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
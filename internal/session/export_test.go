@@ -0,0 +1,153 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleOutput = "Last login: Tue\r\n" +
+	"[ec2-user@ip-10-0-0-1 ~]$ ls --color\r\n" +
+	"\x1b[0m\x1b[01;34mlogs\x1b[0m  notes.txt\r\n" +
+	"[ec2-user@ip-10-0-0-1 ~]$ grep -i error app.log\r\n" +
+	"ERROR disk full\r\n" +
+	"error: retrying\r\n" +
+	"[ec2-user@ip-10-0-0-1 ~]$ "
+
+func TestSearchOutput(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	s := fakeSession(m, "term-1", "alice")
+	s.outputBuf.WriteString(sampleOutput)
+
+	res, err := m.SearchOutput("term-1", SearchOptions{Pattern: "error", IgnoreCase: true, From: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command line, "ERROR disk full" and "error: retrying".
+	if len(res.Matches) != 3 || res.Lines != 7 {
+		t.Fatalf("result = %+v", res)
+	}
+	if got := res.Matches[1]; got.Line != 4 || got.Column != 0 || got.Length != 5 || got.Text != "ERROR disk full" {
+		t.Errorf("match = %+v", got)
+	}
+	if res.Current != 2 {
+		t.Errorf("current from the end = %d", res.Current)
+	}
+
+	res, _ = m.SearchOutput("term-1", SearchOptions{Pattern: `^E\w+`, Regex: true})
+	if len(res.Matches) != 1 || res.Matches[0].Line != 4 {
+		t.Errorf("regex matches = %+v", res.Matches)
+	}
+	if _, err := m.SearchOutput("term-1", SearchOptions{Pattern: "(", Regex: true}); err == nil {
+		t.Error("invalid regex accepted")
+	}
+}
+
+func TestCurrentMatch(t *testing.T) {
+	matches := []Match{{Line: 2}, {Line: 5}, {Line: 9}}
+	tests := []struct {
+		from int
+		dir  string
+		want int
+	}{
+		{5, "next", 2},
+		{9, "next", 0}, // wraps
+		{5, "prev", 0},
+		{2, "prev", 2}, // wraps
+		{-1, "", 2},
+	}
+	for _, tt := range tests {
+		if got := currentMatch(matches, tt.from, tt.dir, 10); got != tt.want {
+			t.Errorf("currentMatch(%d, %q) = %d, want %d", tt.from, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestSplitBlocks(t *testing.T) {
+	blocks := splitBlocks([]chunk{{data: []byte(sampleOutput)}})
+	if len(blocks) != 3 {
+		t.Fatalf("blocks = %+v", blocks)
+	}
+	if blocks[0].Command != "" || blocks[0].Output != "Last login: Tue" {
+		t.Errorf("block 0 = %+v", blocks[0])
+	}
+	if blocks[1].Command != "ls --color" || blocks[1].Output != "logs  notes.txt" {
+		t.Errorf("block 1 = %+v", blocks[1])
+	}
+	if blocks[2].Command != "grep -i error app.log" || blocks[2].Output != "ERROR disk full\nerror: retrying\n[ec2-user@ip-10-0-0-1 ~]$" {
+		t.Errorf("block 2 = %+v", blocks[2])
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	s := &SSMSession{SessionID: "term-1", InstanceID: "i-1", InstanceName: "<web>"}
+	out := string(renderHTML(s, []byte("\x1b[1;31mfail\x1b[0m <ok>\x1b]0;title\x07 \x1b[38;5;196mx\x1b[48;2;0;128;255my\x1b[m")))
+	for _, want := range []string{
+		`<title>&lt;web&gt; (i-1)`,
+		`<span style="color:#cd3131;font-weight:bold">fail</span> &lt;ok&gt; `,
+		`<span style="color:#ff0000">x</span><span style="color:#ff0000;background:#0080ff">y</span></pre>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "title\x07") {
+		t.Error("OSC sequence was not removed")
+	}
+}
+
+func TestExportFromRecording(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(log.New(io.Discard, "", 0), dir, false)
+	s := fakeSession(m, "term-1", "alice")
+	s.outputBuf.WriteString("$ tail\r\n")
+
+	if _, err := m.ExportSession("term-1", dir, ExportOptions{Source: SourceRecording}); !errors.Is(err, ErrNoRecording) {
+		t.Fatalf("export without recording = %v", err)
+	}
+
+	cast := `{"version": 2, "width": 80, "height": 24, "timestamp": 1760000000}
+[0.5, "o", "$ uptime\r\n"]
+[0.6, "i", "x"]
+[1.0, "o", " 10:00 up 3 days\r\n$ "]
+`
+	os.WriteFile(filepath.Join(dir, "rec.cast"), []byte(cast), 0644)
+	s.recordings = []string{"rec.cast"}
+
+	name, err := m.ExportSession("term-1", dir, ExportOptions{Format: FormatJSON, Source: SourceRecording})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr Transcript
+	if err := json.Unmarshal(data, &tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Source != SourceRecording || len(tr.Blocks) != 1 {
+		t.Fatalf("transcript = %+v", tr)
+	}
+	b := tr.Blocks[0]
+	if b.Command != "uptime" || b.Output != " 10:00 up 3 days\n$" || b.Time == nil || b.Time.Unix() != 1760000000 {
+		t.Errorf("block = %+v", b)
+	}
+
+	name, err = m.ExportSession("term-1", dir, ExportOptions{Format: FormatMarkdown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, name))
+	if !strings.HasSuffix(name, ".md") || !strings.Contains(string(data), "```console\n$ tail\n```\n") {
+		t.Errorf("markdown %s:\n%s", name, data)
+	}
+	if _, err := m.ExportSession("term-1", dir, ExportOptions{Format: "pdf"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
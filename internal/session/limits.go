@@ -0,0 +1,304 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Why a session expires.
+const (
+	ExpiryIdle        = "idle_timeout"
+	ExpiryMaxDuration = "max_duration"
+)
+
+// Expiry event types passed to the SetLimitPolicy callback.
+const (
+	ExpiryWarning = "warning"
+	ExpiryExpired = "expired"
+)
+
+// ErrNotExtendable is returned by Extend when the session's maximum
+// duration is close and may not be extended.
+var ErrNotExtendable = errors.New("maximum session duration cannot be extended")
+
+// limitCheckInterval is how often sessions with limits are checked.
+var limitCheckInterval = 10 * time.Second
+
+// Limits bounds one session's lifetime. Zero disables a limit.
+type Limits struct {
+	Idle time.Duration // time without input
+	Max  time.Duration // time since the session started
+}
+
+// LimitPolicy chooses Limits for new sessions. Rules are keyed by an
+// instance's environment tag value (case-insensitive) or account ID; an
+// account rule wins over an environment rule, which wins over the default.
+// A rule of zero lifts the limit for matching instances.
+type LimitPolicy struct {
+	Idle   time.Duration
+	IdleBy map[string]time.Duration
+	Max    time.Duration
+	MaxBy  map[string]time.Duration
+	// Warn is how long before expiry the user is warned.
+	Warn time.Duration
+	// Extend is how much Extend adds to the maximum duration; zero makes
+	// the maximum a hard limit. Extend always resets the idle timer.
+	Extend time.Duration
+}
+
+// Enabled reports whether any session can have a limit.
+func (p LimitPolicy) Enabled() bool {
+	if p.Idle > 0 || p.Max > 0 {
+		return true
+	}
+	for _, v := range p.IdleBy {
+		if v > 0 {
+			return true
+		}
+	}
+	for _, v := range p.MaxBy {
+		if v > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the limits for an instance in env and account.
+func (p LimitPolicy) For(env, accountID string) Limits {
+	return Limits{
+		Idle: limitFor(p.Idle, p.IdleBy, env, accountID),
+		Max:  limitFor(p.Max, p.MaxBy, env, accountID),
+	}
+}
+
+func limitFor(def time.Duration, rules map[string]time.Duration, env, accountID string) time.Duration {
+	if v, ok := rules[accountID]; ok && accountID != "" {
+		return v
+	}
+	if env != "" {
+		for k, v := range rules {
+			if strings.EqualFold(k, env) {
+				return v
+			}
+		}
+	}
+	return def
+}
+
+// ParseLimitRules parses "prod=15,123456789012=60" (minutes per
+// environment tag value or account ID) into LimitPolicy rule form.
+func ParseLimitRules(s string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, minutes, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(minutes))
+		if !ok || strings.TrimSpace(key) == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid session limit rule %q (want env=minutes or account=minutes)", part)
+		}
+		out[strings.TrimSpace(key)] = time.Duration(n) * time.Minute
+	}
+	return out, nil
+}
+
+// ExpiryEvent reports a session nearing or reaching one of its limits.
+type ExpiryEvent struct {
+	Type         string // ExpiryWarning or ExpiryExpired
+	Reason       string // ExpiryIdle or ExpiryMaxDuration
+	SessionID    string
+	InstanceID   string
+	InstanceName string
+	Owner        string
+	ExpiresAt    time.Time
+}
+
+// SetLimitPolicy enforces p on sessions started from now on. locate returns
+// the environment tag value and account ID of an instance; fn, if non-nil,
+// is called when a session is warned and when it expires. Call it before
+// any session starts.
+func (m *Manager) SetLimitPolicy(p LimitPolicy, locate func(instanceID string) (env, accountID string), fn func(ExpiryEvent)) {
+	m.limits = p
+	m.locate = locate
+	m.onExpiry = fn
+}
+
+// applyLimits sets a new session's limits and, if it has any, starts
+// enforcing them.
+func (m *Manager) applyLimits(s *SSMSession) {
+	if !m.limits.Enabled() {
+		return
+	}
+	var env, account string
+	if m.locate != nil {
+		env, account = m.locate(s.InstanceID)
+	}
+	l := m.limits.For(env, account)
+	if l.Idle <= 0 && l.Max <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.limits = l
+	s.mu.Unlock()
+	go m.enforceLimits(s)
+}
+
+// enforceLimits warns the user ahead of expiry and closes s when a limit
+// is reached.
+func (m *Manager) enforceLimits(s *SSMSession) {
+	ticker := time.NewTicker(limitCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		now := time.Now()
+		s.mu.Lock()
+		deadline, reason := s.deadlineLocked()
+		switch {
+		case !now.Before(deadline):
+			ev := m.expiryEventLocked(s, ExpiryExpired, reason, deadline)
+			cev, fns := s.eventLocked(EventSessionExpired, "")
+			cev.Reason, cev.ExpiresAt = reason, &deadline
+			out := s.onOutput
+			s.mu.Unlock()
+
+			out([]byte(expiredBanner(reason)))
+			deliver(cev, fns)
+			if err := m.EndSession(s.SessionID, reason); err != nil {
+				return
+			}
+			m.logger.Printf("session %s expired: %s", s.SessionID, reason)
+			if m.onExpiry != nil {
+				m.onExpiry(ev)
+			}
+			return
+		case !now.Before(deadline.Add(-m.limits.Warn)) && !s.warnedFor.Equal(deadline):
+			s.warnedFor = deadline
+			extendable := m.extendable(reason)
+			ev := m.expiryEventLocked(s, ExpiryWarning, reason, deadline)
+			cev, fns := s.eventLocked(EventExpiryWarning, "")
+			cev.Reason, cev.ExpiresAt, cev.Extendable = reason, &deadline, extendable
+			out := s.onOutput
+			s.mu.Unlock()
+
+			out([]byte(warningBanner(reason, deadline.Sub(now), extendable)))
+			deliver(cev, fns)
+			if m.onExpiry != nil {
+				m.onExpiry(ev)
+			}
+		default:
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Extend resets a session's idle timer and, once its maximum duration is
+// within the warning period, extends that by the policy's Extend. It
+// returns the new deadline and the limit it belongs to.
+func (m *Manager) Extend(sessionID string) (time.Time, string, error) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limits.Idle <= 0 && s.limits.Max <= 0 {
+		return time.Time{}, "", fmt.Errorf("session %s has no time limit", sessionID)
+	}
+	s.lastInput = time.Now()
+	if s.limits.Max > 0 && time.Until(s.started.Add(s.limits.Max+s.extended)) <= m.limits.Warn {
+		if m.limits.Extend <= 0 {
+			deadline, reason := s.deadlineLocked()
+			return deadline, reason, ErrNotExtendable
+		}
+		s.extended += m.limits.Extend
+	}
+	s.warnedFor = time.Time{}
+	deadline, reason := s.deadlineLocked()
+	return deadline, reason, nil
+}
+
+// Deadline returns when a session will expire and why; ok is false if it
+// has no limits.
+func (m *Manager) Deadline(sessionID string) (deadline time.Time, reason string, ok bool) {
+	s, found := m.GetSession(sessionID)
+	if !found {
+		return time.Time{}, "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limits.Idle <= 0 && s.limits.Max <= 0 {
+		return time.Time{}, "", false
+	}
+	deadline, reason = s.deadlineLocked()
+	return deadline, reason, true
+}
+
+// deadlineLocked returns the earlier of the idle and maximum-duration
+// deadlines; s.mu must be held and s must have a limit.
+func (s *SSMSession) deadlineLocked() (time.Time, string) {
+	var deadline time.Time
+	var reason string
+	if s.limits.Idle > 0 {
+		deadline, reason = s.lastInput.Add(s.limits.Idle), ExpiryIdle
+	}
+	if s.limits.Max > 0 {
+		if d := s.started.Add(s.limits.Max + s.extended); reason == "" || d.Before(deadline) {
+			deadline, reason = d, ExpiryMaxDuration
+		}
+	}
+	return deadline, reason
+}
+
+// extendable reports whether Extend can push back a deadline reached for
+// reason.
+func (m *Manager) extendable(reason string) bool {
+	return reason == ExpiryIdle || m.limits.Extend > 0
+}
+
+// expiryEventLocked describes s for the expiry callback; s.mu must be held.
+func (m *Manager) expiryEventLocked(s *SSMSession, typ, reason string, deadline time.Time) ExpiryEvent {
+	return ExpiryEvent{
+		Type:         typ,
+		Reason:       reason,
+		SessionID:    s.SessionID,
+		InstanceID:   s.InstanceID,
+		InstanceName: s.InstanceName,
+		Owner:        s.owner,
+		ExpiresAt:    deadline,
+	}
+}
+
+// warningBanner is written to the terminal ahead of expiry.
+func warningBanner(reason string, left time.Duration, extendable bool) string {
+	what := "it has been idle"
+	if reason == ExpiryMaxDuration {
+		what = "it reaches the maximum session length"
+	}
+	hint := "Save your work; this limit cannot be extended."
+	if extendable {
+		hint = "Choose Extend to keep it open."
+		if reason == ExpiryIdle {
+			hint = "Type anything or choose Extend to keep it open."
+		}
+	}
+	return fmt.Sprintf("\r\n\x1b[1;33m*** This session will close in %s because %s. %s ***\x1b[0m\r\n", left.Round(time.Second), what, hint)
+}
+
+// expiredBanner is written to the terminal as the session is closed.
+func expiredBanner(reason string) string {
+	what := "idle timeout"
+	if reason == ExpiryMaxDuration {
+		what = "maximum session length reached"
+	}
+	return fmt.Sprintf("\r\n\x1b[1;31m*** Session closed: %s ***\x1b[0m\r\n", what)
+}
@@ -0,0 +1,152 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitPolicyFor(t *testing.T) {
+	idleBy, err := ParseLimitRules("prod=15, 123456789012=60,dev=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := LimitPolicy{Idle: 30 * time.Minute, IdleBy: idleBy, Max: 8 * time.Hour}
+	tests := []struct {
+		env, account string
+		want         time.Duration
+	}{
+		{"", "", 30 * time.Minute},
+		{"PROD", "", 15 * time.Minute},
+		{"prod", "123456789012", time.Hour}, // account wins
+		{"dev", "", 0},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.env, tt.account); got.Idle != tt.want || got.Max != 8*time.Hour {
+			t.Errorf("For(%q, %q) = %+v, want idle %s", tt.env, tt.account, got, tt.want)
+		}
+	}
+
+	if (LimitPolicy{}).Enabled() || !(LimitPolicy{MaxBy: map[string]time.Duration{"prod": time.Hour}}).Enabled() {
+		t.Error("Enabled is wrong")
+	}
+	for _, bad := range []string{"prod", "prod=x", "=5", "prod=-1"} {
+		if _, err := ParseLimitRules(bad); err == nil {
+			t.Errorf("ParseLimitRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIdleSessionExpires(t *testing.T) {
+	defer func(d time.Duration) { limitCheckInterval = d }(limitCheckInterval)
+	limitCheckInterval = 5 * time.Millisecond
+
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	var mu sync.Mutex
+	var events []string
+	expired := make(chan struct{})
+	m.SetLimitPolicy(LimitPolicy{IdleBy: map[string]time.Duration{"prod": 150 * time.Millisecond}, Warn: 100 * time.Millisecond},
+		func(string) (string, string) { return "prod", "" },
+		func(ev ExpiryEvent) {
+			mu.Lock()
+			events = append(events, ev.Type+":"+ev.Reason+":"+ev.Owner)
+			mu.Unlock()
+			if ev.Type == ExpiryExpired {
+				close(expired)
+			}
+		})
+
+	s := fakeSession(m, "term-1", "alice")
+	var out strings.Builder
+	var collab []string
+	s.lastInput = time.Now()
+	s.started = s.lastInput
+	s.onOutput = func(b []byte) {
+		mu.Lock()
+		out.Write(b)
+		mu.Unlock()
+	}
+	s.onEvent = func(ev CollabEvent) {
+		mu.Lock()
+		collab = append(collab, ev.Type)
+		mu.Unlock()
+	}
+	m.applyLimits(s)
+
+	select {
+	case <-expired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session did not expire")
+	}
+	if _, ok := m.GetSession("term-1"); ok {
+		t.Error("expired session is still registered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(events, " "); got != "warning:idle_timeout:alice expired:idle_timeout:alice" {
+		t.Errorf("events = %s", got)
+	}
+	if got := strings.Join(collab, " "); !strings.HasPrefix(got, "expiry_warning session_expired") {
+		t.Errorf("collab events = %s", got)
+	}
+	if !strings.Contains(out.String(), "will close in") || !strings.Contains(out.String(), "Session closed: idle timeout") {
+		t.Errorf("banners = %q", out.String())
+	}
+}
+
+func TestSessionWithoutLimits(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	m.SetLimitPolicy(LimitPolicy{IdleBy: map[string]time.Duration{"prod": time.Minute}}, func(string) (string, string) { return "dev", "" }, nil)
+	s := fakeSession(m, "term-1", "alice")
+	m.applyLimits(s)
+	if _, _, ok := m.Deadline("term-1"); ok {
+		t.Error("dev session has a deadline")
+	}
+	if _, _, err := m.Extend("term-1"); err == nil {
+		t.Error("extended a session without limits")
+	}
+}
+
+func TestExtend(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	m.limits = LimitPolicy{Warn: 5 * time.Minute, Extend: 30 * time.Minute}
+	s := fakeSession(m, "term-1", "alice")
+	now := time.Now()
+	s.limits = Limits{Idle: 10 * time.Minute, Max: time.Hour}
+	s.started = now.Add(-58 * time.Minute)
+	s.lastInput = now.Add(-9 * time.Minute)
+
+	deadline, reason, ok := m.Deadline("term-1")
+	if !ok || reason != ExpiryIdle || deadline.Sub(now) > time.Minute+time.Second {
+		t.Fatalf("deadline = %s %s", deadline.Sub(now), reason)
+	}
+
+	// Both limits are close: the idle timer restarts and the maximum
+	// duration moves out by 30 minutes.
+	deadline, reason, err := m.Extend("term-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != ExpiryIdle || deadline.Sub(now) < 9*time.Minute {
+		t.Errorf("after extend: %s %s", deadline.Sub(now), reason)
+	}
+	if s.extended != 30*time.Minute {
+		t.Errorf("extended = %s", s.extended)
+	}
+
+	// Far from the maximum, Extend only resets the idle timer.
+	m.Extend("term-1")
+	if s.extended != 30*time.Minute {
+		t.Errorf("extended again = %s", s.extended)
+	}
+
+	m.limits.Extend = 0
+	s.started = now.Add(-88 * time.Minute)
+	if _, reason, err := m.Extend("term-1"); !errors.Is(err, ErrNotExtendable) || reason != ExpiryMaxDuration {
+		t.Errorf("hard limit: %s %v", reason, err)
+	}
+}
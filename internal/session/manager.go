@@ -2,6 +2,7 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -32,21 +33,46 @@ const maxOutputBuf = 512 * 1024 // 512 KB output buffer per session (for reconne
 // ssmKeepaliveInterval is how often we check whether an SSM session has been
 // idle. If no user input has been received for this duration, a null byte is
 // written to the PTY so that AWS SSM does not consider the session idle and
-// terminate it (default SSM idle timeout is 20 min).
+// terminate it (default SSM idle timeout is 20 min). CloudTerm's own idle
+// timeout (see LimitPolicy) is what ends idle sessions.
 const ssmKeepaliveInterval = 5 * time.Minute
 
-// SSMSession represents a single SSM terminal session backed by a PTY.
+// channelOpenTimeout bounds StartSession plus the data channel (or SSH)
+// handshake.
+const channelOpenTimeout = 30 * time.Second
+
+// SSMSession represents a single SSM terminal session.
 type SSMSession struct {
 	InstanceID   string
 	InstanceName string
 	SessionID    string
-	cmd          *exec.Cmd
-	ptmx         *os.File
+	Profile      string
+	Region       string
+	SSHUser      string // OS user of an SSH session; empty for Session Manager shells
+	owner        string // user who started the session
+	term         terminal
 	done         chan struct{}
 	onOutput     func([]byte)
 	outputBuf    bytes.Buffer
 	recorder     *Recorder
+	recordings   []string  // recording file names, oldest first
+	bytesIn      int64     // input written to the terminal
+	bytesOut     int64     // output read from the terminal
 	lastInput    time.Time // last time user sent input
+	started      time.Time // when the terminal opened; the maximum duration counts from here
+	limits       Limits
+	extended     time.Duration // added to limits.Max by Extend
+	warnedFor    time.Time     // deadline the user was last warned about
+	detachedAt   time.Time     // zero while a client is attached
+	attached     chan struct{} // closed when a detached session is reattached
+	onEvent      func(CollabEvent)
+	watchers     map[string]*Watcher
+	taps         map[int]func([]byte) // internal output listeners, see Tap
+	nextTap      int
+	invited      map[string]bool
+	requests     map[string]bool // users asking for control
+	controller   string          // user holding input; empty means the owner
+	secrets      [][]byte        // values masked in output, see WriteSecretInput
 	mu           sync.Mutex
 }
 
@@ -57,6 +83,30 @@ type Manager struct {
 	logger       *log.Logger
 	recordingDir string
 	autoRecord   bool
+	captureInput bool
+	inputPrompt  *regexp.Regexp
+	onRecording  func(RecordingInfo)
+	detachGrace  time.Duration
+	onDetachEnd  func(DetachedSession, string)
+	openChannel  ChannelOpener
+	openSSH      SSHOpener
+	limits       LimitPolicy
+	locate       func(instanceID string) (env, accountID string)
+	onExpiry     func(ExpiryEvent)
+	onEnd        func(Summary)
+}
+
+// RecordingInfo describes a finished SSH recording.
+type RecordingInfo struct {
+	Path         string
+	InstanceID   string
+	InstanceName string
+	SessionID    string
+	Profile      string
+	Region       string
+	Owner        string
+	Started      time.Time
+	Ended        time.Time
 }
 
 // NewManager creates a Manager with the given logger.
@@ -69,6 +119,67 @@ func NewManager(logger *log.Logger, recordingDir string, autoRecord bool) *Manag
 	}
 }
 
+// SetInputCapture makes new recordings include keystrokes ("i" events).
+// Input typed after output matching prompt (DefaultPasswordPrompt if nil)
+// is masked until the next Enter. Call it before any session starts.
+func (m *Manager) SetInputCapture(enabled bool, prompt *regexp.Regexp) {
+	m.captureInput = enabled
+	m.inputPrompt = prompt
+}
+
+// SetRecordingHook registers fn to be called, in its own goroutine, for
+// every SSH recording once it is closed. Call it before any session starts.
+func (m *Manager) SetRecordingHook(fn func(RecordingInfo)) {
+	m.onRecording = fn
+}
+
+// SetSessionOwner records the user who started a session; it is reported
+// with the session's recordings.
+func (m *Manager) SetSessionOwner(sessionID, owner string) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.owner = owner
+	s.mu.Unlock()
+}
+
+// newRecorder starts a recording for s with the manager's capture settings.
+func (m *Manager) newRecorder(s *SSMSession) (*Recorder, error) {
+	rec, err := NewRecorder(m.recordingDir, s.InstanceID, s.InstanceName, 80, 24)
+	if err != nil {
+		return nil, err
+	}
+	if m.captureInput {
+		rec.CaptureInput(NewInputRedactor(m.inputPrompt))
+	}
+	if hook := m.onRecording; hook != nil {
+		started := rec.startTime
+		rec.OnClose(func(path string) {
+			ended := time.Now()
+			// The recorder may be closed with s.mu held.
+			go func() {
+				s.mu.Lock()
+				owner := s.owner
+				s.mu.Unlock()
+				hook(RecordingInfo{
+					Path:         path,
+					InstanceID:   s.InstanceID,
+					InstanceName: s.InstanceName,
+					SessionID:    s.SessionID,
+					Profile:      s.Profile,
+					Region:       s.Region,
+					Owner:        owner,
+					Started:      started,
+					Ended:        ended,
+				})
+			}()
+		})
+	}
+	return rec, nil
+}
+
 // AWSCreds holds explicit AWS credentials for manual accounts.
 // When nil, the session uses --profile instead.
 type AWSCreds struct {
@@ -77,11 +188,38 @@ type AWSCreds struct {
 	SessionToken    string
 }
 
-// StartSession launches an aws ssm start-session process inside a PTY and
-// begins streaming its output through onOutput.
-// If creds is non-nil, the credentials are passed via environment variables
-// instead of --profile (used for manually-added AWS accounts).
+// StartSession opens a shell on the instance and begins streaming its
+// output through onOutput. With a ChannelOpener set (SetChannelOpener) the
+// session uses a native data channel; otherwise it runs aws ssm
+// start-session inside a PTY. If creds is non-nil, the CLI gets the
+// credentials via environment variables instead of --profile (used for
+// manually-added AWS accounts).
 func (m *Manager) StartSession(instanceID, instanceName, sessionID, awsProfile, awsRegion string, creds *AWSCreds, cols, rows uint16, onOutput func([]byte)) error {
+	return m.start(instanceID, instanceName, sessionID, awsProfile, awsRegion, "", onOutput, func() (terminal, error) {
+		return m.openTerminal(instanceID, awsProfile, awsRegion, creds, cols, rows)
+	})
+}
+
+// StartSSHSession is StartSession for an SSH shell as osUser, opened with
+// the SSHOpener set by SetSSHOpener.
+func (m *Manager) StartSSHSession(instanceID, instanceName, sessionID, awsProfile, awsRegion, osUser string, cols, rows uint16, onOutput func([]byte)) error {
+	if m.openSSH == nil {
+		return fmt.Errorf("ssh sessions are not enabled")
+	}
+	return m.start(instanceID, instanceName, sessionID, awsProfile, awsRegion, osUser, onOutput, func() (terminal, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), channelOpenTimeout)
+		defer cancel()
+		ch, err := m.openSSH(ctx, instanceID, awsProfile, awsRegion, osUser, cols, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ssh session: %w", err)
+		}
+		return channelTerminal{ch}, nil
+	})
+}
+
+// start registers a session whose terminal is opened by open, reattaching
+// instead if sessionID is already running.
+func (m *Manager) start(instanceID, instanceName, sessionID, awsProfile, awsRegion, sshUser string, onOutput func([]byte), open func() (terminal, error)) error {
 	// Atomic check-and-reserve under a full write lock to prevent a TOCTOU
 	// race where two concurrent start_session messages both pass the check.
 	m.mu.Lock()
@@ -89,20 +227,10 @@ func (m *Manager) StartSession(instanceID, instanceName, sessionID, awsProfile,
 	if exists && existing != nil {
 		m.mu.Unlock()
 		// Session already running — rebind its output to the new connection
-		// (the old WebSocket may have been closed and reconnected).
-		existing.mu.Lock()
-		existing.onOutput = onOutput
-		existing.mu.Unlock()
-		m.logger.Printf("session %s already active, rebound output to new connection", sessionID)
-		// Replay the buffered output so the reconnected client can catch up.
-		existing.mu.Lock()
-		replay := make([]byte, existing.outputBuf.Len())
-		copy(replay, existing.outputBuf.Bytes())
-		existing.mu.Unlock()
-		if len(replay) > 0 {
-			onOutput(replay)
-		}
-		return nil
+		// (the old WebSocket may have been closed and reconnected) and replay
+		// the buffered output so the client can catch up.
+		m.logger.Printf("session %s already active, rebinding output to new connection", sessionID)
+		return m.Attach(sessionID, onOutput)
 	}
 	if exists && existing == nil {
 		// Slot reserved by a concurrent StartSession in progress — treat as duplicate.
@@ -114,63 +242,37 @@ func (m *Manager) StartSession(instanceID, instanceName, sessionID, awsProfile,
 	m.sessions[sessionID] = nil
 	m.mu.Unlock()
 
-	var cmd *exec.Cmd
-	if creds != nil {
-		cmd = exec.Command("aws", "ssm", "start-session",
-			"--target", instanceID,
-			"--region", awsRegion,
-		)
-		cmd.Env = append(os.Environ(),
-			"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
-			"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
-			"AWS_DEFAULT_REGION="+awsRegion,
-			"TERM=xterm-256color",
-			"COLORTERM=truecolor",
-		)
-		if creds.SessionToken != "" {
-			cmd.Env = append(cmd.Env, "AWS_SESSION_TOKEN="+creds.SessionToken)
-		}
-	} else {
-		cmd = exec.Command("aws", "ssm", "start-session",
-			"--target", instanceID,
-			"--profile", awsProfile,
-			"--region", awsRegion,
-		)
-	}
-
-	if cmd.Env == nil {
-		cmd.Env = os.Environ()
-	}
-	cmd.Env = append(cmd.Env, "TERM=xterm-256color", "COLORTERM=truecolor")
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
-
-	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	term, err := open()
 	if err != nil {
 		// Release the reserved slot so the session ID can be retried.
 		m.mu.Lock()
 		delete(m.sessions, sessionID)
 		m.mu.Unlock()
-		return fmt.Errorf("failed to start pty: %w", err)
+		return err
 	}
 
 	s := &SSMSession{
 		InstanceID:   instanceID,
 		InstanceName: instanceName,
 		SessionID:    sessionID,
-		cmd:          cmd,
-		ptmx:         ptmx,
+		Profile:      awsProfile,
+		Region:       awsRegion,
+		SSHUser:      sshUser,
+		term:         term,
 		done:         make(chan struct{}),
 		onOutput:     onOutput,
 		lastInput:    time.Now(),
+		started:      time.Now(),
 	}
 
 	// Auto-start recording if enabled.
 	if m.autoRecord && m.recordingDir != "" {
-		rec, err := NewRecorder(m.recordingDir, instanceID, instanceName, 80, 24)
+		rec, err := m.newRecorder(s)
 		if err != nil {
 			m.logger.Printf("failed to start recording for %s: %v", sessionID, err)
 		} else {
 			s.recorder = rec
+			s.recordings = append(s.recordings, filepath.Base(rec.Filename()))
 			m.logger.Printf("recording started for session %s", sessionID)
 		}
 	}
@@ -179,14 +281,68 @@ func (m *Manager) StartSession(instanceID, instanceName, sessionID, awsProfile,
 	m.sessions[sessionID] = s
 	m.mu.Unlock()
 
-	go s.readLoop(m.logger)
+	go s.readLoop(m.logger, term)
 	go s.ssmKeepalive(m.logger)
+	m.applyLimits(s)
 
 	m.logger.Printf("session %s started for instance %s", sessionID, instanceID)
 	return nil
 }
 
-// WriteInput sends raw bytes (keystrokes) into the session's PTY.
+// openTerminal connects to an instance's shell, natively or via the CLI.
+func (m *Manager) openTerminal(instanceID, awsProfile, awsRegion string, creds *AWSCreds, cols, rows uint16) (terminal, error) {
+	if m.openChannel != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), channelOpenTimeout)
+		defer cancel()
+		ch, err := m.openChannel(ctx, instanceID, awsProfile, awsRegion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ssm session: %w", err)
+		}
+		if err := ch.Resize(cols, rows); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("failed to size ssm session: %w", err)
+		}
+		return channelTerminal{ch}, nil
+	}
+
+	var cmd *exec.Cmd
+	if creds != nil {
+		cmd = exec.Command("aws", "ssm", "start-session",
+			"--target", instanceID,
+			"--region", awsRegion,
+		)
+		cmd.Env = append(os.Environ(),
+			"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+			"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+			"AWS_DEFAULT_REGION="+awsRegion,
+			"TERM=xterm-256color",
+			"COLORTERM=truecolor",
+		)
+		if creds.SessionToken != "" {
+			cmd.Env = append(cmd.Env, "AWS_SESSION_TOKEN="+creds.SessionToken)
+		}
+	} else {
+		cmd = exec.Command("aws", "ssm", "start-session",
+			"--target", instanceID,
+			"--profile", awsProfile,
+			"--region", awsRegion,
+		)
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TERM=xterm-256color", "COLORTERM=truecolor")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pty: %w", err)
+	}
+	return &ptyTerminal{cmd: cmd, ptmx: ptmx}, nil
+}
+
+// WriteInput sends raw bytes (keystrokes) into the session's terminal.
 func (m *Manager) WriteInput(sessionID string, data []byte) error {
 	s, ok := m.GetSession(sessionID)
 	if !ok {
@@ -196,16 +352,22 @@ func (m *Manager) WriteInput(sessionID string, data []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.ptmx == nil {
-		return fmt.Errorf("session %s pty closed", sessionID)
+	if s.term == nil {
+		return fmt.Errorf("session %s terminal closed", sessionID)
 	}
 
 	s.lastInput = time.Now()
-	_, err := s.ptmx.Write(data)
+	_, err := s.term.Write(data)
+	if err == nil {
+		s.bytesIn += int64(len(data))
+	}
+	if err == nil && s.recorder != nil {
+		s.recorder.WriteInput(data)
+	}
 	return err
 }
 
-// SendInterrupt sends SIGINT to the process group of the session.
+// SendInterrupt interrupts the session's foreground command.
 func (m *Manager) SendInterrupt(sessionID string) error {
 	s, ok := m.GetSession(sessionID)
 	if !ok {
@@ -215,15 +377,36 @@ func (m *Manager) SendInterrupt(sessionID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.cmd == nil || s.cmd.Process == nil {
-		return fmt.Errorf("session %s process not running", sessionID)
+	if s.term == nil {
+		return fmt.Errorf("session %s terminal closed", sessionID)
 	}
+	return s.term.Interrupt()
+}
 
-	// Negative PID signals the entire process group.
-	return syscall.Kill(-s.cmd.Process.Pid, syscall.SIGINT)
+// Tap calls fn with the session's output from now on, until the returned
+// function is called. Unlike Watch it replays nothing and is not visible to
+// collaborators. The returned channel is closed when the session ends.
+func (m *Manager) Tap(sessionID string, fn func([]byte)) (func(), <-chan struct{}, error) {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil, nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taps == nil {
+		s.taps = make(map[int]func([]byte))
+	}
+	id := s.nextTap
+	s.nextTap++
+	s.taps[id] = fn
+	return func() {
+		s.mu.Lock()
+		delete(s.taps, id)
+		s.mu.Unlock()
+	}, s.done, nil
 }
 
-// ResizeTerminal resizes the PTY window for the given session.
+// ResizeTerminal resizes the terminal window for the given session.
 func (m *Manager) ResizeTerminal(sessionID string, rows, cols uint16) error {
 	s, ok := m.GetSession(sessionID)
 	if !ok {
@@ -233,11 +416,11 @@ func (m *Manager) ResizeTerminal(sessionID string, rows, cols uint16) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.ptmx == nil {
-		return fmt.Errorf("session %s pty closed", sessionID)
+	if s.term == nil {
+		return fmt.Errorf("session %s terminal closed", sessionID)
 	}
 
-	if err := pty.Setsize(s.ptmx, &pty.Winsize{Rows: rows, Cols: cols}); err != nil {
+	if err := s.term.Resize(rows, cols); err != nil {
 		return err
 	}
 	if s.recorder != nil {
@@ -246,8 +429,44 @@ func (m *Manager) ResizeTerminal(sessionID string, rows, cols uint16) error {
 	return nil
 }
 
+// Why a session ended, as reported to the SetEndHook callback. Expired
+// sessions report ExpiryIdle or ExpiryMaxDuration, and detached sessions
+// "detach_expired" or "exited".
+const (
+	EndClosed     = "closed"
+	EndShutdown   = "shutdown"
+	EndUser       = "user"
+	EndDisconnect = "disconnect"
+	EndTerminated = "terminated"
+)
+
+// Summary describes a session once it has ended.
+type Summary struct {
+	SessionID    string
+	InstanceID   string
+	InstanceName string
+	Owner        string
+	Reason       string
+	Started      time.Time
+	Ended        time.Time
+	BytesIn      int64
+	BytesOut     int64
+	Recordings   []string // recording file names
+}
+
+// SetEndHook registers fn to be called with the summary of every session
+// the manager closes. Call it before any session starts.
+func (m *Manager) SetEndHook(fn func(Summary)) {
+	m.onEnd = fn
+}
+
 // CloseSession tears down a single session by ID.
 func (m *Manager) CloseSession(sessionID string) error {
+	return m.EndSession(sessionID, EndClosed)
+}
+
+// EndSession is CloseSession, reporting reason to the end hook.
+func (m *Manager) EndSession(sessionID, reason string) error {
 	m.mu.Lock()
 	s, ok := m.sessions[sessionID]
 	if !ok || s == nil {
@@ -258,7 +477,9 @@ func (m *Manager) CloseSession(sessionID string) error {
 	m.mu.Unlock()
 
 	s.Close()
-	m.logger.Printf("session %s closed", sessionID)
+	s.notifyClosed()
+	m.ended(s, reason)
+	m.logger.Printf("session %s closed (%s)", sessionID, reason)
 	return nil
 }
 
@@ -277,10 +498,33 @@ func (m *Manager) CloseAll() {
 			continue
 		}
 		s.Close()
+		m.ended(s, EndShutdown)
 		m.logger.Printf("session %s closed (shutdown)", id)
 	}
 }
 
+// ended reports a closed session to the end hook.
+func (m *Manager) ended(s *SSMSession, reason string) {
+	if m.onEnd == nil {
+		return
+	}
+	s.mu.Lock()
+	sum := Summary{
+		SessionID:    s.SessionID,
+		InstanceID:   s.InstanceID,
+		InstanceName: s.InstanceName,
+		Owner:        s.owner,
+		Reason:       reason,
+		Started:      s.started,
+		Ended:        time.Now(),
+		BytesIn:      s.bytesIn,
+		BytesOut:     s.bytesOut,
+		Recordings:   append([]string(nil), s.recordings...),
+	}
+	s.mu.Unlock()
+	m.onEnd(sum)
+}
+
 // GetSession returns the session for the given ID, if it exists and is fully initialised.
 func (m *Manager) GetSession(sessionID string) (*SSMSession, bool) {
 	m.mu.RLock()
@@ -289,31 +533,6 @@ func (m *Manager) GetSession(sessionID string) (*SSMSession, bool) {
 	return s, ok && s != nil
 }
 
-// ExportSession writes the output buffer of a session to a file and returns the filename.
-func (m *Manager) ExportSession(sessionID, exportDir string) (string, error) {
-	s, ok := m.GetSession(sessionID)
-	if !ok {
-		return "", fmt.Errorf("session %s not found", sessionID)
-	}
-
-	s.mu.Lock()
-	raw := make([]byte, s.outputBuf.Len())
-	copy(raw, s.outputBuf.Bytes())
-	s.mu.Unlock()
-
-	// Strip ANSI escape sequences so the export reads like plain terminal text.
-	data := stripANSI(raw)
-
-	filename := fmt.Sprintf("%s_%d.log", sessionID, time.Now().Unix())
-	path := filepath.Join(exportDir, filename)
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return "", fmt.Errorf("failed to write export: %w", err)
-	}
-
-	m.logger.Printf("session %s exported to %s (%d bytes)", sessionID, filename, len(data))
-	return filename, nil
-}
-
 // StartRecording begins recording a session (if not already recording).
 func (m *Manager) StartRecording(sessionID string) error {
 	s, ok := m.GetSession(sessionID)
@@ -325,11 +544,12 @@ func (m *Manager) StartRecording(sessionID string) error {
 	if s.recorder != nil {
 		return nil // already recording
 	}
-	rec, err := NewRecorder(m.recordingDir, s.InstanceID, s.InstanceName, 80, 24)
+	rec, err := m.newRecorder(s)
 	if err != nil {
 		return err
 	}
 	s.recorder = rec
+	s.recordings = append(s.recordings, filepath.Base(rec.Filename()))
 	m.logger.Printf("recording started for session %s", sessionID)
 	return nil
 }
@@ -351,16 +571,43 @@ func (m *Manager) StopRecording(sessionID string) error {
 	return nil
 }
 
-// CloseSessionsForClient closes every session whose ID is in the provided slice.
+// allSessions snapshots the fully initialised sessions.
+func (m *Manager) allSessions() []*SSMSession {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sessions := make([]*SSMSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		if s != nil {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// ActiveRecordings returns the filenames of recordings still being written.
+func (m *Manager) ActiveRecordings() map[string]bool {
+	active := make(map[string]bool)
+	for _, s := range m.allSessions() {
+		s.mu.Lock()
+		if s.recorder != nil {
+			active[filepath.Base(s.recorder.Filename())] = true
+		}
+		s.mu.Unlock()
+	}
+	return active
+}
+
+// CloseSessionsForClient closes every session whose ID is in the provided
+// slice after its client disconnected.
 func (m *Manager) CloseSessionsForClient(sessionIDs []string) {
 	for _, id := range sessionIDs {
-		if err := m.CloseSession(id); err != nil {
+		if err := m.EndSession(id, EndDisconnect); err != nil {
 			m.logger.Printf("close session %s: %v", id, err)
 		}
 	}
 }
 
-// ssmKeepalive periodically sends a null byte to the PTY when the session has
+// ssmKeepalive periodically sends a null byte to the terminal when the session has
 // been idle, preventing AWS SSM from hitting its idle-session timeout.
 func (s *SSMSession) ssmKeepalive(logger *log.Logger) {
 	ticker := time.NewTicker(ssmKeepaliveInterval)
@@ -371,11 +618,11 @@ func (s *SSMSession) ssmKeepalive(logger *log.Logger) {
 		case <-ticker.C:
 			s.mu.Lock()
 			idle := time.Since(s.lastInput) >= ssmKeepaliveInterval
-			ptmx := s.ptmx
+			term := s.term
 			s.mu.Unlock()
 
-			if idle && ptmx != nil {
-				if _, err := ptmx.Write([]byte{0}); err != nil {
+			if idle && term != nil {
+				if _, err := term.Write([]byte{0}); err != nil {
 					logger.Printf("session %s ssm keepalive write failed: %v", s.SessionID, err)
 					return
 				}
@@ -386,13 +633,15 @@ func (s *SSMSession) ssmKeepalive(logger *log.Logger) {
 	}
 }
 
-// readLoop continuously reads output from the PTY and forwards it via onOutput.
-func (s *SSMSession) readLoop(logger *log.Logger) {
+// readLoop continuously reads output from the terminal and forwards it via
+// onOutput. term is passed in because Close clears s.term, possibly before
+// this goroutine runs.
+func (s *SSMSession) readLoop(logger *log.Logger, term terminal) {
 	defer close(s.done)
 
 	buf := make([]byte, 4096)
 	for {
-		n, err := s.ptmx.Read(buf)
+		n, err := term.Read(buf)
 		if n > 0 {
 			// Copy so the callback owns the slice.
 			out := make([]byte, n)
@@ -403,14 +652,26 @@ func (s *SSMSession) readLoop(logger *log.Logger) {
 			s.mu.Lock()
 			cb := s.onOutput
 			rec := s.recorder
-			if s.outputBuf.Len()+n > maxOutputBuf {
-				excess := s.outputBuf.Len() + n - maxOutputBuf
+			watchers := make([]func([]byte), 0, len(s.watchers))
+			for _, w := range s.watchers {
+				watchers = append(watchers, w.Output)
+			}
+			for _, fn := range s.taps {
+				watchers = append(watchers, fn)
+			}
+			out = s.maskSecrets(out)
+			if s.outputBuf.Len()+len(out) > maxOutputBuf {
+				excess := s.outputBuf.Len() + len(out) - maxOutputBuf
 				s.outputBuf.Next(excess)
 			}
 			s.outputBuf.Write(out)
+			s.bytesOut += int64(n)
 			s.mu.Unlock()
 
 			cb(out)
+			for _, w := range watchers {
+				w(out)
+			}
 			if rec != nil {
 				rec.Write(out)
 			}
@@ -423,7 +684,7 @@ func (s *SSMSession) readLoop(logger *log.Logger) {
 	}
 }
 
-// Close tears down the PTY and kills the process group.
+// Close stops recording and closes the terminal.
 func (s *SSMSession) Close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -433,16 +694,9 @@ func (s *SSMSession) Close() {
 		s.recorder = nil
 	}
 
-	if s.ptmx != nil {
-		s.ptmx.Close()
-		s.ptmx = nil
-	}
-
-	if s.cmd != nil && s.cmd.Process != nil {
-		// Kill the entire process group.
-		_ = syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
-		_ = s.cmd.Wait()
-		s.cmd = nil
+	if s.term != nil {
+		s.term.Close()
+		s.term = nil
 	}
 }
 
@@ -12,10 +12,13 @@ import (
 )
 
 // Recorder writes terminal output in asciicast v2 format (NDJSON).
-// Each line after the header is [elapsed_seconds, "o", "data"].
+// Each line after the header is [elapsed_seconds, "o", "data"]; with input
+// capture enabled, keystrokes are also written as "i" events.
 type Recorder struct {
 	file      *os.File
 	startTime time.Time
+	redactor  *InputRedactor // non-nil when input capture is on
+	onClose   func(path string)
 	mu        sync.Mutex
 }
 
@@ -80,10 +83,37 @@ func (r *Recorder) Write(data []byte) {
 		return
 	}
 
+	if r.redactor != nil {
+		r.redactor.ObserveOutput(data)
+	}
+	r.writeEvent("o", data)
+}
+
+// CaptureInput enables "i" events. Keystrokes typed while redactor detects a
+// password prompt are masked.
+func (r *Recorder) CaptureInput(redactor *InputRedactor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactor = redactor
+}
+
+// WriteInput records keystrokes sent to the terminal, if input capture is
+// enabled.
+func (r *Recorder) WriteInput(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || r.redactor == nil {
+		return
+	}
+	r.writeEvent("i", r.redactor.Redact(data))
+}
+
+// writeEvent appends [elapsed, code, "escaped_data"]; r.mu must be held.
+func (r *Recorder) writeEvent(code string, data []byte) {
 	elapsed := time.Since(r.startTime).Seconds()
-	// Asciicast v2 event: [elapsed, "o", "escaped_data"]
 	escaped, _ := json.Marshal(string(data))
-	line := fmt.Sprintf("[%.6f, \"o\", %s]\n", elapsed, escaped)
+	line := fmt.Sprintf("[%.6f, %q, %s]\n", elapsed, code, escaped)
 	r.file.WriteString(line)
 }
 
@@ -101,14 +131,29 @@ func (r *Recorder) WriteResize(cols, rows int) {
 	r.file.WriteString(line)
 }
 
-// Close flushes and closes the recording file.
-func (r *Recorder) Close() {
+// OnClose registers fn to be called with the file path once the recording
+// is closed.
+func (r *Recorder) OnClose(fn func(path string)) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.onClose = fn
+}
 
-	if r.file != nil {
-		r.file.Close()
-		r.file = nil
+// Close flushes and closes the recording file.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if r.file == nil {
+		r.mu.Unlock()
+		return
+	}
+	path := r.file.Name()
+	r.file.Close()
+	r.file = nil
+	fn := r.onClose
+	r.mu.Unlock()
+
+	if fn != nil {
+		fn(path)
 	}
 }
 
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// castEvents returns the [code, data] pairs of every event in a .cast file.
+func castEvents(t *testing.T, path string) [][2]string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var out [][2]string
+	for _, line := range lines[1:] {
+		var ev []interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", line, err)
+		}
+		out = append(out, [2]string{ev[1].(string), ev[2].(string)})
+	}
+	return out
+}
+
+func TestRecorderInputCaptureRedactsPasswords(t *testing.T) {
+	rec, err := NewRecorder(t.TempDir(), "i-1", "web", 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.CaptureInput(NewInputRedactor(nil))
+
+	rec.Write([]byte("$ "))
+	rec.WriteInput([]byte("sudo -i\r"))
+	rec.Write([]byte("\r\n\x1b[1m[sudo] password for ec2-user: \x1b[0m"))
+	rec.WriteInput([]byte("hunter2"))
+	rec.WriteInput([]byte("\rwhoami\r"))
+	rec.Write([]byte("\r\nroot\r\n# "))
+	rec.WriteInput([]byte("ls\r"))
+	path := rec.Filename()
+	rec.Close()
+
+	var inputs []string
+	for _, ev := range castEvents(t, path) {
+		if ev[0] == "i" {
+			inputs = append(inputs, ev[1])
+		}
+	}
+	want := []string{"sudo -i\r", "*******", "\rwhoami\r", "ls\r"}
+	if strings.Join(inputs, "|") != strings.Join(want, "|") {
+		t.Errorf("recorded input %q, want %q", inputs, want)
+	}
+}
+
+func TestRecorderWithoutInputCapture(t *testing.T) {
+	rec, err := NewRecorder(t.TempDir(), "i-1", "web", 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write([]byte("$ "))
+	rec.WriteInput([]byte("ls\r"))
+	path := rec.Filename()
+	rec.Close()
+
+	for _, ev := range castEvents(t, path) {
+		if ev[0] == "i" {
+			t.Fatalf("unexpected input event %q", ev[1])
+		}
+	}
+}
+
+func TestDefaultPasswordPrompt(t *testing.T) {
+	for _, s := range []string{"Password: ", "[sudo] password for bob:", "Enter passphrase for key '/home/a/.ssh/id_rsa': ", "Verification code:", "Enter PIN: "} {
+		if !DefaultPasswordPrompt.MatchString(s) {
+			t.Errorf("expected %q to be detected as a prompt", s)
+		}
+	}
+	for _, s := range []string{"$ ", "password changed successfully\n$ ", "Last login: Mon"} {
+		if DefaultPasswordPrompt.MatchString(s) {
+			t.Errorf("did not expect %q to be a prompt", s)
+		}
+	}
+}
+
+func TestRecorderOnClose(t *testing.T) {
+	rec, err := NewRecorder(t.TempDir(), "i-1", "web", 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closed []string
+	rec.OnClose(func(path string) { closed = append(closed, path) })
+	path := rec.Filename()
+	rec.Close()
+	rec.Close()
+	if len(closed) != 1 || closed[0] != path {
+		t.Errorf("OnClose called with %q, want [%q]", closed, path)
+	}
+}
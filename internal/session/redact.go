@@ -0,0 +1,74 @@
+package session
+
+import (
+	"regexp"
+	"sync"
+)
+
+// DefaultPasswordPrompt matches the tail of terminal output that asks for a
+// secret: "Password:", "[sudo] password for bob:", "Enter passphrase for
+// key ...:", "Verification code:", "PIN:" and similar.
+var DefaultPasswordPrompt = regexp.MustCompile(`(?i)(pass(word|phrase|code)?|\bpin|secret|token|verification code|one-time code|otp)[^\n]{0,80}:\s*$`)
+
+// promptTail is how much recent (ANSI-stripped) output is kept to match a
+// prompt against.
+const promptTail = 256
+
+// InputRedactor masks recorded keystrokes while the terminal is waiting for
+// a secret. A prompt seen at the end of the output switches masking on; the
+// next Enter key switches it off again.
+type InputRedactor struct {
+	prompt  *regexp.Regexp
+	mu      sync.Mutex
+	tail    []byte
+	masking bool
+}
+
+// NewInputRedactor returns a redactor for prompt, or DefaultPasswordPrompt
+// when prompt is nil.
+func NewInputRedactor(prompt *regexp.Regexp) *InputRedactor {
+	if prompt == nil {
+		prompt = DefaultPasswordPrompt
+	}
+	return &InputRedactor{prompt: prompt}
+}
+
+// ObserveOutput feeds terminal output to the prompt detector.
+func (r *InputRedactor) ObserveOutput(data []byte) {
+	clean := stripANSI(data)
+	if len(clean) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tail = append(r.tail, clean...)
+	if len(r.tail) > promptTail {
+		r.tail = append(r.tail[:0], r.tail[len(r.tail)-promptTail:]...)
+	}
+	r.masking = r.prompt.Match(r.tail)
+}
+
+// Redact returns data as it should be recorded. While masking, every byte
+// except CR/LF becomes '*', so the length of the secret is still visible but
+// not its content.
+func (r *InputRedactor) Redact(data []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.masking {
+		return data
+	}
+	out := make([]byte, len(data))
+	for i, b := range data {
+		switch {
+		case b == '\r' || b == '\n':
+			out[i] = b
+			r.masking = false
+			r.tail = r.tail[:0]
+		case r.masking:
+			out[i] = '*'
+		default:
+			out[i] = b
+		}
+	}
+	return out
+}
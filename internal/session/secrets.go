@@ -0,0 +1,68 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// secretMask replaces secret values in session output.
+var secretMask = []byte("********")
+
+// minSecretLen is the shortest value masked in output; shorter ones would
+// mask ordinary text.
+const minSecretLen = 4
+
+// maxSecrets bounds the values a session masks; the oldest are dropped.
+const maxSecrets = 64
+
+// WriteSecretInput writes data, which holds secret values, to the session.
+// The recording gets masked instead, and the secrets (and each line of a
+// multi-line secret) are replaced in the session's output from then on, so
+// an echo never reaches clients, watchers, the scrollback or the recording.
+// Masking works within each read from the terminal; a secret split across
+// two reads is not masked.
+func (m *Manager) WriteSecretInput(sessionID string, data, masked []byte, secrets ...string) error {
+	s, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.term == nil {
+		return fmt.Errorf("session %s terminal closed", sessionID)
+	}
+	for _, secret := range secrets {
+		for _, line := range strings.Split(secret, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= minSecretLen {
+				s.secrets = append(s.secrets, []byte(line))
+			}
+		}
+	}
+	if len(s.secrets) > maxSecrets {
+		s.secrets = s.secrets[len(s.secrets)-maxSecrets:]
+	}
+
+	s.lastInput = time.Now()
+	_, err := s.term.Write(data)
+	if err == nil {
+		s.bytesIn += int64(len(data))
+	}
+	if err == nil && s.recorder != nil {
+		s.recorder.WriteInput(masked)
+	}
+	return err
+}
+
+// maskSecrets replaces the session's secrets in out. Callers hold s.mu.
+func (s *SSMSession) maskSecrets(out []byte) []byte {
+	for _, secret := range s.secrets {
+		if bytes.Contains(out, secret) {
+			out = bytes.ReplaceAll(out, secret, secretMask)
+		}
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package session
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestWriteSecretInputMasksOutput(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	ch := newFakeChannel()
+	m.SetChannelOpener(func(ctx context.Context, instanceID, profile, region string) (Channel, error) {
+		return ch, nil
+	})
+	output := make(chan string, 4)
+	if err := m.StartSession("i-1", "web", "term-1", "dev", "us-east-1", nil, 80, 24, func(b []byte) { output <- string(b) }); err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseSession("term-1")
+
+	key := "-----BEGIN KEY-----\nc2VjcmV0LWtleQ==\n-----END KEY-----"
+	if err := m.WriteSecretInput("term-1", []byte("export T=tok-1234\r"), []byte("export T={{vault:token.token}}\r"), "tok-1234", "abc", key); err != nil {
+		t.Fatal(err)
+	}
+	ch.mu.Lock()
+	input := ch.input.String()
+	ch.mu.Unlock()
+	if input != "export T=tok-1234\r" {
+		t.Fatalf("channel input %q", input)
+	}
+
+	ch.pw.Write([]byte("export T=tok-1234 abc c2VjcmV0LWtleQ==\r\n"))
+	select {
+	case got := <-output:
+		if got != "export T=******** abc ********\r\n" {
+			t.Fatalf("output %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no output from channel")
+	}
+	s, _ := m.GetSession("term-1")
+	s.mu.Lock()
+	scrollback := s.outputBuf.String()
+	s.mu.Unlock()
+	if scrollback != "export T=******** abc ********\r\n" {
+		t.Fatalf("scrollback %q", scrollback)
+	}
+}
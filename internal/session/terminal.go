@@ -0,0 +1,85 @@
+package session
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// Channel is a native Session Manager data channel (ssmclient.Session).
+type Channel interface {
+	io.ReadWriteCloser
+	Resize(cols, rows uint16) error
+}
+
+// ChannelOpener opens a shell data channel to an instance.
+type ChannelOpener func(ctx context.Context, instanceID, profile, region string) (Channel, error)
+
+// SetChannelOpener makes StartSession open native data channels with fn
+// instead of running the aws CLI in a PTY. Call it before any session starts.
+func (m *Manager) SetChannelOpener(fn ChannelOpener) {
+	m.openChannel = fn
+}
+
+// SSHOpener opens an SSH shell (sshclient.Shell) to an instance as osUser
+// with a PTY of the given size.
+type SSHOpener func(ctx context.Context, instanceID, profile, region, osUser string, cols, rows uint16) (Channel, error)
+
+// SetSSHOpener enables StartSSHSession. Call it before any session starts.
+func (m *Manager) SetSSHOpener(fn SSHOpener) {
+	m.openSSH = fn
+}
+
+// terminal is the I/O side of a session.
+type terminal interface {
+	io.ReadWriter
+	Resize(rows, cols uint16) error
+	Interrupt() error
+	Close() error
+}
+
+// ptyTerminal runs `aws ssm start-session` (and session-manager-plugin) in
+// a PTY.
+type ptyTerminal struct {
+	cmd  *exec.Cmd
+	ptmx *os.File
+}
+
+func (t *ptyTerminal) Read(p []byte) (int, error)  { return t.ptmx.Read(p) }
+func (t *ptyTerminal) Write(p []byte) (int, error) { return t.ptmx.Write(p) }
+
+func (t *ptyTerminal) Resize(rows, cols uint16) error {
+	return pty.Setsize(t.ptmx, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Interrupt sends SIGINT to the process group.
+func (t *ptyTerminal) Interrupt() error {
+	return syscall.Kill(-t.cmd.Process.Pid, syscall.SIGINT)
+}
+
+// Close closes the PTY and kills the process group.
+func (t *ptyTerminal) Close() error {
+	t.ptmx.Close()
+	_ = syscall.Kill(-t.cmd.Process.Pid, syscall.SIGKILL)
+	_ = t.cmd.Wait()
+	return nil
+}
+
+// channelTerminal is a native data channel.
+type channelTerminal struct {
+	Channel
+}
+
+func (t channelTerminal) Resize(rows, cols uint16) error {
+	return t.Channel.Resize(cols, rows)
+}
+
+// Interrupt sends Ctrl+C; the remote shell delivers SIGINT.
+func (t channelTerminal) Interrupt() error {
+	_, err := t.Channel.Write([]byte{0x03})
+	return err
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeChannel struct {
+	out *io.PipeReader
+	pw  *io.PipeWriter
+
+	mu     sync.Mutex
+	input  bytes.Buffer
+	sizes  [][2]uint16
+	closed bool
+}
+
+func newFakeChannel() *fakeChannel {
+	r, w := io.Pipe()
+	return &fakeChannel{out: r, pw: w}
+}
+
+func (c *fakeChannel) Read(p []byte) (int, error) { return c.out.Read(p) }
+
+func (c *fakeChannel) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.input.Write(p)
+}
+
+func (c *fakeChannel) Resize(cols, rows uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sizes = append(c.sizes, [2]uint16{cols, rows})
+	return nil
+}
+
+func (c *fakeChannel) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.pw.Close()
+}
+
+func TestStartSessionWithChannel(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	ch := newFakeChannel()
+	var opened string
+	m.SetChannelOpener(func(ctx context.Context, instanceID, profile, region string) (Channel, error) {
+		opened = instanceID + "/" + profile + "/" + region
+		return ch, nil
+	})
+
+	output := make(chan string, 4)
+	if err := m.StartSession("i-1", "web", "term-1", "dev", "us-east-1", nil, 100, 30, func(b []byte) { output <- string(b) }); err != nil {
+		t.Fatal(err)
+	}
+	if opened != "i-1/dev/us-east-1" {
+		t.Fatalf("opener called with %q", opened)
+	}
+
+	ch.pw.Write([]byte("$ "))
+	select {
+	case got := <-output:
+		if got != "$ " {
+			t.Fatalf("output %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no output from channel")
+	}
+
+	if err := m.WriteInput("term-1", []byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SendInterrupt("term-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResizeTerminal("term-1", 50, 200); err != nil {
+		t.Fatal(err)
+	}
+	ch.mu.Lock()
+	input, sizes := ch.input.String(), ch.sizes
+	ch.mu.Unlock()
+	if input != "ls\r\x03" {
+		t.Fatalf("channel input %q", input)
+	}
+	if len(sizes) != 2 || sizes[0] != [2]uint16{100, 30} || sizes[1] != [2]uint16{200, 50} {
+		t.Fatalf("resizes %v", sizes)
+	}
+
+	if err := m.CloseSession("term-1"); err != nil {
+		t.Fatal(err)
+	}
+	ch.mu.Lock()
+	closed := ch.closed
+	ch.mu.Unlock()
+	if !closed {
+		t.Fatal("channel not closed with the session")
+	}
+}
+
+func TestStartSessionChannelError(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	m.SetChannelOpener(func(ctx context.Context, instanceID, profile, region string) (Channel, error) {
+		return nil, errors.New("TargetNotConnected")
+	})
+	if err := m.StartSession("i-1", "web", "term-1", "dev", "us-east-1", nil, 80, 24, func([]byte) {}); err == nil {
+		t.Fatal("expected an error")
+	}
+	// The reserved slot is released so the ID can be retried.
+	m.mu.RLock()
+	_, reserved := m.sessions["term-1"]
+	m.mu.RUnlock()
+	if reserved {
+		t.Fatal("session slot still reserved after failure")
+	}
+}
+
+func TestStartSSHSession(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	if err := m.StartSSHSession("i-1", "web", "term-1", "dev", "us-east-1", "ubuntu", 80, 24, func([]byte) {}); err == nil {
+		t.Fatal("expected an error without an SSH opener")
+	}
+
+	ch := newFakeChannel()
+	var opened string
+	m.SetSSHOpener(func(ctx context.Context, instanceID, profile, region, osUser string, cols, rows uint16) (Channel, error) {
+		opened = fmt.Sprintf("%s/%s/%s/%s/%dx%d", instanceID, profile, region, osUser, cols, rows)
+		return ch, nil
+	})
+	if err := m.StartSSHSession("i-1", "web", "term-1", "dev", "us-east-1", "ubuntu", 80, 24, func([]byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	if opened != "i-1/dev/us-east-1/ubuntu/80x24" {
+		t.Fatalf("opener called with %q", opened)
+	}
+	s, ok := m.GetSession("term-1")
+	if !ok || s.SSHUser != "ubuntu" {
+		t.Fatalf("session %+v", s)
+	}
+	if err := m.WriteInput("term-1", []byte("id\r")); err != nil {
+		t.Fatal(err)
+	}
+	ch.mu.Lock()
+	input := ch.input.String()
+	ch.mu.Unlock()
+	if input != "id\r" {
+		t.Fatalf("channel input %q", input)
+	}
+	m.CloseSession("term-1")
+}
+
+func TestTap(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), false)
+	ch := newFakeChannel()
+	m.SetSSHOpener(func(context.Context, string, string, string, string, uint16, uint16) (Channel, error) {
+		return ch, nil
+	})
+	if err := m.StartSSHSession("i-1", "web", "term-1", "dev", "us-east-1", "ubuntu", 80, 24, func([]byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Tap("nope", func([]byte) {}); err == nil {
+		t.Fatal("tapped an unknown session")
+	}
+
+	got := make(chan string, 4)
+	untap, ended, err := m.Tap("term-1", func(p []byte) { got <- string(p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.pw.Write([]byte("hello"))
+	select {
+	case s := <-got:
+		if s != "hello" {
+			t.Fatalf("tap got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("tap saw no output")
+	}
+
+	untap()
+	ch.pw.Write([]byte("again"))
+	m.CloseSession("term-1")
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ended not closed")
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("output %q after untap", s)
+	default:
+	}
+}
+
+func TestEndHook(t *testing.T) {
+	m := NewManager(log.New(io.Discard, "", 0), t.TempDir(), true)
+	ch := newFakeChannel()
+	m.SetChannelOpener(func(ctx context.Context, instanceID, profile, region string) (Channel, error) {
+		return ch, nil
+	})
+	ended := make(chan Summary, 1)
+	m.SetEndHook(func(s Summary) { ended <- s })
+
+	output := make(chan struct{}, 1)
+	if err := m.StartSession("i-1", "web", "term-1", "dev", "us-east-1", nil, 80, 24, func([]byte) { output <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	m.SetSessionOwner("term-1", "alice")
+	ch.pw.Write([]byte("$ "))
+	select {
+	case <-output:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no output from channel")
+	}
+	if err := m.WriteInput("term-1", []byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.EndSession("term-1", EndUser); err != nil {
+		t.Fatal(err)
+	}
+
+	s := <-ended
+	if s.SessionID != "term-1" || s.Owner != "alice" || s.Reason != EndUser || s.Started.IsZero() || s.Ended.Before(s.Started) {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.BytesIn != 3 || s.BytesOut != 2 {
+		t.Errorf("bytes in/out = %d/%d", s.BytesIn, s.BytesOut)
+	}
+	if len(s.Recordings) != 1 {
+		t.Errorf("recordings = %v", s.Recordings)
+	}
+}
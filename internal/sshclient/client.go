@@ -0,0 +1,166 @@
+// Package sshclient runs SSH over an already-open connection, such as an
+// SSM AWS-StartSSHSession tunnel, authenticating with an ephemeral key
+// pushed by EC2 Instance Connect or with a stored private key. It does not
+// forward an SSH agent or act as a jump host for other SSH clients.
+package sshclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Key is an SSH key pair: an ephemeral ed25519 key or a stored one.
+type Key struct {
+	signer ssh.Signer
+}
+
+// GenerateKey creates a new ephemeral key.
+func GenerateKey() (*Key, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate ssh key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("generate ssh key: %w", err)
+	}
+	return &Key{signer: signer}, nil
+}
+
+// ParseKey reads a PEM or OpenSSH private key, decrypting it with
+// passphrase when it is protected.
+func ParseKey(pemBytes []byte, passphrase string) (*Key, error) {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("parse ssh key: key is protected by a passphrase")
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key: %w", err)
+	}
+	return &Key{signer: signer}, nil
+}
+
+// AuthorizedKey returns the public key in authorized_keys format, as
+// SendSSHPublicKey expects it.
+func (k *Key) AuthorizedKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.signer.PublicKey())))
+}
+
+// Client is an authenticated SSH connection.
+type Client struct {
+	*ssh.Client
+}
+
+// Dial runs the SSH handshake over conn as user, checking the server's key
+// with hostKey (see TrustOnFirstUse). conn is closed if the handshake fails
+// or ctx ends first.
+func Dial(ctx context.Context, conn net.Conn, user string, key *Key, hostKey ssh.HostKeyCallback) (*Client, error) {
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key.signer)},
+		HostKeyCallback: hostKey,
+	}
+
+	type result struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{client: ssh.NewClient(c, chans, reqs)}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ssh handshake: %w", res.err)
+		}
+		return &Client{res.client}, nil
+	case <-ctx.Done():
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake: %w", ctx.Err())
+	}
+}
+
+// SFTP starts the SFTP subsystem. Closing the returned client leaves the
+// SSH connection open.
+func (c *Client) SFTP() (*sftp.Client, error) {
+	s, err := sftp.NewClient(c.Client)
+	if err != nil {
+		return nil, fmt.Errorf("start sftp: %w", err)
+	}
+	return s, nil
+}
+
+// Shell is an interactive login shell in a remote PTY.
+type Shell struct {
+	client  *Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+}
+
+// Shell starts a login shell in an xterm-256color PTY of the given size.
+// The shell owns the connection: closing it closes the client.
+func (c *Client) Shell(cols, rows uint16) (*Shell, error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("open ssh session: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	modes := ssh.TerminalModes{ssh.ECHO: 1}
+	if err := session.RequestPty("xterm-256color", int(rows), int(cols), modes); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("request pty: %w", err)
+	}
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("start shell: %w", err)
+	}
+	return &Shell{client: c, session: session, stdin: stdin, stdout: stdout}, nil
+}
+
+// Read returns the shell's output; with a PTY it includes stderr.
+func (s *Shell) Read(p []byte) (int, error) { return s.stdout.Read(p) }
+
+// Write sends keystrokes to the shell.
+func (s *Shell) Write(p []byte) (int, error) { return s.stdin.Write(p) }
+
+// Resize sends a window-change request.
+func (s *Shell) Resize(cols, rows uint16) error {
+	return s.session.WindowChange(int(rows), int(cols))
+}
+
+// Close ends the shell and the SSH connection.
+func (s *Shell) Close() error {
+	s.session.Close()
+	return s.client.Close()
+}
@@ -0,0 +1,286 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server that accepts one authorized key. Its shell
+// echoes input upper-cased; the sftp subsystem serves the local filesystem.
+type testServer struct {
+	config *ssh.ServerConfig
+
+	mu    sync.Mutex
+	user  string
+	ptys  []string
+	sizes [][2]uint32 // cols, rows
+}
+
+func newTestServer(t *testing.T, authorized *Key) *testServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{}
+	srv.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(md ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.signer.PublicKey().Marshal()) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			srv.mu.Lock()
+			srv.user = md.User()
+			srv.mu.Unlock()
+			return nil, nil
+		},
+	}
+	srv.config.AddHostKey(hostKey)
+	return srv
+}
+
+// dial connects a client to the server over loopback TCP; both ends send
+// their version line at once, so a synchronous net.Pipe would deadlock.
+func (srv *testServer) dial(t *testing.T, user string, key *Key) (*Client, error) {
+	return srv.dialPinned(t, user, key, NewMemoryHostKeys())
+}
+
+// dialPinned is dial with the host key checked against keys.
+func (srv *testServer) dialPinned(t *testing.T, user string, key *Key, keys HostKeys) (*Client, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			srv.serve(c)
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Dial(context.Background(), conn, user, key, TrustOnFirstUse(keys, "i-0123456789abcdef0"))
+}
+
+func (srv *testServer) serve(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, srv.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go srv.session(ch, requests)
+	}
+}
+
+func (srv *testServer) session(ch ssh.Channel, requests <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			termLen := binary.BigEndian.Uint32(req.Payload)
+			p := req.Payload[4+termLen:]
+			srv.mu.Lock()
+			srv.ptys = append(srv.ptys, string(req.Payload[4:4+termLen]))
+			srv.sizes = append(srv.sizes, [2]uint32{binary.BigEndian.Uint32(p), binary.BigEndian.Uint32(p[4:])})
+			srv.mu.Unlock()
+			req.Reply(true, nil)
+		case "window-change":
+			srv.mu.Lock()
+			srv.sizes = append(srv.sizes, [2]uint32{binary.BigEndian.Uint32(req.Payload), binary.BigEndian.Uint32(req.Payload[4:])})
+			srv.mu.Unlock()
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, err := ch.Read(buf)
+					if n > 0 {
+						ch.Write(bytes.ToUpper(buf[:n]))
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		case "subsystem":
+			req.Reply(true, nil)
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.AuthorizedKey())); err != nil {
+		t.Fatalf("authorized key %q: %v", key.AuthorizedKey(), err)
+	}
+	srv := newTestServer(t, key)
+
+	client, err := srv.dial(t, "ec2-user", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell, err := client.Shell(120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shell.Write([]byte("ls -la\r")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(shell, buf); err != nil || string(buf) != "LS -LA\r" {
+		t.Fatalf("shell output %q, %v", buf, err)
+	}
+	if err := shell.Resize(200, 50); err != nil {
+		t.Fatal(err)
+	}
+	shell.Close()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.user != "ec2-user" {
+		t.Fatalf("authenticated as %q", srv.user)
+	}
+	if len(srv.ptys) != 1 || srv.ptys[0] != "xterm-256color" {
+		t.Fatalf("pty requests %v", srv.ptys)
+	}
+	// The window-change is sent without waiting for a reply; only check it
+	// if it arrived before the connection closed.
+	if srv.sizes[0] != [2]uint32{120, 40} || (len(srv.sizes) > 1 && srv.sizes[1] != [2]uint32{200, 50}) {
+		t.Fatalf("sizes %v", srv.sizes)
+	}
+}
+
+func TestDialRejectedKey(t *testing.T) {
+	authorized, _ := GenerateKey()
+	other, _ := GenerateKey()
+	srv := newTestServer(t, authorized)
+	if _, err := srv.dial(t, "ec2-user", other); err == nil {
+		t.Fatal("expected the handshake to fail with an unauthorized key")
+	}
+}
+
+func TestDialPinsHostKey(t *testing.T) {
+	key, _ := GenerateKey()
+	keys := NewMemoryHostKeys()
+	srv := newTestServer(t, key)
+	for i := 0; i < 2; i++ {
+		client, err := srv.dialPinned(t, "ec2-user", key, keys)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+		client.Close()
+	}
+
+	// Same instance ID, different host key: refused.
+	impostor := newTestServer(t, key)
+	if _, err := impostor.dialPinned(t, "ec2-user", key, keys); !errors.Is(err, ErrHostKeyChanged) {
+		t.Fatalf("changed host key: err = %v, want ErrHostKeyChanged", err)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	key, _ := GenerateKey()
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// Nothing answers on serverConn, so only the context ends the handshake.
+	if _, err := Dial(ctx, clientConn, "ec2-user", key, TrustOnFirstUse(NewMemoryHostKeys(), "i-test")); err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	key, _ := GenerateKey()
+	srv := newTestServer(t, key)
+	client, err := srv.dial(t, "ec2-user", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "motd"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := client.SFTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	f, err := fs.Open(filepath.Join(dir, "motd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(block)
+	if _, err := ParseKey(data, ""); err == nil {
+		t.Error("protected key parsed without a passphrase")
+	}
+	if _, err := ParseKey(data, "wrong"); err == nil {
+		t.Error("protected key parsed with the wrong passphrase")
+	}
+	key, err := ParseKey(data, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t, key)
+	client, err := srv.dial(t, "ubuntu", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	plain, _ := ssh.MarshalPrivateKey(priv, "")
+	if _, err := ParseKey(pem.EncodeToMemory(plain), "ignored"); err != nil {
+		t.Errorf("unprotected key: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrHostKeyChanged is returned by Dial when an instance presents a host
+// key other than the one pinned for it.
+var ErrHostKeyChanged = errors.New("ssh host key changed")
+
+// HostKeys pins host keys, in authorized_keys format, by instance ID.
+type HostKeys interface {
+	// PinHostKey pins key for instanceID unless a key is already pinned,
+	// and returns the pinned key.
+	PinHostKey(instanceID, key string) (string, error)
+}
+
+// TrustOnFirstUse returns a host key callback that pins the first key
+// instanceID presents and refuses any other key afterwards.
+func TrustOnFirstUse(keys HostKeys, instanceID string) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		offered := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		pinned, err := keys.PinHostKey(instanceID, offered)
+		if err != nil {
+			return fmt.Errorf("pin host key of %s: %w", instanceID, err)
+		}
+		if pinned == offered {
+			return nil
+		}
+		return fmt.Errorf("%w: %s presented %s, pinned %s", ErrHostKeyChanged, instanceID, ssh.FingerprintSHA256(key), fingerprint(pinned))
+	}
+}
+
+// fingerprint returns the SHA256 fingerprint of an authorized_keys line.
+func fingerprint(authorizedKey string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "an unparseable key"
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// MemoryHostKeys pins host keys for the life of the process.
+type MemoryHostKeys struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// NewMemoryHostKeys returns an empty in-memory pin set.
+func NewMemoryHostKeys() *MemoryHostKeys {
+	return &MemoryHostKeys{keys: make(map[string]string)}
+}
+
+// PinHostKey implements HostKeys.
+func (m *MemoryHostKeys) PinHostKey(instanceID, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pinned, ok := m.keys[instanceID]; ok {
+		return pinned, nil
+	}
+	m.keys[instanceID] = key
+	return key, nil
+}
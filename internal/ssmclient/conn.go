@@ -0,0 +1,41 @@
+package ssmclient
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrPortUnreachable ends a session returned by Conn when the agent cannot
+// connect to the remote port.
+var ErrPortUnreachable = errors.New("ssm agent could not connect to the remote port")
+
+// Conn returns a port session as a net.Conn, for protocols that run over a
+// single remote connection such as SSH through AWS-StartSSHSession.
+// Closing the conn closes the session. Deadlines are ignored.
+func (s *Session) Conn() net.Conn {
+	go func() {
+		select {
+		case <-s.portError:
+			s.closeWith(ErrPortUnreachable)
+		case <-s.done:
+		}
+	}()
+	return sessionConn{s}
+}
+
+type sessionConn struct {
+	*Session
+}
+
+func (c sessionConn) LocalAddr() net.Addr                { return addr("local") }
+func (c sessionConn) RemoteAddr() net.Addr               { return addr(c.ID) }
+func (c sessionConn) SetDeadline(t time.Time) error      { return nil }
+func (c sessionConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c sessionConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// addr names a data channel endpoint; the remote end is the session ID.
+type addr string
+
+func (a addr) Network() string { return "ssm" }
+func (a addr) String() string  { return string(a) }
@@ -0,0 +1,88 @@
+package ssmclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Serve relays connections accepted on ln over a port forwarding session,
+// one at a time. When a local connection closes the agent is told to drop
+// its side, and it reconnects to the remote port when the next connection
+// sends data. Serve returns when the session ends or ln is closed.
+func (s *Session) Serve(ln net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-s.done:
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	var mu sync.Mutex
+	var current net.Conn
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := s.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				c := current
+				mu.Unlock()
+				// Output for a connection that has already closed is dropped.
+				if c != nil {
+					c.Write(buf[:n])
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if s.closed() {
+				return s.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		current = c
+		mu.Unlock()
+
+		s.relay(c)
+
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+		c.Close()
+		if err := s.SendFlag(DisconnectToPort); err != nil {
+			return s.Err()
+		}
+	}
+}
+
+// relay copies a local connection's data to the agent until the connection
+// closes or the agent reports the remote port unreachable.
+func (s *Session) relay(c net.Conn) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-s.portError:
+			c.Close()
+		case <-s.done:
+			c.Close()
+		case <-finished:
+		}
+	}()
+	io.Copy(s, c)
+}
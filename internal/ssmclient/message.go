@@ -0,0 +1,163 @@
+package ssmclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message types carried in the 32-byte MessageType header field.
+const (
+	msgInputStream      = "input_stream_data"
+	msgOutputStream     = "output_stream_data"
+	msgAcknowledge      = "acknowledge"
+	msgChannelClosed    = "channel_closed"
+	msgStartPublication = "start_publication"
+	msgPausePublication = "pause_publication"
+)
+
+// Payload types of stream data messages.
+const (
+	payloadOutput            uint32 = 1
+	payloadSize              uint32 = 3
+	payloadHandshakeRequest  uint32 = 5
+	payloadHandshakeResponse uint32 = 6
+	payloadHandshakeComplete uint32 = 7
+	payloadFlag              uint32 = 10
+	payloadStdErr            uint32 = 11
+	payloadExitCode          uint32 = 12
+)
+
+// Header flags.
+const (
+	flagData uint64 = 0
+	flagAck  uint64 = 3
+)
+
+// Flag is the value of a flag message, used by port forwarding sessions.
+type Flag uint32
+
+const (
+	// DisconnectToPort tells the agent the local connection closed; it
+	// reconnects to the remote port when new data arrives.
+	DisconnectToPort Flag = 1
+	// TerminateSession asks the agent to end the session.
+	TerminateSession Flag = 2
+	// ConnectToPortError is sent by the agent when the remote port refuses
+	// the connection.
+	ConnectToPortError Flag = 3
+)
+
+// Binary layout of a data channel message; all integers are big-endian.
+const (
+	messageTypeLen           = 32
+	offMessageType           = 4
+	offSchemaVersion         = 36
+	offCreatedDate           = 40
+	offSequenceNumber        = 48
+	offFlags                 = 56
+	offMessageID             = 64
+	offPayloadDigest         = 80
+	offPayloadType           = 112
+	offPayloadLength         = 116
+	headerLength      uint32 = offPayloadLength
+	minMessageLength         = offPayloadLength + 4
+)
+
+var errShortMessage = errors.New("ssm message too short")
+
+// message is one binary frame on the data channel.
+type message struct {
+	Type           string
+	SchemaVersion  uint32
+	CreatedDate    time.Time
+	SequenceNumber int64
+	Flags          uint64
+	ID             uuid.UUID
+	PayloadType    uint32
+	Payload        []byte
+}
+
+func newMessage(msgType string, seq int64, flags uint64, payloadType uint32, payload []byte) *message {
+	return &message{
+		Type:           msgType,
+		SchemaVersion:  1,
+		CreatedDate:    time.Now(),
+		SequenceNumber: seq,
+		Flags:          flags,
+		ID:             uuid.New(),
+		PayloadType:    payloadType,
+		Payload:        payload,
+	}
+}
+
+// marshal encodes m in the Session Manager wire format.
+func (m *message) marshal() []byte {
+	buf := make([]byte, minMessageLength+len(m.Payload))
+	binary.BigEndian.PutUint32(buf, headerLength)
+	typ := bytes.Repeat([]byte{' '}, messageTypeLen)
+	copy(typ, m.Type)
+	copy(buf[offMessageType:], typ)
+	binary.BigEndian.PutUint32(buf[offSchemaVersion:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(buf[offCreatedDate:], uint64(m.CreatedDate.UnixMilli()))
+	binary.BigEndian.PutUint64(buf[offSequenceNumber:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(buf[offFlags:], m.Flags)
+	putUUID(buf[offMessageID:], m.ID)
+	digest := sha256.Sum256(m.Payload)
+	copy(buf[offPayloadDigest:], digest[:])
+	binary.BigEndian.PutUint32(buf[offPayloadType:], m.PayloadType)
+	binary.BigEndian.PutUint32(buf[offPayloadLength:], uint32(len(m.Payload)))
+	copy(buf[minMessageLength:], m.Payload)
+	return buf
+}
+
+// unmarshalMessage decodes a binary frame and verifies its payload digest.
+func unmarshalMessage(buf []byte) (*message, error) {
+	if len(buf) < minMessageLength {
+		return nil, errShortMessage
+	}
+	hl := binary.BigEndian.Uint32(buf)
+	if hl < offPayloadType || int(hl)+4 > len(buf) {
+		return nil, fmt.Errorf("ssm message: invalid header length %d", hl)
+	}
+	m := &message{
+		Type:           string(bytes.TrimRight(buf[offMessageType:offMessageType+messageTypeLen], " \x00")),
+		SchemaVersion:  binary.BigEndian.Uint32(buf[offSchemaVersion:]),
+		CreatedDate:    time.UnixMilli(int64(binary.BigEndian.Uint64(buf[offCreatedDate:]))),
+		SequenceNumber: int64(binary.BigEndian.Uint64(buf[offSequenceNumber:])),
+		Flags:          binary.BigEndian.Uint64(buf[offFlags:]),
+		ID:             getUUID(buf[offMessageID:]),
+		PayloadType:    binary.BigEndian.Uint32(buf[offPayloadType:]),
+	}
+	n := binary.BigEndian.Uint32(buf[hl:])
+	start := int(hl) + 4
+	if uint64(start)+uint64(n) > uint64(len(buf)) {
+		return nil, fmt.Errorf("ssm message: payload length %d exceeds frame", n)
+	}
+	m.Payload = buf[start : start+int(n)]
+	if n > 0 {
+		digest := sha256.Sum256(m.Payload)
+		if !bytes.Equal(digest[:], buf[offPayloadDigest:offPayloadDigest+sha256.Size]) {
+			return nil, errors.New("ssm message: payload digest mismatch")
+		}
+	}
+	return m, nil
+}
+
+// The wire format stores a UUID's least significant half first.
+func putUUID(dst []byte, id uuid.UUID) {
+	copy(dst[:8], id[8:])
+	copy(dst[8:16], id[:8])
+}
+
+func getUUID(src []byte) uuid.UUID {
+	var id uuid.UUID
+	copy(id[8:], src[:8])
+	copy(id[:8], src[8:16])
+	return id
+}
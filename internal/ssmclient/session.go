@@ -0,0 +1,567 @@
+// Package ssmclient implements the client side of the AWS Systems Manager
+// Session Manager data channel, the websocket protocol spoken by
+// session-manager-plugin, so sessions can be opened without the AWS CLI.
+package ssmclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// clientVersion is reported to the agent. It is below 1.1.70, the first
+// plugin release with multiplexed port forwarding, so port sessions use the
+// one-connection-at-a-time protocol implemented by Serve.
+const clientVersion = "1.1.61.0"
+
+// streamChunkSize is the largest payload sent in one input message.
+const streamChunkSize = 1024
+
+// Tunables; tests shorten them.
+var (
+	handshakeTimeout = 30 * time.Second
+	resendInterval   = time.Second
+	resendTimeout    = 5 * time.Minute
+	pingInterval     = 5 * time.Minute
+)
+
+// Session types announced by the agent during the handshake.
+const (
+	SessionTypeShell = "Standard_Stream"
+	SessionTypePort  = "Port"
+)
+
+// ErrEncryptionRequired is returned when the session document requires KMS
+// encryption, which this client does not implement.
+var ErrEncryptionRequired = errors.New("ssm session requires KMS encryption, which is not supported")
+
+// Session is an open data channel. Read returns the agent's output (stdout
+// and stderr interleaved as the agent sends them); Write sends input.
+type Session struct {
+	// ID is the Session Manager session ID, set by Start.
+	ID string
+
+	conn          *websocket.Conn
+	writeMu       sync.Mutex // serialises websocket writes
+	terminate     func(context.Context) error
+	terminateOnce sync.Once
+
+	mu          sync.Mutex
+	cond        *sync.Cond // signalled when publication resumes or the channel closes
+	seq         int64      // next outgoing sequence number
+	unacked     map[int64]*pending
+	expected    int64 // next incoming sequence number
+	outOfOrder  map[int64]*message
+	paused      bool
+	ready       bool // handshake finished
+	sessionType string
+	exitCode    *int
+
+	output    chan []byte
+	rest      []byte
+	handshake chan error
+	portError chan struct{} // the agent could not reach the remote port
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+type pending struct {
+	raw       []byte
+	firstSent time.Time
+	lastSent  time.Time
+}
+
+type openDataChannelInput struct {
+	MessageSchemaVersion string `json:"MessageSchemaVersion"`
+	RequestID            string `json:"RequestId"`
+	TokenValue           string `json:"TokenValue"`
+	ClientID             string `json:"ClientId"`
+	ClientVersion        string `json:"ClientVersion"`
+}
+
+type acknowledgeContent struct {
+	MessageType    string `json:"AcknowledgedMessageType"`
+	MessageID      string `json:"AcknowledgedMessageId"`
+	SequenceNumber int64  `json:"AcknowledgedMessageSequenceNumber"`
+	IsSequential   bool   `json:"IsSequentialMessage"`
+}
+
+type handshakeRequest struct {
+	AgentVersion           string `json:"AgentVersion"`
+	RequestedClientActions []struct {
+		ActionType       string          `json:"ActionType"`
+		ActionParameters json.RawMessage `json:"ActionParameters"`
+	} `json:"RequestedClientActions"`
+}
+
+type processedClientAction struct {
+	ActionType   string `json:"ActionType"`
+	ActionStatus int    `json:"ActionStatus"` // 1 success, 2 failed, 3 unsupported
+	Error        string `json:"Error,omitempty"`
+}
+
+type handshakeResponse struct {
+	ClientVersion          string                  `json:"ClientVersion"`
+	ProcessedClientActions []processedClientAction `json:"ProcessedClientActions"`
+	Errors                 []string                `json:"Errors"`
+}
+
+type channelClosed struct {
+	SessionID string `json:"SessionId"`
+	Output    string `json:"Output"`
+}
+
+// Open connects to a data channel stream URL (from StartSession) and
+// completes the handshake with the agent.
+func Open(ctx context.Context, streamURL, token string) (*Session, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssm data channel: %w", err)
+	}
+	s := &Session{
+		conn:       conn,
+		unacked:    make(map[int64]*pending),
+		outOfOrder: make(map[int64]*message),
+		output:     make(chan []byte, 256),
+		handshake:  make(chan error, 1),
+		portError:  make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	open, _ := json.Marshal(openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestID:            uuid.NewString(),
+		TokenValue:           token,
+		ClientID:             uuid.NewString(),
+		ClientVersion:        clientVersion,
+	})
+	if err := s.writeFrame(websocket.TextMessage, open); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open ssm data channel: %w", err)
+	}
+
+	go s.readLoop()
+	go s.resendLoop(resendInterval, resendTimeout)
+	go s.pingLoop(pingInterval)
+
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-s.handshake:
+	case <-s.done:
+		err = s.Err()
+		if err == nil {
+			err = errors.New("ssm data channel closed during handshake")
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = errors.New("timed out waiting for ssm handshake")
+	}
+	if err != nil {
+		s.shutdown(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// SessionType is the session type the agent announced, e.g.
+// SessionTypeShell or SessionTypePort. It is empty for agents that predate
+// the handshake.
+func (s *Session) SessionType() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessionType
+}
+
+// Read returns output from the agent. It returns io.EOF once the channel
+// has closed and all output was read. Read must not be called concurrently.
+func (s *Session) Read(p []byte) (int, error) {
+	if len(s.rest) == 0 {
+		select {
+		case data := <-s.output:
+			s.rest = data
+		case <-s.done:
+			// Drain output that arrived before the close.
+			select {
+			case data := <-s.output:
+				s.rest = data
+			default:
+				if err := s.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+		}
+	}
+	n := copy(p, s.rest)
+	s.rest = s.rest[n:]
+	return n, nil
+}
+
+// Write sends input to the agent, blocking while the agent has paused
+// publication.
+func (s *Session) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > streamChunkSize {
+			chunk = chunk[:streamChunkSize]
+		}
+		// Copy: the caller may reuse p, and the payload is kept for resends.
+		data := append([]byte(nil), chunk...)
+		if err := s.send(payloadOutput, data); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Resize sets the remote terminal size.
+func (s *Session) Resize(cols, rows uint16) error {
+	payload, _ := json.Marshal(struct {
+		Cols uint16 `json:"cols"`
+		Rows uint16 `json:"rows"`
+	}{cols, rows})
+	return s.send(payloadSize, payload)
+}
+
+// SendFlag sends a control flag, e.g. DisconnectToPort.
+func (s *Session) SendFlag(f Flag) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(f))
+	return s.send(payloadFlag, payload)
+}
+
+// ExitCode returns the remote command's exit code if the agent reported one.
+func (s *Session) ExitCode() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exitCode == nil {
+		return 0, false
+	}
+	return *s.exitCode, true
+}
+
+// Done is closed when the data channel ends.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err reports why the channel ended; nil means the agent closed it normally.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the session: the Session Manager session is terminated (when
+// opened with Start) and the websocket closed. Only the first call
+// terminates.
+func (s *Session) Close() error {
+	return s.closeWith(nil)
+}
+
+// closeWith terminates the session and shuts the channel down with reason.
+func (s *Session) closeWith(reason error) error {
+	var err error
+	s.terminateOnce.Do(func() {
+		if s.terminate != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = s.terminate(ctx)
+			cancel()
+		}
+	})
+	s.shutdown(reason)
+	return err
+}
+
+// send queues a stream data message and transmits it, keeping it for
+// resending until the agent acknowledges it.
+func (s *Session) send(payloadType uint32, payload []byte) error {
+	s.mu.Lock()
+	for s.paused && !s.closed() {
+		s.cond.Wait()
+	}
+	if s.closed() {
+		s.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	m := newMessage(msgInputStream, s.seq, flagData, payloadType, payload)
+	s.seq++
+	raw := m.marshal()
+	now := time.Now()
+	s.unacked[m.SequenceNumber] = &pending{raw: raw, firstSent: now, lastSent: now}
+	s.mu.Unlock()
+	return s.writeFrame(websocket.BinaryMessage, raw)
+}
+
+func (s *Session) writeFrame(kind int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(kind, data)
+}
+
+func (s *Session) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown closes the channel once, recording err as the reason.
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		close(s.done)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		s.writeMu.Lock()
+		s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		s.writeMu.Unlock()
+		s.conn.Close()
+	})
+}
+
+func (s *Session) readLoop() {
+	for {
+		kind, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if !s.closed() {
+				s.shutdown(fmt.Errorf("ssm data channel: %w", err))
+			}
+			return
+		}
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+		m, err := unmarshalMessage(data)
+		if err != nil {
+			// A corrupt frame is never acknowledged, so the agent resends it.
+			continue
+		}
+		switch m.Type {
+		case msgAcknowledge:
+			s.handleAck(m)
+		case msgOutputStream:
+			s.handleOutput(m)
+		case msgChannelClosed:
+			var cc channelClosed
+			json.Unmarshal(m.Payload, &cc)
+			s.mu.Lock()
+			ready := s.ready
+			s.mu.Unlock()
+			// Closing before the handshake means the session never started,
+			// e.g. the target is not connected.
+			var reason error
+			if !ready {
+				reason = fmt.Errorf("ssm session closed: %s", cc.Output)
+			}
+			s.shutdown(reason)
+			return
+		case msgPausePublication, msgStartPublication:
+			s.mu.Lock()
+			s.paused = m.Type == msgPausePublication
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Session) handleAck(m *message) {
+	var ack acknowledgeContent
+	if err := json.Unmarshal(m.Payload, &ack); err != nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.unacked, ack.SequenceNumber)
+	s.mu.Unlock()
+}
+
+// handleOutput acknowledges an incoming stream message and processes
+// messages strictly in sequence order, holding early ones back.
+func (s *Session) handleOutput(m *message) {
+	s.acknowledge(m)
+
+	s.mu.Lock()
+	if m.SequenceNumber < s.expected {
+		s.mu.Unlock()
+		return // duplicate of a message already processed
+	}
+	s.outOfOrder[m.SequenceNumber] = m
+	var ready []*message
+	for {
+		next, ok := s.outOfOrder[s.expected]
+		if !ok {
+			break
+		}
+		delete(s.outOfOrder, s.expected)
+		s.expected++
+		ready = append(ready, next)
+	}
+	s.mu.Unlock()
+
+	for _, next := range ready {
+		s.process(next)
+	}
+}
+
+func (s *Session) acknowledge(m *message) {
+	payload, _ := json.Marshal(acknowledgeContent{
+		MessageType:    m.Type,
+		MessageID:      m.ID.String(),
+		SequenceNumber: m.SequenceNumber,
+		IsSequential:   true,
+	})
+	ack := newMessage(msgAcknowledge, 0, flagAck, 0, payload)
+	s.writeFrame(websocket.BinaryMessage, ack.marshal())
+}
+
+func (s *Session) process(m *message) {
+	switch m.PayloadType {
+	case payloadOutput, payloadStdErr:
+		s.signalHandshake(nil) // agents without a handshake start streaming directly
+		select {
+		case s.output <- m.Payload:
+		case <-s.done:
+		}
+	case payloadHandshakeRequest:
+		s.handleHandshake(m.Payload)
+	case payloadHandshakeComplete:
+		s.signalHandshake(nil)
+	case payloadExitCode:
+		if len(m.Payload) >= 4 {
+			code := int(int32(binary.BigEndian.Uint32(m.Payload)))
+			s.mu.Lock()
+			s.exitCode = &code
+			s.mu.Unlock()
+		}
+	case payloadFlag:
+		if len(m.Payload) >= 4 && Flag(binary.BigEndian.Uint32(m.Payload)) == ConnectToPortError {
+			select {
+			case s.portError <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// handleHandshake answers the agent's requested client actions. Session
+// type negotiation is accepted; KMS encryption is refused.
+func (s *Session) handleHandshake(payload []byte) {
+	var req handshakeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.signalHandshake(fmt.Errorf("ssm handshake: %w", err))
+		return
+	}
+	resp := handshakeResponse{ClientVersion: clientVersion, Errors: []string{}}
+	var failure error
+	for _, action := range req.RequestedClientActions {
+		switch action.ActionType {
+		case "SessionType":
+			var params struct {
+				SessionType string `json:"SessionType"`
+			}
+			json.Unmarshal(action.ActionParameters, &params)
+			s.mu.Lock()
+			s.sessionType = params.SessionType
+			s.mu.Unlock()
+			resp.ProcessedClientActions = append(resp.ProcessedClientActions, processedClientAction{ActionType: action.ActionType, ActionStatus: 1})
+		case "KMSEncryption":
+			failure = ErrEncryptionRequired
+			resp.ProcessedClientActions = append(resp.ProcessedClientActions, processedClientAction{ActionType: action.ActionType, ActionStatus: 3, Error: ErrEncryptionRequired.Error()})
+			resp.Errors = append(resp.Errors, ErrEncryptionRequired.Error())
+		default:
+			resp.ProcessedClientActions = append(resp.ProcessedClientActions, processedClientAction{ActionType: action.ActionType, ActionStatus: 3})
+		}
+	}
+	data, _ := json.Marshal(resp)
+	if err := s.send(payloadHandshakeResponse, data); err != nil && failure == nil {
+		failure = err
+	}
+	if failure != nil {
+		s.signalHandshake(failure)
+	}
+}
+
+func (s *Session) signalHandshake(err error) {
+	s.mu.Lock()
+	if s.ready {
+		s.mu.Unlock()
+		return
+	}
+	s.ready = err == nil
+	s.mu.Unlock()
+	select {
+	case s.handshake <- err:
+	default:
+	}
+}
+
+// resendLoop retransmits input the agent has not acknowledged, giving up
+// on the channel after timeout.
+func (s *Session) resendLoop(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+		now := time.Now()
+		var frames [][]byte
+		s.mu.Lock()
+		for _, p := range s.unacked {
+			if now.Sub(p.firstSent) > timeout {
+				s.mu.Unlock()
+				s.shutdown(errors.New("ssm data channel: agent stopped acknowledging input"))
+				return
+			}
+			if now.Sub(p.lastSent) >= interval {
+				p.lastSent = now
+				frames = append(frames, p.raw)
+			}
+		}
+		s.mu.Unlock()
+		for _, f := range frames {
+			if err := s.writeFrame(websocket.BinaryMessage, f); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// pingLoop keeps the websocket from idling out between keystrokes.
+func (s *Session) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(10*time.Second))
+			s.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package ssmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/gorilla/websocket"
+)
+
+// fakeAgent plays the message gateway and SSM agent side of a data channel.
+type fakeAgent struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	open   openDataChannelInput
+	seq    int64
+	inputs chan *message // new input_stream_data messages, in arrival order
+	acks   chan int64    // sequence numbers the client acknowledged
+
+	mu      sync.Mutex
+	noAck   bool // stop acknowledging input
+	seen    map[int64]int
+	writeMu sync.Mutex
+}
+
+// fakeGateway serves one data channel per connection, running script as
+// the agent once the client has sent its open message.
+func fakeGateway(t *testing.T, script func(a *fakeAgent)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		a := &fakeAgent{t: t, conn: conn, inputs: make(chan *message, 64), acks: make(chan int64, 64), seen: map[int64]int{}}
+		kind, data, err := conn.ReadMessage()
+		if err != nil || kind != websocket.TextMessage {
+			t.Errorf("expected open message, got kind %d err %v", kind, err)
+			return
+		}
+		json.Unmarshal(data, &a.open)
+		go a.readLoop()
+		script(a)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func (a *fakeAgent) readLoop() {
+	for {
+		_, data, err := a.conn.ReadMessage()
+		if err != nil {
+			close(a.inputs)
+			return
+		}
+		m, err := unmarshalMessage(data)
+		if err != nil {
+			a.t.Errorf("client sent invalid frame: %v", err)
+			continue
+		}
+		switch m.Type {
+		case msgAcknowledge:
+			var ack acknowledgeContent
+			json.Unmarshal(m.Payload, &ack)
+			a.acks <- ack.SequenceNumber
+		case msgInputStream:
+			a.mu.Lock()
+			a.seen[m.SequenceNumber]++
+			first := a.seen[m.SequenceNumber] == 1
+			noAck := a.noAck
+			a.mu.Unlock()
+			if !noAck {
+				payload, _ := json.Marshal(acknowledgeContent{MessageType: m.Type, MessageID: m.ID.String(), SequenceNumber: m.SequenceNumber, IsSequential: true})
+				a.write(newMessage(msgAcknowledge, 0, flagAck, 0, payload))
+			}
+			if first {
+				a.inputs <- m
+			}
+		}
+	}
+}
+
+func (a *fakeAgent) write(m *message) {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	a.conn.WriteMessage(websocket.BinaryMessage, m.marshal())
+}
+
+// output sends the next output_stream_data message.
+func (a *fakeAgent) output(payloadType uint32, payload []byte) {
+	a.write(newMessage(msgOutputStream, a.seq, flagData, payloadType, payload))
+	a.seq++
+}
+
+func (a *fakeAgent) handshake(actions ...string) {
+	req := map[string]interface{}{"AgentVersion": "3.3.0.0"}
+	var list []map[string]interface{}
+	for _, act := range actions {
+		switch act {
+		case "SessionType":
+			list = append(list, map[string]interface{}{"ActionType": act, "ActionParameters": map[string]string{"SessionType": SessionTypeShell}})
+		default:
+			list = append(list, map[string]interface{}{"ActionType": act, "ActionParameters": map[string]string{"KMSKeyId": "k"}})
+		}
+	}
+	req["RequestedClientActions"] = list
+	data, _ := json.Marshal(req)
+	a.output(payloadHandshakeRequest, data)
+}
+
+// next returns the next input message, failing the test on timeout.
+func (a *fakeAgent) next() *message {
+	select {
+	case m, ok := <-a.inputs:
+		if !ok {
+			a.t.Error("client closed the channel")
+			return &message{}
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		a.t.Error("timed out waiting for client input")
+		return &message{}
+	}
+}
+
+func (a *fakeAgent) close(output string) {
+	data, _ := json.Marshal(channelClosed{Output: output})
+	a.write(newMessage(msgChannelClosed, 0, flagData, 0, data))
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := newMessage(msgOutputStream, 42, flagData, payloadOutput, []byte("hello"))
+	raw := m.marshal()
+	if got := binary.BigEndian.Uint32(raw); got != 116 {
+		t.Fatalf("header length = %d, want 116", got)
+	}
+	if !bytes.Equal(raw[offMessageID:offMessageID+8], m.ID[8:]) {
+		t.Fatal("message ID must be stored least significant half first")
+	}
+	got, err := unmarshalMessage(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != msgOutputStream || got.SequenceNumber != 42 || got.ID != m.ID || string(got.Payload) != "hello" || got.PayloadType != payloadOutput {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+
+	raw[len(raw)-1] ^= 0xff
+	if _, err := unmarshalMessage(raw); err == nil {
+		t.Fatal("corrupted payload must fail the digest check")
+	}
+	if _, err := unmarshalMessage(raw[:50]); err == nil {
+		t.Fatal("short frame must fail")
+	}
+}
+
+func TestShellSession(t *testing.T) {
+	type result struct {
+		handshake handshakeResponse
+		size      string
+		input     string
+	}
+	got := make(chan result, 1)
+	url := fakeGateway(t, func(a *fakeAgent) {
+		if a.open.TokenValue != "tok" || a.open.ClientVersion != clientVersion {
+			t.Errorf("open message = %+v", a.open)
+		}
+		var res result
+		a.handshake("SessionType")
+		resp := a.next()
+		if resp.PayloadType != payloadHandshakeResponse {
+			t.Errorf("payload type %d, want handshake response", resp.PayloadType)
+		}
+		json.Unmarshal(resp.Payload, &res.handshake)
+		a.output(payloadHandshakeComplete, []byte(`{"HandshakeTimeToComplete":1000000}`))
+
+		res.size = string(a.next().Payload)
+		res.input = string(a.next().Payload)
+		got <- res
+
+		// Deliver out of order and with a duplicate; the client must
+		// reassemble "hello world" and ack every copy.
+		m0 := newMessage(msgOutputStream, 2, flagData, payloadOutput, []byte("hello "))
+		m1 := newMessage(msgOutputStream, 3, flagData, payloadStdErr, []byte("world"))
+		a.write(m1)
+		a.write(m0)
+		a.write(m0)
+		a.seq = 4
+		for i := 0; i < 5; i++ { // handshake request, complete, and the three above
+			<-a.acks
+		}
+		code := make([]byte, 4)
+		binary.BigEndian.PutUint32(code, 3)
+		a.output(payloadExitCode, code)
+		<-a.acks
+		a.close("")
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	s, err := Open(context.Background(), url, "tok")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if s.SessionType() != SessionTypeShell {
+		t.Fatalf("session type %q", s.SessionType())
+	}
+	if err := s.Resize(120, 40); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-got
+	if len(res.handshake.ProcessedClientActions) != 1 || res.handshake.ProcessedClientActions[0].ActionStatus != 1 {
+		t.Fatalf("handshake response %+v", res.handshake)
+	}
+	if res.size != `{"cols":120,"rows":40}` {
+		t.Fatalf("size payload %s", res.size)
+	}
+	if res.input != "ls\r" {
+		t.Fatalf("input payload %q", res.input)
+	}
+
+	out, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(out) != "hello world" {
+		t.Fatalf("output %q", out)
+	}
+	if code, ok := s.ExitCode(); !ok || code != 3 {
+		t.Fatalf("exit code %d, %v", code, ok)
+	}
+	if s.Err() != nil {
+		t.Fatalf("Err after normal close: %v", s.Err())
+	}
+}
+
+func TestResendUntilAcknowledged(t *testing.T) {
+	defer func(d time.Duration) { resendInterval = d }(resendInterval)
+	resendInterval = 20 * time.Millisecond
+
+	resent := make(chan int, 1)
+	url := fakeGateway(t, func(a *fakeAgent) {
+		a.output(payloadOutput, []byte("$ ")) // no handshake: an older agent
+		a.mu.Lock()
+		a.noAck = true
+		a.mu.Unlock()
+		m := a.next()
+		time.Sleep(100 * time.Millisecond)
+		a.mu.Lock()
+		copies := a.seen[m.SequenceNumber]
+		a.noAck = false
+		a.mu.Unlock()
+		resent <- copies
+		time.Sleep(100 * time.Millisecond)
+		a.close("")
+	})
+	s, err := Open(context.Background(), url, "tok")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	s.Write([]byte("x"))
+	if n := <-resent; n < 2 {
+		t.Fatalf("unacknowledged input sent %d times, want resends", n)
+	}
+	<-s.Done()
+	s.mu.Lock()
+	left := len(s.unacked)
+	s.mu.Unlock()
+	if left != 0 {
+		t.Fatalf("%d messages still unacknowledged", left)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	url := fakeGateway(t, func(a *fakeAgent) {
+		a.handshake("SessionType", "KMSEncryption")
+		resp := a.next()
+		var hr handshakeResponse
+		json.Unmarshal(resp.Payload, &hr)
+		if len(hr.Errors) == 0 {
+			t.Errorf("handshake response must report the refused action: %+v", hr)
+		}
+		time.Sleep(100 * time.Millisecond)
+	})
+	if _, err := Open(context.Background(), url, "tok"); !errors.Is(err, ErrEncryptionRequired) {
+		t.Fatalf("KMS session: err = %v", err)
+	}
+
+	url = fakeGateway(t, func(a *fakeAgent) {
+		a.close("TargetNotConnected")
+		time.Sleep(100 * time.Millisecond)
+	})
+	if _, err := Open(context.Background(), url, "tok"); err == nil || !strings.Contains(err.Error(), "TargetNotConnected") {
+		t.Fatalf("closed before handshake: err = %v", err)
+	}
+}
+
+type fakeAPI struct {
+	streamURL  string
+	input      *ssm.StartSessionInput
+	terminated string
+}
+
+func (f *fakeAPI) StartSession(ctx context.Context, in *ssm.StartSessionInput, _ ...func(*ssm.Options)) (*ssm.StartSessionOutput, error) {
+	f.input = in
+	return &ssm.StartSessionOutput{SessionId: aws.String("sess-1"), StreamUrl: aws.String(f.streamURL), TokenValue: aws.String("tok")}, nil
+}
+
+func (f *fakeAPI) TerminateSession(ctx context.Context, in *ssm.TerminateSessionInput, _ ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error) {
+	f.terminated = aws.ToString(in.SessionId)
+	return &ssm.TerminateSessionOutput{}, nil
+}
+
+func TestPortForward(t *testing.T) {
+	disconnects := make(chan struct{}, 2)
+	url := fakeGateway(t, func(a *fakeAgent) {
+		a.handshake("SessionType")
+		a.next()
+		a.output(payloadHandshakeComplete, []byte(`{}`))
+		// Echo data back upper-cased and report disconnect flags.
+		for m := range a.inputs {
+			switch m.PayloadType {
+			case payloadOutput:
+				a.output(payloadOutput, bytes.ToUpper(m.Payload))
+			case payloadFlag:
+				if Flag(binary.BigEndian.Uint32(m.Payload)) == DisconnectToPort {
+					disconnects <- struct{}{}
+				}
+			}
+		}
+	})
+	api := &fakeAPI{streamURL: url}
+	s, err := StartPortForward(context.Background(), api, "i-123", 3389)
+	if err != nil {
+		t.Fatalf("StartPortForward: %v", err)
+	}
+	if aws.ToString(api.input.DocumentName) != PortForwardingDocument || api.input.Parameters["portNumber"][0] != "3389" || s.ID != "sess-1" {
+		t.Fatalf("StartSession input %+v, id %q", api.input, s.ID)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	for _, word := range []string{"first", "second"} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(word))
+		buf := make([]byte, len(word))
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != strings.ToUpper(word) {
+			t.Fatalf("connection %s got %q, %v", word, buf, err)
+		}
+		c.Close()
+		select {
+		case <-disconnects:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no DisconnectToPort after connection %s closed", word)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if api.terminated != "sess-1" {
+		t.Fatalf("TerminateSession called with %q", api.terminated)
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
+
+func TestSSHConn(t *testing.T) {
+	url := fakeGateway(t, func(a *fakeAgent) {
+		a.handshake("SessionType")
+		a.next()
+		a.output(payloadHandshakeComplete, []byte(`{}`))
+		for m := range a.inputs {
+			if m.PayloadType != payloadOutput {
+				continue
+			}
+			if string(m.Payload) == "refuse" {
+				flag := make([]byte, 4)
+				binary.BigEndian.PutUint32(flag, uint32(ConnectToPortError))
+				a.output(payloadFlag, flag)
+				continue
+			}
+			a.output(payloadOutput, bytes.ToUpper(m.Payload))
+		}
+	})
+	api := &fakeAPI{streamURL: url}
+	s, err := StartSSH(context.Background(), api, "i-123", 22)
+	if err != nil {
+		t.Fatalf("StartSSH: %v", err)
+	}
+	if aws.ToString(api.input.DocumentName) != SSHDocument || api.input.Parameters["portNumber"][0] != "22" {
+		t.Fatalf("StartSession input %+v", api.input)
+	}
+
+	c := s.Conn()
+	if c.RemoteAddr().String() != "sess-1" {
+		t.Fatalf("remote addr %q", c.RemoteAddr())
+	}
+	c.Write([]byte("ssh-2.0"))
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "SSH-2.0" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+
+	c.Write([]byte("refuse"))
+	if _, err := c.Read(buf); !errors.Is(err, ErrPortUnreachable) {
+		t.Fatalf("read after port error: %v", err)
+	}
+	if api.terminated != "sess-1" {
+		t.Fatalf("TerminateSession called with %q", api.terminated)
+	}
+}
@@ -0,0 +1,72 @@
+package ssmclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// PortForwardingDocument is the SSM document for forwarding a local port to
+// a port on the target instance.
+const PortForwardingDocument = "AWS-StartPortForwardingSession"
+
+// SSHDocument is the SSM document for tunnelling an SSH connection to an
+// instance.
+const SSHDocument = "AWS-StartSSHSession"
+
+// API is the part of the SSM client used to start and end sessions;
+// *ssm.Client implements it.
+type API interface {
+	StartSession(ctx context.Context, params *ssm.StartSessionInput, optFns ...func(*ssm.Options)) (*ssm.StartSessionOutput, error)
+	TerminateSession(ctx context.Context, params *ssm.TerminateSessionInput, optFns ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error)
+}
+
+// Start calls StartSession and opens its data channel. Closing the returned
+// Session terminates the Session Manager session.
+func Start(ctx context.Context, api API, input *ssm.StartSessionInput) (*Session, error) {
+	out, err := api.StartSession(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("SSM StartSession: %w", err)
+	}
+	id := aws.ToString(out.SessionId)
+	terminate := func(ctx context.Context) error {
+		_, err := api.TerminateSession(ctx, &ssm.TerminateSessionInput{SessionId: aws.String(id)})
+		return err
+	}
+	s, err := Open(ctx, aws.ToString(out.StreamUrl), aws.ToString(out.TokenValue))
+	if err != nil {
+		terminate(context.Background())
+		return nil, err
+	}
+	s.ID = id
+	s.terminate = terminate
+	return s, nil
+}
+
+// StartShell opens an interactive shell session on an instance.
+func StartShell(ctx context.Context, api API, instanceID string) (*Session, error) {
+	return Start(ctx, api, &ssm.StartSessionInput{Target: aws.String(instanceID)})
+}
+
+// StartPortForward opens a port forwarding session to port on an instance;
+// pass the session to Serve to accept local connections.
+func StartPortForward(ctx context.Context, api API, instanceID string, port int) (*Session, error) {
+	return Start(ctx, api, &ssm.StartSessionInput{
+		Target:       aws.String(instanceID),
+		DocumentName: aws.String(PortForwardingDocument),
+		Parameters:   map[string][]string{"portNumber": {strconv.Itoa(port)}},
+	})
+}
+
+// StartSSH opens a tunnel to the SSH server listening on port of an
+// instance; run an SSH client over the session's Conn.
+func StartSSH(ctx context.Context, api API, instanceID string, port int) (*Session, error) {
+	return Start(ctx, api, &ssm.StartSessionInput{
+		Target:       aws.String(instanceID),
+		DocumentName: aws.String(SSHDocument),
+		Parameters:   map[string][]string{"portNumber": {strconv.Itoa(port)}},
+	})
+}
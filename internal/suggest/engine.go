@@ -9,6 +9,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"cloudterm-go/internal/crypto"
 )
 
 // Suggestion represents a single autocomplete suggestion.
@@ -272,6 +274,23 @@ func (e *Engine) StoreDeleteKey(bucket, key string) error {
 	return e.store.DeleteKey(bucket, key)
 }
 
+// RotateKey re-encrypts the engine's store; see Store.RotateKey.
+func (e *Engine) RotateKey(r crypto.Rotation) error {
+	if e == nil || e.store == nil {
+		return nil
+	}
+	return e.store.RotateKey(r)
+}
+
+// ForgetPreviousKeys drops the store's earlier keys; see
+// Store.ForgetPreviousKeys.
+func (e *Engine) ForgetPreviousKeys() {
+	if e == nil || e.store == nil {
+		return
+	}
+	e.store.ForgetPreviousKeys()
+}
+
 func (e *Engine) StoreUpdateKey(bucket, key, value string) error {
 	return e.store.UpdateKey(bucket, key, value)
 }
@@ -0,0 +1,42 @@
+package suggest
+
+// LineBuffer rebuilds the command line being typed from raw keystrokes:
+// printable bytes are appended, backspace deletes the last byte and Enter
+// (CR or LF) ends the line. Other control bytes are ignored.
+type LineBuffer struct {
+	b []byte
+}
+
+// Feed processes one keystroke byte. On Enter it returns the finished line
+// and true, and starts a new one.
+func (l *LineBuffer) Feed(c byte) (string, bool) {
+	switch {
+	case c == 0x0d || c == 0x0a:
+		line := string(l.b)
+		l.b = l.b[:0]
+		return line, true
+	case c == 0x7f || c == 0x08:
+		if len(l.b) > 0 {
+			l.b = l.b[:len(l.b)-1]
+		}
+	case c >= 0x20:
+		l.b = append(l.b, c)
+	}
+	return "", false
+}
+
+// Append adds text that reached the line some other way, such as the
+// shell's echo of a tab completion.
+func (l *LineBuffer) Append(s string) {
+	l.b = append(l.b, s...)
+}
+
+// String returns the line typed so far.
+func (l *LineBuffer) String() string {
+	return string(l.b)
+}
+
+// Reset discards the current line.
+func (l *LineBuffer) Reset() {
+	l.b = l.b[:0]
+}
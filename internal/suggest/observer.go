@@ -13,7 +13,7 @@ var defaultPromptRE = regexp.MustCompile(`[$#%>]\s*$`)
 type Observer struct {
 	mu            sync.Mutex
 	outputCh      chan []byte
-	currentInput  strings.Builder
+	currentInput  LineBuffer
 	lastCommand   string
 	lastOutput    strings.Builder
 	prevCmdOutput string
@@ -55,25 +55,17 @@ func (o *Observer) FeedInput(data []byte) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	for _, b := range data {
-		if b == 0x0d || b == 0x0a {
-			cmd := strings.TrimSpace(o.currentInput.String())
+		if b == 0x09 {
+			o.tabPending = true
+		}
+		if line, enter := o.currentInput.Feed(b); enter {
+			cmd := strings.TrimSpace(line)
 			if cmd != "" {
 				o.lastCommand = cmd
 				o.commandActive = true
 				o.lastOutput.Reset()
 			}
-			o.currentInput.Reset()
 			o.tabPending = false
-		} else if b == 0x09 {
-			o.tabPending = true
-		} else if b == 0x7f || b == 0x08 {
-			s := o.currentInput.String()
-			if len(s) > 0 {
-				o.currentInput.Reset()
-				o.currentInput.WriteString(s[:len(s)-1])
-			}
-		} else if b >= 0x20 {
-			o.currentInput.WriteByte(b)
 		}
 	}
 }
@@ -132,7 +124,7 @@ func (o *Observer) processOutput(raw []byte) {
 	if o.tabPending && !o.commandActive {
 		completion := strings.TrimRight(cleaned, "\r\n")
 		if completion != "" && !strings.Contains(completion, "\n") {
-			o.currentInput.WriteString(completion)
+			o.currentInput.Append(completion)
 		}
 		o.tabPending = false
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"cloudterm-go/internal/crypto"
@@ -13,8 +14,10 @@ import (
 
 // Store provides encrypted persistence via bbolt.
 type Store struct {
-	db  *bolt.DB
-	key []byte
+	db       *bolt.DB
+	mu       sync.RWMutex
+	key      []byte
+	previous [][]byte // keys replaced by RotateKey, for reads racing it
 }
 
 // CommandMeta holds metadata for a stored command.
@@ -52,6 +55,8 @@ func (s *Store) bucketName(env, category string) []byte {
 }
 
 func (s *Store) encrypt(data []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if len(s.key) == 0 {
 		return data, nil
 	}
@@ -59,10 +64,44 @@ func (s *Store) encrypt(data []byte) ([]byte, error) {
 }
 
 func (s *Store) decrypt(data []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if len(s.key) == 0 {
 		return data, nil
 	}
-	return crypto.Decrypt(s.key, data)
+	return crypto.Rotation{Key: s.key, Previous: s.previous}.Open(data)
+}
+
+// RotateKey re-encrypts every bucket with r.Key in a single transaction
+// and switches the store to it.
+func (s *Store) RotateKey(r crypto.Rotation) error {
+	s.mu.RLock()
+	oldKey, oldPrevious := s.key, s.previous
+	s.mu.RUnlock()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := crypto.ResealBuckets(tx, r); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.key, s.previous = r.Key, r.Previous
+		s.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.key, s.previous = oldKey, oldPrevious
+		s.mu.Unlock()
+		return fmt.Errorf("suggest store: %w", err)
+	}
+	return nil
+}
+
+// ForgetPreviousKeys drops the keys of the last rotation once every store
+// has been resealed with the new one.
+func (s *Store) ForgetPreviousKeys() {
+	s.mu.Lock()
+	s.previous = nil
+	s.mu.Unlock()
 }
 
 // StoreCommand saves a command with metadata.
@@ -137,3 +137,32 @@ func TestStoreBlobRoundTrip(t *testing.T) {
 		t.Errorf("blob roundtrip failed: got %s", string(loaded))
 	}
 }
+
+func TestStoreRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir, nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	s.StoreCommand("dev", "git status", CommandMeta{Command: "git status"})
+	s.StoreBlob("engine", "trie", []byte{0x00, 0xff})
+
+	// Data written without a key is encrypted by the first rotation.
+	key, _ := crypto.GenerateKey()
+	if err := s.RotateKey(crypto.Rotation{Key: key, Plaintext: true}); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	s.Close()
+
+	s, err = OpenStore(dir, key)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if results, _ := s.QueryByPrefix("dev", "git", 10); len(results) != 1 {
+		t.Errorf("commands after rotation = %+v", results)
+	}
+	if blob, err := s.LoadBlob("engine", "trie"); err != nil || len(blob) != 2 || blob[1] != 0xff {
+		t.Errorf("blob after rotation = %v, %v", blob, err)
+	}
+}
@@ -96,6 +96,10 @@ type AccountNode struct {
 	AccountAlias string       `json:"account_alias,omitempty"`
 	Profile      string       `json:"profile"`
 	Regions      []RegionNode `json:"regions"`
+	// OUPath and AccountTags are set for accounts found through AWS
+	// Organizations, for grouping by OU or account tag.
+	OUPath      string            `json:"ou_path,omitempty"`
+	AccountTags map[string]string `json:"account_tags,omitempty"`
 }
 
 type RegionNode struct {
@@ -124,6 +128,9 @@ type ScanStatus struct {
 	SuccessfulRegions   int    `json:"successful_regions"`
 	TotalInstances      int    `json:"total_instances"`
 	Message             string `json:"message,omitempty"`
+	// ExpiredProfiles lists profiles skipped because their SSO session or
+	// session token has expired.
+	ExpiredProfiles []string `json:"expired_profiles,omitempty"`
 }
 
 // FleetStats provides aggregate counts for the sidebar.
@@ -174,6 +181,46 @@ type TerminalInputMsg struct {
 	Input     string `json:"input"`
 }
 
+// BroadcastGroupMsg defines a named group of sessions that receive the
+// same keystrokes. Excluded members stay in the group but are skipped.
+type BroadcastGroupMsg struct {
+	Group      string   `json:"group"`
+	SessionIDs []string `json:"session_ids"`
+	Excluded   []string `json:"excluded,omitempty"`
+}
+
+// BroadcastExcludeMsg toggles one member of a broadcast group.
+type BroadcastExcludeMsg struct {
+	Group     string `json:"group"`
+	SessionID string `json:"session_id"`
+	Excluded  bool   `json:"excluded"`
+}
+
+// BroadcastInputMsg sends keystrokes to every active member of a group.
+type BroadcastInputMsg struct {
+	Group string `json:"group"`
+	Input string `json:"input"`
+}
+
+// GuardDecisionMsg tells the client a typed command matched a guard rule.
+// For "guard_warn" the command is held until the client answers with
+// GuardConfirmMsg; "guard_block" means it was cancelled; "guard_resolved"
+// reports the outcome of an earlier warning.
+type GuardDecisionMsg struct {
+	SessionID string `json:"session_id"`
+	Command   string `json:"command"`
+	Action    string `json:"action"`
+	Rule      string `json:"rule,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Outcome   string `json:"outcome,omitempty"`
+}
+
+// GuardConfirmMsg answers a guard_warn prompt.
+type GuardConfirmMsg struct {
+	SessionID string `json:"session_id"`
+	Confirmed bool   `json:"confirmed"`
+}
+
 type TerminalResizeMsg struct {
 	SessionID string `json:"session_id"`
 	Rows      uint16 `json:"rows"`
@@ -187,10 +234,13 @@ type TerminalOutputMsg struct {
 }
 
 type SessionEventMsg struct {
-	InstanceID string `json:"instance_id"`
-	SessionID  string `json:"session_id"`
-	Error      string `json:"error,omitempty"`
-	Recording  bool   `json:"recording,omitempty"`
+	InstanceID   string     `json:"instance_id"`
+	SessionID    string     `json:"session_id"`
+	Error        string     `json:"error,omitempty"`
+	Recording    bool       `json:"recording,omitempty"`
+	Reattached   bool       `json:"reattached,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`    // set when a time limit applies
+	ExpiryReason string     `json:"expiry_reason,omitempty"` // "idle_timeout" or "max_duration"
 }
 
 // RDP session types
@@ -243,6 +293,10 @@ type ForwarderStartResponse struct {
 	Port         int    `json:"port"`
 	RemotePort   int    `json:"remote_port"`
 	InstanceName string `json:"instance_name"`
+	// DBLink and DBLabel are added when a vault database credential
+	// matches the forwarded port; DBLabel omits the password.
+	DBLink  string `json:"db_link,omitempty"`
+	DBLabel string `json:"db_label,omitempty"`
 }
 
 type ForwarderSession struct {
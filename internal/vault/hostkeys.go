@@ -0,0 +1,39 @@
+package vault
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// hostKeyBucket holds the SSH host key pinned for each instance ID. Host
+// keys are public, so they are stored in plaintext and need no vault key.
+var hostKeyBucket = []byte("hostkeys")
+
+// PinHostKey pins key for instanceID unless a key is already pinned, and
+// returns the pinned key. It implements sshclient.HostKeys.
+func (s *Store) PinHostKey(instanceID, key string) (string, error) {
+	pinned := key
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(hostKeyBucket)
+		if err != nil {
+			return err
+		}
+		if v := b.Get([]byte(instanceID)); v != nil {
+			pinned = string(v)
+			return nil
+		}
+		return b.Put([]byte(instanceID), []byte(key))
+	})
+	return pinned, err
+}
+
+// ForgetHostKey removes the pinned host key of instanceID, so the next
+// connection pins whatever key the instance presents.
+func (s *Store) ForgetHostKey(instanceID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostKeyBucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(instanceID))
+	})
+}
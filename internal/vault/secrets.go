@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Secret kinds. Every kind uses the same MatchRule hierarchy; FindMatch only
+// considers entries of the requested kind.
+const (
+	KindRDP   = "rdp"
+	KindSSH   = "ssh"
+	KindDB    = "db"
+	KindToken = "token"
+)
+
+// ErrInvalidEntry is returned by Save for an incomplete entry.
+var ErrInvalidEntry = errors.New("invalid vault entry")
+
+// mask replaces secret values in listings.
+const mask = "\u2022\u2022\u2022\u2022\u2022\u2022\u2022\u2022"
+
+// SSHKey is a private key for SSH-over-SSM sessions, used instead of an
+// EC2 Instance Connect key when it matches the instance and user.
+type SSHKey struct {
+	Username   string `json:"username,omitempty"` // empty matches any OS user
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// DBCredential is a database login, offered as a connection link when a
+// port forward reaches the database port.
+type DBCredential struct {
+	Engine   string `json:"engine"`         // "postgres", "mysql", "mariadb", "sqlserver", "mongodb" or "redis"
+	Port     int    `json:"port,omitempty"` // defaults to the engine's standard port
+	Database string `json:"database,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// APIToken is a bearer token or API key, usable from snippets.
+type APIToken struct {
+	Token string `json:"token,omitempty"`
+}
+
+// dbEngines maps supported engines to their URL scheme and default port.
+var dbEngines = map[string]struct {
+	scheme string
+	port   int
+}{
+	"postgres":  {"postgresql", 5432},
+	"mysql":     {"mysql", 3306},
+	"mariadb":   {"mariadb", 3306},
+	"sqlserver": {"sqlserver", 1433},
+	"mongodb":   {"mongodb", 27017},
+	"redis":     {"redis", 6379},
+}
+
+// DefaultPort returns the port the database listens on.
+func (c DBCredential) DefaultPort() int {
+	if c.Port > 0 {
+		return c.Port
+	}
+	return dbEngines[c.Engine].port
+}
+
+// URL returns a connection URL for the database at host:port. The password
+// is included only when withPassword is set.
+func (c DBCredential) URL(host string, port int, withPassword bool) string {
+	u := url.URL{Scheme: dbEngines[c.Engine].scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	if c.Username != "" || (withPassword && c.Password != "") {
+		if withPassword && c.Password != "" {
+			u.User = url.UserPassword(c.Username, c.Password)
+		} else {
+			u.User = url.User(c.Username)
+		}
+	}
+	if c.Database != "" {
+		u.Path = "/" + c.Database
+	}
+	return u.String()
+}
+
+// kind returns the entry's kind; entries saved before kinds existed are RDP.
+func (e VaultEntry) kind() string {
+	if e.Kind == "" {
+		return KindRDP
+	}
+	return e.Kind
+}
+
+// validate checks that the entry carries the credential its kind needs.
+func (e VaultEntry) validate() error {
+	switch e.kind() {
+	case KindRDP:
+	case KindSSH:
+		if e.SSH == nil || !strings.Contains(e.SSH.PrivateKey, "PRIVATE KEY") {
+			return fmt.Errorf("%w: ssh entries need a PEM or OpenSSH private key", ErrInvalidEntry)
+		}
+	case KindDB:
+		if e.DB == nil {
+			return fmt.Errorf("%w: db entries need a database credential", ErrInvalidEntry)
+		}
+		if _, ok := dbEngines[e.DB.Engine]; !ok {
+			return fmt.Errorf("%w: unsupported database engine %q", ErrInvalidEntry, e.DB.Engine)
+		}
+	case KindToken:
+		if e.Token == nil || e.Token.Token == "" {
+			return fmt.Errorf("%w: token entries need a token", ErrInvalidEntry)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidEntry, e.Kind)
+	}
+	return nil
+}
+
+// Redacted returns a copy of e with its secret values masked.
+func (e VaultEntry) Redacted() VaultEntry {
+	if e.Credential.Password != "" {
+		e.Credential.Password = mask
+	}
+	if e.SSH != nil {
+		ssh := *e.SSH
+		ssh.PrivateKey = mask
+		if ssh.Passphrase != "" {
+			ssh.Passphrase = mask
+		}
+		e.SSH = &ssh
+	}
+	if e.DB != nil {
+		db := *e.DB
+		if db.Password != "" {
+			db.Password = mask
+		}
+		e.DB = &db
+	}
+	if e.Token != nil {
+		e.Token = &APIToken{Token: mask}
+	}
+	return e
+}
+
+// Field returns one value of the entry by name, as referenced by snippet
+// placeholders: "username", "password", "domain", "private_key",
+// "passphrase", "database" or "token".
+func (e VaultEntry) Field(name string) (string, error) {
+	var fields map[string]string
+	switch e.kind() {
+	case KindRDP:
+		c := e.Credential
+		fields = map[string]string{"username": c.Username, "password": c.Password, "domain": c.Domain}
+	case KindSSH:
+		if e.SSH != nil {
+			fields = map[string]string{"username": e.SSH.Username, "private_key": e.SSH.PrivateKey, "passphrase": e.SSH.Passphrase}
+		}
+	case KindDB:
+		if e.DB != nil {
+			fields = map[string]string{"username": e.DB.Username, "password": e.DB.Password, "database": e.DB.Database}
+		}
+	case KindToken:
+		if e.Token != nil {
+			fields = map[string]string{"token": e.Token.Token}
+		}
+	}
+	v, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("%s entry %s has no field %q", e.kind(), e.Rule.ID, name)
+	}
+	return v, nil
+}
@@ -1,12 +1,15 @@
 package vault
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"cloudterm-go/internal/crypto"
@@ -15,6 +18,10 @@ import (
 
 var bucketName = []byte("vault")
 
+// ErrNoKey is returned by Save when the vault has no encryption key;
+// credentials are never stored in plaintext.
+var ErrNoKey = errors.New("vault has no encryption key")
+
 // MatchRule defines how a credential maps to instances.
 type MatchRule struct {
 	ID       string `json:"id"`
@@ -32,18 +39,25 @@ type RDPCredential struct {
 	Security string `json:"security"`
 }
 
-// VaultEntry is a stored credential with metadata.
+// VaultEntry is a stored secret with metadata. Kind selects which of
+// Credential, SSH, DB or Token holds the secret; an empty Kind is RDP.
 type VaultEntry struct {
 	Rule       MatchRule     `json:"rule"`
+	Kind       string        `json:"kind,omitempty"`
 	Credential RDPCredential `json:"credential"`
+	SSH        *SSHKey       `json:"ssh,omitempty"`
+	DB         *DBCredential `json:"db,omitempty"`
+	Token      *APIToken     `json:"token,omitempty"`
 	CreatedAt  time.Time     `json:"created_at"`
 	UpdatedAt  time.Time     `json:"updated_at"`
 }
 
 // Store provides encrypted credential storage.
 type Store struct {
-	db  *bolt.DB
-	key []byte
+	db       *bolt.DB
+	mu       sync.RWMutex
+	key      []byte
+	previous [][]byte // keys replaced by RotateKey, for reads racing it
 }
 
 // Open opens or creates the vault database.
@@ -68,21 +82,71 @@ func (s *Store) Close() error {
 }
 
 func (s *Store) encrypt(data []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if len(s.key) == 0 {
-		return data, nil
+		return nil, ErrNoKey
 	}
 	return crypto.Encrypt(s.key, data)
 }
 
+// decrypt opens a stored value. Without a key, values written by earlier
+// versions without one are returned as is.
 func (s *Store) decrypt(data []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if len(s.key) == 0 {
 		return data, nil
 	}
-	return crypto.Decrypt(s.key, data)
+	return crypto.Rotation{Key: s.key, Previous: s.previous}.Open(data)
+}
+
+// Encrypted reports whether the vault can store credentials.
+func (s *Store) Encrypted() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.key) > 0
+}
+
+// RotateKey re-encrypts every entry with r.Key in a single transaction and
+// switches the store to it.
+func (s *Store) RotateKey(r crypto.Rotation) error {
+	s.mu.RLock()
+	oldKey, oldPrevious := s.key, s.previous
+	s.mu.RUnlock()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := crypto.ResealBuckets(tx, r, bucketName); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.key, s.previous = r.Key, r.Previous
+		s.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.key, s.previous = oldKey, oldPrevious
+		s.mu.Unlock()
+		return fmt.Errorf("vault: %w", err)
+	}
+	return nil
+}
+
+// ForgetPreviousKeys drops the keys of the last rotation once every store
+// has been resealed with the new one.
+func (s *Store) ForgetPreviousKeys() {
+	s.mu.Lock()
+	s.previous = nil
+	s.mu.Unlock()
 }
 
-// Save stores a credential entry.
+// Save stores a credential entry. It fails with ErrInvalidEntry when the
+// entry lacks the secret its kind needs and with ErrNoKey when the vault
+// has no encryption key.
 func (s *Store) Save(entry VaultEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists(bucketName)
 		if err != nil {
@@ -139,7 +203,7 @@ func (s *Store) Delete(id string) error {
 	})
 }
 
-// List returns all entries with passwords redacted.
+// List returns all entries with their secrets redacted.
 func (s *Store) List() []VaultEntry {
 	var entries []VaultEntry
 	s.db.View(func(tx *bolt.Tx) error {
@@ -154,8 +218,7 @@ func (s *Store) List() []VaultEntry {
 			}
 			var e VaultEntry
 			if json.Unmarshal(dec, &e) == nil {
-				e.Credential.Password = "\u2022\u2022\u2022\u2022\u2022\u2022\u2022\u2022"
-				entries = append(entries, e)
+				entries = append(entries, e.Redacted())
 			}
 			return nil
 		})
@@ -163,12 +226,16 @@ func (s *Store) List() []VaultEntry {
 	return entries
 }
 
-// FindMatch checks the match hierarchy for a matching credential.
-func (s *Store) FindMatch(instanceID, instanceName, env, accountID string) (*VaultEntry, error) {
+// FindMatch checks the match hierarchy for a matching secret of the given
+// kind.
+func (s *Store) FindMatch(kind, instanceID, instanceName, env, accountID string) (*VaultEntry, error) {
 	all := s.listUnredacted()
 	sort.Slice(all, func(i, j int) bool { return all[i].Rule.Priority < all[j].Rule.Priority })
 
 	for _, entry := range all {
+		if entry.kind() != kind {
+			continue
+		}
 		switch entry.Rule.Type {
 		case "instance":
 			if entry.Rule.Value == instanceID {
@@ -224,8 +291,10 @@ func (s *Store) Browse() []BrowseBucket {
 			b.ForEach(func(k, v []byte) error {
 				bi.Count++
 				e := BrowseEntry{Key: string(k), Encrypted: true}
-				dec, err := s.decrypt(v)
-				if err == nil {
+				if bytes.Equal(name, hostKeyBucket) {
+					e.Value = string(v)
+					e.Encrypted = false
+				} else if dec, err := s.decrypt(v); err == nil {
 					e.Value = redactPwd(string(dec))
 					e.Encrypted = false
 				} else {
@@ -246,9 +315,21 @@ func redactPwd(s string) string {
 	if json.Unmarshal([]byte(s), &m) != nil {
 		return s
 	}
-	if cred, ok := m["credential"].(map[string]interface{}); ok {
-		if _, has := cred["password"]; has {
-			cred["password"] = "\u2022\u2022\u2022\u2022\u2022\u2022\u2022\u2022"
+	secrets := map[string][]string{
+		"credential": {"password"},
+		"ssh":        {"private_key", "passphrase"},
+		"db":         {"password"},
+		"token":      {"token"},
+	}
+	for obj, fields := range secrets {
+		sub, ok := m[obj].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, f := range fields {
+			if _, has := sub[f]; has {
+				sub[f] = mask
+			}
 		}
 	}
 	out, _ := json.Marshal(m)
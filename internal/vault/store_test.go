@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+
+	"cloudterm-go/internal/crypto"
+)
+
+func testEntry(id, password string) VaultEntry {
+	return VaultEntry{
+		Rule:       MatchRule{ID: id, Type: "global", Priority: 6},
+		Credential: RDPCredential{Username: "Administrator", Password: password},
+	}
+}
+
+func TestSaveRequiresKey(t *testing.T) {
+	s, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Save(testEntry("v-1", "secret")); !errors.Is(err, ErrNoKey) {
+		t.Errorf("save without a key: err = %v", err)
+	}
+	if len(s.List()) != 0 {
+		t.Error("entry stored without a key")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := crypto.GenerateKey()
+	newKey, _ := crypto.GenerateKey()
+	s, err := Open(dir, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(testEntry("v-1", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateKey(crypto.Rotation{Key: newKey, Previous: [][]byte{oldKey}}); err != nil {
+		t.Fatal(err)
+	}
+	// Saves after the rotation use the new key.
+	if err := s.Save(testEntry("v-2", "other")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(dir, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for id, want := range map[string]string{"v-1": "secret", "v-2": "other"} {
+		got, err := s.Get(id)
+		if err != nil || got.Credential.Password != want {
+			t.Errorf("%s = %+v, %v", id, got, err)
+		}
+	}
+
+	// Values sealed with an unknown key abort the rotation.
+	otherKey, _ := crypto.GenerateKey()
+	if err := s.RotateKey(crypto.Rotation{Key: otherKey, Previous: [][]byte{oldKey}}); err == nil {
+		t.Error("rotation succeeded without the current key")
+	}
+	if got, err := s.Get("v-1"); err != nil || got.Credential.Password != "secret" {
+		t.Errorf("after failed rotation = %+v, %v", got, err)
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	newKey, _ := crypto.GenerateKey()
+	s, err := Open(t.TempDir(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if pinned, err := s.PinHostKey("i-1", "ssh-ed25519 AAAA1"); err != nil || pinned != "ssh-ed25519 AAAA1" {
+		t.Fatalf("first pin = %q, %v", pinned, err)
+	}
+	if pinned, _ := s.PinHostKey("i-1", "ssh-ed25519 AAAA2"); pinned != "ssh-ed25519 AAAA1" {
+		t.Errorf("second pin replaced the key: %q", pinned)
+	}
+	// Host keys are plaintext and must survive a key rotation untouched.
+	if err := s.RotateKey(crypto.Rotation{Key: newKey, Previous: [][]byte{key}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ForgetHostKey("i-1"); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := s.PinHostKey("i-1", "ssh-ed25519 AAAA2"); pinned != "ssh-ed25519 AAAA2" {
+		t.Errorf("pin after forget = %q", pinned)
+	}
+}
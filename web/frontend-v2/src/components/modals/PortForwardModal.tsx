@@ -23,6 +23,10 @@ interface StartTunnelResponse {
   port: number;
   remote_port: number;
   instance_name: string;
+  /** Connection URL from a matching vault database credential. */
+  db_link?: string;
+  /** db_link without the password. */
+  db_label?: string;
 }
 
 interface ActiveTunnel {
@@ -108,7 +112,10 @@ export function PortForwardModal({
           return;
         }
         const allocatedPort = result.value.port;
-        const tunnelLink = buildTunnelUrl(allocatedPort, remote, protocol);
+        const { db_link: dbLink, db_label: dbLabel } = result.value;
+        const tunnelLink = dbLink
+          ? { href: dbLink, label: dbLabel ?? dbLink }
+          : buildTunnelUrl(allocatedPort, remote, protocol);
         pushToast({
           variant: 'info',
           title: 'Tunnel active',
@@ -4208,7 +4208,9 @@ class CloudTermApp {
         if (!list) return;
         list.innerHTML = '<div style="color:var(--dim);font-size:11px;padding:12px;text-align:center;">Loading...</div>';
         fetch('/vault/credentials').then(r => r.json()).then(entries => {
-            if (!entries || entries.length === 0) {
+            // This view manages RDP credentials; other secret kinds live in the v2 settings.
+            entries = (entries || []).filter(e => !e.kind || e.kind === 'rdp');
+            if (entries.length === 0) {
                 list.innerHTML = '<div style="color:var(--dim);font-size:11px;padding:16px;text-align:center;">' +
                     '\uD83D\uDD12 No saved credentials.<br><span style="font-size:10px;color:var(--dim);">Save credentials via the RDP connection modal using the "Save to Vault" checkbox.</span></div>';
                 return;